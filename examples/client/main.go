@@ -0,0 +1,70 @@
+// Command client fetches a URL repeatedly without exceeding a request rate,
+// pacing outbound requests with an http.RoundTripper.
+//
+//	go run ./examples/client -url http://localhost:8080/ -n 20
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+// limitedTransport waits for the limiter before every request. The library
+// has no client transport yet, so this is the glue an application writes.
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *limiter.MultiWindowLimiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for {
+		decision := t.limiter.AllowDetail()
+		if decision.Allowed {
+			break
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(max(decision.RetryAfter, time.Millisecond)):
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newClient returns a client sending at most count requests per window
+func newClient(count int, window time.Duration) (*http.Client, error) {
+	l, err := limiter.NewMultiWindowLimiter(limiter.WindowLimit{Count: count, Window: window})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: &limitedTransport{base: http.DefaultTransport, limiter: l}}, nil
+}
+
+func main() {
+	url := flag.String("url", "http://localhost:8080/", "URL to fetch")
+	n := flag.Int("n", 20, "Number of requests")
+	rate := flag.Int("rate", 5, "Requests per second")
+	flag.Parse()
+
+	client, err := newClient(*rate, time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < *n; i++ {
+		resp, err := client.Get(*url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		fmt.Printf("%6.3fs %s\n", time.Since(start).Seconds(), resp.Status)
+	}
+}
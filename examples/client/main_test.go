@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientPacesRequests(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	const count, window = 5, 100 * time.Millisecond
+	client, err := newClient(count, window)
+	if err != nil {
+		t.Fatalf("newClient failed: %v", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+
+	// 15 requests at 5 per window need at least two more windows
+	if elapsed := time.Since(start); elapsed < 2*window || elapsed > 20*window {
+		t.Errorf("Expected 15 requests to take about %v, took %v", 2*window, elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) != 15 {
+		t.Fatalf("Expected the server to see 15 requests, saw %d", len(times))
+	}
+	// The first window's burst is the only time count requests arrive together
+	if first := times[count].Sub(times[0]); first < window/2 {
+		t.Errorf("Expected request %d to wait for the window to slide, came after %v", count+1, first)
+	}
+}
+
+func TestClientHonoursContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client, err := newClient(1, time.Hour)
+	if err != nil {
+		t.Fatalf("newClient failed: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("First request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if _, err := client.Do(req); err == nil {
+		t.Error("Expected the paced request to give up when its context ends")
+	}
+}
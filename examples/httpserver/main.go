@@ -0,0 +1,145 @@
+// Command httpserver serves an API limited per API key from a JSON or YAML config,
+// with endpoints reporting statistics, the busiest keys and the state of
+// every key.
+//
+//	go run ./examples/httpserver -config limits.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/limiter"
+	"github.com/rRateLimit/arg/sub/middleware"
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+// defaultConfig allows 5 requests per second and 20 per minute for each key
+const defaultConfig = `{
+	"name": "api",
+	"enabled": true,
+	"per_key_limits": true,
+	"error_message": "slow down",
+	"limits": [
+		{"count": 5, "window": 1000000000},
+		{"count": 20, "window": 60000000000}
+	]
+}`
+
+// statsLimiter records every decision of a limiter in shared statistics.
+// It implements AllowDetail so the middleware still learns the limits and
+// retry delay of the wrapped limiter.
+type statsLimiter struct {
+	limiter.Allower
+	stats *stats.Stats
+}
+
+func (s statsLimiter) Allow() bool {
+	return s.AllowDetail().Allowed
+}
+
+func (s statsLimiter) AllowDetail() limiter.Decision {
+	decision := limiter.AllowDetail(s.Allower)
+	if decision.Allowed {
+		s.stats.RecordAllowed()
+	} else {
+		s.stats.RecordDenied()
+	}
+	return decision
+}
+
+// newServer returns the API handler for cfg, which must set Limits
+func newServer(cfg *config.Config) (http.Handler, error) {
+	limits := make([]limiter.WindowLimit, len(cfg.Limits))
+	for i, l := range cfg.Limits {
+		limits[i] = limiter.WindowLimit{Count: l.Count, Window: l.Window}
+	}
+	if _, err := limiter.NewMultiWindowLimiter(limits...); err != nil {
+		return nil, fmt.Errorf("invalid limits: %w", err)
+	}
+
+	errorHandler, err := middleware.ErrorHandlerFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	total := stats.NewStats()
+	total.Name = cfg.Name
+	perKey := middleware.NewPerKeyHTTPRateLimiter(func() middleware.RateLimiter {
+		l, _ := limiter.NewMultiWindowLimiter(limits...)
+		return statsLimiter{Allower: l, stats: total}
+	}, &middleware.Options{
+		KeyFunc:      middleware.KeyFuncs.ByAPIKey("X-API-Key"),
+		ErrorHandler: errorHandler,
+		TopConsumers: 10,
+	})
+
+	api := http.NewServeMux()
+	api.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "hello")
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/", perKey.Middleware(api))
+	mux.Handle("/stats", stats.Handler(total))
+	mux.HandleFunc("/debug/top", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, perKey.TopConsumers(10))
+	})
+	mux.HandleFunc("/debug/keys", func(w http.ResponseWriter, r *http.Request) {
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 100
+		}
+		states, next, err := perKey.SnapshotStates(limit, r.URL.Query().Get("cursor"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, keysPage{Keys: states, Next: next})
+	})
+	return mux, nil
+}
+
+// keysPage is one page of the /debug/keys listing. Pass Next as the cursor
+// parameter to get the following page.
+type keysPage struct {
+	Keys []middleware.KeyState `json:"keys"`
+	Next string                `json:"next,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func main() {
+	configFile := flag.String("config", "", "JSON or YAML config file (default: built-in limits)")
+	addr := flag.String("addr", ":8080", "Address to listen on")
+	flag.Parse()
+
+	var (
+		cfg *config.Config
+		err error
+	)
+	if *configFile != "" {
+		cfg, err = config.LoadFromFile(*configFile)
+	} else {
+		cfg, err = config.LoadFromReader(strings.NewReader(defaultConfig))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handler, err := newServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, handler))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+func TestServer(t *testing.T) {
+	cfg, err := config.LoadFromReader(strings.NewReader(defaultConfig))
+	if err != nil {
+		t.Fatalf("Failed to load default config: %v", err)
+	}
+	handler, err := newServer(cfg)
+	if err != nil {
+		t.Fatalf("newServer failed: %v", err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(path, apiKey string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		return resp
+	}
+
+	for i := 0; i < 5; i++ {
+		resp := get("/", "alice")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Request %d: expected 200, got %d", i, resp.StatusCode)
+		}
+	}
+
+	resp := get("/", "alice")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After after the per-second limit, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if !strings.Contains(string(body), "slow down") {
+		t.Errorf("Expected the configured error message, got %q", body)
+	}
+
+	// Another key has its own limits
+	resp = get("/", "bob")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected another key to be allowed, got %d", resp.StatusCode)
+	}
+
+	var snapshot stats.StatsSnapshot
+	resp = get("/stats", "")
+	json.NewDecoder(resp.Body).Decode(&snapshot)
+	resp.Body.Close()
+	if snapshot.Name != "api" || snapshot.AllowedRequests != 6 || snapshot.DeniedRequests != 1 {
+		t.Errorf("Expected 6 allowed and 1 denied for api, got %+v", snapshot)
+	}
+
+	var top []stats.KeyCount
+	resp = get("/debug/top", "")
+	json.NewDecoder(resp.Body).Decode(&top)
+	resp.Body.Close()
+	if len(top) != 2 || top[0].Key != "alice" || top[0].Count != 5 {
+		t.Errorf("Expected alice to be the top consumer with 5 requests, got %+v", top)
+	}
+
+	// Page through the keys one at a time
+	remaining := make(map[string]int)
+	cursor := ""
+	for {
+		var page keysPage
+		resp = get("/debug/keys?limit=1&cursor="+cursor, "")
+		json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		for _, s := range page.Keys {
+			remaining[s.Key] = s.Remaining
+		}
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+	}
+	if len(remaining) != 2 || remaining["alice"] != 0 || remaining["bob"] != 4 {
+		t.Errorf("Expected alice with 0 and bob with 4 remaining, got %v", remaining)
+	}
+
+	resp = get("/debug/keys?cursor=bogus", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid cursor, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerRejectsConfigWithoutLimits(t *testing.T) {
+	if _, err := newServer(config.DefaultConfig()); err == nil {
+		t.Error("Expected an error for a config without window limits")
+	}
+}
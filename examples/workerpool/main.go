@@ -0,0 +1,83 @@
+// Command workerpool processes jobs with a pool of workers that together
+// stay within a rate limit.
+//
+//	go run ./examples/workerpool -jobs 50 -workers 8 -rate 10
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+// Job is a unit of work
+type Job struct {
+	ID int
+}
+
+// Result records when a job was processed and by which worker
+type Result struct {
+	Job    Job
+	Worker int
+	At     time.Time
+}
+
+// run processes jobs with the given number of workers, each waiting on the
+// shared limiter before starting a job, and returns the results in the
+// order they completed
+func run(jobs []Job, workers int, l *limiter.MultiWindowLimiter, process func(Job)) []Result {
+	queue := make(chan Job)
+	results := make(chan Result, len(jobs))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for job := range queue {
+				l.Wait()
+				process(job)
+				results <- Result{Job: job, Worker: worker, At: time.Now()}
+			}
+		}(w)
+	}
+
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	close(results)
+
+	var done []Result
+	for r := range results {
+		done = append(done, r)
+	}
+	return done
+}
+
+func main() {
+	n := flag.Int("jobs", 50, "Number of jobs")
+	workers := flag.Int("workers", 8, "Number of workers")
+	rate := flag.Int("rate", 10, "Jobs per second")
+	flag.Parse()
+
+	l, err := limiter.NewMultiWindowLimiter(limiter.WindowLimit{Count: *rate, Window: time.Second})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	jobs := make([]Job, *n)
+	for i := range jobs {
+		jobs[i] = Job{ID: i + 1}
+	}
+
+	start := time.Now()
+	for _, r := range run(jobs, *workers, l, func(Job) {}) {
+		fmt.Printf("%6.3fs worker %d finished job %d\n", r.At.Sub(start).Seconds(), r.Worker, r.Job.ID)
+	}
+}
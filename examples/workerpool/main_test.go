@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+func TestWorkerPoolPacing(t *testing.T) {
+	const count, window = 10, 100 * time.Millisecond
+	l, err := limiter.NewMultiWindowLimiter(limiter.WindowLimit{Count: count, Window: window})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := make([]Job, 30)
+	for i := range jobs {
+		jobs[i] = Job{ID: i}
+	}
+
+	start := time.Now()
+	results := run(jobs, 8, l, func(Job) {})
+	elapsed := time.Since(start)
+
+	if len(results) != len(jobs) {
+		t.Fatalf("Expected %d results, got %d", len(jobs), len(results))
+	}
+	seen := make(map[int]bool)
+	for _, r := range results {
+		seen[r.Job.ID] = true
+	}
+	if len(seen) != len(jobs) {
+		t.Errorf("Expected every job processed once, got %d distinct", len(seen))
+	}
+
+	// 30 jobs at 10 per window need at least two more windows after the
+	// first burst
+	if elapsed < 2*window || elapsed > 20*window {
+		t.Errorf("Expected 30 jobs to take about %v, took %v", 2*window, elapsed)
+	}
+
+	// No window-long span may contain more than the limit plus what the
+	// sliding estimate lets through at a window boundary
+	sort.Slice(results, func(i, j int) bool { return results[i].At.Before(results[j].At) })
+	for i := range results {
+		inWindow := 0
+		for j := i; j < len(results) && results[j].At.Sub(results[i].At) < window/2; j++ {
+			inWindow++
+		}
+		if inWindow > count+1 {
+			t.Errorf("Expected at most %d jobs per half window, got %d starting at job %d", count+1, inWindow, i)
+			break
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Package leaktest helps tests check that components release their
+// goroutines when they are stopped or closed.
+//
+// Take a baseline before creating the component and assert after shutting
+// it down:
+//
+//	baseline := leaktest.BaselineGoroutines()
+//	c := start()
+//	c.Close()
+//	leaktest.AssertNoLeak(t, baseline, time.Second)
+package leaktest
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// BaselineGoroutines returns the number of goroutines running now
+func BaselineGoroutines() int {
+	return runtime.NumGoroutine()
+}
+
+// AssertNoLeak fails t unless the number of goroutines falls back to
+// baseline within the given time. Goroutines exit asynchronously, so it
+// polls rather than checking once. On failure it logs every goroutine's
+// stack.
+func AssertNoLeak(t testing.TB, baseline int, within time.Duration) {
+	t.Helper()
+
+	deadline := time.Now().Add(within)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= baseline {
+			return
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Errorf("%d goroutines leaked, %d running against a baseline of %d:\n%s", n-baseline, n, baseline, buf)
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Soak runs cycle the given number of times, asserting after each batch of
+// every cycles that no goroutines have leaked. It reports the first batch
+// that leaked and stops there.
+func Soak(t testing.TB, cycles, every int, within time.Duration, cycle func(i int)) {
+	t.Helper()
+
+	baseline := BaselineGoroutines()
+	for i := 0; i < cycles; i++ {
+		cycle(i)
+		if (i+1)%every == 0 || i == cycles-1 {
+			AssertNoLeak(t, baseline, within)
+			if t.Failed() {
+				t.Logf("leak detected after cycle %d", i)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package leaktest
+
+import (
+	"testing"
+	"time"
+)
+
+// recordingT captures failures instead of failing the test
+type recordingT struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recordingT) Helper()               {}
+func (r *recordingT) Errorf(string, ...any) { r.failed = true }
+func (r *recordingT) Logf(string, ...any)   {}
+func (r *recordingT) Failed() bool          { return r.failed }
+
+func TestAssertNoLeakDetectsLeak(t *testing.T) {
+	baseline := BaselineGoroutines()
+	stop := make(chan struct{})
+	go func() { <-stop }()
+
+	rec := &recordingT{TB: t}
+	AssertNoLeak(rec, baseline, 20*time.Millisecond)
+	if !rec.failed {
+		t.Error("Expected a running goroutine to be reported")
+	}
+
+	close(stop)
+	AssertNoLeak(t, baseline, time.Second)
+}
+
+func TestSoakStopsAtFirstLeak(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	rec := &recordingT{TB: t}
+	cycles := 0
+	Soak(rec, 10, 2, 20*time.Millisecond, func(i int) {
+		cycles++
+		if i == 2 {
+			go func() { <-stop }()
+		}
+	})
+	if !rec.failed || cycles != 4 {
+		t.Errorf("Expected the leak to be found after the batch ending at cycle 4, ran %d, failed %v", cycles, rec.failed)
+	}
+}
@@ -1,77 +1,43 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
+	"log"
+	"os"
 	"sync"
 	"time"
-)
-
-// RateLimiter implements a token bucket algorithm for rate limiting
-type RateLimiter struct {
-	rate       int           // tokens per second
-	burst      int           // maximum number of tokens
-	tokens     int           // current number of tokens
-	lastUpdate time.Time     // last time tokens were updated
-	mu         sync.Mutex    // mutex for thread safety
-}
-
-// NewRateLimiter creates a new rate limiter with the specified rate and burst size
-func NewRateLimiter(rate, burst int) *RateLimiter {
-	return &RateLimiter{
-		rate:       rate,
-		burst:      burst,
-		tokens:     burst, // start with full bucket
-		lastUpdate: time.Now(),
-	}
-}
-
-// Allow checks if a request can be processed and consumes a token if available
-func (rl *RateLimiter) Allow() bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Calculate tokens to add based on elapsed time
-	now := time.Now()
-	elapsed := now.Sub(rl.lastUpdate)
-	rl.lastUpdate = now
 
-	// Add tokens based on rate and elapsed time
-	tokensToAdd := int(elapsed.Seconds() * float64(rl.rate))
-	rl.tokens = min(rl.tokens+tokensToAdd, rl.burst)
-
-	// Check if we have tokens available
-	if rl.tokens > 0 {
-		rl.tokens--
-		return true
-	}
-	return false
-}
-
-// Wait blocks until a token is available
-func (rl *RateLimiter) Wait() {
-	for !rl.Allow() {
-		// Sleep for approximately the time it takes to generate one token
-		time.Sleep(time.Duration(1000/rl.rate) * time.Millisecond)
-	}
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/limiter"
+)
 
 func main() {
 	rate := flag.Int("rate", 10, "Rate limit (requests per second)")
 	burst := flag.Int("burst", 20, "Burst size (maximum tokens)")
 	requests := flag.Int("requests", 50, "Number of requests to simulate")
 	workers := flag.Int("workers", 5, "Number of concurrent workers")
+	state := flag.String("state", "", "File to restore the limiter from and save it to on exit")
 	
 	flag.Parse()
 
-	rl := NewRateLimiter(*rate, *burst)
+	rl, err := limiter.NewRateLimiterFromConfig(&config.Config{Rate: *rate, Burst: *burst, Enabled: true})
+	if err != nil {
+		log.Fatalf("Invalid limits: %v", err)
+	}
+	if *state != "" {
+		if err := loadState(*state, rl); err != nil {
+			log.Fatalf("Failed to restore limiter: %v", err)
+		}
+		defer func() {
+			if err := saveState(*state, rl); err != nil {
+				log.Printf("Failed to save limiter: %v", err)
+			}
+		}()
+	}
 
 	fmt.Printf("Rate Limiter Configuration:\n")
 	fmt.Printf("- Rate: %d requests/second\n", *rate)
@@ -105,4 +71,36 @@ func main() {
 	
 	fmt.Printf("\nCompleted %d requests in %v\n", *requests, elapsed)
 	fmt.Printf("Actual rate: %.2f requests/second\n", float64(*requests)/elapsed.Seconds())
-}
\ No newline at end of file
+}
+
+// loadState restores rl's tokens from the file at path, leaving it as it is
+// if there is no such file. The rate and burst rl was created with win over
+// the saved ones, so changed flags take effect.
+func loadState(path string, rl *limiter.RateLimiter) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	want := rl.State()
+	if err := json.Unmarshal(data, rl); err != nil {
+		return err
+	}
+	if per := rl.State().Per; per != want.Per {
+		return fmt.Errorf("saved limiter adds tokens every %v, not every %v", per, want.Per)
+	}
+	rl.SetRate(want.Rate)
+	rl.SetBurst(want.Burst)
+	return nil
+}
+
+// saveState writes rl to the file at path
+func saveState(path string, rl *limiter.RateLimiter) error {
+	data, err := json.Marshal(rl)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// algorithms holds the names Validate accepts for Algorithm
+var algorithms = struct {
+	sync.RWMutex
+	names map[string]bool
+}{names: map[string]bool{
+	"token_bucket":   true,
+	"sliding_window": true,
+	"gcra":           true,
+}}
+
+// RegisterAlgorithm adds name to the algorithms Validate accepts, failing
+// if it is empty or already known. limiter.Register calls it along with
+// registering how to build the algorithm, so most callers want that.
+func RegisterAlgorithm(name string) error {
+	if name == "" {
+		return errors.New("algorithm name must not be empty")
+	}
+	algorithms.Lock()
+	defer algorithms.Unlock()
+	if algorithms.names[name] {
+		return fmt.Errorf("algorithm %q is already registered", name)
+	}
+	algorithms.names[name] = true
+	return nil
+}
+
+// Algorithms returns the names of the known algorithms, sorted
+func Algorithms() []string {
+	algorithms.RLock()
+	defer algorithms.RUnlock()
+	names := make([]string, 0, len(algorithms.names))
+	for name := range algorithms.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func knownAlgorithm(name string) bool {
+	algorithms.RLock()
+	defer algorithms.RUnlock()
+	return algorithms.names[name]
+}
+
+// cloneParam deep-copies an AlgorithmParams value, as decoded from JSON
+func cloneParam(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		clone := make(map[string]any, len(v))
+		for k, item := range v {
+			clone[k] = cloneParam(item)
+		}
+		return clone
+	case []any:
+		clone := make([]any, len(v))
+		for i, item := range v {
+			clone[i] = cloneParam(item)
+		}
+		return clone
+	}
+	return v
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic saves filename with write, which writes to a temporary
+// file in the same directory that is synced and then renamed over
+// filename. A crash or a failing write leaves filename as it was, and
+// watchers never read part of a file. filename keeps its permissions if it
+// exists. what names the file in errors, such as "config file".
+func writeFileAtomic(filename, what string, write func(w io.Writer) error) (err error) {
+	perm := fs.FileMode(0o644)
+	if info, statErr := os.Stat(filename); statErr == nil {
+		perm = info.Mode().Perm()
+	} else if !errors.Is(statErr, fs.ErrNotExist) {
+		return fmt.Errorf("failed to create %s: %w", what, statErr)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", what, err)
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	if err := write(file); err != nil {
+		return err
+	}
+	if err := file.Chmod(perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", what, err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", what, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", what, err)
+	}
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return fmt.Errorf("failed to write %s: %w", what, err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSaveToFileKeepsPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not kept on Windows")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"limits.json", "limits.yaml"} {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte("{}"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := DefaultConfig().SaveToFile(filename); err != nil {
+			t.Fatalf("SaveToFile failed: %v", err)
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o600 {
+			t.Errorf("%s: Expected permissions 0600 kept, got %o", name, perm)
+		}
+		if _, err := LoadFromFile(filename); err != nil {
+			t.Errorf("%s: Expected the saved file to load, got %v", name, err)
+		}
+
+		cs := NewConfigSet()
+		cs.Add("api", DefaultConfig())
+		if err := cs.SaveToFile(filename); err != nil {
+			t.Fatalf("ConfigSet.SaveToFile failed: %v", err)
+		}
+		if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("%s: Expected the set to keep permissions 0600, got %v, %v", name, info, err)
+		}
+	}
+
+	// New files are readable by others, as with os.Create
+	filename := filepath.Join(dir, "new.json")
+	if err := DefaultConfig().SaveToFile(filename); err != nil {
+		t.Fatalf("SaveToFile failed: %v", err)
+	}
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0o644 {
+		t.Errorf("Expected a new file to get permissions 0644, got %v, %v", info, err)
+	}
+}
+
+func TestWriteFileAtomicFailure(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "limits.json")
+	original := []byte(`{"rate": 5, "burst": 5}`)
+	if err := os.WriteFile(filename, original, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	injected := errors.New("disk full")
+	err := writeFileAtomic(filename, "config file", func(w io.Writer) error {
+		io.WriteString(w, `{"rate": `)
+		return injected
+	})
+	if !errors.Is(err, injected) {
+		t.Fatalf("Expected the write error, got %v", err)
+	}
+
+	if data, err := os.ReadFile(filename); err != nil || string(data) != string(original) {
+		t.Errorf("Expected the file unchanged, got %q, %v", data, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary file left behind, got %v", entries)
+	}
+
+	if err := DefaultConfig().SaveToFile(filepath.Join(dir, "missing", "limits.json")); err == nil {
+		t.Error("Expected saving into a missing directory to fail")
+	}
+}
@@ -5,7 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 )
 
@@ -18,9 +23,113 @@ type Config struct {
 	Enabled         bool          `json:"enabled"`
 	PerKeyLimits    bool          `json:"per_key_limits,omitempty"`
 	ErrorMessage    string        `json:"error_message,omitempty"`
+	// ErrorStatusCode is the status of denied responses, a 4xx or 5xx such
+	// as 503. Zero means 429 Too Many Requests.
+	ErrorStatusCode int           `json:"error_status_code,omitempty"`
+	// RetryAfterMode sets the Retry-After of denied responses: "auto", the
+	// default, for the limiter's estimate when it has one, or
+	// "static:<seconds>" for a fixed value
+	RetryAfterMode  string        `json:"retry_after_mode,omitempty"`
 	ExcludedPaths   []string      `json:"excluded_paths,omitempty"`
 	ExcludedIPs     []string      `json:"excluded_ips,omitempty"`
 	CustomHeaders   map[string]string `json:"custom_headers,omitempty"`
+	ResponseTemplate    string            `json:"response_template,omitempty"`
+	ResponseContentType string            `json:"response_content_type,omitempty"`
+	DocsURL             string            `json:"docs_url,omitempty"`
+	Limits              []WindowLimit     `json:"limits,omitempty"`
+	Extends             string            `json:"extends,omitempty"`
+	Costs               map[string]int    `json:"costs,omitempty"`
+	DefaultCost         int               `json:"default_cost,omitempty"`
+	// Algorithm names the limiter algorithm, such as "token_bucket",
+	// "sliding_window" or "gcra", or one added with RegisterAlgorithm.
+	// Empty picks one from the other fields.
+	Algorithm           string            `json:"algorithm,omitempty"`
+	// AlgorithmParams holds settings specific to the algorithm, for
+	// algorithms added with RegisterAlgorithm to interpret
+	AlgorithmParams     map[string]any    `json:"algorithm_params,omitempty"`
+	// InitialTokens is how many tokens a new limiter starts with, from 0 to
+	// Burst. Nil starts it full. Starting low makes new per-key limiters
+	// ramp up instead of granting every new key a full burst.
+	InitialTokens       *int              `json:"initial_tokens,omitempty"`
+	// MaxKeys caps how many keys have a limiter with PerKeyLimits. New keys
+	// beyond it evict the least recently used, or are denied with
+	// RejectNewKeys. Zero means no cap.
+	MaxKeys             int               `json:"max_keys,omitempty"`
+	RejectNewKeys       bool              `json:"reject_new_keys,omitempty"`
+	// KeyTTL evicts the limiter of a key that has made no request for this
+	// long with PerKeyLimits. Zero keeps limiters until MaxKeys evicts them.
+	KeyTTL              time.Duration     `json:"key_ttl,omitempty"`
+	// DryRun makes the middleware evaluate limits without enforcing them,
+	// to see what they would deny before turning them on
+	DryRun              bool              `json:"dry_run,omitempty"`
+
+	// warnings lists the unknown fields the config was loaded with
+	warnings []string
+}
+
+// WindowLimit allows Count requests per Window. A Config with Limits
+// enforces all of them at once instead of Rate and Burst.
+type WindowLimit struct {
+	Count  int           `json:"count"`
+	Window time.Duration `json:"window"`
+}
+
+// ResponseData is the data ResponseTemplate, the text/template rendered as
+// the body of denied responses, is executed with. Values the limiter cannot
+// provide are empty strings, so templates render them as nothing rather than
+// failing.
+type ResponseData struct {
+	Limit             string
+	Remaining         string
+	RetryAfterSeconds string
+	Key               string
+	Name              string
+	DocsURL           string
+}
+
+// Retry-After modes for Config.RetryAfterMode
+const (
+	RetryAfterAuto   = "auto"
+	RetryAfterStatic = "static:"
+)
+
+// ParseRetryAfterMode parses a Config.RetryAfterMode. For "static:<seconds>"
+// it returns the fixed Retry-After and true; for "auto" or "" it returns
+// false, meaning the limiter's estimate is used.
+func ParseRetryAfterMode(mode string) (time.Duration, bool, error) {
+	if mode == "" || mode == RetryAfterAuto {
+		return 0, false, nil
+	}
+	seconds, ok := strings.CutPrefix(mode, RetryAfterStatic)
+	if !ok {
+		return 0, false, fmt.Errorf("retry after mode %q must be %q or %q followed by seconds", mode, RetryAfterAuto, RetryAfterStatic)
+	}
+	n, err := strconv.Atoi(seconds)
+	if err != nil || n < 0 {
+		return 0, false, fmt.Errorf("retry after mode %q must give a non-negative number of seconds", mode)
+	}
+	return time.Duration(n) * time.Second, true, nil
+}
+
+// ParseResponseTemplate parses a response body template. Besides the
+// standard functions, templates may use json to encode a value as a JSON
+// literal, e.g. {{json .Key}}.
+func ParseResponseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("response").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	// Executing against empty data catches references to unknown fields
+	if err := tmpl.Execute(io.Discard, ResponseData{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
 }
 
 // DefaultConfig returns a default configuration
@@ -34,59 +143,269 @@ func DefaultConfig() *Config {
 	}
 }
 
-// Validate checks if the configuration is valid
+// Validate checks if the configuration is valid, returning the first
+// problem found. ValidateAll returns every one.
 func (c *Config) Validate() error {
-	if c.Rate <= 0 {
-		return errors.New("rate must be positive")
+	if errs := c.validate(); len(errs) > 0 {
+		return errs[0]
 	}
-	if c.Burst <= 0 {
-		return errors.New("burst must be positive")
+	return nil
+}
+
+// ValidateAll is like Validate but returns every problem at once, joined
+// with errors.Join, so a config can be fixed in one pass
+func (c *Config) ValidateAll() error {
+	return errors.Join(c.validate()...)
+}
+
+// validate returns the problems with the configuration, skipping those
+// that only follow from one already found
+func (c *Config) validate() []error {
+	var errs []error
+	if len(c.Limits) > 0 {
+		if err := c.validateLimits(); err != nil {
+			errs = append(errs, err)
+		}
+	} else {
+		if c.Rate <= 0 {
+			errs = append(errs, errors.New("rate must be positive"))
+		}
+		if c.Burst <= 0 {
+			errs = append(errs, errors.New("burst must be positive"))
+		} else if c.Burst < c.Rate {
+			errs = append(errs, errors.New("burst must be greater than or equal to rate"))
+		}
 	}
-	if c.Burst < c.Rate {
-		return errors.New("burst must be greater than or equal to rate")
+	if c.InitialTokens != nil {
+		if len(c.Limits) > 0 {
+			errs = append(errs, errors.New("initial tokens do not apply to window limits"))
+		} else if *c.InitialTokens < 0 || *c.InitialTokens > c.Burst {
+			errs = append(errs, errors.New("initial tokens must be between 0 and burst"))
+		}
 	}
 	if c.Window < 0 {
-		return errors.New("window must be non-negative")
+		errs = append(errs, errors.New("window must be non-negative"))
+	}
+	if c.Algorithm != "" && !knownAlgorithm(c.Algorithm) {
+		errs = append(errs, fmt.Errorf("unknown algorithm %q, available: %s", c.Algorithm, strings.Join(Algorithms(), ", ")))
+	}
+	if c.MaxKeys < 0 {
+		errs = append(errs, errors.New("max keys must be non-negative"))
+	}
+	if c.KeyTTL < 0 {
+		errs = append(errs, errors.New("key TTL must be non-negative"))
+	}
+	if err := c.validateCosts(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := CompileExclusions(c.ExcludedPaths, c.ExcludedIPs); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.validateHeaders(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.ErrorStatusCode != 0 && (c.ErrorStatusCode < 400 || c.ErrorStatusCode > 599) {
+		errs = append(errs, fmt.Errorf("error status code %d must be a 4xx or 5xx status", c.ErrorStatusCode))
+	}
+	if _, _, err := ParseRetryAfterMode(c.RetryAfterMode); err != nil {
+		errs = append(errs, err)
+	}
+	if c.ResponseTemplate != "" {
+		if _, err := ParseResponseTemplate(c.ResponseTemplate); err != nil {
+			errs = append(errs, fmt.Errorf("invalid response template: %w", err))
+		}
+	}
+	return errs
+}
+
+// validateLimits checks that every window limit is positive, that no window
+// appears twice, and that no shorter window allows more requests than a
+// longer one, since such a limit could never bind
+func (c *Config) validateLimits() error {
+	limits := c.sortedLimits()
+	for i, l := range limits {
+		if l.Count <= 0 {
+			return fmt.Errorf("limit count for window %v must be positive", l.Window)
+		}
+		if l.Window <= 0 {
+			return errors.New("limit window must be positive")
+		}
+		if i == 0 {
+			continue
+		}
+		prev := limits[i-1]
+		if prev.Window == l.Window {
+			return fmt.Errorf("duplicate limit for window %v", l.Window)
+		}
+		if prev.Count > l.Count {
+			return fmt.Errorf("limit for window %v allows more requests than limit for window %v", prev.Window, l.Window)
+		}
 	}
 	return nil
 }
 
-// LoadFromFile loads configuration from a JSON file
-func LoadFromFile(filename string) (*Config, error) {
-	file, err := os.Open(filename)
+// validateCosts checks that the cost matchers compile and that no request
+// costs more than the limiter could ever allow at once
+func (c *Config) validateCosts() error {
+	if len(c.Costs) == 0 && c.DefaultCost == 0 {
+		return nil
+	}
+	table, err := CompileCosts(c.Costs, c.DefaultCost)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open config file: %w", err)
+		return err
 	}
-	defer file.Close()
-	
-	return LoadFromReader(file)
+
+	// With window limits, the shortest window allows the fewest requests
+	capacity, what := c.Burst, "burst"
+	if len(c.Limits) > 0 {
+		shortest := c.sortedLimits()[0]
+		capacity, what = shortest.Count, fmt.Sprintf("limit for window %v", shortest.Window)
+	}
+	if highest := table.MaxCost(); highest > capacity {
+		return fmt.Errorf("cost %d exceeds %s of %d", highest, what, capacity)
+	}
+	return nil
 }
 
-// LoadFromReader loads configuration from an io.Reader
-func LoadFromReader(r io.Reader) (*Config, error) {
-	config := DefaultConfig()
-	
-	decoder := json.NewDecoder(r)
-	if err := decoder.Decode(config); err != nil {
-		return nil, fmt.Errorf("failed to decode config: %w", err)
+// validateHeaders checks that every custom header has a legal name and a
+// value without line breaks or other control characters, which would
+// otherwise be dropped or mangled when the response is written
+func (c *Config) validateHeaders() error {
+	for name, value := range c.CustomHeaders {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid custom header name %q", name)
+		}
+		for _, r := range value {
+			if r < ' ' && r != '\t' || r == 0x7f {
+				return fmt.Errorf("invalid value for custom header %s: control character %q", name, r)
+			}
+		}
 	}
-	
-	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid config: %w", err)
+	return nil
+}
+
+// validHeaderName reports whether name is an HTTP token, as RFC 9110
+// requires header names to be
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
 	}
-	
-	return config, nil
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Lengths ValidateStrict allows for Name and ErrorMessage
+const (
+	MaxNameLength         = 128
+	MaxErrorMessageLength = 1024
+)
+
+// ValidateStrict is Validate with further checks for settings that work but
+// are probably mistakes: a Name longer than MaxNameLength, an ErrorMessage
+// longer than MaxErrorMessageLength, duplicate excluded paths or IPs, and
+// anything Lint warns about. Validate stays lenient so configs that loaded
+// before keep loading.
+func (c *Config) ValidateStrict() error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if len(c.Name) > MaxNameLength {
+		return fmt.Errorf("name is %d bytes, longer than %d", len(c.Name), MaxNameLength)
+	}
+	if len(c.ErrorMessage) > MaxErrorMessageLength {
+		return fmt.Errorf("error message is %d bytes, longer than %d", len(c.ErrorMessage), MaxErrorMessageLength)
+	}
+	if err := checkDuplicates("excluded path", c.ExcludedPaths, func(p string) string { return p }); err != nil {
+		return err
+	}
+	// Addresses are compared as the blocks they stand for, so 10.0.0.1 and
+	// 10.0.0.1/32 are duplicates
+	if err := checkDuplicates("excluded IP", c.ExcludedIPs, func(ip string) string {
+		network, _ := parseNetwork(ip)
+		return network.String()
+	}); err != nil {
+		return err
+	}
+	if warnings := c.Lint(); len(warnings) > 0 {
+		return errors.New(warnings[0])
+	}
+	return nil
+}
+
+// checkDuplicates returns an error naming the first entry whose key repeats
+// an earlier one's
+func checkDuplicates(what string, entries []string, key func(string) string) error {
+	seen := make(map[string]int, len(entries))
+	for i, entry := range entries {
+		k := key(entry)
+		if first, ok := seen[k]; ok {
+			return fmt.Errorf("duplicate %s %q at index %d: same as index %d", what, entry, i, first)
+		}
+		seen[k] = i
+	}
+	return nil
+}
+
+// Lint returns warnings about settings that are valid but probably not
+// what was intended
+func (c *Config) Lint() []string {
+	var warnings []string
+
+	// A longer window that permits at least as much as a shorter window
+	// allows over the same period can never be the binding limit
+	limits := c.sortedLimits()
+	for i := 1; i < len(limits); i++ {
+		shorter, longer := limits[i-1], limits[i]
+		if shorter.Window <= 0 || longer.Window <= 0 {
+			continue
+		}
+		proportional := float64(shorter.Count) * float64(longer.Window) / float64(shorter.Window)
+		if float64(longer.Count) >= proportional {
+			warnings = append(warnings, fmt.Sprintf(
+				"limit of %d per %v never binds: %d per %v already allows at most %.0f",
+				longer.Count, longer.Window, shorter.Count, shorter.Window, proportional))
+		}
+	}
+
+	return warnings
 }
 
-// SaveToFile saves configuration to a JSON file
+// sortedLimits returns a copy of Limits ordered by window
+func (c *Config) sortedLimits() []WindowLimit {
+	limits := make([]WindowLimit, len(c.Limits))
+	copy(limits, c.Limits)
+	sort.Slice(limits, func(i, j int) bool {
+		return limits[i].Window < limits[j].Window
+	})
+	return limits
+}
+
+// LoadFromFile loads configuration from a JSON file, or from YAML if the
+// file name ends in .yaml or .yml
+func LoadFromFile(filename string) (*Config, error) {
+	return loadFromFile(filename, isYAMLFile(filename), false)
+}
+
+// LoadFromReader loads configuration from an io.Reader. Unknown fields are
+// ignored and reported by Warnings; LoadFromReaderStrict rejects them.
+func LoadFromReader(r io.Reader) (*Config, error) {
+	return loadFromReader(r, false, false)
+}
+
+// SaveToFile saves configuration to a JSON file, or to YAML if the file
+// name ends in .yaml or .yml. The file is replaced atomically, keeping its
+// permissions, so a failed save leaves it as it was.
 func (c *Config) SaveToFile(filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create config file: %w", err)
+	if isYAMLFile(filename) {
+		return c.SaveYAMLToFile(filename)
 	}
-	defer file.Close()
-	
-	return c.SaveToWriter(file)
+
+	return writeFileAtomic(filename, "config file", c.SaveToWriter)
 }
 
 // SaveToWriter saves configuration to an io.Writer
@@ -121,23 +440,219 @@ func (c *Config) Clone() *Config {
 			clone.CustomHeaders[k] = v
 		}
 	}
+
+	if c.Limits != nil {
+		clone.Limits = make([]WindowLimit, len(c.Limits))
+		copy(clone.Limits, c.Limits)
+	}
+
+	if c.Costs != nil {
+		clone.Costs = make(map[string]int, len(c.Costs))
+		for k, v := range c.Costs {
+			clone.Costs[k] = v
+		}
+	}
+
+	if c.InitialTokens != nil {
+		initial := *c.InitialTokens
+		clone.InitialTokens = &initial
+	}
+
+	if c.AlgorithmParams != nil {
+		clone.AlgorithmParams = cloneParam(c.AlgorithmParams).(map[string]any)
+	}
 	
 	return &clone
 }
 
-// ConfigSet represents a collection of named configurations
+// Merge returns a copy of c with every field set in over applied on top.
+// A field is set when it is not its zero value, so over cannot clear a
+// field or turn Enabled, PerKeyLimits, RejectNewKeys or DryRun off.
+// Slices and maps are replaced as a whole; see MergeOptions to append or
+// merge them instead.
+func (c *Config) Merge(over *Config) *Config {
+	return c.MergeWithOptions(over, nil)
+}
+
+// MergeOptions controls how MergeWithOptions combines configurations
+type MergeOptions struct {
+	// AppendSlices appends over's ExcludedPaths, ExcludedIPs and Limits to
+	// the base's instead of replacing them
+	AppendSlices bool
+	// MergeMaps merges over's CustomHeaders, Costs and AlgorithmParams
+	// into the base's key by key instead of replacing them
+	MergeMaps bool
+	// Present names the fields over sets by their JSON names, such as
+	// "enabled". Fields it names are applied even when zero, so over can
+	// turn Enabled off or clear a list; others are left alone. When nil, a
+	// field is set when it is not its zero value, as with Merge.
+	Present map[string]bool
+}
+
+// MergeWithOptions is like Merge using the given options. The result is
+// not validated, since either side may be partial.
+func (c *Config) MergeWithOptions(over *Config, opts *MergeOptions) *Config {
+	if opts == nil {
+		opts = &MergeOptions{}
+	}
+	set := func(field string, nonZero bool) bool {
+		if opts.Present != nil {
+			return opts.Present[field]
+		}
+		return nonZero
+	}
+	mergeStrings := func(base, over []string) []string {
+		if opts.AppendSlices {
+			return append(append([]string(nil), base...), over...)
+		}
+		return append([]string(nil), over...)
+	}
+
+	merged := c.Clone()
+	if len(over.warnings) > 0 {
+		merged.warnings = append(c.Warnings(), over.warnings...)
+	}
+
+	if set("rate", over.Rate != 0) {
+		merged.Rate = over.Rate
+	}
+	if set("burst", over.Burst != 0) {
+		merged.Burst = over.Burst
+	}
+	if set("window", over.Window != 0) {
+		merged.Window = over.Window
+	}
+	if set("name", over.Name != "") {
+		merged.Name = over.Name
+	}
+	if set("enabled", over.Enabled) {
+		merged.Enabled = over.Enabled
+	}
+	if set("per_key_limits", over.PerKeyLimits) {
+		merged.PerKeyLimits = over.PerKeyLimits
+	}
+	if set("error_message", over.ErrorMessage != "") {
+		merged.ErrorMessage = over.ErrorMessage
+	}
+	if set("error_status_code", over.ErrorStatusCode != 0) {
+		merged.ErrorStatusCode = over.ErrorStatusCode
+	}
+	if set("retry_after_mode", over.RetryAfterMode != "") {
+		merged.RetryAfterMode = over.RetryAfterMode
+	}
+	if set("excluded_paths", over.ExcludedPaths != nil) {
+		merged.ExcludedPaths = mergeStrings(merged.ExcludedPaths, over.ExcludedPaths)
+	}
+	if set("excluded_ips", over.ExcludedIPs != nil) {
+		merged.ExcludedIPs = mergeStrings(merged.ExcludedIPs, over.ExcludedIPs)
+	}
+	if set("custom_headers", len(over.CustomHeaders) > 0) {
+		if !opts.MergeMaps || over.CustomHeaders == nil {
+			merged.CustomHeaders = nil
+		}
+		if merged.CustomHeaders == nil && over.CustomHeaders != nil {
+			merged.CustomHeaders = make(map[string]string, len(over.CustomHeaders))
+		}
+		for k, v := range over.CustomHeaders {
+			merged.CustomHeaders[k] = v
+		}
+	}
+	if set("response_template", over.ResponseTemplate != "") {
+		merged.ResponseTemplate = over.ResponseTemplate
+	}
+	if set("response_content_type", over.ResponseContentType != "") {
+		merged.ResponseContentType = over.ResponseContentType
+	}
+	if set("docs_url", over.DocsURL != "") {
+		merged.DocsURL = over.DocsURL
+	}
+	if set("limits", over.Limits != nil) {
+		if opts.AppendSlices {
+			merged.Limits = append(merged.Limits, over.Limits...)
+		} else {
+			merged.Limits = append([]WindowLimit(nil), over.Limits...)
+		}
+	}
+	if set("algorithm", over.Algorithm != "") {
+		merged.Algorithm = over.Algorithm
+	}
+	if set("algorithm_params", len(over.AlgorithmParams) > 0) {
+		if !opts.MergeMaps || over.AlgorithmParams == nil {
+			merged.AlgorithmParams = nil
+		}
+		if merged.AlgorithmParams == nil && over.AlgorithmParams != nil {
+			merged.AlgorithmParams = make(map[string]any, len(over.AlgorithmParams))
+		}
+		for k, v := range over.AlgorithmParams {
+			merged.AlgorithmParams[k] = cloneParam(v)
+		}
+	}
+	if set("initial_tokens", over.InitialTokens != nil) {
+		merged.InitialTokens = nil
+		if over.InitialTokens != nil {
+			initial := *over.InitialTokens
+			merged.InitialTokens = &initial
+		}
+	}
+	if set("costs", len(over.Costs) > 0) {
+		if !opts.MergeMaps || over.Costs == nil {
+			merged.Costs = nil
+		}
+		if merged.Costs == nil && over.Costs != nil {
+			merged.Costs = make(map[string]int, len(over.Costs))
+		}
+		for k, v := range over.Costs {
+			merged.Costs[k] = v
+		}
+	}
+	if set("default_cost", over.DefaultCost != 0) {
+		merged.DefaultCost = over.DefaultCost
+	}
+	if set("max_keys", over.MaxKeys != 0) {
+		merged.MaxKeys = over.MaxKeys
+	}
+	if set("key_ttl", over.KeyTTL != 0) {
+		merged.KeyTTL = over.KeyTTL
+	}
+	if set("reject_new_keys", over.RejectNewKeys) {
+		merged.RejectNewKeys = over.RejectNewKeys
+	}
+	if set("dry_run", over.DryRun) {
+		merged.DryRun = over.DryRun
+	}
+	if set("extends", over.Extends != "") {
+		merged.Extends = over.Extends
+	}
+
+	return merged
+}
+
+// ConfigSet represents a collection of named configurations. It is safe
+// for concurrent use: configurations are copied on the way in and out, so
+// one being reloaded never changes under a reader.
 type ConfigSet struct {
+	mu      sync.RWMutex
 	configs map[string]*Config
+	routes  *RouteTable
+	// present holds the fields set by each loaded configuration, so a
+	// configuration that extends another overrides exactly those
+	present map[string]map[string]bool
 }
 
 // NewConfigSet creates a new configuration set
 func NewConfigSet() *ConfigSet {
 	return &ConfigSet{
 		configs: make(map[string]*Config),
+		present: make(map[string]map[string]bool),
 	}
 }
 
-// Add adds a configuration to the set
+// MaxExtendsDepth is the longest chain of Extends references Resolve follows
+const MaxExtendsDepth = 16
+
+// Add adds a copy of a configuration to the set, replacing any of the same
+// name. A configuration that extends another may be partial, so it is only
+// validated once resolved; see Validate.
 func (cs *ConfigSet) Add(name string, config *Config) error {
 	if name == "" {
 		return errors.New("config name cannot be empty")
@@ -145,27 +660,142 @@ func (cs *ConfigSet) Add(name string, config *Config) error {
 	if config == nil {
 		return errors.New("config cannot be nil")
 	}
-	if err := config.Validate(); err != nil {
-		return fmt.Errorf("invalid config for %s: %w", name, err)
+	if config.Extends == "" {
+		if err := config.Validate(); err != nil {
+			return fmt.Errorf("invalid config for %s: %w", name, err)
+		}
 	}
 	
+	config = config.Clone()
+	cs.mu.Lock()
 	cs.configs[name] = config
+	delete(cs.present, name)
+	cs.mu.Unlock()
 	return nil
 }
 
-// Get retrieves a configuration by name
+// Get retrieves a copy of a configuration by name
 func (cs *ConfigSet) Get(name string) (*Config, bool) {
+	cs.mu.RLock()
 	config, exists := cs.configs[name]
-	return config, exists
+	cs.mu.RUnlock()
+	if !exists {
+		return nil, false
+	}
+	return config.Clone(), true
+}
+
+// Len returns the number of configurations in the set
+func (cs *ConfigSet) Len() int {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return len(cs.configs)
+}
+
+// All returns a copy of every configuration in the set by name
+func (cs *ConfigSet) All() map[string]*Config {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	all := make(map[string]*Config, len(cs.configs))
+	for name, config := range cs.configs {
+		all[name] = config.Clone()
+	}
+	return all
+}
+
+// Range calls fn with a copy of each configuration in the set, in order of
+// name, until fn returns false. It works on a snapshot taken before the
+// first call, so fn may modify the set.
+func (cs *ConfigSet) Range(fn func(name string, c *Config) bool) {
+	all := cs.All()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !fn(name, all[name]) {
+			return
+		}
+	}
+}
+
+// Resolve returns the named configuration merged over the configurations it
+// extends, directly or through a chain. A configuration loaded from a file
+// overrides exactly the fields it sets, even to zero values such as
+// "enabled": false, as MergeOptions.Present does; one added with Add
+// overrides its non-zero fields, as Merge does. Maps are merged key by
+// key, as MergeOptions.MergeMaps does. The result has no Extends reference
+// and is validated.
+func (cs *ConfigSet) Resolve(name string) (*Config, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	config, ok := cs.configs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown config %q", name)
+	}
+
+	// Walk up to the root, then merge back down
+	chain := []*Config{config}
+	seen := map[string]bool{name: true}
+	path := []string{name}
+	for current := config; current.Extends != ""; {
+		parentName := current.Extends
+		path = append(path, parentName)
+		if seen[parentName] {
+			return nil, fmt.Errorf("config %q: extends cycle %s", name, strings.Join(path, " -> "))
+		}
+		if len(chain) > MaxExtendsDepth {
+			return nil, fmt.Errorf("config %q: extends chain deeper than %d", name, MaxExtendsDepth)
+		}
+		parent, ok := cs.configs[parentName]
+		if !ok {
+			return nil, fmt.Errorf("config %q extends unknown config %q", path[len(path)-2], parentName)
+		}
+		seen[parentName] = true
+		chain = append(chain, parent)
+		current = parent
+	}
+
+	resolved := chain[len(chain)-1].Clone()
+	for i := len(chain) - 2; i >= 0; i-- {
+		resolved = resolved.MergeWithOptions(chain[i], &MergeOptions{Present: cs.present[path[i]], MergeMaps: true})
+	}
+	resolved.Extends = ""
+
+	if err := resolved.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config for %s: %w", name, err)
+	}
+	return resolved, nil
+}
+
+// Validate resolves every configuration in the set, so cycles, dangling
+// Extends references and configurations that are invalid once resolved are
+// reported up front rather than when first used
+func (cs *ConfigSet) Validate() error {
+	names := cs.Names()
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := cs.Resolve(name); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Remove removes a configuration from the set
 func (cs *ConfigSet) Remove(name string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
 	delete(cs.configs, name)
+	delete(cs.present, name)
 }
 
 // Names returns all configuration names in the set
 func (cs *ConfigSet) Names() []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
 	names := make([]string, 0, len(cs.configs))
 	for name := range cs.configs {
 		names = append(names, name)
@@ -173,47 +803,93 @@ func (cs *ConfigSet) Names() []string {
 	return names
 }
 
-// LoadFromFile loads a configuration set from a JSON file
+// LoadFromFile loads a configuration set from a JSON file, or from YAML if
+// the file name ends in .yaml or .yml. Unknown fields are ignored and
+// reported by Warnings; LoadFromFileStrict rejects them.
 func (cs *ConfigSet) LoadFromFile(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("failed to open config set file: %w", err)
-	}
-	defer file.Close()
-	
-	var configs map[string]*Config
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&configs); err != nil {
-		return fmt.Errorf("failed to decode config set: %w", err)
-	}
-	
+	return cs.loadFromFile(filename, isYAMLFile(filename), false)
+}
+
+// addAll adds loaded configurations to the set, with the fields present in
+// each, and validates it
+func (cs *ConfigSet) addAll(configs map[string]*Config, present map[string]map[string]bool) error {
 	for name, config := range configs {
 		if err := cs.Add(name, config); err != nil {
 			return fmt.Errorf("failed to add config %s: %w", name, err)
 		}
+		if fields, ok := present[name]; ok {
+			cs.mu.Lock()
+			cs.present[name] = fields
+			cs.mu.Unlock()
+		}
 	}
 	
-	return nil
+	return cs.Validate()
 }
 
-// SaveToFile saves the configuration set to a JSON file
+// SaveToFile saves the configuration set to a JSON file, or to YAML if the
+// file name ends in .yaml or .yml, replacing it atomically as
+// Config.SaveToFile does
 func (cs *ConfigSet) SaveToFile(filename string) error {
-	file, err := os.Create(filename)
+	if isYAMLFile(filename) {
+		return cs.SaveYAMLToFile(filename)
+	}
+
+	return writeFileAtomic(filename, "config set file", cs.SaveToWriter)
+}
+
+// LoadFromReader loads a configuration set from JSON read from an
+// io.Reader, as LoadFromFile does from a file
+func (cs *ConfigSet) LoadFromReader(r io.Reader) error {
+	data, err := io.ReadAll(r)
 	if err != nil {
-		return fmt.Errorf("failed to create config set file: %w", err)
+		return fmt.Errorf("failed to read config set: %w", err)
 	}
-	defer file.Close()
-	
-	encoder := json.NewEncoder(file)
+
+	configs, present, err := decodeConfigSet(data, false, false)
+	if err != nil {
+		return err
+	}
+	return cs.addAll(configs, present)
+}
+
+// SaveToWriter saves the configuration set to an io.Writer as JSON
+func (cs *ConfigSet) SaveToWriter(w io.Writer) error {
+	encoder := json.NewEncoder(w)
 	encoder.SetIndent("", "  ")
-	
-	if err := encoder.Encode(cs.configs); err != nil {
+
+	if err := encoder.Encode(cs.encodable()); err != nil {
 		return fmt.Errorf("failed to encode config set: %w", err)
 	}
-	
+
 	return nil
 }
 
+// encodable returns the set as it is saved. A configuration that extends
+// another is saved with only the fields it sets or that are non-zero, so
+// the zero values of fields it leaves to its parent are not loaded back as
+// overrides.
+func (cs *ConfigSet) encodable() map[string]any {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	all := make(map[string]any, len(cs.configs))
+	for name, config := range cs.configs {
+		if config.Extends == "" {
+			all[name] = config.Clone()
+			continue
+		}
+		v := reflect.ValueOf(config.Clone()).Elem()
+		fields := make(map[string]any)
+		for _, f := range taggedFields(configType) {
+			if field := v.Field(f.index); cs.present[name][f.name] || !field.IsZero() {
+				fields[f.name] = field.Interface()
+			}
+		}
+		all[name] = fields
+	}
+	return all
+}
+
 // Builder provides a fluent interface for building configurations
 type Builder struct {
 	config *Config
@@ -226,6 +902,14 @@ func NewBuilder() *Builder {
 	}
 }
 
+// NewBuilderFrom creates a configuration builder starting from a copy of c,
+// to change a few fields of a loaded configuration. c is not modified.
+func NewBuilderFrom(c *Config) *Builder {
+	return &Builder{
+		config: c.Clone(),
+	}
+}
+
 // WithRate sets the rate
 func (b *Builder) WithRate(rate int) *Builder {
 	b.config.Rate = rate
@@ -286,10 +970,95 @@ func (b *Builder) WithCustomHeaders(headers map[string]string) *Builder {
 	return b
 }
 
+// WithResponseTemplate sets the denied response body template and its content type
+func (b *Builder) WithResponseTemplate(tmpl, contentType string) *Builder {
+	b.config.ResponseTemplate = tmpl
+	b.config.ResponseContentType = contentType
+	return b
+}
+
+// WithDocsURL sets the documentation URL available to response templates
+func (b *Builder) WithDocsURL(url string) *Builder {
+	b.config.DocsURL = url
+	return b
+}
+
+// WithErrorStatusCode sets the status of denied responses
+func (b *Builder) WithErrorStatusCode(code int) *Builder {
+	b.config.ErrorStatusCode = code
+	return b
+}
+
+// WithRetryAfterMode sets how denied responses get their Retry-After
+func (b *Builder) WithRetryAfterMode(mode string) *Builder {
+	b.config.RetryAfterMode = mode
+	return b
+}
+
+// WithLimits sets the window limits enforced instead of rate and burst
+func (b *Builder) WithLimits(limits ...WindowLimit) *Builder {
+	b.config.Limits = limits
+	return b
+}
+
+// WithAlgorithm sets the limiter algorithm
+func (b *Builder) WithAlgorithm(algorithm string) *Builder {
+	b.config.Algorithm = algorithm
+	return b
+}
+
+// WithAlgorithmParams sets the algorithm-specific settings
+func (b *Builder) WithAlgorithmParams(params map[string]any) *Builder {
+	b.config.AlgorithmParams = params
+	return b
+}
+
+// WithInitialTokens sets how many tokens new limiters start with
+func (b *Builder) WithInitialTokens(n int) *Builder {
+	b.config.InitialTokens = &n
+	return b
+}
+
+// WithMaxKeys caps the number of per-key limiters, rejecting new keys
+// beyond the cap instead of evicting old ones if rejectNew is set
+func (b *Builder) WithMaxKeys(n int, rejectNew bool) *Builder {
+	b.config.MaxKeys = n
+	b.config.RejectNewKeys = rejectNew
+	return b
+}
+
+// WithKeyTTL evicts per-key limiters idle for longer than ttl
+func (b *Builder) WithKeyTTL(ttl time.Duration) *Builder {
+	b.config.KeyTTL = ttl
+	return b
+}
+
+// WithDryRun makes the middleware evaluate limits without enforcing them
+func (b *Builder) WithDryRun(enabled bool) *Builder {
+	b.config.DryRun = enabled
+	return b
+}
+
+// WithCosts sets the per-request cost matchers and the default cost
+func (b *Builder) WithCosts(costs map[string]int, defaultCost int) *Builder {
+	b.config.Costs = costs
+	b.config.DefaultCost = defaultCost
+	return b
+}
+
 // Build validates and returns the configuration
 func (b *Builder) Build() (*Config, error) {
 	if err := b.config.Validate(); err != nil {
 		return nil, err
 	}
 	return b.config.Clone(), nil
+}
+
+// BuildAll is like Build but reports every validation error at once, as
+// ValidateAll does
+func (b *Builder) BuildAll() (*Config, error) {
+	if err := b.config.ValidateAll(); err != nil {
+		return nil, err
+	}
+	return b.config.Clone(), nil
 }
\ No newline at end of file
@@ -2,9 +2,12 @@ package config
 
 import (
 	"bytes"
+	"fmt"
+	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -98,6 +101,97 @@ func TestConfigValidate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "valid response template",
+			config: &Config{
+				Rate:             10,
+				Burst:            20,
+				ResponseTemplate: `{"limit":"{{.Limit}}","docs":{{json .DocsURL}}}`,
+			},
+			wantErr: false,
+		},
+		{
+			name: "response template syntax error",
+			config: &Config{
+				Rate:             10,
+				Burst:            20,
+				ResponseTemplate: "retry in {{.RetryAfterSeconds",
+			},
+			wantErr: true,
+			errMsg:  "invalid response template",
+		},
+		{
+			name: "window limits replace rate and burst",
+			config: &Config{
+				Limits: []WindowLimit{
+					{Count: 10, Window: time.Second},
+					{Count: 100, Window: time.Minute},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "window limit with zero count",
+			config: &Config{
+				Limits: []WindowLimit{{Count: 0, Window: time.Second}},
+			},
+			wantErr: true,
+			errMsg:  "limit count for window 1s must be positive",
+		},
+		{
+			name: "duplicate window limit",
+			config: &Config{
+				Limits: []WindowLimit{
+					{Count: 10, Window: time.Second},
+					{Count: 20, Window: time.Second},
+				},
+			},
+			wantErr: true,
+			errMsg:  "duplicate limit for window 1s",
+		},
+		{
+			name: "shorter window allows more than longer",
+			config: &Config{
+				Limits: []WindowLimit{
+					{Count: 100, Window: time.Minute},
+					{Count: 500, Window: time.Second},
+				},
+			},
+			wantErr: true,
+			errMsg:  "limit for window 1s allows more requests than limit for window 1m0s",
+		},
+		{
+			name: "response template unknown field",
+			config: &Config{
+				Rate:             10,
+				Burst:            20,
+				ResponseTemplate: "see {{.DocumentationURL}}",
+			},
+			wantErr: true,
+			errMsg:  "invalid response template",
+		},
+		{
+			name:   "service unavailable status",
+			config: &Config{Rate: 10, Burst: 20, ErrorStatusCode: 503, RetryAfterMode: "static:30"},
+		},
+		{
+			name:    "success status",
+			config:  &Config{Rate: 10, Burst: 20, ErrorStatusCode: 200},
+			wantErr: true,
+			errMsg:  "error status code 200 must be a 4xx or 5xx status",
+		},
+		{
+			name:    "unknown retry after mode",
+			config:  &Config{Rate: 10, Burst: 20, RetryAfterMode: "sometimes"},
+			wantErr: true,
+			errMsg:  `retry after mode "sometimes" must be "auto" or "static:" followed by seconds`,
+		},
+		{
+			name:    "negative static retry after",
+			config:  &Config{Rate: 10, Burst: 20, RetryAfterMode: "static:-1"},
+			wantErr: true,
+			errMsg:  "must give a non-negative number of seconds",
+		},
 	}
 	
 	for _, tt := range tests {
@@ -332,6 +426,97 @@ func TestConfigSet(t *testing.T) {
 	}
 }
 
+func TestConfigSetCopies(t *testing.T) {
+	cs := NewConfigSet()
+	config := &Config{Rate: 10, Burst: 20, ExcludedPaths: []string{"/health"}}
+	if err := cs.Add("api", config); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	config.Rate = 1
+	got, _ := cs.Get("api")
+	got.Burst = 2
+	got.ExcludedPaths[0] = "/metrics"
+	for name, c := range cs.All() {
+		c.Rate = 3
+		if name != "api" {
+			t.Errorf("Unexpected config %q", name)
+		}
+	}
+
+	if got, _ := cs.Get("api"); got.Rate != 10 || got.Burst != 20 || got.ExcludedPaths[0] != "/health" {
+		t.Errorf("Expected the stored config to be unchanged, got %+v", got)
+	}
+}
+
+func TestConfigSetRange(t *testing.T) {
+	cs := NewConfigSet()
+	for _, name := range []string{"c", "a", "b"} {
+		cs.Add(name, &Config{Rate: 10, Burst: 20})
+	}
+	if n := cs.Len(); n != 3 {
+		t.Errorf("Expected 3 configs, got %d", n)
+	}
+
+	var names []string
+	cs.Range(func(name string, c *Config) bool {
+		names = append(names, name)
+		// The callback may change the set
+		cs.Remove(name)
+		return name != "b"
+	})
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("Expected to range over a and b in order, got %v", names)
+	}
+	if n := cs.Len(); n != 1 {
+		t.Errorf("Expected 1 config left, got %d", n)
+	}
+}
+
+func TestConfigSetConcurrentAccess(t *testing.T) {
+	cs := NewConfigSet()
+	cs.Add("base", &Config{Rate: 10, Burst: 20, Enabled: true})
+	if err := cs.SetRoutes(&RouteTable{Default: "base"}); err != nil {
+		t.Fatalf("SetRoutes() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				name := fmt.Sprintf("tenant-%d", j%10)
+				cs.Add(name, &Config{Extends: "base", Rate: j%10 + 1})
+				if j%3 == 0 {
+					cs.Remove(name)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				name := fmt.Sprintf("tenant-%d", j%10)
+				if c, ok := cs.Get(name); ok && c.Extends != "base" {
+					t.Errorf("Unexpected config %+v", c)
+				}
+				if c, err := cs.Resolve(name); err == nil && c.Burst != 20 {
+					t.Errorf("Expected %s to resolve with burst 20, got %d", name, c.Burst)
+				}
+				cs.Range(func(string, *Config) bool { return true })
+				cs.Names()
+				cs.Len()
+				cs.Routes()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, ok := cs.Get("base"); !ok {
+		t.Error("Expected base to remain")
+	}
+}
+
 func TestConfigSetFileOperations(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "configset.json")
@@ -433,6 +618,66 @@ func TestBuilderInvalidConfig(t *testing.T) {
 	}
 }
 
+func TestBuilderBuildAll(t *testing.T) {
+	_, err := NewBuilder().
+		WithRate(-1).
+		WithBurst(0).
+		WithWindow(-time.Second).
+		WithErrorStatusCode(200).
+		BuildAll()
+	if err == nil {
+		t.Fatal("Expected errors for an invalid config")
+	}
+	want := []string{
+		"rate must be positive",
+		"burst must be positive",
+		"window must be non-negative",
+		"error status code 200 must be a 4xx or 5xx status",
+	}
+	if got := strings.Split(err.Error(), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected every problem reported, got %q", got)
+	}
+
+	// Build still stops at the first
+	if _, err := NewBuilder().WithRate(-1).WithBurst(0).Build(); err == nil || err.Error() != "rate must be positive" {
+		t.Errorf("Expected Build to report the first problem, got %v", err)
+	}
+
+	cfg, err := NewBuilder().WithMaxKeys(100, false).WithKeyTTL(time.Minute).WithAlgorithm("gcra").WithErrorStatusCode(503).BuildAll()
+	if err != nil {
+		t.Fatalf("BuildAll failed: %v", err)
+	}
+	if cfg.MaxKeys != 100 || cfg.KeyTTL != time.Minute || cfg.Algorithm != "gcra" || cfg.ErrorStatusCode != 503 {
+		t.Errorf("Expected the builder to set the fields, got %+v", cfg)
+	}
+	if err := (&Config{Rate: 1, Burst: 1, KeyTTL: -1}).Validate(); err == nil {
+		t.Error("Expected a negative key TTL to be rejected")
+	}
+}
+
+func TestNewBuilderFrom(t *testing.T) {
+	source := &Config{
+		Rate: 10, Burst: 20, Enabled: true, Name: "api",
+		ExcludedPaths: []string{"/health"}, CustomHeaders: map[string]string{"X-Team": "platform"},
+	}
+	original := source.Clone()
+
+	cfg, err := NewBuilderFrom(source).
+		WithRate(15).
+		WithExcludedPaths("/metrics").
+		WithCustomHeaders(map[string]string{"X-Env": "prod"}).
+		Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if cfg.Rate != 15 || cfg.Burst != 20 || cfg.Name != "api" || !cfg.Enabled {
+		t.Errorf("Expected the source's fields with the rate changed, got %+v", cfg)
+	}
+	if !reflect.DeepEqual(source, original) {
+		t.Errorf("Expected the source unchanged, got %+v", source)
+	}
+}
+
 func TestLoadFromFile(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "config.json")
@@ -475,4 +720,439 @@ func TestConfigSetAddNil(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error when adding nil config")
 	}
-}
\ No newline at end of file
+}
+
+func TestLoadResponseTemplate(t *testing.T) {
+	input := `{
+		"rate": 5,
+		"burst": 10,
+		"response_template": "limit {{.Limit}} see {{.DocsURL}}",
+		"response_content_type": "text/plain",
+		"docs_url": "https://example.com/limits"
+	}`
+
+	config, err := LoadFromReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+
+	tmpl, err := ParseResponseTemplate(config.ResponseTemplate)
+	if err != nil {
+		t.Fatalf("ParseResponseTemplate failed: %v", err)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, ResponseData{DocsURL: config.DocsURL}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if body.String() != "limit  see https://example.com/limits" {
+		t.Errorf("Expected missing limit to render empty, got %q", body.String())
+	}
+
+	if _, err := LoadFromReader(strings.NewReader(`{"rate":5,"burst":10,"response_template":"{{if}}"}`)); err == nil {
+		t.Error("Expected load to fail for an invalid response template")
+	}
+}
+
+func TestConfigLint(t *testing.T) {
+	config := &Config{
+		Limits: []WindowLimit{
+			{Count: 10, Window: time.Second},
+			{Count: 1000, Window: time.Minute},
+			{Count: 5000, Window: time.Hour},
+		},
+	}
+
+	warnings := config.Lint()
+	if len(warnings) != 1 {
+		t.Fatalf("Expected 1 warning, got %v", warnings)
+	}
+	if !strings.Contains(warnings[0], "1000 per 1m0s never binds") {
+		t.Errorf("Expected warning about the per-minute limit, got %q", warnings[0])
+	}
+
+	if warnings := DefaultConfig().Lint(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings for the default config, got %v", warnings)
+	}
+}
+
+func TestLoadWindowLimits(t *testing.T) {
+	input := `{"limits":[{"count":100,"window":60000000000},{"count":10,"window":1000000000}]}`
+
+	config, err := LoadFromReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	if len(config.Limits) != 2 || config.Limits[0].Count != 100 || config.Limits[1].Window != time.Second {
+		t.Errorf("Unexpected limits: %+v", config.Limits)
+	}
+
+	clone := config.Clone()
+	clone.Limits[0].Count = 1
+	if config.Limits[0].Count != 100 {
+		t.Error("Expected Clone to deep copy Limits")
+	}
+}
+
+func TestConfigSetResolve(t *testing.T) {
+	cs := NewConfigSet()
+	base := &Config{Rate: 10, Burst: 20, Enabled: true, ErrorMessage: "slow down", CustomHeaders: map[string]string{"X-Team": "core"}}
+	add := func(name string, c *Config) {
+		t.Helper()
+		if err := cs.Add(name, c); err != nil {
+			t.Fatalf("Add(%s) failed: %v", name, err)
+		}
+	}
+	add("base", base)
+	add("api", &Config{Extends: "base", Rate: 50, Burst: 100})
+	add("search", &Config{Extends: "api", ErrorMessage: "search limited", CustomHeaders: map[string]string{"X-Service": "search"}})
+	add("admin", &Config{Extends: "base", PerKeyLimits: true})
+
+	search, err := cs.Resolve("search")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if search.Rate != 50 || search.Burst != 100 || !search.Enabled || search.ErrorMessage != "search limited" || search.Extends != "" {
+		t.Errorf("Expected chain to merge down to search, got %+v", search)
+	}
+	if !reflect.DeepEqual(search.CustomHeaders, map[string]string{"X-Team": "core", "X-Service": "search"}) {
+		t.Errorf("Expected headers merged key by key, got %v", search.CustomHeaders)
+	}
+
+	// Diamond: both extend base without affecting each other or base
+	admin, err := cs.Resolve("admin")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if admin.Rate != 10 || !admin.PerKeyLimits || admin.ErrorMessage != "slow down" {
+		t.Errorf("Expected admin to inherit base, got %+v", admin)
+	}
+	if base.PerKeyLimits || base.Rate != 10 || len(base.CustomHeaders) != 1 {
+		t.Errorf("Expected base to be left untouched, got %+v", base)
+	}
+
+	if err := cs.Validate(); err != nil {
+		t.Errorf("Expected set to validate, got %v", err)
+	}
+}
+
+func TestConfigSetResolveErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs map[string]*Config
+		errMsg  string
+	}{
+		{
+			name: "cycle",
+			configs: map[string]*Config{
+				"a": {Extends: "b"},
+				"b": {Extends: "c"},
+				"c": {Extends: "a"},
+			},
+			errMsg: "extends cycle a -> b -> c -> a",
+		},
+		{
+			name:    "self reference",
+			configs: map[string]*Config{"a": {Extends: "a"}},
+			errMsg:  "extends cycle a -> a",
+		},
+		{
+			name: "missing parent",
+			configs: map[string]*Config{
+				"a": {Extends: "b"},
+				"b": {Extends: "gone"},
+			},
+			errMsg: `config "b" extends unknown config "gone"`,
+		},
+		{
+			name: "invalid once resolved",
+			configs: map[string]*Config{
+				"base": {Rate: 10, Burst: 20},
+				"a":    {Extends: "base", Rate: 50},
+			},
+			errMsg: "burst must be greater than or equal to rate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := NewConfigSet()
+			for name, c := range tt.configs {
+				if err := cs.Add(name, c); err != nil {
+					t.Fatalf("Add(%s) failed: %v", name, err)
+				}
+			}
+			_, err := cs.Resolve("a")
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+			}
+			if err := cs.Validate(); err == nil {
+				t.Error("Expected Validate to fail")
+			}
+		})
+	}
+}
+
+func TestConfigSetResolveDepthLimit(t *testing.T) {
+	cs := NewConfigSet()
+	cs.Add("c0", &Config{Rate: 1, Burst: 1})
+	for i := 1; i <= MaxExtendsDepth+1; i++ {
+		cs.Add(fmt.Sprintf("c%d", i), &Config{Extends: fmt.Sprintf("c%d", i-1)})
+	}
+
+	if _, err := cs.Resolve(fmt.Sprintf("c%d", MaxExtendsDepth)); err != nil {
+		t.Errorf("Expected a chain of %d to resolve, got %v", MaxExtendsDepth, err)
+	}
+	if _, err := cs.Resolve(fmt.Sprintf("c%d", MaxExtendsDepth+1)); err == nil || !strings.Contains(err.Error(), "deeper than") {
+		t.Errorf("Expected depth limit error, got %v", err)
+	}
+}
+
+func TestConfigSetLoadResolvesUpFront(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "set.json")
+	if err := os.WriteFile(path, []byte(`{
+		"base": {"rate": 10, "burst": 20, "enabled": true},
+		"api": {"extends": "missing"}
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := NewConfigSet().LoadFromFile(path)
+	if err == nil || !strings.Contains(err.Error(), `extends unknown config "missing"`) {
+		t.Errorf("Expected load to fail on the dangling reference, got %v", err)
+	}
+}
+
+func TestConfigSetLoadExtendsPresence(t *testing.T) {
+	data := `{
+		"base": {"rate": 10, "burst": 20, "enabled": true, "per_key_limits": true, "error_message": "slow down"},
+		"api": {"extends": "base", "rate": 50, "burst": 100, "per_key_limits": false},
+		"internal": {"extends": "api", "enabled": false, "error_message": ""}
+	}`
+	cs := NewConfigSet()
+	if err := cs.LoadFromReader(strings.NewReader(data)); err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	internal, err := cs.Resolve("internal")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if internal.Rate != 50 || internal.Burst != 100 || internal.Enabled || internal.PerKeyLimits || internal.ErrorMessage != "" {
+		t.Errorf("Expected explicit zero values to override the chain, got %+v", internal)
+	}
+
+	// Saving keeps the overrides without turning inherited fields into them
+	for _, name := range []string{"set.json", "set.yaml"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := cs.SaveToFile(path); err != nil {
+			t.Fatalf("%s: SaveToFile failed: %v", name, err)
+		}
+		loaded := NewConfigSet()
+		if err := loaded.LoadFromFile(path); err != nil {
+			t.Fatalf("%s: LoadFromFile failed: %v", name, err)
+		}
+		for _, configName := range []string{"api", "internal"} {
+			want, _ := cs.Resolve(configName)
+			got, err := loaded.Resolve(configName)
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: Expected %s to resolve as before saving, got %+v, %v", name, configName, got, err)
+			}
+		}
+	}
+
+	// Configs added directly override only their non-zero fields
+	cs.Add("internal", &Config{Extends: "api", ErrorMessage: "internal"})
+	if internal, _ := cs.Resolve("internal"); !internal.Enabled || internal.ErrorMessage != "internal" {
+		t.Errorf("Expected the added config to keep Merge semantics, got %+v", internal)
+	}
+
+	tests := []struct {
+		data   string
+		errMsg string
+	}{
+		{`{"a": {"extends": "b"}, "b": {"extends": "a"}}`, "extends cycle"},
+		{`{"a": {"extends": "b", "rate": 1, "burst": 1}}`, `extends unknown config "b"`},
+	}
+	for _, tt := range tests {
+		err := NewConfigSet().LoadFromReader(strings.NewReader(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+		}
+	}
+}
+
+func TestInitialTokens(t *testing.T) {
+	cfg, err := LoadFromReader(strings.NewReader(`{"rate": 10, "burst": 20, "initial_tokens": 0}`))
+	if err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	if cfg.InitialTokens == nil || *cfg.InitialTokens != 0 {
+		t.Fatalf("Expected initial_tokens 0 to be kept, got %v", cfg.InitialTokens)
+	}
+
+	clone := cfg.Clone()
+	*clone.InitialTokens = 5
+	if *cfg.InitialTokens != 0 {
+		t.Error("InitialTokens not deep copied")
+	}
+	if merged := cfg.Merge(&Config{InitialTokens: clone.InitialTokens}); *merged.InitialTokens != 5 {
+		t.Errorf("Expected the override's initial tokens, got %d", *merged.InitialTokens)
+	}
+
+	for _, initial := range []int{-1, 21} {
+		if _, err := NewBuilder().WithRate(10).WithBurst(20).WithInitialTokens(initial).Build(); err == nil {
+			t.Errorf("Expected initial tokens %d to be rejected", initial)
+		}
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	cfg, err := LoadFromReader(strings.NewReader(`{"rate": 10, "burst": 20, "per_key_limits": true, "max_keys": 1000, "reject_new_keys": true}`))
+	if err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	if cfg.MaxKeys != 1000 || !cfg.RejectNewKeys {
+		t.Errorf("Expected max_keys 1000 rejecting new keys, got %d and %v", cfg.MaxKeys, cfg.RejectNewKeys)
+	}
+	if merged := (&Config{MaxKeys: 10}).Merge(cfg); merged.MaxKeys != 1000 || !merged.RejectNewKeys {
+		t.Errorf("Expected the override's max keys, got %+v", merged)
+	}
+
+	if _, err := NewBuilder().WithRate(10).WithBurst(20).WithMaxKeys(-1, false).Build(); err == nil {
+		t.Error("Expected negative max keys to be rejected")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	cfg, err := LoadFromReader(strings.NewReader(`{"rate": 10, "burst": 20, "dry_run": true}`))
+	if err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	if !cfg.DryRun {
+		t.Error("Expected dry_run to be loaded")
+	}
+	if merged := (&Config{Rate: 1, Burst: 1}).Merge(cfg); !merged.DryRun {
+		t.Error("Expected the override to turn dry run on")
+	}
+	if built, _ := NewBuilder().WithDryRun(true).Build(); !built.DryRun {
+		t.Error("Expected the builder to set dry run")
+	}
+}
+
+func TestAlgorithm(t *testing.T) {
+	for _, algorithm := range []string{"", "token_bucket", "sliding_window", "gcra"} {
+		if err := (&Config{Rate: 1, Burst: 1, Algorithm: algorithm}).Validate(); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", algorithm, err)
+		}
+	}
+
+	err := (&Config{Rate: 1, Burst: 1, Algorithm: "leaky"}).Validate()
+	if err == nil || !strings.Contains(err.Error(), `unknown algorithm "leaky", available: gcra, sliding_window, token_bucket`) {
+		t.Errorf("Expected the error to list the available algorithms, got %v", err)
+	}
+
+	if err := RegisterAlgorithm("gcra"); err == nil {
+		t.Error("Expected registering a known algorithm to fail")
+	}
+	if err := RegisterAlgorithm(""); err == nil {
+		t.Error("Expected registering an empty name to fail")
+	}
+}
+
+func TestAlgorithmParams(t *testing.T) {
+	want := map[string]any{"leak": 2.5, "strict": true, "tiers": []any{"free", 1.0}}
+	cfg, err := LoadFromReader(strings.NewReader(`{"rate": 1, "burst": 1, "algorithm_params": {"leak": 2.5, "strict": true, "tiers": ["free", 1]}}`))
+	if err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.AlgorithmParams, want) {
+		t.Errorf("Expected params from JSON, got %v", cfg.AlgorithmParams)
+	}
+	yamlCfg, err := LoadYAMLFromReader(strings.NewReader("rate: 1\nburst: 1\nalgorithm_params:\n  leak: 2.5\n  strict: true\n  tiers: [free, 1]\n"))
+	if err != nil {
+		t.Fatalf("LoadYAMLFromReader failed: %v", err)
+	}
+	if !reflect.DeepEqual(yamlCfg.AlgorithmParams, want) {
+		t.Errorf("Expected YAML params to decode as JSON would, got %v", yamlCfg.AlgorithmParams)
+	}
+
+	clone := cfg.Clone()
+	clone.AlgorithmParams["tiers"].([]any)[0] = "paid"
+	if cfg.AlgorithmParams["tiers"].([]any)[0] != "free" {
+		t.Error("Expected Clone to copy nested params")
+	}
+
+	over := &Config{AlgorithmParams: map[string]any{"leak": 5.0}}
+	if merged := cfg.Merge(over); !reflect.DeepEqual(merged.AlgorithmParams, over.AlgorithmParams) {
+		t.Errorf("Expected params replaced, got %v", merged.AlgorithmParams)
+	}
+	merged := cfg.MergeWithOptions(over, &MergeOptions{MergeMaps: true})
+	if merged.AlgorithmParams["leak"] != 5.0 || merged.AlgorithmParams["strict"] != true {
+		t.Errorf("Expected params merged key by key, got %v", merged.AlgorithmParams)
+	}
+}
+
+func TestValidateCustomHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		errMsg  string
+	}{
+		{"valid", map[string]string{"X-Team": "platform", "X-Note": "a\tb", "X_Odd.Name~1": ""}, ""},
+		{"space in name", map[string]string{"X Team": "platform"}, `invalid custom header name "X Team"`},
+		{"colon in name", map[string]string{"X-Team:": "platform"}, "invalid custom header name"},
+		{"empty name", map[string]string{"": "platform"}, `invalid custom header name ""`},
+		{"non-ASCII name", map[string]string{"X-Équipe": "platform"}, "invalid custom header name"},
+		{"line break in value", map[string]string{"X-Team": "a\r\nSet-Cookie: x"}, "invalid value for custom header X-Team"},
+		{"NUL in value", map[string]string{"X-Team": "a\x00"}, "control character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Config{Rate: 1, Burst: 1, CustomHeaders: tt.headers}).Validate()
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Errorf("Unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateStrict(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		errMsg string
+	}{
+		{"valid", Config{Rate: 1, Burst: 1, Name: "api", ExcludedPaths: []string{"/health", "/health/*"}}, ""},
+		{"lenient checks first", Config{Rate: -1, Burst: 1}, "rate must be positive"},
+		{"long name", Config{Rate: 1, Burst: 1, Name: strings.Repeat("n", MaxNameLength+1)}, "name is 129 bytes, longer than 128"},
+		{"long error message", Config{Rate: 1, Burst: 1, ErrorMessage: strings.Repeat("m", MaxErrorMessageLength+1)}, "error message is 1025 bytes"},
+		{"duplicate path", Config{Rate: 1, Burst: 1, ExcludedPaths: []string{"/health", "/metrics", "/health"}}, `duplicate excluded path "/health" at index 2: same as index 0`},
+		{"duplicate IP", Config{Rate: 1, Burst: 1, ExcludedIPs: []string{"10.0.0.1", "10.0.0.1/32"}}, `duplicate excluded IP "10.0.0.1/32" at index 1`},
+		{"lint warning", Config{Limits: []WindowLimit{{Count: 10, Window: time.Second}, {Count: 1000, Window: time.Minute}}}, "never binds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.ValidateStrict()
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Errorf("Unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+			}
+			if tt.name != "lenient checks first" {
+				if err := tt.config.Validate(); err != nil {
+					t.Errorf("Expected Validate to accept what only ValidateStrict rejects, got %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CostTable maps requests to token costs. It is compiled from a Config's
+// Costs, whose keys are matchers of the form "[METHOD ]PATH": a matcher
+// without a method applies to every method, and a path ending in "*"
+// matches every path with that prefix.
+//
+// An exact path beats a prefix and a longer prefix beats a shorter one;
+// between equally specific matchers, one naming the method wins. Requests
+// no matcher covers cost the default.
+type CostTable struct {
+	defaultCost int
+	methods     map[string]*costNode
+	any         *costNode
+}
+
+// costNode is a node of a byte-wise prefix trie over paths
+type costNode struct {
+	children map[byte]*costNode
+	exact    int
+	prefix   int
+}
+
+// CompileCosts builds a CostTable from matchers and a default cost, which is
+// 1 if zero. It returns an error for malformed matchers, non-positive costs
+// and matchers that are duplicates once normalized.
+func CompileCosts(costs map[string]int, defaultCost int) (*CostTable, error) {
+	if defaultCost == 0 {
+		defaultCost = 1
+	}
+	if defaultCost < 0 {
+		return nil, errors.New("default cost must be positive")
+	}
+
+	t := &CostTable{defaultCost: defaultCost, methods: make(map[string]*costNode), any: &costNode{}}
+	seen := make(map[string]string, len(costs))
+	for matcher, cost := range costs {
+		method, path, err := parseCostMatcher(matcher)
+		if err != nil {
+			return nil, err
+		}
+		if cost <= 0 {
+			return nil, fmt.Errorf("cost for %q must be positive", matcher)
+		}
+		normalized := method + " " + path
+		if other, ok := seen[normalized]; ok {
+			// Report the pair in a stable order
+			first, second := min(other, matcher), max(other, matcher)
+			return nil, fmt.Errorf("duplicate cost matchers %q and %q", first, second)
+		}
+		seen[normalized] = matcher
+
+		root := t.any
+		if method != "" {
+			if root = t.methods[method]; root == nil {
+				root = &costNode{}
+				t.methods[method] = root
+			}
+		}
+		root.insert(path, cost)
+	}
+	return t, nil
+}
+
+// parseCostMatcher splits a matcher into an upper-case method, empty for
+// any method, and a path
+func parseCostMatcher(matcher string) (method, path string, err error) {
+	fields := strings.Fields(matcher)
+	switch len(fields) {
+	case 1:
+		path = fields[0]
+	case 2:
+		method, path = strings.ToUpper(fields[0]), fields[1]
+	default:
+		return "", "", fmt.Errorf("invalid cost matcher %q: expected \"[METHOD ]PATH\"", matcher)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("invalid cost matcher %q: path must start with /", matcher)
+	}
+	if strings.Contains(strings.TrimSuffix(path, "*"), "*") {
+		return "", "", fmt.Errorf("invalid cost matcher %q: * is only allowed at the end", matcher)
+	}
+	return method, path, nil
+}
+
+func (n *costNode) insert(path string, cost int) {
+	prefix := strings.HasSuffix(path, "*")
+	path = strings.TrimSuffix(path, "*")
+	for i := 0; i < len(path); i++ {
+		if n.children == nil {
+			n.children = make(map[byte]*costNode)
+		}
+		child := n.children[path[i]]
+		if child == nil {
+			child = &costNode{}
+			n.children[path[i]] = child
+		}
+		n = child
+	}
+	if prefix {
+		n.prefix = cost
+	} else {
+		n.exact = cost
+	}
+}
+
+// match returns the exact cost for path, or else the cost of the longest
+// matching prefix and its length. Costs are zero when nothing matches.
+func (n *costNode) match(path string) (exact, prefix, prefixLen int) {
+	prefixLen = -1
+	for i := 0; ; i++ {
+		if n.prefix > 0 {
+			prefix, prefixLen = n.prefix, i
+		}
+		if i == len(path) {
+			return n.exact, prefix, prefixLen
+		}
+		if n = n.children[path[i]]; n == nil {
+			return 0, prefix, prefixLen
+		}
+	}
+}
+
+// Cost returns the cost of a request with the given method and path
+func (t *CostTable) Cost(method, path string) int {
+	var methodExact, methodPrefix, methodLen int
+	methodLen = -1
+	if root := t.methods[strings.ToUpper(method)]; root != nil {
+		methodExact, methodPrefix, methodLen = root.match(path)
+	}
+	anyExact, anyPrefix, anyLen := t.any.match(path)
+
+	switch {
+	case methodExact > 0:
+		return methodExact
+	case anyExact > 0:
+		return anyExact
+	case methodLen >= 0 && methodLen >= anyLen:
+		return methodPrefix
+	case anyLen >= 0:
+		return anyPrefix
+	}
+	return t.defaultCost
+}
+
+// MaxCost returns the highest cost any request can have
+func (t *CostTable) MaxCost() int {
+	highest := t.defaultCost
+	var walk func(*costNode)
+	walk = func(n *costNode) {
+		highest = max(highest, n.exact, n.prefix)
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(t.any)
+	for _, root := range t.methods {
+		walk(root)
+	}
+	return highest
+}
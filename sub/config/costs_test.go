@@ -0,0 +1,132 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCostTablePrecedence(t *testing.T) {
+	table, err := CompileCosts(map[string]int{
+		"POST /v1/search":   10,
+		"/v1/search":        5,
+		"/v1/*":             2,
+		"GET /v1/reports/*": 4,
+		"/v1/reports/*":     3,
+		"/v1/reports/big*":  8,
+	}, 0)
+	if err != nil {
+		t.Fatalf("CompileCosts failed: %v", err)
+	}
+
+	tests := []struct {
+		method, path string
+		cost         int
+	}{
+		{"POST", "/v1/search", 10},       // method and exact path
+		{"post", "/v1/search", 10},       // methods are case-insensitive
+		{"GET", "/v1/search", 5},         // exact path beats method prefix
+		{"GET", "/v1/search/more", 2},    // only the prefix matches
+		{"GET", "/v1/reports/daily", 4},  // method prefix beats equal prefix
+		{"POST", "/v1/reports/daily", 3}, // longer prefix beats shorter
+		{"GET", "/v1/reports/bigone", 8}, // longest prefix beats method
+		{"GET", "/v2/anything", 1},       // default
+		{"GET", "/v1", 1},                // shorter than every prefix
+		{"DELETE", "/v1/", 2},            // prefix matches the empty rest
+	}
+	for _, tt := range tests {
+		if got := table.Cost(tt.method, tt.path); got != tt.cost {
+			t.Errorf("Cost(%s %s) = %d, expected %d", tt.method, tt.path, got, tt.cost)
+		}
+	}
+	if got := table.MaxCost(); got != 10 {
+		t.Errorf("Expected max cost 10, got %d", got)
+	}
+}
+
+func TestCompileCostsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		costs  map[string]int
+		errMsg string
+	}{
+		{"zero cost", map[string]int{"/a": 0}, "must be positive"},
+		{"duplicate after normalizing", map[string]int{"GET /a": 1, "get  /a": 2}, "duplicate cost matchers"},
+		{"relative path", map[string]int{"GET a": 1}, "must start with /"},
+		{"inner wildcard", map[string]int{"/a/*/b": 1}, "only allowed at the end"},
+		{"too many fields", map[string]int{"GET /a b": 1}, "invalid cost matcher"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileCosts(tt.costs, 1)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateCosts(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		errMsg string
+	}{
+		{
+			name:   "cost within burst",
+			config: &Config{Rate: 10, Burst: 20, Costs: map[string]int{"POST /search": 20}},
+		},
+		{
+			name:   "cost exceeds burst",
+			config: &Config{Rate: 10, Burst: 20, Costs: map[string]int{"POST /search": 25}},
+			errMsg: "cost 25 exceeds burst of 20",
+		},
+		{
+			name:   "default cost exceeds burst",
+			config: &Config{Rate: 1, Burst: 1, DefaultCost: 2},
+			errMsg: "cost 2 exceeds burst of 1",
+		},
+		{
+			name: "cost exceeds shortest window",
+			config: &Config{
+				Limits: []WindowLimit{{Count: 5, Window: time.Second}, {Count: 100, Window: time.Minute}},
+				Costs:  map[string]int{"/export": 6},
+			},
+			errMsg: "cost 6 exceeds limit for window 1s of 5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Errorf("Expected valid config, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestLoadCosts(t *testing.T) {
+	cfg, err := LoadFromReader(strings.NewReader(`{
+		"rate": 10, "burst": 20,
+		"costs": {"POST /v1/search": 10, "/v1/export*": 15},
+		"default_cost": 2
+	}`))
+	if err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	if cfg.Costs["POST /v1/search"] != 10 || cfg.DefaultCost != 2 {
+		t.Errorf("Expected costs to load, got %v default %d", cfg.Costs, cfg.DefaultCost)
+	}
+
+	clone := cfg.Clone()
+	clone.Costs["POST /v1/search"] = 1
+	if cfg.Costs["POST /v1/search"] != 10 {
+		t.Error("Expected Clone to copy costs")
+	}
+}
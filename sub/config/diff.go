@@ -0,0 +1,216 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChangeKind says how a FieldChange changed its field
+type ChangeKind int
+
+const (
+	// FieldChanged means the field, or the map entry Key, has a new value
+	FieldChanged ChangeKind = iota
+	// FieldAdded means a map entry, list item or optional value was added
+	FieldAdded
+	// FieldRemoved means a map entry, list item or optional value was
+	// removed
+	FieldRemoved
+)
+
+// FieldChange is one difference between two configs, as reported by Diff
+type FieldChange struct {
+	// Field is the config file name of the field, such as "rate"
+	Field string
+	// Key is the entry of a map field, such as a header name for
+	// "custom_headers", and empty for other fields
+	Key  string
+	Kind ChangeKind
+	// Old and New are the values before and after, formatted as in config
+	// files. Old is empty for an addition and New for a removal.
+	Old string
+	New string
+}
+
+// String formats the change for logs, such as "rate 100→250",
+// "excluded_paths +/debug" or "custom_headers -X-Team"
+func (fc FieldChange) String() string {
+	field := fc.Field
+	if fc.Key != "" {
+		field += "[" + fc.Key + "]"
+	}
+	switch fc.Kind {
+	case FieldAdded:
+		return field + " +" + fc.New
+	case FieldRemoved:
+		return field + " -" + fc.Old
+	}
+	return field + " " + quoteEmpty(fc.Old) + "→" + quoteEmpty(fc.New)
+}
+
+// quoteEmpty makes an empty value visible in a change
+func quoteEmpty(s string) string {
+	if s == "" {
+		return `""`
+	}
+	return s
+}
+
+// Equal reports whether c and other configure the same behavior, which is
+// when Diff finds no changes. Nil and empty lists and maps are equal. The
+// order of lists such as ExcludedPaths matters, even where the middleware
+// would behave the same either way, so a reordered list is a change.
+// Warnings are not compared.
+func (c *Config) Equal(other *Config) bool {
+	return len(c.Diff(other)) == 0
+}
+
+// Diff returns the changes from c to other field by field, in the order of
+// the fields of Config. Maps report each added, removed or changed entry,
+// and ExcludedPaths and ExcludedIPs each added or removed item; a list that
+// was only reordered is reported as changed as a whole. A nil config is
+// treated as the zero Config.
+func (c *Config) Diff(other *Config) []FieldChange {
+	if c == nil {
+		c = &Config{}
+	}
+	if other == nil {
+		other = &Config{}
+	}
+	before := reflect.ValueOf(c).Elem()
+	after := reflect.ValueOf(other).Elem()
+
+	var changes []FieldChange
+	for _, f := range taggedFields(configType) {
+		changes = append(changes, diffField(f.name, before.Field(f.index), after.Field(f.index))...)
+	}
+	return changes
+}
+
+// diffField compares the values of a field of two configs
+func diffField(name string, before, after reflect.Value) []FieldChange {
+	switch before.Kind() {
+	case reflect.Map:
+		return diffMap(name, before, after)
+	case reflect.Slice:
+		if before.Len() == 0 && after.Len() == 0 {
+			return nil
+		}
+		if before.Type().Elem().Kind() == reflect.String {
+			return diffStrings(name, before.Interface().([]string), after.Interface().([]string))
+		}
+	case reflect.Pointer:
+		switch {
+		case before.IsNil() && after.IsNil():
+			return nil
+		case before.IsNil():
+			return []FieldChange{{Field: name, Kind: FieldAdded, New: formatValue(after)}}
+		case after.IsNil():
+			return []FieldChange{{Field: name, Kind: FieldRemoved, Old: formatValue(before)}}
+		}
+		before, after = before.Elem(), after.Elem()
+	}
+	if reflect.DeepEqual(before.Interface(), after.Interface()) {
+		return nil
+	}
+	return []FieldChange{{Field: name, Kind: FieldChanged, Old: formatValue(before), New: formatValue(after)}}
+}
+
+// diffMap reports the entries of a map field that differ, in order of key
+func diffMap(name string, before, after reflect.Value) []FieldChange {
+	keys := make(map[string]reflect.Value)
+	for _, k := range before.MapKeys() {
+		keys[k.String()] = k
+	}
+	for _, k := range after.MapKeys() {
+		keys[k.String()] = k
+	}
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	var changes []FieldChange
+	for _, key := range names {
+		was, is := before.MapIndex(keys[key]), after.MapIndex(keys[key])
+		switch {
+		case !was.IsValid():
+			changes = append(changes, FieldChange{Field: name, Key: key, Kind: FieldAdded, New: formatValue(is)})
+		case !is.IsValid():
+			changes = append(changes, FieldChange{Field: name, Key: key, Kind: FieldRemoved, Old: formatValue(was)})
+		case !reflect.DeepEqual(was.Interface(), is.Interface()):
+			changes = append(changes, FieldChange{Field: name, Key: key, Kind: FieldChanged, Old: formatValue(was), New: formatValue(is)})
+		}
+	}
+	return changes
+}
+
+// diffStrings reports the items removed from and added to a list, or the
+// whole list if only its order changed
+func diffStrings(name string, before, after []string) []FieldChange {
+	remaining := make(map[string]int, len(after))
+	for _, item := range after {
+		remaining[item]++
+	}
+	var changes []FieldChange
+	for _, item := range before {
+		if remaining[item] > 0 {
+			remaining[item]--
+			continue
+		}
+		changes = append(changes, FieldChange{Field: name, Kind: FieldRemoved, Old: item})
+	}
+
+	existing := make(map[string]int, len(before))
+	for _, item := range before {
+		existing[item]++
+	}
+	for _, item := range after {
+		if existing[item] > 0 {
+			existing[item]--
+			continue
+		}
+		changes = append(changes, FieldChange{Field: name, Kind: FieldAdded, New: item})
+	}
+
+	if len(changes) == 0 && !reflect.DeepEqual(before, after) {
+		changes = append(changes, FieldChange{
+			Field: name,
+			Kind:  FieldChanged,
+			Old:   "[" + strings.Join(before, ", ") + "]",
+			New:   "[" + strings.Join(after, ", ") + "]",
+		})
+	}
+	return changes
+}
+
+// formatValue formats a field value for a FieldChange
+func formatValue(v reflect.Value) string {
+	v = indirectYAML(v)
+	if !v.IsValid() {
+		return ""
+	}
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Type() == reflect.TypeOf(WindowLimit{}):
+		limit := v.Interface().(WindowLimit)
+		return strconv.Itoa(limit.Count) + "/" + limit.Window.String()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatValue(v.Index(i))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Pointer, reflect.Interface:
+		return "null"
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigDiff(t *testing.T) {
+	zero, five := 0, 5
+	tests := []struct {
+		name   string
+		before *Config
+		after  *Config
+		want   []string
+	}{
+		{"equal", &Config{Rate: 10, Burst: 20}, &Config{Rate: 10, Burst: 20}, nil},
+		{"int", &Config{Rate: 100}, &Config{Rate: 250}, []string{"rate 100→250"}},
+		{"bool", &Config{Enabled: true}, &Config{}, []string{"enabled true→false"}},
+		{"string", &Config{}, &Config{ErrorMessage: "slow down"}, []string{`error_message ""→slow down`}},
+		{"duration", &Config{Window: time.Second}, &Config{Window: time.Minute}, []string{"window 1s→1m0s"}},
+		{"pointer added", &Config{}, &Config{InitialTokens: &zero}, []string{"initial_tokens +0"}},
+		{"pointer changed", &Config{InitialTokens: &zero}, &Config{InitialTokens: &five}, []string{"initial_tokens 0→5"}},
+		{"pointer removed", &Config{InitialTokens: &five}, &Config{}, []string{"initial_tokens -5"}},
+		{"pointer to zero", &Config{InitialTokens: &five}, &Config{InitialTokens: new(int)}, []string{"initial_tokens 5→0"}},
+		{"nil and empty slices", &Config{ExcludedPaths: nil}, &Config{ExcludedPaths: []string{}}, nil},
+		{
+			"slice items",
+			&Config{ExcludedPaths: []string{"/health", "/metrics"}},
+			&Config{ExcludedPaths: []string{"/health", "/debug"}},
+			[]string{"excluded_paths -/metrics", "excluded_paths +/debug"},
+		},
+		{
+			"slice order",
+			&Config{ExcludedIPs: []string{"10.0.0.1", "10.0.0.2"}},
+			&Config{ExcludedIPs: []string{"10.0.0.2", "10.0.0.1"}},
+			[]string{"excluded_ips [10.0.0.1, 10.0.0.2]→[10.0.0.2, 10.0.0.1]"},
+		},
+		{
+			"struct slice",
+			&Config{Limits: []WindowLimit{{Count: 10, Window: time.Second}}},
+			&Config{Limits: []WindowLimit{{Count: 10, Window: time.Second}, {Count: 100, Window: time.Minute}}},
+			[]string{"limits [10/1s]→[10/1s, 100/1m0s]"},
+		},
+		{"nil and empty maps", &Config{CustomHeaders: nil}, &Config{CustomHeaders: map[string]string{}}, nil},
+		{
+			"map entries",
+			&Config{CustomHeaders: map[string]string{"X-Team": "core", "X-Tier": "free", "X-Old": "1"}},
+			&Config{CustomHeaders: map[string]string{"X-Team": "core", "X-Tier": "pro", "X-New": "2"}},
+			[]string{"custom_headers[X-New] +2", "custom_headers[X-Old] -1", "custom_headers[X-Tier] free→pro"},
+		},
+		{"int map", &Config{Costs: map[string]int{"/search": 5}}, &Config{Costs: map[string]int{"/search": 10}}, []string{"costs[/search] 5→10"}},
+		{
+			"any map",
+			&Config{AlgorithmParams: map[string]any{"levels": []any{1.0, 2.0}}},
+			&Config{AlgorithmParams: map[string]any{"levels": []any{1.0, 3.0}, "mode": nil}},
+			[]string{"algorithm_params[levels] [1, 2]→[1, 3]", "algorithm_params[mode] +null"},
+		},
+		{"nil config", nil, &Config{Rate: 1}, []string{"rate 0→1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, change := range tt.before.Diff(tt.after) {
+				got = append(got, change.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected changes %q, got %q", tt.want, got)
+			}
+			if equal := tt.before.Equal(tt.after); equal != (len(tt.want) == 0) {
+				t.Errorf("Expected Equal to be %v", !equal)
+			}
+		})
+	}
+}
+
+func TestConfigDiffFields(t *testing.T) {
+	changes := (&Config{CustomHeaders: map[string]string{"X-Team": "core"}}).Diff(&Config{})
+	want := []FieldChange{{Field: "custom_headers", Key: "X-Team", Kind: FieldRemoved, Old: "core"}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Expected %+v, got %+v", want, changes)
+	}
+
+	// Warnings are not part of the config's behavior
+	if !(&Config{Rate: 1, warnings: []string{"unknown field"}}).Equal(&Config{Rate: 1}) {
+		t.Error("Expected warnings to be ignored")
+	}
+}
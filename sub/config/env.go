@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadFromEnv loads configuration from environment variables named after
+// the JSON fields, upper-cased under prefix: with prefix "RATELIMIT",
+// RATELIMIT_RATE sets Rate and RATELIMIT_EXCLUDED_PATHS sets ExcludedPaths.
+// Variables that are unset or empty keep the defaults.
+//
+// Values are parsed by field type:
+//   - lists are comma-separated, as in RATELIMIT_EXCLUDED_PATHS=/health,/metrics
+//   - custom_headers and costs are key=value pairs separated by semicolons,
+//     as in RATELIMIT_CUSTOM_HEADERS=X-Team=platform;X-Tier=free
+//   - limits are count/window pairs, as in RATELIMIT_LIMITS=10/1s,1000/1h
+//   - window is a duration such as 2s or 1m
+//   - booleans take the forms strconv.ParseBool accepts
+func LoadFromEnv(prefix string) (*Config, error) {
+	config := DefaultConfig()
+
+	if err := config.applyEnv(prefix); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return config, nil
+}
+
+// LoadFromFileWithEnvOverride loads configuration from a file, as
+// LoadFromFile does, and then applies the environment variables LoadFromEnv
+// reads on top of it
+func LoadFromFileWithEnvOverride(filename, prefix string) (*Config, error) {
+	config, err := LoadFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.applyEnv(prefix); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return config, nil
+}
+
+// envName returns the environment variable LoadFromEnv reads a field from,
+// given the field's JSON name
+func envName(prefix, field string) string {
+	name := strings.ToUpper(field)
+	if prefix == "" {
+		return name
+	}
+	return strings.TrimSuffix(prefix, "_") + "_" + name
+}
+
+// applyEnv sets the fields whose environment variables are set
+func (c *Config) applyEnv(prefix string) error {
+	v := reflect.ValueOf(c).Elem()
+	for _, f := range taggedFields(v.Type()) {
+		name := envName(prefix, f.name)
+		value := strings.TrimSpace(os.Getenv(name))
+		if value == "" {
+			continue
+		}
+		if err := setFromEnv(v.Field(f.index), value); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+var windowLimitsType = reflect.TypeOf([]WindowLimit(nil))
+
+// setFromEnv parses value into field
+func setFromEnv(field reflect.Value, value string) error {
+	switch field.Type() {
+	case durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 2s or 1m", value)
+		}
+		field.SetInt(int64(d))
+		return nil
+	case windowLimitsType:
+		var limits []WindowLimit
+		for _, item := range splitEnvList(value, ",") {
+			count, window, ok := strings.Cut(item, "/")
+			if !ok {
+				return fmt.Errorf("%q is not a count/window pair such as 10/1s", item)
+			}
+			n, err := parseEnvInt(count)
+			if err != nil {
+				return err
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(window))
+			if err != nil {
+				return fmt.Errorf("%q is not a duration such as 2s or 1m", strings.TrimSpace(window))
+			}
+			limits = append(limits, WindowLimit{Count: n, Window: d})
+		}
+		field.Set(reflect.ValueOf(limits))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := parseEnvInt(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(b)
+	case reflect.Pointer:
+		elem := reflect.New(field.Type().Elem())
+		if err := setFromEnv(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.Slice:
+		field.Set(reflect.ValueOf(splitEnvList(value, ",")).Convert(field.Type()))
+	case reflect.Map:
+		m := reflect.MakeMap(field.Type())
+		for _, pair := range splitEnvList(value, ";") {
+			key, item, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%q is not a key=value pair", pair)
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setFromEnv(elem, strings.TrimSpace(item)); err != nil {
+				return fmt.Errorf("%s: %w", strings.TrimSpace(key), err)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(key)), elem)
+		}
+		field.Set(m)
+	case reflect.Interface:
+		// As JSON would decode it: a number, a boolean or else a string
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			field.Set(reflect.ValueOf(f))
+		} else if b, err := strconv.ParseBool(value); err == nil {
+			field.Set(reflect.ValueOf(b))
+		} else {
+			field.Set(reflect.ValueOf(value))
+		}
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+func parseEnvInt(value string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an integer", strings.TrimSpace(value))
+	}
+	return n, nil
+}
+
+// splitEnvList splits value at sep, dropping blank items
+func splitEnvList(value, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(value, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
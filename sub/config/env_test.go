@@ -0,0 +1,202 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(t *testing.T, c *Config)
+	}{
+		{
+			name: "rate and burst",
+			env:  map[string]string{"RATELIMIT_RATE": "100", "RATELIMIT_BURST": " 200 "},
+			check: func(t *testing.T, c *Config) {
+				if c.Rate != 100 || c.Burst != 200 {
+					t.Errorf("Expected Rate 100 and Burst 200, got %d and %d", c.Rate, c.Burst)
+				}
+			},
+		},
+		{
+			name: "window and strings",
+			env: map[string]string{
+				"RATELIMIT_WINDOW":                "1m",
+				"RATELIMIT_NAME":                  "api",
+				"RATELIMIT_ERROR_MESSAGE":         "Slow down",
+				"RATELIMIT_RESPONSE_TEMPLATE":     `{"docs": {{json .DocsURL}}}`,
+				"RATELIMIT_RESPONSE_CONTENT_TYPE": "application/json",
+				"RATELIMIT_DOCS_URL":              "https://example.com/limits",
+				"RATELIMIT_ALGORITHM":             "gcra",
+			},
+			check: func(t *testing.T, c *Config) {
+				if c.Window != time.Minute || c.Name != "api" || c.ErrorMessage != "Slow down" {
+					t.Errorf("Unexpected window, name or message: %v, %q, %q", c.Window, c.Name, c.ErrorMessage)
+				}
+				if c.ResponseTemplate != `{"docs": {{json .DocsURL}}}` || c.ResponseContentType != "application/json" {
+					t.Errorf("Unexpected response template %q of type %q", c.ResponseTemplate, c.ResponseContentType)
+				}
+				if c.DocsURL != "https://example.com/limits" || c.Algorithm != "gcra" {
+					t.Errorf("Unexpected docs URL or algorithm: %q, %q", c.DocsURL, c.Algorithm)
+				}
+			},
+		},
+		{
+			name: "booleans",
+			env: map[string]string{
+				"RATELIMIT_ENABLED":         "false",
+				"RATELIMIT_PER_KEY_LIMITS":  "1",
+				"RATELIMIT_MAX_KEYS":        "1000",
+				"RATELIMIT_REJECT_NEW_KEYS": "TRUE",
+				"RATELIMIT_DRY_RUN":         "t",
+			},
+			check: func(t *testing.T, c *Config) {
+				if c.Enabled || !c.PerKeyLimits || !c.RejectNewKeys || !c.DryRun {
+					t.Errorf("Unexpected booleans %+v", c)
+				}
+				if c.MaxKeys != 1000 {
+					t.Errorf("Expected MaxKeys 1000, got %d", c.MaxKeys)
+				}
+			},
+		},
+		{
+			name: "lists",
+			env: map[string]string{
+				"RATELIMIT_EXCLUDED_PATHS": "/health,/metrics",
+				"RATELIMIT_EXCLUDED_IPS":   "10.0.0.0/8, 127.0.0.1,",
+			},
+			check: func(t *testing.T, c *Config) {
+				if !reflect.DeepEqual(c.ExcludedPaths, []string{"/health", "/metrics"}) {
+					t.Errorf("Unexpected ExcludedPaths %q", c.ExcludedPaths)
+				}
+				if !reflect.DeepEqual(c.ExcludedIPs, []string{"10.0.0.0/8", "127.0.0.1"}) {
+					t.Errorf("Unexpected ExcludedIPs %q", c.ExcludedIPs)
+				}
+			},
+		},
+		{
+			name: "maps",
+			env: map[string]string{
+				"RATELIMIT_CUSTOM_HEADERS": "X-Team=platform; X-Query=a=b",
+				"RATELIMIT_COSTS":          "GET /api/search=5;/api/export*=8",
+				"RATELIMIT_DEFAULT_COST":   "2",
+			},
+			check: func(t *testing.T, c *Config) {
+				if !reflect.DeepEqual(c.CustomHeaders, map[string]string{"X-Team": "platform", "X-Query": "a=b"}) {
+					t.Errorf("Unexpected CustomHeaders %v", c.CustomHeaders)
+				}
+				if !reflect.DeepEqual(c.Costs, map[string]int{"GET /api/search": 5, "/api/export*": 8}) {
+					t.Errorf("Unexpected Costs %v", c.Costs)
+				}
+				if c.DefaultCost != 2 {
+					t.Errorf("Expected DefaultCost 2, got %d", c.DefaultCost)
+				}
+			},
+		},
+		{
+			name: "window limits",
+			env:  map[string]string{"RATELIMIT_LIMITS": "10/1s, 1000/1h"},
+			check: func(t *testing.T, c *Config) {
+				want := []WindowLimit{{Count: 10, Window: time.Second}, {Count: 1000, Window: time.Hour}}
+				if !reflect.DeepEqual(c.Limits, want) {
+					t.Errorf("Expected limits %v, got %v", want, c.Limits)
+				}
+			},
+		},
+		{
+			name: "initial tokens",
+			env:  map[string]string{"RATELIMIT_INITIAL_TOKENS": "0"},
+			check: func(t *testing.T, c *Config) {
+				if c.InitialTokens == nil || *c.InitialTokens != 0 {
+					t.Errorf("Expected InitialTokens 0, got %v", c.InitialTokens)
+				}
+			},
+		},
+		{
+			name: "unset and empty variables use defaults",
+			env:  map[string]string{"RATELIMIT_RATE": "", "RATELIMIT_WINDOW": "  "},
+			check: func(t *testing.T, c *Config) {
+				if !reflect.DeepEqual(c, DefaultConfig()) {
+					t.Errorf("Expected the default config, got %+v", c)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			config, err := LoadFromEnv("RATELIMIT")
+			if err != nil {
+				t.Fatalf("LoadFromEnv() error = %v", err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestLoadFromEnvErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"integer", map[string]string{"RATELIMIT_RATE": "fast"}, `invalid RATELIMIT_RATE: "fast" is not an integer`},
+		{"boolean", map[string]string{"RATELIMIT_ENABLED": "maybe"}, `invalid RATELIMIT_ENABLED: "maybe" is not a boolean`},
+		{"duration", map[string]string{"RATELIMIT_WINDOW": "60"}, `invalid RATELIMIT_WINDOW: "60" is not a duration`},
+		{"pair", map[string]string{"RATELIMIT_CUSTOM_HEADERS": "X-Team"}, `invalid RATELIMIT_CUSTOM_HEADERS: "X-Team" is not a key=value pair`},
+		{"cost", map[string]string{"RATELIMIT_COSTS": "/api=lots"}, `invalid RATELIMIT_COSTS: /api: "lots" is not an integer`},
+		{"limit", map[string]string{"RATELIMIT_LIMITS": "10"}, `invalid RATELIMIT_LIMITS: "10" is not a count/window pair`},
+		{"limit window", map[string]string{"RATELIMIT_LIMITS": "10/soon"}, `invalid RATELIMIT_LIMITS: "soon" is not a duration`},
+		{"initial tokens", map[string]string{"RATELIMIT_INITIAL_TOKENS": "full"}, `invalid RATELIMIT_INITIAL_TOKENS: "full" is not an integer`},
+		{"validation", map[string]string{"RATELIMIT_RATE": "50", "RATELIMIT_BURST": "10"}, "invalid config: burst must be greater than or equal to rate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			_, err := LoadFromEnv("RATELIMIT_")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadFromFileWithEnvOverride(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte("rate: 10\nburst: 20\nname: file\nexcluded_paths: [/health]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("APP_BURST", "50")
+	t.Setenv("APP_EXCLUDED_PATHS", "/health,/metrics")
+
+	config, err := LoadFromFileWithEnvOverride(filename, "APP")
+	if err != nil {
+		t.Fatalf("LoadFromFileWithEnvOverride() error = %v", err)
+	}
+	if config.Rate != 10 || config.Burst != 50 || config.Name != "file" {
+		t.Errorf("Expected the file's rate and name with the burst from the environment, got %+v", config)
+	}
+	if !reflect.DeepEqual(config.ExcludedPaths, []string{"/health", "/metrics"}) {
+		t.Errorf("Expected the environment to replace ExcludedPaths, got %q", config.ExcludedPaths)
+	}
+
+	t.Setenv("APP_RATE", "100")
+	if _, err := LoadFromFileWithEnvOverride(filename, "APP"); err == nil || !strings.Contains(err.Error(), "invalid config") {
+		t.Errorf("Expected the overridden config to be validated, got %v", err)
+	}
+	if _, err := LoadFromFileWithEnvOverride("/non/existent/file.json", "APP"); err == nil {
+		t.Error("Expected error for non-existent file")
+	}
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"strings"
+)
+
+// Exclusions decides which requests skip rate limiting. It is compiled from
+// a Config's ExcludedPaths and ExcludedIPs.
+//
+// A path pattern ending in "*" matches every path with that prefix, one
+// containing any other wildcard is matched as a path.Match glob, so
+// "/users/*/avatar" matches a single segment, and any other pattern matches
+// only that exact path. IP entries are single addresses, such as
+// "127.0.0.1" or "::1", or CIDR blocks, such as "10.0.0.0/8".
+type Exclusions struct {
+	exact    map[string]bool
+	prefixes []string
+	globs    []string
+	networks []netip.Prefix
+}
+
+// CompileExclusions builds Exclusions from path patterns and IP entries. It
+// returns an error naming the first malformed pattern or entry and its
+// index.
+func CompileExclusions(paths, ips []string) (*Exclusions, error) {
+	e := &Exclusions{exact: make(map[string]bool)}
+	for i, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("invalid excluded path %q at index %d: must start with /", p, i)
+		}
+		prefix, isPrefix := strings.CutSuffix(p, "*")
+		switch {
+		case isPrefix && !strings.ContainsAny(prefix, "*?[\\"):
+			e.prefixes = append(e.prefixes, prefix)
+		case strings.ContainsAny(p, "*?[\\"):
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid excluded path %q at index %d: %w", p, i, err)
+			}
+			e.globs = append(e.globs, p)
+		default:
+			e.exact[p] = true
+		}
+	}
+	for i, ip := range ips {
+		network, err := parseNetwork(ip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid excluded IP %q at index %d: %w", ip, i, err)
+		}
+		e.networks = append(e.networks, network)
+	}
+	return e, nil
+}
+
+// parseNetwork parses an address or CIDR block, treating an address as a
+// block of one
+func parseNetwork(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		network, err := netip.ParsePrefix(s)
+		return network.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ExcludesPath reports whether requests for path skip rate limiting
+func (e *Exclusions) ExcludesPath(p string) bool {
+	if e.exact[p] {
+		return true
+	}
+	for _, prefix := range e.prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	for _, glob := range e.globs {
+		if ok, _ := path.Match(glob, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ExcludesIP reports whether requests from ip skip rate limiting. IPv4
+// addresses embedded in IPv6, such as ::ffff:10.0.0.1, match IPv4 entries.
+func (e *Exclusions) ExcludesIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, network := range e.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
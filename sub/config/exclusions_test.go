@@ -0,0 +1,82 @@
+package config
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestExclusions(t *testing.T) {
+	e, err := CompileExclusions(
+		[]string{"/health", "/static/*", "/users/*/avatar"},
+		[]string{"127.0.0.1", "10.0.0.0/8", "2001:db8::/32"},
+	)
+	if err != nil {
+		t.Fatalf("CompileExclusions failed: %v", err)
+	}
+
+	paths := []struct {
+		path     string
+		excluded bool
+	}{
+		{"/health", true},
+		{"/health/deep", false}, // exact patterns match only themselves
+		{"/static/", true},
+		{"/static/css/site.css", true},
+		{"/static", false},
+		{"/users/42/avatar", true},
+		{"/users/42/posts", false},
+		{"/users/42/7/avatar", false}, // globs match a single segment
+		{"/api", false},
+	}
+	for _, tt := range paths {
+		if got := e.ExcludesPath(tt.path); got != tt.excluded {
+			t.Errorf("ExcludesPath(%q) = %v, expected %v", tt.path, got, tt.excluded)
+		}
+	}
+
+	ips := []struct {
+		ip       string
+		excluded bool
+	}{
+		{"127.0.0.1", true},
+		{"127.0.0.2", false},
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"2001:db8::1", true},
+		{"::1", false},
+	}
+	for _, tt := range ips {
+		if got := e.ExcludesIP(netip.MustParseAddr(tt.ip)); got != tt.excluded {
+			t.Errorf("ExcludesIP(%s) = %v, expected %v", tt.ip, got, tt.excluded)
+		}
+	}
+}
+
+func TestCompileExclusionsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		paths  []string
+		ips    []string
+		errMsg string
+	}{
+		{"relative path", []string{"health"}, nil, "must start with /"},
+		{"bad glob", []string{"/health", "/a/[b"}, nil, `invalid excluded path "/a/[b" at index 1`},
+		{"bad IP", nil, []string{"localhost"}, `invalid excluded IP "localhost" at index 0`},
+		{"bad CIDR", nil, []string{"10.0.0.0/8", "::1", "10.0.0.0/33"}, `invalid excluded IP "10.0.0.0/33" at index 2`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileExclusions(tt.paths, tt.ips)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+
+	cfg := &Config{Rate: 1, Burst: 1, ExcludedIPs: []string{"not an ip"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected Validate to reject a malformed excluded IP")
+	}
+}
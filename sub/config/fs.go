@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// LoadFromFS adds the configurations of every file in fsys matching glob,
+// such as "limits/*.yaml", to the set, so configs can be embedded with
+// go:embed or split across files. A file holding a single configuration,
+// one whose top-level keys include a Config field such as "rate", adds it
+// under the file's base name, "api" for "limits/api.yaml". Any other file
+// is a configuration set, as with LoadFromFile. Files are JSON or YAML by
+// extension; directories are skipped. Two files defining the same name is
+// an error, and the set is validated once every file is added, so configs
+// may extend configs from other files.
+func (cs *ConfigSet) LoadFromFS(fsys fs.FS, glob string) error {
+	files, err := fs.Glob(fsys, glob)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no config files match %q", glob)
+	}
+
+	configs := make(map[string]*Config)
+	present := make(map[string]map[string]bool)
+	sources := make(map[string]string)
+	for _, filename := range files {
+		if info, err := fs.Stat(fsys, filename); err == nil && info.IsDir() {
+			continue
+		}
+		loaded, fields, err := loadFSFile(fsys, filename)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		for name, config := range loaded {
+			if source, ok := sources[name]; ok {
+				return fmt.Errorf("config %q is defined in both %s and %s", name, source, filename)
+			}
+			configs[name] = config
+			present[name] = fields[name]
+			sources[name] = filename
+		}
+	}
+
+	return cs.addAll(configs, present)
+}
+
+// loadFSFile decodes a file of LoadFromFS into the configurations it
+// defines by name, with the fields present in each
+func loadFSFile(fsys fs.FS, filename string) (map[string]*Config, map[string]map[string]bool, error) {
+	data, err := fs.ReadFile(fsys, filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	yaml := isYAMLFile(filename)
+
+	var keys []string
+	if yaml {
+		node, err := parseYAML(string(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode config: yaml: %w", err)
+		}
+		if node != nil {
+			keys = node.keys
+		}
+	} else {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode config: %w", err)
+		}
+		for key := range raw {
+			keys = append(keys, key)
+		}
+	}
+
+	fields := taggedFields(reflect.TypeOf(Config{}))
+	for _, key := range keys {
+		if _, ok := lookupTaggedField(fields, key); !ok {
+			continue
+		}
+		config := &Config{}
+		if yaml {
+			config.warnings, err = decodeYAMLConfig(data, config, false)
+		} else {
+			config.warnings, err = decodeJSONConfig(data, config, false)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode config: %w", err)
+		}
+		name := strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+		return map[string]*Config{name: config}, map[string]map[string]bool{name: presentFields(keys)}, nil
+	}
+
+	return decodeConfigSet(data, yaml, false)
+}
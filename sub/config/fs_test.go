@@ -0,0 +1,80 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestConfigSetReaderWriter(t *testing.T) {
+	cs := NewConfigSet()
+	cs.Add("default", &Config{Rate: 10, Burst: 20, Enabled: true})
+	cs.Add("premium", &Config{Extends: "default", Rate: 100, Burst: 200})
+
+	var buf bytes.Buffer
+	if err := cs.SaveToWriter(&buf); err != nil {
+		t.Fatalf("SaveToWriter failed: %v", err)
+	}
+	loaded := NewConfigSet()
+	if err := loaded.LoadFromReader(&buf); err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.All(), cs.All()) {
+		t.Errorf("Expected the set to round trip, got %v", loaded.All())
+	}
+
+	if err := NewConfigSet().LoadFromReader(strings.NewReader(`{"a": {"rate": -1}}`)); err == nil {
+		t.Error("Expected an invalid config to be rejected")
+	}
+}
+
+func TestConfigSetLoadFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"limits/api.yaml":   {Data: []byte("rate: 10\nburst: 20\nenabled: true\n")},
+		"limits/web.json":   {Data: []byte(`{"rate": 5, "burst": 5, "enabled": true}`)},
+		"limits/tiers.json": {Data: []byte(`{"free": {"extends": "api", "burst": 10}, "pro": {"extends": "web", "rate": 50, "burst": 50}}`)},
+		"README.md":         {Data: []byte("not a config")},
+	}
+
+	cs := NewConfigSet()
+	if err := cs.LoadFromFS(fsys, "limits/*"); err != nil {
+		t.Fatalf("LoadFromFS failed: %v", err)
+	}
+	names := cs.Names()
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"api", "free", "pro", "web"}) {
+		t.Errorf("Expected configs named by file and by set, got %v", names)
+	}
+	free, err := cs.Resolve("free")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if free.Rate != 10 || free.Burst != 10 || !free.Enabled {
+		t.Errorf("Expected free to extend api from another file, got %+v", free)
+	}
+	if web, _ := cs.Get("web"); web.Rate != 5 || web.ErrorMessage != "" {
+		t.Errorf("Expected web decoded as in a set file, got %+v", web)
+	}
+
+	fsys["limits/more/api.json"] = &fstest.MapFile{Data: []byte(`{"rate": 1, "burst": 1}`)}
+	err = NewConfigSet().LoadFromFS(fsys, "limits/*/*.json")
+	if err != nil {
+		t.Fatalf("LoadFromFS failed: %v", err)
+	}
+	fsys["limits/dup.json"] = &fstest.MapFile{Data: []byte(`{"api": {"rate": 1, "burst": 1}}`)}
+	err = NewConfigSet().LoadFromFS(fsys, "limits/*")
+	if err == nil || !strings.Contains(err.Error(), `config "api" is defined in both limits/api.yaml and limits/dup.json`) {
+		t.Errorf("Expected the collision to name both files, got %v", err)
+	}
+
+	if err := NewConfigSet().LoadFromFS(fsys, "missing/*.json"); err == nil {
+		t.Error("Expected a glob matching nothing to fail")
+	}
+	fsys["limits/broken.json"] = &fstest.MapFile{Data: []byte(`{"rate": "fast"}`)}
+	if err := NewConfigSet().LoadFromFS(fsys, "limits/broken.json"); err == nil || !strings.Contains(err.Error(), "limits/broken.json") {
+		t.Errorf("Expected the error to name the file, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// LoadLayered loads configuration from files in order, each applied on top
+// of the defaults and the files before it, so a small environment-specific
+// file can override a base one. Unlike Merge, a field a file sets is
+// applied even when zero: "enabled": false turns limiting off and
+// "excluded_ips": null clears the base's list. Fields a file leaves out
+// keep their values. Lists and maps replace earlier ones; see
+// LoadLayeredWithOptions to append or merge them. Files may be JSON or YAML, by extension
+// as with LoadFromFile, and only the result is validated. Unknown fields
+// are reported by the result's Warnings, prefixed by their file.
+func LoadLayered(files ...string) (*Config, error) {
+	return LoadLayeredWithOptions(nil, files...)
+}
+
+// LoadLayeredWithOptions is like LoadLayered, combining lists and maps as
+// opts says. Its Present field is ignored.
+func LoadLayeredWithOptions(opts *MergeOptions, files ...string) (*Config, error) {
+	var merge MergeOptions
+	if opts != nil {
+		merge = *opts
+	}
+
+	config := DefaultConfig()
+	for _, filename := range files {
+		layer, present, err := loadLayer(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		merge.Present = present
+		config = config.MergeWithOptions(layer, &merge)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return config, nil
+}
+
+// loadLayer decodes filename without defaults, along with the JSON names of
+// the fields it sets
+func loadLayer(filename string) (*Config, map[string]bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	layer := &Config{}
+	var keys []string
+	if isYAMLFile(filename) {
+		node, err := parseYAML(string(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode config: yaml: %w", err)
+		}
+		if err := decodeYAMLNode(node, reflect.ValueOf(layer).Elem()); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode config: yaml: %w", err)
+		}
+		if node != nil {
+			keys = node.keys
+		}
+		unknownYAMLFields(node, configType, "", &layer.warnings)
+	} else {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode config: %w", err)
+		}
+		if err := json.NewDecoder(bytes.NewReader(data)).Decode(layer); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode config: %w", err)
+		}
+		for key := range raw {
+			keys = append(keys, key)
+		}
+		unknownJSONFields(data, configType, "", &layer.warnings)
+	}
+
+	for i, w := range layer.warnings {
+		layer.warnings[i] = filename + ": " + w
+	}
+
+	return layer, presentFields(keys), nil
+}
+
+// presentFields maps the keys of a decoded config to the names of the
+// fields they set, for MergeOptions.Present. Keys match fields regardless
+// of case, as when decoding.
+func presentFields(keys []string) map[string]bool {
+	fields := taggedFields(configType)
+	present := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if field, ok := lookupTaggedField(fields, key); ok {
+			present[field.name] = true
+		}
+	}
+	return present
+}
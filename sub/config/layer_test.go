@@ -0,0 +1,210 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLayers writes each file into a temporary directory and returns their
+// paths
+func writeLayers(t *testing.T, files ...[2]string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for _, f := range files {
+		path := filepath.Join(dir, f[0])
+		if err := os.WriteFile(path, []byte(f[1]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+const layerBase = `{
+	"rate": 10,
+	"burst": 20,
+	"window": 1000000000,
+	"enabled": true,
+	"name": "api",
+	"excluded_paths": ["/health"],
+	"excluded_ips": ["10.0.0.0/8"],
+	"custom_headers": {"X-Team": "platform"},
+	"costs": {"/api/export": 5},
+	"max_keys": 1000,
+	"dry_run": true,
+	"initial_tokens": 5
+}`
+
+func TestLoadLayered(t *testing.T) {
+	tests := []struct {
+		name    string
+		overlay [2]string
+		opts    *MergeOptions
+		check   func(t *testing.T, c *Config)
+	}{
+		{
+			name:    "scalars and lists",
+			overlay: [2]string{"prod.yaml", "rate: 15\nexcluded_ips: [192.168.0.0/16]\nwindow: 2s\n"},
+			check: func(t *testing.T, c *Config) {
+				if c.Rate != 15 || c.Burst != 20 || c.Window != 2*time.Second || c.Name != "api" {
+					t.Errorf("Expected rate and window overridden and the rest kept, got %+v", c)
+				}
+				if !reflect.DeepEqual(c.ExcludedIPs, []string{"192.168.0.0/16"}) {
+					t.Errorf("Expected ExcludedIPs replaced, got %q", c.ExcludedIPs)
+				}
+				if !reflect.DeepEqual(c.ExcludedPaths, []string{"/health"}) {
+					t.Errorf("Expected ExcludedPaths kept, got %q", c.ExcludedPaths)
+				}
+				if c.InitialTokens == nil || *c.InitialTokens != 5 || !c.DryRun || c.MaxKeys != 1000 {
+					t.Errorf("Expected fields the overlay leaves out kept, got %+v", c)
+				}
+			},
+		},
+		{
+			name:    "explicit zero values",
+			overlay: [2]string{"off.json", `{"enabled": false, "dry_run": false, "max_keys": 0, "name": ""}`},
+			check: func(t *testing.T, c *Config) {
+				if c.Enabled || c.DryRun || c.MaxKeys != 0 || c.Name != "" {
+					t.Errorf("Expected explicit zero values applied, got %+v", c)
+				}
+				if c.Rate != 10 {
+					t.Errorf("Expected Rate kept, got %d", c.Rate)
+				}
+			},
+		},
+		{
+			name:    "null clears",
+			overlay: [2]string{"clear.json", `{"excluded_ips": null, "initial_tokens": null, "custom_headers": null}`},
+			check: func(t *testing.T, c *Config) {
+				if c.ExcludedIPs != nil || c.InitialTokens != nil || c.CustomHeaders != nil {
+					t.Errorf("Expected null fields cleared, got %+v", c)
+				}
+			},
+		},
+		{
+			name:    "keys ignore case",
+			overlay: [2]string{"case.json", `{"Enabled": false}`},
+			check: func(t *testing.T, c *Config) {
+				if c.Enabled {
+					t.Error("Expected Enabled turned off")
+				}
+			},
+		},
+		{
+			name:    "maps replace",
+			overlay: [2]string{"maps.yaml", "custom_headers:\n  X-Env: prod\ncosts:\n  /api/search: 2\n"},
+			check: func(t *testing.T, c *Config) {
+				if !reflect.DeepEqual(c.CustomHeaders, map[string]string{"X-Env": "prod"}) {
+					t.Errorf("Expected headers replaced, got %v", c.CustomHeaders)
+				}
+				if !reflect.DeepEqual(c.Costs, map[string]int{"/api/search": 2}) {
+					t.Errorf("Expected costs replaced, got %v", c.Costs)
+				}
+			},
+		},
+		{
+			name:    "maps merge",
+			overlay: [2]string{"maps.yaml", "custom_headers:\n  X-Env: prod\ncosts:\n  /api/search: 2\n"},
+			opts:    &MergeOptions{MergeMaps: true},
+			check: func(t *testing.T, c *Config) {
+				if !reflect.DeepEqual(c.CustomHeaders, map[string]string{"X-Team": "platform", "X-Env": "prod"}) {
+					t.Errorf("Expected headers merged, got %v", c.CustomHeaders)
+				}
+				if !reflect.DeepEqual(c.Costs, map[string]int{"/api/export": 5, "/api/search": 2}) {
+					t.Errorf("Expected costs merged, got %v", c.Costs)
+				}
+			},
+		},
+		{
+			name:    "maps merge and lists append",
+			overlay: [2]string{"opts.yaml", "custom_headers:\n  X-Env: prod\nexcluded_paths: [/metrics]\n"},
+			opts:    &MergeOptions{AppendSlices: true, MergeMaps: true},
+			check: func(t *testing.T, c *Config) {
+				if !reflect.DeepEqual(c.CustomHeaders, map[string]string{"X-Team": "platform", "X-Env": "prod"}) {
+					t.Errorf("Expected headers merged, got %v", c.CustomHeaders)
+				}
+				if !reflect.DeepEqual(c.Costs, map[string]int{"/api/export": 5}) {
+					t.Errorf("Expected costs kept, got %v", c.Costs)
+				}
+				if !reflect.DeepEqual(c.ExcludedPaths, []string{"/health", "/metrics"}) {
+					t.Errorf("Expected paths appended, got %q", c.ExcludedPaths)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := writeLayers(t, [2]string{"base.json", layerBase}, tt.overlay)
+			config, err := LoadLayeredWithOptions(tt.opts, files...)
+			if err != nil {
+				t.Fatalf("LoadLayered() error = %v", err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestLoadLayeredValidatesResult(t *testing.T) {
+	// The base alone is invalid, but only the result counts
+	files := writeLayers(t,
+		[2]string{"base.yaml", "rate: 100\nburst: 50\n"},
+		[2]string{"fix.yaml", "burst: 200\n"},
+	)
+	config, err := LoadLayered(files...)
+	if err != nil {
+		t.Fatalf("LoadLayered() error = %v", err)
+	}
+	if config.Rate != 100 || config.Burst != 200 {
+		t.Errorf("Expected rate 100 and burst 200, got %d and %d", config.Rate, config.Burst)
+	}
+
+	files = writeLayers(t,
+		[2]string{"base.json", layerBase},
+		[2]string{"bad.json", `{"rate": 0}`},
+	)
+	if _, err := LoadLayered(files...); err == nil || !strings.Contains(err.Error(), "invalid config: rate must be positive") {
+		t.Errorf("Expected an explicit zero rate to fail validation, got %v", err)
+	}
+
+	files = writeLayers(t, [2]string{"broken.json", `{"rate": "fast"}`})
+	if _, err := LoadLayered(files...); err == nil || !strings.Contains(err.Error(), "broken.json") {
+		t.Errorf("Expected the error to name the file, got %v", err)
+	}
+
+	if config, err := LoadLayered(); err != nil || !reflect.DeepEqual(config, DefaultConfig()) {
+		t.Errorf("Expected no files to give the defaults, got %+v, %v", config, err)
+	}
+}
+
+func TestMergeWithOptionsPresent(t *testing.T) {
+	initial := 3
+	base := &Config{
+		Rate: 10, Burst: 20, Window: time.Second, Enabled: true, PerKeyLimits: true,
+		ExcludedPaths: []string{"/health"}, CustomHeaders: map[string]string{"X-Team": "platform"},
+		Limits: []WindowLimit{{Count: 5, Window: time.Second}}, InitialTokens: &initial,
+	}
+
+	// Without presence, zero values are not set
+	if merged := base.Merge(&Config{}); !reflect.DeepEqual(merged, base) {
+		t.Errorf("Expected merging an empty config to change nothing, got %+v", merged)
+	}
+
+	merged := base.MergeWithOptions(&Config{Burst: 30}, &MergeOptions{Present: map[string]bool{
+		"rate": true, "enabled": true, "per_key_limits": true, "limits": true, "initial_tokens": true,
+	}})
+	if merged.Rate != 0 || merged.Enabled || merged.PerKeyLimits || merged.Limits != nil || merged.InitialTokens != nil {
+		t.Errorf("Expected present fields applied even when zero, got %+v", merged)
+	}
+	if merged.Burst != 20 || merged.Window != time.Second || merged.ExcludedPaths == nil || merged.CustomHeaders == nil {
+		t.Errorf("Expected fields not present kept, got %+v", merged)
+	}
+	if base.Rate != 10 || !base.Enabled || base.InitialTokens == nil {
+		t.Errorf("Expected the base unchanged, got %+v", base)
+	}
+}
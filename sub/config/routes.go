@@ -0,0 +1,168 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Route sends the requests matching Pattern to the limiter built from the
+// config of the set named Config. Name identifies the route to error
+// handlers and stats, and defaults to Pattern.
+//
+// Patterns have the form "[METHOD ]PATH", where a pattern without a method
+// applies to every method. Each path segment is matched on its own: a
+// "{param}" segment matches any non-empty segment, and other segments are
+// path.Match globs, so "/users/{id}/avatar" and "/users/*/avatar" are the
+// same. A path ending in "*" matches every path with that prefix, whatever
+// its depth.
+type Route struct {
+	Name    string `json:"name,omitempty"`
+	Pattern string `json:"pattern"`
+	Config  string `json:"config"`
+}
+
+// RouteTable is an ordered list of routes. The first route matching a
+// request wins, and requests no route matches are limited by the Default
+// config, or not at all if it is empty.
+type RouteTable struct {
+	Routes  []Route `json:"routes"`
+	Default string  `json:"default,omitempty"`
+}
+
+// RoutePattern is a compiled Route pattern
+type RoutePattern struct {
+	method   string
+	segments []string
+	prefix   bool
+}
+
+// CompileRoutePattern compiles a route pattern. It returns an error for
+// malformed patterns.
+func CompileRoutePattern(pattern string) (*RoutePattern, error) {
+	fields := strings.Fields(pattern)
+	p := &RoutePattern{}
+	var rest string
+	switch len(fields) {
+	case 1:
+		rest = fields[0]
+	case 2:
+		p.method, rest = strings.ToUpper(fields[0]), fields[1]
+	default:
+		return nil, fmt.Errorf("invalid route pattern %q: expected \"[METHOD ]PATH\"", pattern)
+	}
+	if !strings.HasPrefix(rest, "/") {
+		return nil, fmt.Errorf("invalid route pattern %q: path must start with /", pattern)
+	}
+	rest, p.prefix = strings.CutSuffix(rest, "*")
+	p.segments = strings.Split(rest[1:], "/")
+	for i, segment := range p.segments {
+		if p.prefix && i == len(p.segments)-1 {
+			if strings.ContainsAny(segment, "*?[\\{}") {
+				return nil, fmt.Errorf("invalid route pattern %q: a trailing * must follow a literal", pattern)
+			}
+			continue
+		}
+		if isParam(segment) {
+			continue
+		}
+		if strings.ContainsAny(segment, "{}") {
+			return nil, fmt.Errorf("invalid route pattern %q: parameters must fill their segment", pattern)
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid route pattern %q: %w", pattern, err)
+		}
+	}
+	return p, nil
+}
+
+// isParam reports whether a pattern segment is a "{param}"
+func isParam(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
+
+// Match reports whether a request with the given method and path matches
+func (p *RoutePattern) Match(method, urlPath string) bool {
+	if p.method != "" && !strings.EqualFold(p.method, method) {
+		return false
+	}
+	if !strings.HasPrefix(urlPath, "/") {
+		return false
+	}
+	segments := strings.Split(urlPath[1:], "/")
+	if len(segments) < len(p.segments) || (!p.prefix && len(segments) > len(p.segments)) {
+		return false
+	}
+	last := len(p.segments) - 1
+	for i, want := range p.segments {
+		got := segments[i]
+		switch {
+		case p.prefix && i == last:
+			if !strings.HasPrefix(got, want) {
+				return false
+			}
+		case isParam(want):
+			if got == "" {
+				return false
+			}
+		default:
+			if ok, _ := path.Match(want, got); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SetRoutes sets the set's route table, checking that every pattern
+// compiles and every config it names resolves
+func (cs *ConfigSet) SetRoutes(table *RouteTable) error {
+	if table == nil {
+		return errors.New("route table cannot be nil")
+	}
+	for _, route := range table.Routes {
+		if _, err := CompileRoutePattern(route.Pattern); err != nil {
+			return err
+		}
+		if _, err := cs.Resolve(route.Config); err != nil {
+			return fmt.Errorf("route %q: %w", route.Pattern, err)
+		}
+	}
+	if table.Default != "" {
+		if _, err := cs.Resolve(table.Default); err != nil {
+			return fmt.Errorf("default route: %w", err)
+		}
+	}
+	routes := &RouteTable{Routes: append([]Route(nil), table.Routes...), Default: table.Default}
+	cs.mu.Lock()
+	cs.routes = routes
+	cs.mu.Unlock()
+	return nil
+}
+
+// Routes returns a copy of the set's route table, or nil if it has none
+func (cs *ConfigSet) Routes() *RouteTable {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.routes == nil {
+		return nil
+	}
+	return &RouteTable{Routes: append([]Route(nil), cs.routes.Routes...), Default: cs.routes.Default}
+}
+
+// LoadRoutesFromFile loads the set's route table from a JSON file. The
+// configs it names must already be in the set.
+func (cs *ConfigSet) LoadRoutesFromFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read route file: %w", err)
+	}
+	var table RouteTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return fmt.Errorf("failed to decode route table: %w", err)
+	}
+	return cs.SetRoutes(&table)
+}
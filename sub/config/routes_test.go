@@ -0,0 +1,94 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRoutePatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern, method, path string
+		match                 bool
+	}{
+		{"/api/search", "GET", "/api/search", true},
+		{"/api/search", "GET", "/api/search/more", false},
+		{"POST /api/search", "post", "/api/search", true},
+		{"POST /api/search", "GET", "/api/search", false},
+		{"/api/items/*", "GET", "/api/items/42", true},
+		{"/api/items/*", "GET", "/api/items/42/reviews", true},
+		{"/api/items/*", "GET", "/api/items", false},
+		{"/api/it*", "GET", "/api/items/42", true},
+		{"/users/{id}/avatar", "GET", "/users/42/avatar", true},
+		{"/users/{id}/avatar", "GET", "/users//avatar", false},
+		{"/users/{id}/avatar", "GET", "/users/42/7/avatar", false},
+		{"/users/{id}/*", "GET", "/users/42/posts/1", true},
+		{"/files/*.json", "GET", "/files/a.json", true},
+		{"/files/*.json", "GET", "/files/a/b.json", false}, // globs stay within a segment
+		{"/files/?.json", "GET", "/files/a.json", true},
+		{"/files/[a-c].json", "GET", "/files/d.json", false},
+		{"/*", "DELETE", "/", true},
+	}
+	for _, tt := range tests {
+		p, err := CompileRoutePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("CompileRoutePattern(%q) failed: %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.method, tt.path); got != tt.match {
+			t.Errorf("%q.Match(%s %s) = %v, expected %v", tt.pattern, tt.method, tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestCompileRoutePatternErrors(t *testing.T) {
+	tests := []struct {
+		pattern, errMsg string
+	}{
+		{"api/search", "must start with /"},
+		{"GET /a b", "expected"},
+		{"/users/{id", "parameters must fill their segment"},
+		{"/users/x{id}", "parameters must fill their segment"},
+		{"/files/[a", "syntax error"},
+		{"/users/{id}*", "must follow a literal"},
+	}
+	for _, tt := range tests {
+		_, err := CompileRoutePattern(tt.pattern)
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("CompileRoutePattern(%q): expected error containing %q, got %v", tt.pattern, tt.errMsg, err)
+		}
+	}
+}
+
+func TestConfigSetRoutes(t *testing.T) {
+	cs := NewConfigSet()
+	cs.Add("search", &Config{Rate: 5, Burst: 5})
+	cs.Add("items", &Config{Rate: 100, Burst: 100})
+
+	if cs.Routes() != nil {
+		t.Error("Expected a new set to have no routes")
+	}
+	if err := cs.SetRoutes(&RouteTable{Routes: []Route{{Pattern: "/a", Config: "missing"}}}); err == nil {
+		t.Error("Expected a route naming an unknown config to be rejected")
+	}
+	if err := cs.SetRoutes(&RouteTable{Default: "missing"}); err == nil {
+		t.Error("Expected an unknown default config to be rejected")
+	}
+
+	path := filepath.Join(t.TempDir(), "routes.json")
+	data := `{"routes": [{"name": "search", "pattern": "/api/search", "config": "search"}, {"pattern": "/api/items/*", "config": "items"}], "default": "items"}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.LoadRoutesFromFile(path); err != nil {
+		t.Fatalf("LoadRoutesFromFile failed: %v", err)
+	}
+	table := cs.Routes()
+	if len(table.Routes) != 2 || table.Routes[1].Pattern != "/api/items/*" || table.Default != "items" {
+		t.Errorf("Unexpected route table %+v", table)
+	}
+	table.Routes[0].Config = "modified"
+	if cs.Routes().Routes[0].Config != "search" {
+		t.Error("Expected Routes to return a copy")
+	}
+}
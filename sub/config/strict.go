@@ -0,0 +1,347 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Loading ignores fields it does not know, so an old binary can read a
+// config written for a newer one, but a misspelled field silently keeps its
+// default. The lenient loaders record such fields as warnings; the strict
+// ones reject them.
+
+// Warnings returns the fields the config was loaded with that no Config
+// field matches, such as "bursts" for "burst", to be logged by the caller
+func (c *Config) Warnings() []string {
+	return append([]string(nil), c.warnings...)
+}
+
+// Warnings returns the unknown fields of every configuration in the set,
+// each prefixed by the configuration's name
+func (cs *ConfigSet) Warnings() []string {
+	var warnings []string
+	cs.Range(func(name string, c *Config) bool {
+		for _, w := range c.warnings {
+			warnings = append(warnings, name+": "+w)
+		}
+		return true
+	})
+	return warnings
+}
+
+// LoadFromReaderStrict is like LoadFromReader but fails on fields no Config
+// field matches
+func LoadFromReaderStrict(r io.Reader) (*Config, error) {
+	return loadFromReader(r, false, true)
+}
+
+// LoadYAMLFromReaderStrict is like LoadYAMLFromReader but fails on fields
+// no Config field matches
+func LoadYAMLFromReaderStrict(r io.Reader) (*Config, error) {
+	return loadFromReader(r, true, true)
+}
+
+// LoadFromFileStrict is like LoadFromFile but fails on fields no Config
+// field matches
+func LoadFromFileStrict(filename string) (*Config, error) {
+	return loadFromFile(filename, isYAMLFile(filename), true)
+}
+
+// LoadFromFileStrict is like LoadFromFile but fails on fields no Config
+// field matches
+func (cs *ConfigSet) LoadFromFileStrict(filename string) error {
+	return cs.loadFromFile(filename, isYAMLFile(filename), true)
+}
+
+func loadFromFile(filename string, yaml, strict bool) (*Config, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
+
+	return loadFromReader(file, yaml, strict)
+}
+
+// loadFromReader loads a config from JSON or YAML over the defaults
+func loadFromReader(r io.Reader, yaml, strict bool) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	config := DefaultConfig()
+	if yaml {
+		config.warnings, err = decodeYAMLConfig(data, config, strict)
+	} else {
+		config.warnings, err = decodeJSONConfig(data, config, strict)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return config, nil
+}
+
+func (cs *ConfigSet) loadFromFile(filename string, yaml, strict bool) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open config set file: %w", err)
+	}
+
+	configs, present, err := decodeConfigSet(data, yaml, strict)
+	if err != nil {
+		return err
+	}
+	return cs.addAll(configs, present)
+}
+
+// decodeConfigSet decodes a JSON or YAML object mapping names to configs,
+// and returns the fields present in each config by name, for resolving
+// Extends
+func decodeConfigSet(data []byte, yaml, strict bool) (map[string]*Config, map[string]map[string]bool, error) {
+	var configs map[string]*Config
+	var err error
+	if yaml {
+		_, err = decodeYAMLConfig(data, &configs, strict)
+	} else {
+		_, err = decodeJSONConfig(data, &configs, strict)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode config set: %w", err)
+	}
+
+	present := make(map[string]map[string]bool, len(configs))
+	if yaml {
+		node, err := parseYAML(string(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode config set: yaml: %w", err)
+		}
+		for name := range configs {
+			if node != nil && node.fields[name] != nil {
+				present[name] = presentFields(node.fields[name].keys)
+			}
+		}
+	} else {
+		var items map[string]map[string]json.RawMessage
+		if err := json.NewDecoder(bytes.NewReader(data)).Decode(&items); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode config set: %w", err)
+		}
+		for name, fields := range items {
+			keys := make([]string, 0, len(fields))
+			for key := range fields {
+				keys = append(keys, key)
+			}
+			present[name] = presentFields(keys)
+		}
+	}
+	return configs, present, nil
+}
+
+// decodeJSONConfig decodes the first JSON value in data into v, a *Config
+// or a map of them, and returns the unknown fields of a config. Those of a
+// map's configs are stored on them instead.
+func decodeJSONConfig(data []byte, v any, strict bool) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(v); err != nil || strict {
+		return nil, err
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&raw); err != nil {
+		return nil, err
+	}
+	if configs, ok := v.(*map[string]*Config); ok {
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+		for name, config := range *configs {
+			if config != nil {
+				unknownJSONFields(items[name], configType, "", &config.warnings)
+			}
+		}
+		return nil, nil
+	}
+	var unknown []string
+	unknownJSONFields(raw, configType, "", &unknown)
+	return unknown, nil
+}
+
+// decodeYAMLConfig is decodeJSONConfig for YAML
+func decodeYAMLConfig(data []byte, v any, strict bool) ([]string, error) {
+	node, err := parseYAML(string(data))
+	if err == nil {
+		err = decodeYAMLNode(node, reflect.ValueOf(v).Elem())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("yaml: %w", err)
+	}
+
+	var unknown []string
+	if configs, ok := v.(*map[string]*Config); ok && node != nil {
+		for name, config := range *configs {
+			if config == nil {
+				continue
+			}
+			unknownYAMLFields(node.fields[name], configType, "", &config.warnings)
+			if strict && len(config.warnings) > 0 {
+				return nil, fmt.Errorf("yaml: %s: %s", name, config.warnings[0])
+			}
+		}
+		return nil, nil
+	}
+	unknownYAMLFields(node, configType, "", &unknown)
+	if strict && len(unknown) > 0 {
+		return nil, fmt.Errorf("yaml: %s", unknown[0])
+	}
+	return unknown, nil
+}
+
+var configType = reflect.TypeOf(Config{})
+
+// unknownJSONFields appends a warning for every key of raw, and of the
+// objects nested in it, that no field of t matches
+func unknownJSONFields(raw json.RawMessage, t reflect.Type, path string, unknown *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(raw, &object) != nil {
+			return
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fields := taggedFields(t)
+		for _, key := range keys {
+			if field, ok := lookupTaggedField(fields, key); ok {
+				unknownJSONFields(object[key], t.Field(field.index).Type, joinFieldPath(path, field.name), unknown)
+			} else {
+				*unknown = append(*unknown, unknownFieldWarning(fields, path, key))
+			}
+		}
+	case reflect.Slice:
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			return
+		}
+		for i, item := range items {
+			unknownJSONFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), unknown)
+		}
+	case reflect.Map:
+		var items map[string]json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			return
+		}
+		for key, item := range items {
+			unknownJSONFields(item, t.Elem(), joinFieldPath(path, key), unknown)
+		}
+	}
+}
+
+// unknownYAMLFields is unknownJSONFields for YAML, with line numbers
+func unknownYAMLFields(node *yamlNode, t reflect.Type, path string, unknown *[]string) {
+	if node == nil {
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Struct && node.kind == yamlMapping:
+		fields := taggedFields(t)
+		for _, key := range node.keys {
+			if field, ok := lookupTaggedField(fields, key); ok {
+				unknownYAMLFields(node.fields[key], t.Field(field.index).Type, joinFieldPath(path, field.name), unknown)
+			} else {
+				line := node.fields[key].lineOr(node.line)
+				*unknown = append(*unknown, fmt.Sprintf("line %d: %s", line, unknownFieldWarning(fields, path, key)))
+			}
+		}
+	case t.Kind() == reflect.Slice && node.kind == yamlSequence:
+		for i, item := range node.items {
+			unknownYAMLFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), unknown)
+		}
+	case t.Kind() == reflect.Map && node.kind == yamlMapping:
+		for _, key := range node.keys {
+			unknownYAMLFields(node.fields[key], t.Elem(), joinFieldPath(path, key), unknown)
+		}
+	}
+}
+
+// lineOr returns the node's line, or line for a missing value
+func (n *yamlNode) lineOr(line int) int {
+	if n == nil || n.line == 0 {
+		return line
+	}
+	return n.line
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// unknownFieldWarning describes an unknown key, suggesting the field it
+// was probably meant to be
+func unknownFieldWarning(fields []taggedField, path, key string) string {
+	warning := fmt.Sprintf("unknown field %q", joinFieldPath(path, key))
+	if suggestion := suggestField(fields, key); suggestion != "" {
+		warning += fmt.Sprintf(" (did you mean %q?)", suggestion)
+	}
+	return warning
+}
+
+// suggestField returns the field whose name is within two edits of key,
+// ignoring case and treating dashes as underscores, or "" if none is
+func suggestField(fields []taggedField, key string) string {
+	key = strings.ReplaceAll(strings.ToLower(key), "-", "_")
+	best, bestDistance := "", 3
+	for _, f := range fields {
+		if d := editDistance(key, f.name); d < bestDistance && d < len(key) {
+			best, bestDistance = f.name, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
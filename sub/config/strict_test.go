@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadStrict(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     bool
+		input    string
+		warnings []string
+	}{
+		{
+			name:  "valid json",
+			input: `{"rate": 10, "burst": 20, "limits": [{"count": 5, "window": 1000000000}]}`,
+		},
+		{
+			name:     "misspelled json",
+			input:    `{"rate": 10, "bursts": 20}`,
+			warnings: []string{`unknown field "bursts" (did you mean "burst"?)`},
+		},
+		{
+			name:  "nested json",
+			input: `{"rate": 10, "burst": 20, "limits": [{"count": 5, "windw": 2, "window": 1000000000}], "colour": "red"}`,
+			warnings: []string{
+				`unknown field "colour"`,
+				`unknown field "limits[0].windw" (did you mean "window"?)`,
+			},
+		},
+		{
+			name:  "valid yaml",
+			yaml:  true,
+			input: "rate: 10\nburst: 20\nexcluded_paths: [/health]\n",
+		},
+		{
+			name:     "misspelled yaml",
+			yaml:     true,
+			input:    "rate: 10\nburst: 20\nper-key-limits: true\n",
+			warnings: []string{`line 3: unknown field "per-key-limits" (did you mean "per_key_limits"?)`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			load, loadStrict := LoadFromReader, LoadFromReaderStrict
+			if tt.yaml {
+				load, loadStrict = LoadYAMLFromReader, LoadYAMLFromReaderStrict
+			}
+
+			config, err := load(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("Expected lenient loading to succeed, got %v", err)
+			}
+			if !reflect.DeepEqual(config.Warnings(), tt.warnings) {
+				t.Errorf("Warnings() = %q, want %q", config.Warnings(), tt.warnings)
+			}
+
+			_, err = loadStrict(strings.NewReader(tt.input))
+			if tt.warnings == nil && err != nil {
+				t.Errorf("Expected strict loading to succeed, got %v", err)
+			}
+			if tt.warnings != nil && (err == nil || !strings.Contains(err.Error(), "unknown field")) {
+				t.Errorf("Expected strict loading to name the unknown field, got %v", err)
+			}
+		})
+	}
+}
+
+func TestConfigSetLoadStrict(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"set.json": `{"api": {"rate": 10, "burst": 20, "enabeld": true}, "web": {"rate": 5, "burst": 5}}`,
+		"set.yaml": "api:\n  rate: 10\n  burst: 20\n  enabeld: true\nweb:\n  rate: 5\n  burst: 5\n",
+	} {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		cs := NewConfigSet()
+		if err := cs.LoadFromFile(filename); err != nil {
+			t.Fatalf("%s: LoadFromFile failed: %v", name, err)
+		}
+		warnings := cs.Warnings()
+		if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "api: ") || !strings.Contains(warnings[0], `(did you mean "enabled"?)`) {
+			t.Errorf("%s: Expected one warning for api, got %q", name, warnings)
+		}
+		if config, _ := cs.Get("web"); config.Warnings() != nil {
+			t.Errorf("%s: Expected no warnings for web, got %q", name, config.Warnings())
+		}
+
+		if err := NewConfigSet().LoadFromFileStrict(filename); err == nil || !strings.Contains(err.Error(), "enabeld") {
+			t.Errorf("%s: Expected strict loading to name the unknown field, got %v", name, err)
+		}
+	}
+}
+
+func TestLoadLayeredWarnings(t *testing.T) {
+	files := writeLayers(t,
+		[2]string{"base.json", `{"rate": 10, "burst": 20, "dryrun": true}`},
+		[2]string{"prod.yaml", "rate_limit: 15\n"},
+	)
+	config, err := LoadLayered(files...)
+	if err != nil {
+		t.Fatalf("LoadLayered() error = %v", err)
+	}
+	want := []string{
+		files[0] + `: unknown field "dryrun" (did you mean "dry_run"?)`,
+		files[1] + `: line 1: unknown field "rate_limit"`,
+	}
+	if !reflect.DeepEqual(config.Warnings(), want) {
+		t.Errorf("Warnings() = %q, want %q", config.Warnings(), want)
+	}
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// URLOptions configures LoadFromURLWithOptions and PollURLWithOptions
+type URLOptions struct {
+	// Client makes the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Timeout bounds each request, including reading the body. Defaults
+	// to ten seconds.
+	Timeout time.Duration
+	// MaxSize is the largest config accepted, in bytes. Defaults to 1 MiB.
+	MaxSize int64
+	// MaxBackoff caps how long polling waits after failed fetches, which
+	// double the wait each time starting from the interval. Defaults to
+	// five minutes, or the interval if longer.
+	MaxBackoff time.Duration
+	// OnError is called when polling fails to fetch a config or fetches an
+	// invalid one. The last good config stays in effect. When nil the
+	// error is logged with the standard logger.
+	OnError func(error)
+}
+
+// LoadFromURL fetches a JSON config from url with GET and validates it,
+// as LoadFromReader does. Requests time out after ten seconds and bodies
+// over 1 MiB are refused; see LoadFromURLWithOptions.
+func LoadFromURL(ctx context.Context, url string) (*Config, error) {
+	return LoadFromURLWithOptions(ctx, url, nil)
+}
+
+// LoadFromURLWithOptions is like LoadFromURL using the given options
+func LoadFromURLWithOptions(ctx context.Context, url string, opts *URLOptions) (*Config, error) {
+	cfg, _, _, err := newURLFetcher(opts).fetch(ctx, url, "")
+	return cfg, err
+}
+
+// PollURL fetches a JSON config from url every interval and calls onChange
+// with the first valid config and with each later one that differs from
+// the last. Unchanged content is recognized by its ETag, sent back in
+// If-None-Match, and else by comparing configs. Failed fetches and invalid
+// configs are logged and otherwise ignored, so the last good config stays
+// in effect, and polling backs off while they persist. PollURL returns
+// ctx's error once ctx is done; no callback runs after it returns.
+func PollURL(ctx context.Context, url string, interval time.Duration, onChange func(*Config)) error {
+	return PollURLWithOptions(ctx, url, interval, onChange, nil)
+}
+
+// PollURLWithOptions is like PollURL using the given options
+func PollURLWithOptions(ctx context.Context, url string, interval time.Duration, onChange func(*Config), opts *URLOptions) error {
+	if interval <= 0 {
+		return errors.New("poll interval must be positive")
+	}
+	f := newURLFetcher(opts)
+	maxBackoff := max(5*time.Minute, interval)
+	onError := func(err error) {
+		log.Printf("config: not reloading %s: %v", url, err)
+	}
+	if opts != nil {
+		if opts.MaxBackoff > 0 {
+			maxBackoff = max(opts.MaxBackoff, interval)
+		}
+		if opts.OnError != nil {
+			onError = opts.OnError
+		}
+	}
+
+	var last *Config
+	var etag string
+	delay := interval
+	for {
+		cfg, newETag, notModified, err := f.fetch(ctx, url, etag)
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			onError(err)
+			if delay *= 2; delay > maxBackoff {
+				delay = maxBackoff
+			}
+		case notModified:
+			delay = interval
+		default:
+			delay = interval
+			etag = newETag
+			if !reflect.DeepEqual(cfg, last) {
+				last = cfg
+				onChange(cfg.Clone())
+			}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// urlFetcher fetches configs over HTTP within a timeout and a size limit
+type urlFetcher struct {
+	client  *http.Client
+	timeout time.Duration
+	maxSize int64
+}
+
+func newURLFetcher(opts *URLOptions) *urlFetcher {
+	f := &urlFetcher{client: http.DefaultClient, timeout: 10 * time.Second, maxSize: 1 << 20}
+	if opts != nil {
+		if opts.Client != nil {
+			f.client = opts.Client
+		}
+		if opts.Timeout > 0 {
+			f.timeout = opts.Timeout
+		}
+		if opts.MaxSize > 0 {
+			f.maxSize = opts.MaxSize
+		}
+	}
+	return f
+}
+
+// fetch GETs url, sending etag in If-None-Match if set, and returns the
+// validated config with its ETag, or notModified if the server answered
+// 304 Not Modified
+func (f *urlFetcher) fetch(ctx context.Context, url, etag string) (cfg *Config, newETag string, notModified bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("failed to fetch config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, "", true, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", false, fmt.Errorf("failed to fetch config: unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.maxSize+1))
+	if err != nil {
+		return nil, "", false, fmt.Errorf("failed to fetch config: %w", err)
+	}
+	if int64(len(data)) > f.maxSize {
+		return nil, "", false, fmt.Errorf("failed to fetch config: larger than %d bytes", f.maxSize)
+	}
+
+	cfg, err = LoadFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", false, err
+	}
+	return cfg, resp.Header.Get("ETag"), false, nil
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadFromURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte(`{"rate": 10, "burst": 20}`))
+		case "/large":
+			w.Write([]byte(`{"name": "` + strings.Repeat("x", 2048) + `", "rate": 10, "burst": 20}`))
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg, err := LoadFromURL(context.Background(), server.URL+"/ok")
+	if err != nil {
+		t.Fatalf("LoadFromURL failed: %v", err)
+	}
+	if cfg.Rate != 10 || cfg.Burst != 20 || !cfg.Enabled {
+		t.Errorf("Expected the config over the defaults, got %+v", cfg)
+	}
+
+	tests := []struct {
+		path   string
+		opts   *URLOptions
+		errMsg string
+	}{
+		{"/missing", nil, "unexpected status 404"},
+		{"/large", &URLOptions{MaxSize: 1024}, "larger than 1024 bytes"},
+		{"/slow", &URLOptions{Timeout: 20 * time.Millisecond}, "deadline exceeded"},
+	}
+	for _, tt := range tests {
+		_, err := LoadFromURLWithOptions(context.Background(), server.URL+tt.path, tt.opts)
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("%s: Expected an error containing %q, got %v", tt.path, tt.errMsg, err)
+		}
+	}
+}
+
+func TestPollURL(t *testing.T) {
+	// Each request gets the next response; the last one repeats
+	responses := []struct {
+		status int
+		etag   string
+		body   string
+	}{
+		{http.StatusOK, `"1"`, `{"rate": 10, "burst": 20}`},
+		{http.StatusNotModified, "", ""},
+		{http.StatusOK, `"2"`, `{"rate": `},
+		{http.StatusInternalServerError, "", "unavailable"},
+		{http.StatusOK, `"3"`, `{"rate": 20, "burst": 10}`},
+		{http.StatusOK, `"4"`, `{"rate": 20, "burst": 40}`},
+		{http.StatusOK, `"5"`, `{"burst": 40, "rate": 20}`},
+		{http.StatusNotModified, "", ""},
+	}
+	var mu sync.Mutex
+	var requests int
+	var ifNoneMatch []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		resp := responses[min(requests, len(responses)-1)]
+		requests++
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		if resp.etag != "" {
+			w.Header().Set("ETag", resp.etag)
+		}
+		w.WriteHeader(resp.status)
+		w.Write([]byte(resp.body))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var changes []*Config
+	var errs []error
+	done := make(chan error)
+	go func() {
+		done <- PollURLWithOptions(ctx, server.URL, time.Millisecond, func(c *Config) {
+			changes = append(changes, c)
+		}, &URLOptions{MaxBackoff: 4 * time.Millisecond, OnError: func(err error) { errs = append(errs, err) }})
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := requests
+		mu.Unlock()
+		if n >= len(responses)+2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected at least %d requests, got %d", len(responses)+2, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected PollURL to return context.Canceled, got %v", err)
+	}
+
+	if len(changes) != 2 || changes[0].Rate != 10 || changes[1].Rate != 20 || changes[1].Burst != 40 {
+		t.Errorf("Expected callbacks for the first config and the valid change only, got %+v", changes)
+	}
+	if len(errs) != 3 {
+		t.Errorf("Expected the malformed, failed and invalid fetches reported, got %v", errs)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if ifNoneMatch[0] != "" || ifNoneMatch[1] != `"1"` || ifNoneMatch[3] != `"1"` || ifNoneMatch[len(ifNoneMatch)-1] != `"5"` {
+		t.Errorf("Expected the last good ETag sent back, got %q", ifNoneMatch)
+	}
+}
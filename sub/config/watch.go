@@ -0,0 +1,124 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WatchOptions configures WatchWithOptions
+type WatchOptions struct {
+	// Interval is how often the file is checked for changes. Defaults to
+	// one second.
+	Interval time.Duration
+	// OnError is called when a changed file cannot be loaded or is
+	// invalid. The previous config stays in effect. When nil the error is
+	// logged with the standard logger.
+	OnError func(error)
+	// OnReload is called with the changes from the previous config, as
+	// reported by Diff, before onChange is called with the new one. When
+	// nil the changes are logged with the standard logger, such as
+	// "config: reloaded limits.yaml: rate 100→250, excluded_paths +/debug".
+	OnReload func([]FieldChange)
+}
+
+// Watch loads filename with LoadFromFile, then polls it for changes and
+// calls onChange with each new config that is valid and differs from the
+// last one, as Config.Equal compares them, logging what changed. Edits
+// that fail to load are reported and otherwise ignored, so a half-written
+// file never replaces a working config. It returns an error if the file
+// cannot be loaded to begin with. Call stop to stop watching; no callback
+// runs after it returns, so it must not be called from one.
+func Watch(filename string, onChange func(*Config)) (stop func(), err error) {
+	return WatchWithOptions(filename, onChange, nil)
+}
+
+// WatchWithOptions is like Watch using the given options
+func WatchWithOptions(filename string, onChange func(*Config), opts *WatchOptions) (stop func(), err error) {
+	interval := time.Second
+	onError := func(err error) {
+		log.Printf("config: not reloading %s: %v", filename, err)
+	}
+	onReload := func(changes []FieldChange) {
+		summary := make([]string, len(changes))
+		for i, change := range changes {
+			summary[i] = change.String()
+		}
+		log.Printf("config: reloaded %s: %s", filename, strings.Join(summary, ", "))
+	}
+	if opts != nil {
+		if opts.Interval > 0 {
+			interval = opts.Interval
+		}
+		if opts.OnError != nil {
+			onError = opts.OnError
+		}
+		if opts.OnReload != nil {
+			onReload = opts.OnReload
+		}
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	last, err := LoadFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var lastErr string
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			current, err := os.Stat(filename)
+			if err != nil {
+				if err.Error() != lastErr {
+					lastErr = err.Error()
+					onError(err)
+				}
+				continue
+			}
+			if current.ModTime().Equal(info.ModTime()) && current.Size() == info.Size() {
+				continue
+			}
+			info = current
+
+			cfg, err := LoadFromFile(filename)
+			if err != nil {
+				lastErr = err.Error()
+				onError(err)
+				continue
+			}
+			lastErr = ""
+			changes := last.Diff(cfg)
+			if len(changes) == 0 {
+				continue
+			}
+			last = cfg
+			onReload(changes)
+			onChange(cfg.Clone())
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}, nil
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/internal/leaktest"
+)
+
+func TestWatch(t *testing.T) {
+	baseline := leaktest.BaselineGoroutines()
+	filename := filepath.Join(t.TempDir(), "limits.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("rate: 10\nburst: 20\n")
+
+	changes := make(chan *Config, 10)
+	errs := make(chan error, 10)
+	reloads := make(chan []FieldChange, 10)
+	stop, err := WatchWithOptions(filename, func(c *Config) { changes <- c }, &WatchOptions{
+		Interval: 5 * time.Millisecond,
+		OnError:  func(err error) { errs <- err },
+		OnReload: func(fc []FieldChange) { reloads <- fc },
+	})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	// A half-finished edit is reported but not applied
+	write("rate: 20\nburst: 1")
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "burst must be greater than or equal to rate") {
+			t.Errorf("Unexpected error %v", err)
+		}
+	case c := <-changes:
+		t.Fatalf("Expected the invalid edit to be ignored, got %+v", c)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the invalid edit to be reported")
+	}
+
+	write("rate: 20\nburst: 40\n")
+	select {
+	case c := <-changes:
+		if c.Rate != 20 || c.Burst != 40 {
+			t.Errorf("Expected rate 20 and burst 40, got %d and %d", c.Rate, c.Burst)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the edit to be reported")
+	}
+	if fc := <-reloads; len(fc) != 2 || fc[0].String() != "rate 10→20" || fc[1].String() != "burst 20→40" {
+		t.Errorf("Expected the reload to report the changed fields, got %v", fc)
+	}
+
+	// Rewriting the same config differently is not a change
+	write("# tuned during the incident\nrate: 20\nburst: 40\n")
+	select {
+	case c := <-changes:
+		t.Errorf("Expected no callback for an equal config, got %+v", c)
+	case err := <-errs:
+		t.Errorf("Unexpected error %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	stop()
+	stop()
+	write("rate: 30\nburst: 60\n")
+	time.Sleep(50 * time.Millisecond)
+	if len(changes) != 0 {
+		t.Errorf("Expected no callback after stop, got %d", len(changes))
+	}
+	leaktest.AssertNoLeak(t, baseline, time.Second)
+}
+
+func TestWatchRequiresValidFile(t *testing.T) {
+	if _, err := Watch(filepath.Join(t.TempDir(), "missing.json"), func(*Config) {}); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	filename := filepath.Join(t.TempDir(), "invalid.json")
+	if err := os.WriteFile(filename, []byte(`{"rate": -1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Watch(filename, func(*Config) {}); err == nil {
+		t.Error("Expected an error for an invalid file")
+	}
+}
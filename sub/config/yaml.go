@@ -0,0 +1,1009 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The YAML support covers what hand-written configs use: block mappings and
+// sequences, flow collections on one line, plain, quoted and block scalars,
+// and comments. Anchors, aliases, tags and multiple documents are rejected.
+// Fields are those of the JSON format, but windows may also be durations
+// such as "2s" or "1m".
+
+// LoadYAMLFromFile loads configuration from a YAML file
+func LoadYAMLFromFile(filename string) (*Config, error) {
+	return loadFromFile(filename, true, false)
+}
+
+// LoadYAMLFromReader loads configuration from YAML read from an io.Reader.
+// Fields left out keep their defaults and unknown ones are reported by
+// Warnings, as with LoadFromReader.
+func LoadYAMLFromReader(r io.Reader) (*Config, error) {
+	return loadFromReader(r, true, false)
+}
+
+// SaveYAMLToFile saves configuration to a YAML file
+func (c *Config) SaveYAMLToFile(filename string) error {
+	return writeFileAtomic(filename, "config file", c.SaveYAMLToWriter)
+}
+
+// SaveYAMLToWriter saves configuration to an io.Writer as YAML, with
+// windows written as durations
+func (c *Config) SaveYAMLToWriter(w io.Writer) error {
+	if _, err := io.WriteString(w, encodeYAML(c)); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	return nil
+}
+
+// LoadYAMLFromFile loads a configuration set from a YAML file mapping names
+// to configurations
+func (cs *ConfigSet) LoadYAMLFromFile(filename string) error {
+	return cs.loadFromFile(filename, true, false)
+}
+
+// SaveYAMLToFile saves the configuration set to a YAML file
+func (cs *ConfigSet) SaveYAMLToFile(filename string) error {
+	data := encodeYAML(cs.encodable())
+	return writeFileAtomic(filename, "config set file", func(w io.Writer) error {
+		if _, err := io.WriteString(w, data); err != nil {
+			return fmt.Errorf("failed to encode config set: %w", err)
+		}
+		return nil
+	})
+}
+
+// isYAMLFile reports whether filename has a YAML extension
+func isYAMLFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// decodeYAML parses YAML from r into v, which must be a pointer
+func decodeYAML(r io.Reader, v any) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	node, err := parseYAML(string(data))
+	if err != nil {
+		return fmt.Errorf("yaml: %w", err)
+	}
+	if err := decodeYAMLNode(node, reflect.ValueOf(v).Elem()); err != nil {
+		return fmt.Errorf("yaml: %w", err)
+	}
+	return nil
+}
+
+type yamlKind int
+
+const (
+	yamlNull yamlKind = iota
+	yamlScalar
+	yamlMapping
+	yamlSequence
+)
+
+// yamlNode is a parsed YAML value. Scalars keep their text and are only
+// interpreted once the type they decode into is known.
+type yamlNode struct {
+	kind   yamlKind
+	line   int
+	value  string
+	quoted bool
+	keys   []string
+	fields map[string]*yamlNode
+	items  []*yamlNode
+}
+
+// describe names the node in type errors
+func (n *yamlNode) describe() string {
+	switch n.kind {
+	case yamlMapping:
+		return "a mapping"
+	case yamlSequence:
+		return "a sequence"
+	}
+	return strconv.Quote(n.value)
+}
+
+// yamlLine is a line with content, its comment removed
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+// parseYAML parses a single YAML document. An empty document is a nil node.
+func parseYAML(data string) (*yamlNode, error) {
+	data = strings.TrimPrefix(data, "\ufeff")
+	p := &yamlParser{lines: strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")}
+
+	line, ok, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	for ok && line.indent == 0 && strings.HasPrefix(line.text, "%") {
+		p.pos++
+		if line, ok, err = p.peek(); err != nil {
+			return nil, err
+		}
+	}
+	if ok && line.indent == 0 && line.text == "---" {
+		p.pos++
+	}
+
+	node, err := p.parseNode()
+	if err != nil {
+		return nil, err
+	}
+
+	line, ok, err = p.peek()
+	if ok && line.indent == 0 && line.text == "..." {
+		p.pos++
+		line, ok, err = p.peek()
+	}
+	switch {
+	case err != nil:
+		return nil, err
+	case !ok:
+		return node, nil
+	case isYAMLDocumentMarker(line):
+		return nil, fmt.Errorf("line %d: multiple documents are not supported", line.num)
+	}
+	return nil, fmt.Errorf("line %d: unexpected %q", line.num, line.text)
+}
+
+// peek returns the next line with content without consuming it, skipping
+// blank lines and comments
+func (p *yamlParser) peek() (yamlLine, bool, error) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos]
+		trimmed := strings.TrimLeft(raw, " ")
+		text := strings.TrimRight(stripYAMLComment(trimmed), " \t")
+		if strings.TrimLeft(text, "\t") == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return yamlLine{}, false, fmt.Errorf("line %d: tabs are not allowed in indentation", p.pos+1)
+		}
+		return yamlLine{num: p.pos + 1, indent: len(raw) - len(trimmed), text: text}, true, nil
+	}
+	return yamlLine{}, false, nil
+}
+
+// parseNode parses the block starting at the next line, at that line's
+// indentation
+func (p *yamlParser) parseNode() (*yamlNode, error) {
+	line, ok, err := p.peek()
+	if err != nil || !ok {
+		return nil, err
+	}
+	if isYAMLSequenceEntry(line.text) {
+		return p.parseSequence(line.indent)
+	}
+	if _, _, isKey, err := splitYAMLKey(line.text); err != nil {
+		return nil, fmt.Errorf("line %d: %w", line.num, err)
+	} else if isKey {
+		return p.parseMapping(line.indent)
+	}
+
+	p.pos++
+	if isYAMLBlockScalar(line.text) {
+		return p.parseBlockScalar(line.text, -1, line.num)
+	}
+	return parseYAMLInline(line.text, line.num)
+}
+
+func (p *yamlParser) parseMapping(indent int) (*yamlNode, error) {
+	node := &yamlNode{kind: yamlMapping, line: p.pos + 1, fields: make(map[string]*yamlNode)}
+	for {
+		line, ok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || line.indent < indent || isYAMLDocumentMarker(line) {
+			return node, nil
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+		key, rest, isKey, err := splitYAMLKey(line.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.num, err)
+		}
+		if !isKey {
+			return nil, fmt.Errorf("line %d: expected a key, got %q", line.num, line.text)
+		}
+		if _, dup := node.fields[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.num, key)
+		}
+		p.pos++
+
+		var value *yamlNode
+		switch {
+		case rest == "":
+			next, ok, err := p.peek()
+			switch {
+			case err != nil:
+				return nil, err
+			case ok && next.indent > indent:
+				value, err = p.parseNode()
+			case ok && next.indent == indent && isYAMLSequenceEntry(next.text):
+				// A sequence may sit at the same indentation as its key
+				value, err = p.parseSequence(indent)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case isYAMLBlockScalar(rest):
+			value, err = p.parseBlockScalar(rest, indent, line.num)
+		default:
+			value, err = parseYAMLInline(rest, line.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.keys = append(node.keys, key)
+		node.fields[key] = value
+	}
+}
+
+func (p *yamlParser) parseSequence(indent int) (*yamlNode, error) {
+	node := &yamlNode{kind: yamlSequence, line: p.pos + 1}
+	for {
+		line, ok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || line.indent < indent || line.indent == indent && !isYAMLSequenceEntry(line.text) {
+			return node, nil
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+
+		rest := strings.TrimLeft(line.text[1:], " ")
+		var value *yamlNode
+		switch {
+		case rest == "":
+			p.pos++
+			if next, ok, err := p.peek(); err != nil {
+				return nil, err
+			} else if ok && next.indent > indent {
+				value, err = p.parseNode()
+			}
+		case isYAMLBlockScalar(rest):
+			p.pos++
+			value, err = p.parseBlockScalar(rest, indent, line.num)
+		default:
+			// The entry continues as a block indented past the dash, so
+			// "- count: 5" starts a mapping whose keys line up with count
+			p.lines[p.pos] = strings.Repeat(" ", line.indent+len(line.text)-len(rest)) + rest
+			value, err = p.parseNode()
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.items = append(node.items, value)
+	}
+}
+
+// parseBlockScalar reads the lines of a | or > scalar indented past parent
+func (p *yamlParser) parseBlockScalar(header string, parent, num int) (*yamlNode, error) {
+	chomp := header[1:]
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, fmt.Errorf("line %d: unsupported block scalar header %q", num, header)
+	}
+
+	var lines []string
+	indent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos]
+		if strings.TrimSpace(raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		n := len(raw) - len(strings.TrimLeft(raw, " "))
+		if indent < 0 {
+			if n <= parent {
+				break
+			}
+			indent = n
+		}
+		if n < indent {
+			break
+		}
+		lines = append(lines, raw[indent:])
+	}
+
+	end := len(lines)
+	for end > 0 && lines[end-1] == "" {
+		end--
+	}
+	content, trailing := lines[:end], len(lines)-end
+
+	var b strings.Builder
+	for i, line := range content {
+		switch {
+		case i == 0:
+		case header[0] == '|':
+			b.WriteByte('\n')
+		case line == "":
+			// A blank line folds to a line break
+			b.WriteByte('\n')
+			continue
+		case content[i-1] != "":
+			b.WriteByte(' ')
+		}
+		b.WriteString(line)
+	}
+	if len(content) > 0 && chomp != "-" {
+		b.WriteByte('\n')
+	}
+	if chomp == "+" {
+		b.WriteString(strings.Repeat("\n", trailing))
+	}
+	return &yamlNode{kind: yamlScalar, line: num, value: b.String(), quoted: true}, nil
+}
+
+// parseYAMLInline parses a value that fits on one line
+func parseYAMLInline(text string, num int) (*yamlNode, error) {
+	switch text[0] {
+	case '"', '\'':
+		value, n, err := scanYAMLQuoted(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", num, err)
+		}
+		if rest := strings.TrimSpace(text[n:]); rest != "" {
+			return nil, fmt.Errorf("line %d: unexpected %q after quoted string", num, rest)
+		}
+		return &yamlNode{kind: yamlScalar, line: num, value: value, quoted: true}, nil
+	case '[', '{':
+		f := &yamlFlow{text: text, line: num}
+		node, err := f.parse()
+		if err != nil {
+			return nil, err
+		}
+		if rest := strings.TrimSpace(f.text[f.pos:]); rest != "" {
+			return nil, fmt.Errorf("line %d: unexpected %q after flow collection", num, rest)
+		}
+		return node, nil
+	case '&', '*', '!', '|', '>', '%', '@', '`':
+		return nil, fmt.Errorf("line %d: unsupported YAML syntax %q", num, text)
+	}
+	return plainYAMLScalar(text, num), nil
+}
+
+func plainYAMLScalar(text string, num int) *yamlNode {
+	switch text {
+	case "", "~", "null", "Null", "NULL":
+		return &yamlNode{kind: yamlNull, line: num}
+	}
+	return &yamlNode{kind: yamlScalar, line: num, value: text}
+}
+
+// yamlFlow parses a flow collection such as [a, b] or {count: 5}
+type yamlFlow struct {
+	text string
+	pos  int
+	line int
+}
+
+func (f *yamlFlow) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", f.line, fmt.Sprintf(format, args...))
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.text) && (f.text[f.pos] == ' ' || f.text[f.pos] == '\t') {
+		f.pos++
+	}
+}
+
+// next returns the next non-space byte, or 0 at the end of the line
+func (f *yamlFlow) next() byte {
+	f.skipSpace()
+	if f.pos == len(f.text) {
+		return 0
+	}
+	return f.text[f.pos]
+}
+
+func (f *yamlFlow) parse() (*yamlNode, error) {
+	switch f.next() {
+	case '[':
+		f.pos++
+		node := &yamlNode{kind: yamlSequence, line: f.line}
+		for f.next() != ']' {
+			item, err := f.parse()
+			if err != nil {
+				return nil, err
+			}
+			node.items = append(node.items, item)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+		f.pos++
+		return node, nil
+	case '{':
+		f.pos++
+		node := &yamlNode{kind: yamlMapping, line: f.line, fields: make(map[string]*yamlNode)}
+		for f.next() != '}' {
+			key, err := f.scalar(true)
+			if err != nil {
+				return nil, err
+			}
+			if key.kind != yamlScalar {
+				return nil, f.errorf("expected a key")
+			}
+			if _, dup := node.fields[key.value]; dup {
+				return nil, f.errorf("duplicate key %q", key.value)
+			}
+			if f.next() != ':' {
+				return nil, f.errorf("expected ':' after key %q", key.value)
+			}
+			f.pos++
+			var value *yamlNode
+			if c := f.next(); c != ',' && c != '}' {
+				if value, err = f.parse(); err != nil {
+					return nil, err
+				}
+			}
+			node.keys = append(node.keys, key.value)
+			node.fields[key.value] = value
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+		f.pos++
+		return node, nil
+	case 0:
+		return nil, f.errorf("unterminated flow collection")
+	}
+	return f.scalar(false)
+}
+
+// separator consumes the comma after an entry, unless the collection ends
+func (f *yamlFlow) separator(end byte) error {
+	switch f.next() {
+	case ',':
+		f.pos++
+		return nil
+	case end:
+		return nil
+	case 0:
+		return f.errorf("unterminated flow collection")
+	}
+	return f.errorf("expected ',' or %q, got %q", end, f.text[f.pos:])
+}
+
+// scalar parses a quoted or plain scalar. Plain scalars end at a flow
+// indicator, or at a colon for keys.
+func (f *yamlFlow) scalar(key bool) (*yamlNode, error) {
+	if c := f.next(); c == '"' || c == '\'' {
+		value, n, err := scanYAMLQuoted(f.text[f.pos:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", f.line, err)
+		}
+		f.pos += n
+		return &yamlNode{kind: yamlScalar, line: f.line, value: value, quoted: true}, nil
+	}
+	start := f.pos
+	for f.pos < len(f.text) && !strings.ContainsRune(",[]{}", rune(f.text[f.pos])) && !(key && f.text[f.pos] == ':') {
+		f.pos++
+	}
+	text := strings.TrimSpace(f.text[start:f.pos])
+	if text != "" && strings.ContainsRune("&*!|>%@`", rune(text[0])) {
+		return nil, f.errorf("unsupported YAML syntax %q", text)
+	}
+	return plainYAMLScalar(text, f.line), nil
+}
+
+// isYAMLSequenceEntry reports whether text starts a block sequence entry
+func isYAMLSequenceEntry(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// isYAMLDocumentMarker reports whether line starts a new document
+func isYAMLDocumentMarker(line yamlLine) bool {
+	return line.indent == 0 && (line.text == "---" || strings.HasPrefix(line.text, "--- "))
+}
+
+// isYAMLBlockScalar reports whether text is a block scalar header
+func isYAMLBlockScalar(text string) bool {
+	return text != "" && (text[0] == '|' || text[0] == '>')
+}
+
+// splitYAMLKey splits a mapping entry into its key and the rest of the
+// line. ok is false if text is not a mapping entry.
+func splitYAMLKey(text string) (key, rest string, ok bool, err error) {
+	switch text[0] {
+	case '[', '{':
+		return "", "", false, nil
+	case '"', '\'':
+		value, n, err := scanYAMLQuoted(text)
+		if err != nil {
+			return "", "", false, err
+		}
+		after := strings.TrimLeft(text[n:], " ")
+		if after == ":" || strings.HasPrefix(after, ": ") {
+			return value, strings.TrimSpace(after[1:]), true, nil
+		}
+		return "", "", false, nil
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimRight(text[:i], " "), strings.TrimSpace(text[i+1:]), true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// stripYAMLComment removes a comment from a line. A # starts a comment at
+// the start of the line or after a space, outside quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(line) && line[i+1] == '\'':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" [{,:", line[i-1]) >= 0):
+			quote = c
+		}
+	}
+	return line
+}
+
+// scanYAMLQuoted parses the quoted string text starts with, returning its
+// value and length
+func scanYAMLQuoted(text string) (string, int, error) {
+	if text[0] == '\'' {
+		var b strings.Builder
+		for i := 1; i < len(text); i++ {
+			if text[i] != '\'' {
+				b.WriteByte(text[i])
+				continue
+			}
+			if i+1 < len(text) && text[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), i + 1, nil
+		}
+		return "", 0, errors.New("unterminated quoted string")
+	}
+
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := unescapeYAML(text[1:i])
+			return value, i + 1, err
+		}
+	}
+	return "", 0, errors.New("unterminated quoted string")
+}
+
+// unescapeYAML resolves the escapes of a double-quoted string, which are
+// Go's plus a few of YAML's own
+func unescapeYAML(s string) (string, error) {
+	var b strings.Builder
+	for len(s) > 0 {
+		if len(s) >= 2 && s[0] == '\\' {
+			if r, ok := yamlEscapes[s[1]]; ok {
+				b.WriteRune(r)
+				s = s[2:]
+				continue
+			}
+		}
+		r, multibyte, tail, err := strconv.UnquoteChar(s, '"')
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		if r < utf8.RuneSelf && !multibyte {
+			b.WriteByte(byte(r))
+		} else {
+			b.WriteRune(r)
+		}
+		s = tail
+	}
+	return b.String(), nil
+}
+
+var yamlEscapes = map[byte]rune{
+	'0': 0,
+	'e': 0x1b,
+	' ': ' ',
+	'/': '/',
+	'N': 0x85,
+	'_': 0xa0,
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decodeYAMLNode stores node in v, matching mapping keys to the json names
+// of struct fields. Keys without a field are ignored, as with JSON.
+func decodeYAMLNode(node *yamlNode, v reflect.Value) error {
+	if node == nil || node.kind == yamlNull {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Map, reflect.Slice:
+			v.SetZero()
+		}
+		return nil
+	}
+	typeError := func() error {
+		return fmt.Errorf("line %d: cannot use %s as %s", node.line, node.describe(), v.Type())
+	}
+
+	if v.Type() == durationType {
+		if node.kind != yamlScalar {
+			return typeError()
+		}
+		if n, err := strconv.ParseInt(node.value, 10, 64); err == nil && !node.quoted {
+			v.SetInt(n)
+			return nil
+		}
+		d, err := time.ParseDuration(node.value)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid duration %q", node.line, node.value)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeYAMLNode(node, v.Elem())
+	case reflect.String:
+		if node.kind != yamlScalar {
+			return typeError()
+		}
+		v.SetString(node.value)
+	case reflect.Bool:
+		if node.kind != yamlScalar || node.quoted {
+			return typeError()
+		}
+		switch node.value {
+		case "true", "True", "TRUE":
+			v.SetBool(true)
+		case "false", "False", "FALSE":
+			v.SetBool(false)
+		default:
+			return typeError()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if node.kind != yamlScalar || node.quoted {
+			return typeError()
+		}
+		n, err := strconv.ParseInt(node.value, 10, 64)
+		if err != nil || v.OverflowInt(n) {
+			return typeError()
+		}
+		v.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		if node.kind != yamlScalar || node.quoted {
+			return typeError()
+		}
+		f, err := strconv.ParseFloat(node.value, v.Type().Bits())
+		if err != nil {
+			return typeError()
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if node.kind != yamlSequence {
+			return typeError()
+		}
+		s := reflect.MakeSlice(v.Type(), len(node.items), len(node.items))
+		for i, item := range node.items {
+			if err := decodeYAMLNode(item, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Map:
+		if node.kind != yamlMapping || v.Type().Key().Kind() != reflect.String {
+			return typeError()
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), len(node.keys)))
+		}
+		for _, key := range node.keys {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeYAMLNode(node.fields[key], elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		}
+	case reflect.Struct:
+		if node.kind != yamlMapping {
+			return typeError()
+		}
+		fields := taggedFields(v.Type())
+		for _, key := range node.keys {
+			if field, ok := lookupTaggedField(fields, key); ok {
+				if err := decodeYAMLNode(node.fields[key], v.Field(field.index)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("line %d: cannot decode into %s", node.line, v.Type())
+		}
+		v.Set(reflect.ValueOf(yamlValue(node)))
+	default:
+		return fmt.Errorf("line %d: cannot decode into %s", node.line, v.Type())
+	}
+	return nil
+}
+
+// yamlValue returns node as encoding/json would decode the equivalent JSON
+// into an interface: plain true, false and numbers as bool and float64,
+// mappings as map[string]any and sequences as []any
+func yamlValue(node *yamlNode) any {
+	if node == nil {
+		return nil
+	}
+	switch node.kind {
+	case yamlMapping:
+		m := make(map[string]any, len(node.keys))
+		for _, key := range node.keys {
+			m[key] = yamlValue(node.fields[key])
+		}
+		return m
+	case yamlSequence:
+		items := make([]any, len(node.items))
+		for i, item := range node.items {
+			items[i] = yamlValue(item)
+		}
+		return items
+	case yamlScalar:
+		if !node.quoted {
+			switch node.value {
+			case "true", "True", "TRUE":
+				return true
+			case "false", "False", "FALSE":
+				return false
+			}
+			if f, err := strconv.ParseFloat(node.value, 64); err == nil {
+				return f
+			}
+		}
+		return node.value
+	}
+	return nil
+}
+
+// taggedField is a struct field under its json name
+type taggedField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+func taggedFields(t reflect.Type) []taggedField {
+	var fields []taggedField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		omitEmpty := false
+		for _, opt := range strings.Split(opts, ",") {
+			omitEmpty = omitEmpty || opt == "omitempty"
+		}
+		fields = append(fields, taggedField{name: name, index: i, omitEmpty: omitEmpty})
+	}
+	return fields
+}
+
+// lookupTaggedField finds the field for key, preferring an exact match but
+// ignoring case otherwise, like encoding/json
+func lookupTaggedField(fields []taggedField, key string) (taggedField, bool) {
+	for _, f := range fields {
+		if f.name == key {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, key) {
+			return f, true
+		}
+	}
+	return taggedField{}, false
+}
+
+// encodeYAML writes v as a YAML document using the json names and
+// omitempty options of struct fields, with durations as text
+func encodeYAML(v any) string {
+	var b strings.Builder
+	rv := indirectYAML(reflect.ValueOf(v))
+	if text, ok := yamlScalarText(rv); ok {
+		b.WriteString(text + "\n")
+	} else if empty, ok := emptyYAMLCollection(rv); ok {
+		b.WriteString(empty + "\n")
+	} else {
+		writeYAMLBlock(&b, rv, 0)
+	}
+	return b.String()
+}
+
+// writeYAMLBlock writes a non-empty mapping or sequence at indent
+func writeYAMLBlock(b *strings.Builder, v reflect.Value, indent int) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for _, f := range taggedFields(v.Type()) {
+			field := v.Field(f.index)
+			if f.omitEmpty && isEmptyYAMLValue(field) {
+				continue
+			}
+			writeYAMLEntry(b, indent, quoteYAMLString(f.name), field)
+		}
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeYAMLEntry(b, indent, quoteYAMLString(key), v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())))
+		}
+	case reflect.Slice, reflect.Array:
+		pad := strings.Repeat(" ", indent)
+		for i := 0; i < v.Len(); i++ {
+			item := indirectYAML(v.Index(i))
+			if text, ok := yamlScalarText(item); ok {
+				b.WriteString(pad + "- " + text + "\n")
+			} else if empty, ok := emptyYAMLCollection(item); ok {
+				b.WriteString(pad + "- " + empty + "\n")
+			} else {
+				// Write the item indented past the dash, then put the dash
+				// in front of its first line
+				var nested strings.Builder
+				writeYAMLBlock(&nested, item, indent+2)
+				b.WriteString(pad + "- " + nested.String()[indent+2:])
+			}
+		}
+	}
+}
+
+func writeYAMLEntry(b *strings.Builder, indent int, key string, v reflect.Value) {
+	pad := strings.Repeat(" ", indent)
+	v = indirectYAML(v)
+	if text, ok := yamlScalarText(v); ok {
+		b.WriteString(pad + key + ": " + text + "\n")
+		return
+	}
+	if empty, ok := emptyYAMLCollection(v); ok {
+		b.WriteString(pad + key + ": " + empty + "\n")
+		return
+	}
+	b.WriteString(pad + key + ":\n")
+	writeYAMLBlock(b, v, indent+2)
+}
+
+// indirectYAML follows non-nil pointers and interfaces
+func indirectYAML(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// yamlScalarText formats v if it is a scalar
+func yamlScalarText(v reflect.Value) (string, bool) {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String(), true
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return "null", true
+	case reflect.String:
+		return quoteYAMLString(v.String()), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), true
+	}
+	return "", false
+}
+
+// emptyYAMLCollection returns the flow form of an empty collection
+func emptyYAMLCollection(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		return "[]", v.Len() == 0
+	case reflect.Map:
+		return "{}", v.Len() == 0
+	case reflect.Struct:
+		return "{}", len(taggedFields(v.Type())) == 0
+	}
+	return "", false
+}
+
+// isEmptyYAMLValue reports whether omitempty leaves v out, as encoding/json
+// does
+func isEmptyYAMLValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// quoteYAMLString returns s as a plain scalar if it would read back as the
+// same string, and double-quoted otherwise
+func quoteYAMLString(s string) string {
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsRune("-?:,[]{}#&*!|>'\"%@`~", rune(s[0])) ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return strconv.Quote(s)
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	switch strings.ToLower(s) {
+	case "null", "true", "false", "yes", "no", "on", "off", "y", "n", ".inf", "-.inf", ".nan":
+		return strconv.Quote(s)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	return s
+}
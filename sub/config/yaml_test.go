@@ -0,0 +1,368 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadYAMLFromReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+		check   func(t *testing.T, c *Config)
+	}{
+		{
+			name: "valid yaml",
+			yaml: `
+# Limits for the public API
+rate: 50
+burst: 100
+window: 2s
+name: test-limiter
+enabled: true
+error_message: "Custom error: slow down"  # quoted for the colon
+`,
+			check: func(t *testing.T, c *Config) {
+				if c.Rate != 50 || c.Burst != 100 {
+					t.Errorf("Expected Rate 50 and Burst 100, got %d and %d", c.Rate, c.Burst)
+				}
+				if c.Window != 2*time.Second {
+					t.Errorf("Expected Window 2s, got %v", c.Window)
+				}
+				if c.Name != "test-limiter" {
+					t.Errorf("Expected Name 'test-limiter', got %q", c.Name)
+				}
+				if c.ErrorMessage != "Custom error: slow down" {
+					t.Errorf("Expected custom error message, got %q", c.ErrorMessage)
+				}
+			},
+		},
+		{
+			name: "nested fields",
+			yaml: `
+rate: 10
+burst: 20
+excluded_paths:
+- /health
+- "/metrics"
+excluded_ips: [10.0.0.0/8, 127.0.0.1]
+custom_headers:
+  X-Team: platform
+  X-Retry: 5
+limits:
+  - count: 10
+    window: 1s
+  - {count: 1000, window: 1h}
+costs:
+  GET /api/search: 5
+  /api/export*: 8
+response_template: |
+  {"error": "rate limited",
+   "docs": "{{.DocsURL}}"}
+`,
+			check: func(t *testing.T, c *Config) {
+				if !reflect.DeepEqual(c.ExcludedPaths, []string{"/health", "/metrics"}) {
+					t.Errorf("Unexpected ExcludedPaths %q", c.ExcludedPaths)
+				}
+				if !reflect.DeepEqual(c.ExcludedIPs, []string{"10.0.0.0/8", "127.0.0.1"}) {
+					t.Errorf("Unexpected ExcludedIPs %q", c.ExcludedIPs)
+				}
+				if !reflect.DeepEqual(c.CustomHeaders, map[string]string{"X-Team": "platform", "X-Retry": "5"}) {
+					t.Errorf("Unexpected CustomHeaders %v", c.CustomHeaders)
+				}
+				want := []WindowLimit{{Count: 10, Window: time.Second}, {Count: 1000, Window: time.Hour}}
+				if !reflect.DeepEqual(c.Limits, want) {
+					t.Errorf("Expected limits %v, got %v", want, c.Limits)
+				}
+				if !reflect.DeepEqual(c.Costs, map[string]int{"GET /api/search": 5, "/api/export*": 8}) {
+					t.Errorf("Unexpected Costs %v", c.Costs)
+				}
+				if want := "{\"error\": \"rate limited\",\n \"docs\": \"{{.DocsURL}}\"}\n"; c.ResponseTemplate != want {
+					t.Errorf("Expected template %q, got %q", want, c.ResponseTemplate)
+				}
+			},
+		},
+		{
+			name: "window in nanoseconds",
+			yaml: "rate: 5\nwindow: 2000000000\n",
+			check: func(t *testing.T, c *Config) {
+				if c.Window != 2*time.Second {
+					t.Errorf("Expected Window 2s, got %v", c.Window)
+				}
+			},
+		},
+		{
+			name: "partial config uses defaults",
+			yaml: "rate: 5\n",
+			check: func(t *testing.T, c *Config) {
+				if c.Rate != 5 {
+					t.Errorf("Expected Rate 5, got %d", c.Rate)
+				}
+				if c.Burst != 20 {
+					t.Errorf("Expected default Burst 20, got %d", c.Burst)
+				}
+				if c.Window != time.Second || !c.Enabled {
+					t.Errorf("Expected the default window and enabled, got %v and %v", c.Window, c.Enabled)
+				}
+			},
+		},
+		{
+			name: "empty document uses defaults",
+			yaml: "---\n# nothing yet\n",
+			check: func(t *testing.T, c *Config) {
+				if !reflect.DeepEqual(c, DefaultConfig()) {
+					t.Errorf("Expected the default config, got %+v", c)
+				}
+			},
+		},
+		{
+			name:    "invalid type",
+			yaml:    "rate: not a number\n",
+			wantErr: `line 1: cannot use "not a number" as int`,
+		},
+		{
+			name:    "invalid duration",
+			yaml:    "rate: 5\nwindow: soon\n",
+			wantErr: `line 2: invalid duration "soon"`,
+		},
+		{
+			name:    "invalid config",
+			yaml:    "rate: -1\nburst: 10\n",
+			wantErr: "invalid config",
+		},
+		{
+			name:    "bad indentation",
+			yaml:    "rate: 5\n  burst: 10\n",
+			wantErr: "line 2: unexpected indentation",
+		},
+		{
+			name:    "duplicate key",
+			yaml:    "rate: 5\nrate: 10\n",
+			wantErr: `line 2: duplicate key "rate"`,
+		},
+		{
+			name:    "tabs",
+			yaml:    "custom_headers:\n\tX-Team: platform\n",
+			wantErr: "line 2: tabs are not allowed",
+		},
+		{
+			name:    "anchors",
+			yaml:    "limits: &limits []\n",
+			wantErr: "unsupported YAML syntax",
+		},
+		{
+			name:    "unterminated string",
+			yaml:    "name: \"api\n",
+			wantErr: "line 1: unterminated quoted string",
+		},
+		{
+			name:    "multiple documents",
+			yaml:    "rate: 5\n---\nrate: 10\n",
+			wantErr: "line 2: multiple documents are not supported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadYAMLFromReader(strings.NewReader(tt.yaml))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadYAMLFromReader() error = %v", err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestParseYAMLScalars(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want string
+	}{
+		{`value: plain text`, "plain text"},
+		{`value: 'it''s # not a comment'`, "it's # not a comment"},
+		{`value: "tab\tand é and \/"`, "tab\tand é and /"},
+		{`value: a#b # comment`, "a#b"},
+		{"value: |-\n  one\n\n  two\n", "one\n\ntwo"},
+		{"value: >\n  folded\n  line\n\n  next\n", "folded line\nnext\n"},
+		{"\"quoted key\": x\nvalue: y\n", "y"},
+		{"value:\n  - a\n  - b\n", ""},
+	}
+	for _, tt := range tests {
+		var got struct {
+			Value string `json:"value"`
+		}
+		err := decodeYAML(strings.NewReader(tt.yaml), &got)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%q: expected a sequence to be refused as a string", tt.yaml)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.yaml, err)
+		} else if got.Value != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.yaml, tt.want, got.Value)
+		}
+	}
+}
+
+func TestSaveYAMLToWriter(t *testing.T) {
+	initial := 5
+	config := &Config{
+		Rate:             30,
+		Burst:            60,
+		Window:           5 * time.Second,
+		Name:             "test",
+		Enabled:          true,
+		ErrorMessage:     "Rate limited: try again",
+		ExcludedPaths:    []string{"/health", "/metrics"},
+		CustomHeaders:    map[string]string{"X-RateLimit-Limit": "60", "Retry-After": "5", "X-Flag": "true"},
+		ResponseTemplate: "{\"error\": \"limited\"}\n# not a comment",
+		Costs:            map[string]int{"POST /api/*": 2},
+		InitialTokens:    &initial,
+	}
+
+	var buf bytes.Buffer
+	if err := config.SaveYAMLToWriter(&buf); err != nil {
+		t.Fatalf("SaveYAMLToWriter() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "window: 5s\n") {
+		t.Errorf("Expected the window as a duration, got:\n%s", buf.String())
+	}
+
+	loaded, err := LoadYAMLFromReader(&buf)
+	if err != nil {
+		t.Fatalf("LoadYAMLFromReader() error = %v", err)
+	}
+	if !reflect.DeepEqual(loaded, config) {
+		t.Errorf("Round trip mismatch: got %+v, want %+v", loaded, config)
+	}
+
+	limits := &Config{Rate: 1, Burst: 1, Enabled: true, Limits: []WindowLimit{
+		{Count: 10, Window: time.Second},
+		{Count: 500, Window: 90 * time.Minute},
+	}}
+	buf.Reset()
+	if err := limits.SaveYAMLToWriter(&buf); err != nil {
+		t.Fatalf("SaveYAMLToWriter() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "limits:\n  - count: 10\n    window: 1s\n") {
+		t.Errorf("Expected limits as a block sequence, got:\n%s", buf.String())
+	}
+	loaded, err = LoadYAMLFromReader(&buf)
+	if err != nil {
+		t.Fatalf("LoadYAMLFromReader() error = %v", err)
+	}
+	if !reflect.DeepEqual(loaded.Limits, limits.Limits) {
+		t.Errorf("Limits mismatch: got %v, want %v", loaded.Limits, limits.Limits)
+	}
+}
+
+func TestLoadFromFileSniffsYAML(t *testing.T) {
+	tmpDir := t.TempDir()
+	testConfig := &Config{Rate: 25, Burst: 50, Window: time.Minute, Name: "file-test"}
+
+	for _, name := range []string{"config.yaml", "config.YML"} {
+		filename := filepath.Join(tmpDir, name)
+		if err := testConfig.SaveToFile(filename); err != nil {
+			t.Fatalf("SaveToFile() error = %v", err)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "window: 1m0s") {
+			t.Errorf("%s: expected YAML, got:\n%s", name, data)
+		}
+
+		loaded, err := LoadFromFile(filename)
+		if err != nil {
+			t.Fatalf("LoadFromFile() error = %v", err)
+		}
+		if loaded.Rate != 25 || loaded.Window != time.Minute || loaded.Name != "file-test" {
+			t.Errorf("%s: unexpected config %+v", name, loaded)
+		}
+	}
+
+	if _, err := LoadYAMLFromFile("/non/existent/file.yaml"); err == nil {
+		t.Error("Expected error for non-existent file")
+	}
+}
+
+func TestConfigSetYAMLFileOperations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "configset.yaml")
+
+	cs := NewConfigSet()
+	cs.Add("default", &Config{Rate: 10, Burst: 20, Window: time.Second})
+	cs.Add("premium", &Config{Rate: 100, Burst: 200, Window: time.Second})
+	cs.Add("search", &Config{Extends: "premium", Rate: 5})
+
+	if err := cs.SaveToFile(filename); err != nil {
+		t.Fatalf("SaveToFile() error = %v", err)
+	}
+
+	cs2 := NewConfigSet()
+	if err := cs2.LoadFromFile(filename); err != nil {
+		t.Fatalf("LoadFromFile() error = %v", err)
+	}
+	for _, name := range []string{"default", "premium", "search"} {
+		want, _ := cs.Get(name)
+		got, ok := cs2.Get(name)
+		if !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %+v, got %+v", name, want, got)
+		}
+	}
+	resolved, err := cs2.Resolve("search")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if resolved.Rate != 5 || resolved.Burst != 200 {
+		t.Errorf("Expected search to extend premium, got %+v", resolved)
+	}
+}
+
+func TestConfigSetYAMLLoadResolvesUpFront(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "set.yml")
+	if err := os.WriteFile(path, []byte(`
+base:
+  rate: 10
+  burst: 20
+  enabled: true
+api:
+  extends: missing
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := NewConfigSet().LoadFromFile(path)
+	if err == nil || !strings.Contains(err.Error(), `extends unknown config "missing"`) {
+		t.Errorf("Expected load to fail on the dangling reference, got %v", err)
+	}
+}
+
+func TestQuoteYAMLString(t *testing.T) {
+	for _, s := range []string{"", "true", "no", "null", "~", "12", "1.5", "0x1F", " padded", "a: b", "a #b", "key:",
+		"- item", "*", "{json}", "line\nbreak", "tab\there", "'quoted'", "plain", "/api/*", "GET /api", "é"} {
+		quoted := quoteYAMLString(s)
+		var got struct {
+			Value string `json:"value"`
+		}
+		if err := decodeYAML(strings.NewReader("value: "+quoted+"\n"), &got); err != nil {
+			t.Errorf("%q written as %s: %v", s, quoted, err)
+		} else if got.Value != s {
+			t.Errorf("%q written as %s reads back as %q", s, quoted, got.Value)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package ipc
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+// ErrClientClosed is returned by Check after Close has been called
+var ErrClientClosed = errors.New("ipc: client closed")
+
+// ClientOptions configures a limiter client
+type ClientOptions struct {
+	// Timeout bounds a single round trip, including dialing. Defaults to 100ms.
+	Timeout time.Duration
+	// MaxIdleConns is the number of connections kept open for reuse.
+	// Defaults to 4.
+	MaxIdleConns int
+	// Fallback decides requests while the server is unreachable. When nil
+	// the client fails open and allows them.
+	Fallback RateLimiter
+}
+
+// Client is a rate limiter backed by a limiter server. It is safe for
+// concurrent use; each in-flight request uses its own connection.
+type Client struct {
+	socketPath string
+	timeout    time.Duration
+	fallback   RateLimiter
+	idle       chan *clientConn
+	mu         sync.Mutex
+	closed     bool
+}
+
+type clientConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// DialLimiter connects to the limiter server listening on socketPath
+func DialLimiter(socketPath string) (*Client, error) {
+	return DialLimiterWithOptions(socketPath, nil)
+}
+
+// DialLimiterWithOptions connects to the limiter server listening on
+// socketPath using the given options
+func DialLimiterWithOptions(socketPath string, opts *ClientOptions) (*Client, error) {
+	c := &Client{
+		socketPath: socketPath,
+		timeout:    100 * time.Millisecond,
+	}
+
+	maxIdle := 4
+	if opts != nil {
+		if opts.Timeout > 0 {
+			c.timeout = opts.Timeout
+		}
+		if opts.MaxIdleConns > 0 {
+			maxIdle = opts.MaxIdleConns
+		}
+		c.fallback = opts.Fallback
+	}
+	c.idle = make(chan *clientConn, maxIdle)
+
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.release(conn)
+
+	return c, nil
+}
+
+// Allow asks the server for a single token
+func (c *Client) Allow() bool {
+	return c.AllowN(1)
+}
+
+// AllowN asks the server for n tokens. If the server cannot be reached the
+// decision is made by the fallback limiter, or allowed if there is none.
+func (c *Client) AllowN(n int) bool {
+	result, err := c.Check(n)
+	if err == nil {
+		return result.Allowed
+	}
+
+	var serverErr *serverError
+	if errors.As(err, &serverErr) {
+		return false
+	}
+	if c.fallback == nil {
+		return true
+	}
+	if n == 1 {
+		return c.fallback.Allow()
+	}
+	if l, ok := c.fallback.(limiter.NAllower); ok {
+		return l.AllowN(n)
+	}
+	return false
+}
+
+// Check asks the server for n tokens and returns its full answer. Unlike
+// AllowN it does not fall back when the server is unreachable.
+func (c *Client) Check(n int) (Result, error) {
+	conn, reused, err := c.acquire()
+	if err != nil {
+		return Result{}, err
+	}
+
+	var serverErr *serverError
+	result, err := c.roundTrip(conn, n)
+	if err != nil && reused && !errors.As(err, &serverErr) {
+		// The pooled connection may have gone stale after a server
+		// restart; retry once on a fresh one
+		conn.Close()
+		if conn, err = c.dial(); err != nil {
+			return Result{}, err
+		}
+		result, err = c.roundTrip(conn, n)
+	}
+
+	if err != nil && !errors.As(err, &serverErr) {
+		conn.Close()
+		return Result{}, err
+	}
+	c.release(conn)
+	return result, err
+}
+
+// Close closes all idle connections. Requests made afterwards fail with
+// ErrClientClosed or use the fallback.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	for {
+		select {
+		case conn := <-c.idle:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// serverError is an error reported by the server itself. The connection
+// remains usable after one.
+type serverError struct {
+	err error
+}
+
+func (e *serverError) Error() string {
+	return e.err.Error()
+}
+
+func (c *Client) roundTrip(conn *clientConn, n int) (Result, error) {
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return Result{}, err
+	}
+	if err := writeFrame(conn, []byte("ALLOW "+strconv.Itoa(n))); err != nil {
+		return Result{}, err
+	}
+
+	payload, err := readFrame(conn.reader)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result, err := parseResult(payload)
+	if err != nil {
+		return Result{}, &serverError{err: err}
+	}
+	return result, nil
+}
+
+// acquire returns an idle connection, or dials a new one. It reports
+// whether the connection came from the pool.
+func (c *Client) acquire() (*clientConn, bool, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil, false, ErrClientClosed
+	}
+
+	select {
+	case conn := <-c.idle:
+		return conn, true, nil
+	default:
+		conn, err := c.dial()
+		return conn, false, err
+	}
+}
+
+// release returns a healthy connection to the pool, closing it if the pool
+// is full or the client is closed
+func (c *Client) release(conn *clientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		conn.Close()
+		return
+	}
+
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func (c *Client) dial() (*clientConn, error) {
+	conn, err := net.DialTimeout("unix", c.socketPath, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &clientConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
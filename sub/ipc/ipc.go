@@ -0,0 +1,241 @@
+// Package ipc shares a rate limiter between processes on one host over a
+// Unix domain socket.
+//
+// Every message is a frame made of a 4-byte big-endian payload length
+// followed by the payload. Requests are "ALLOW <n>". Responses are
+// "<allowed> <retryAfter>", where allowed is 1 or 0 and retryAfter is the
+// suggested wait in nanoseconds, or "ERR <message>".
+package ipc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+// maxFrameSize bounds the payload of a single frame
+const maxFrameSize = 1024
+
+// ErrServerClosed is returned by Serve after Close has been called
+var ErrServerClosed = errors.New("ipc: server closed")
+
+// RateLimiter interface that the shared rate limiter should implement.
+// Limiters that also implement limiter.NAllower serve requests for several
+// tokens, and those implementing limiter.RetryAfterer report when to retry.
+type RateLimiter = limiter.Allower
+
+// Result is the server's answer to an admission request
+type Result struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// Server serves a rate limiter over a stream listener
+type Server struct {
+	limiter  RateLimiter
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a server sharing the given limiter
+func NewServer(rl RateLimiter) *Server {
+	return &Server{
+		limiter: rl,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServeLimiter listens on the Unix socket at socketPath and serves
+// rl to every client that connects. A stale socket file left behind by a
+// previous server is removed first. It blocks until the listener fails.
+func ListenAndServeLimiter(socketPath string, rl RateLimiter) error {
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on socket: %w", err)
+	}
+
+	return NewServer(rl).Serve(listener)
+}
+
+// Serve accepts connections on l until Close is called or l fails
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections, closes the open ones, and waits for
+// their handlers to return
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		request, err := readFrame(reader)
+		if err != nil {
+			return
+		}
+		if err := writeFrame(conn, s.handle(request)); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a single request payload
+func (s *Server) handle(request []byte) []byte {
+	command, arg, _ := strings.Cut(string(request), " ")
+	if command != "ALLOW" {
+		return []byte("ERR unknown command " + strconv.Quote(command))
+	}
+
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 {
+		return []byte("ERR invalid token count " + strconv.Quote(arg))
+	}
+
+	var allowed bool
+	switch l := s.limiter.(type) {
+	case limiter.NAllower:
+		allowed = l.AllowN(n)
+	default:
+		if n != 1 {
+			return []byte("ERR limiter does not support AllowN")
+		}
+		allowed = s.limiter.Allow()
+	}
+
+	var retryAfter time.Duration
+	if !allowed {
+		if l, ok := s.limiter.(limiter.RetryAfterer); ok {
+			retryAfter = l.RetryAfter()
+		}
+	}
+
+	return []byte(formatResult(Result{Allowed: allowed, RetryAfter: retryAfter}))
+}
+
+func formatResult(result Result) string {
+	allowed := "0"
+	if result.Allowed {
+		allowed = "1"
+	}
+	return allowed + " " + strconv.FormatInt(int64(result.RetryAfter), 10)
+}
+
+func parseResult(payload []byte) (Result, error) {
+	response := string(payload)
+	if message, ok := strings.CutPrefix(response, "ERR "); ok {
+		return Result{}, fmt.Errorf("ipc: server error: %s", message)
+	}
+
+	allowed, retryAfter, ok := strings.Cut(response, " ")
+	if !ok || (allowed != "0" && allowed != "1") {
+		return Result{}, fmt.Errorf("ipc: malformed response %q", response)
+	}
+	nanos, err := strconv.ParseInt(retryAfter, 10, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("ipc: malformed retry-after %q", retryAfter)
+	}
+
+	return Result{Allowed: allowed == "1", RetryAfter: time.Duration(nanos)}, nil
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("ipc: frame of %d bytes exceeds limit", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func writeFrame(w io.Writer, payload []byte) error {
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err := w.Write(frame)
+	return err
+}
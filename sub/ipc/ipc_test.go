@@ -0,0 +1,316 @@
+package ipc
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/internal/leaktest"
+)
+
+// budgetLimiter hands out a fixed number of tokens that never refill
+type budgetLimiter struct {
+	mu     sync.Mutex
+	tokens int
+}
+
+func (b *budgetLimiter) Allow() bool {
+	return b.AllowN(1)
+}
+
+func (b *budgetLimiter) AllowN(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+func (b *budgetLimiter) RetryAfter() time.Duration {
+	return time.Second
+}
+
+// allowOnlyLimiter always allows and has no AllowN
+type allowOnlyLimiter struct{}
+
+func (allowOnlyLimiter) Allow() bool { return true }
+
+// socketPath returns a short socket path, since Unix socket paths are
+// limited to around 100 bytes on most platforms
+func socketPath(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "ipc")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "rl.sock")
+}
+
+func startServer(t *testing.T, path string, rl RateLimiter) *Server {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	server := NewServer(rl)
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(listener) }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-done; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Expected ErrServerClosed from Serve, got %v", err)
+		}
+	})
+	return server
+}
+
+func TestClientsShareBudget(t *testing.T) {
+	path := socketPath(t)
+	startServer(t, path, &budgetLimiter{tokens: 50})
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		client, err := DialLimiter(path)
+		if err != nil {
+			t.Fatalf("DialLimiter failed: %v", err)
+		}
+		defer client.Close()
+
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 20; k++ {
+					if client.Allow() {
+						allowed.Add(1)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if allowed.Load() != 50 {
+		t.Errorf("Expected clients to share a budget of 50, got %d allowed", allowed.Load())
+	}
+}
+
+func TestClientAllowN(t *testing.T) {
+	path := socketPath(t)
+	startServer(t, path, &budgetLimiter{tokens: 10})
+
+	client, err := DialLimiter(path)
+	if err != nil {
+		t.Fatalf("DialLimiter failed: %v", err)
+	}
+	defer client.Close()
+
+	if !client.AllowN(7) {
+		t.Error("Expected AllowN(7) to succeed")
+	}
+
+	result, err := client.Check(5)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if result.Allowed || result.RetryAfter != time.Second {
+		t.Errorf("Expected denial with 1s retry-after, got %+v", result)
+	}
+	if !client.AllowN(3) {
+		t.Error("Expected AllowN(3) to take the remaining tokens")
+	}
+}
+
+func TestServerWithoutAllowN(t *testing.T) {
+	path := socketPath(t)
+	startServer(t, path, allowOnlyLimiter{})
+
+	client, err := DialLimiter(path)
+	if err != nil {
+		t.Fatalf("DialLimiter failed: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Check(2); err == nil {
+		t.Error("Expected server error for AllowN on an Allow-only limiter")
+	}
+	if client.AllowN(2) {
+		t.Error("Expected AllowN to be denied when the server rejects it")
+	}
+	if !client.Allow() {
+		t.Error("Expected Allow to work after a server error")
+	}
+}
+
+func TestClientFallback(t *testing.T) {
+	path := socketPath(t)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := NewServer(&budgetLimiter{})
+	go server.Serve(listener)
+
+	failOpen, err := DialLimiter(path)
+	if err != nil {
+		t.Fatalf("DialLimiter failed: %v", err)
+	}
+	defer failOpen.Close()
+
+	fallback := &budgetLimiter{tokens: 1}
+	withFallback, err := DialLimiterWithOptions(path, &ClientOptions{Fallback: fallback})
+	if err != nil {
+		t.Fatalf("DialLimiterWithOptions failed: %v", err)
+	}
+	defer withFallback.Close()
+
+	if failOpen.Allow() {
+		t.Error("Expected server with empty budget to deny")
+	}
+
+	server.Close()
+
+	if !failOpen.Allow() {
+		t.Error("Expected client without fallback to fail open")
+	}
+	if !withFallback.Allow() {
+		t.Error("Expected fallback limiter to allow its one token")
+	}
+	if withFallback.Allow() {
+		t.Error("Expected fallback limiter to deny once exhausted")
+	}
+}
+
+func TestClientReconnects(t *testing.T) {
+	path := socketPath(t)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	first := NewServer(&budgetLimiter{})
+	go first.Serve(listener)
+
+	client, err := DialLimiter(path)
+	if err != nil {
+		t.Fatalf("DialLimiter failed: %v", err)
+	}
+	defer client.Close()
+
+	if client.Allow() {
+		t.Error("Expected first server to deny")
+	}
+
+	// Restart the server; the client's pooled connection is now stale
+	first.Close()
+	os.Remove(path)
+	startServer(t, path, &budgetLimiter{tokens: 1})
+
+	result, err := client.Check(1)
+	if err != nil {
+		t.Fatalf("Expected client to reconnect, got %v", err)
+	}
+	if !result.Allowed {
+		t.Error("Expected restarted server to allow")
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	path := socketPath(t)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// Accept connections but never answer
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client, err := DialLimiterWithOptions(path, &ClientOptions{Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("DialLimiterWithOptions failed: %v", err)
+	}
+	defer client.Close()
+
+	start := time.Now()
+	if _, err := client.Check(1); err == nil {
+		t.Error("Expected timeout error from an unresponsive server")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Check to give up promptly, took %v", elapsed)
+	}
+	if !client.Allow() {
+		t.Error("Expected Allow to fail open after a timeout")
+	}
+}
+
+func TestListenAndServeLimiterRemovesStaleSocket(t *testing.T) {
+	path := socketPath(t)
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatalf("Failed to create stale socket file: %v", err)
+	}
+
+	go ListenAndServeLimiter(path, &budgetLimiter{tokens: 1})
+
+	var client *Client
+	var err error
+	for i := 0; i < 100; i++ {
+		if client, err = DialLimiter(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("DialLimiter failed: %v", err)
+	}
+	defer client.Close()
+
+	if !client.Allow() {
+		t.Error("Expected server to allow its one token")
+	}
+}
+
+func TestServerClientSoak(t *testing.T) {
+	leaktest.Soak(t, 30, 10, 2*time.Second, func(i int) {
+		path := socketPath(t)
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		server := NewServer(allowOnlyLimiter{})
+		done := make(chan error, 1)
+		go func() { done <- server.Serve(listener) }()
+
+		var clients []*Client
+		for j := 0; j < 3; j++ {
+			client, err := DialLimiter(path)
+			if err != nil {
+				t.Fatalf("Cycle %d: failed to dial: %v", i, err)
+			}
+			client.Allow()
+			clients = append(clients, client)
+		}
+
+		// Close the server first so it has to tear down open connections
+		server.Close()
+		<-done
+		for _, client := range clients {
+			client.Close()
+		}
+	})
+}
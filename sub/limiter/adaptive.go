@@ -0,0 +1,122 @@
+package limiter
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// AdaptivePolicy describes how an AdaptiveLimiter moves its rate in
+// response to the outcomes reported by the service it calls
+type AdaptivePolicy struct {
+	// MinRate and MaxRate bound the rate in requests per second. The
+	// limiter starts at MaxRate.
+	MinRate float64
+	MaxRate float64
+	// Increase is how much the rate grows, in requests per second, for
+	// each second's worth of successes: every success adds Increase divided
+	// by the current rate. Defaults to 1.
+	Increase float64
+	// Decrease is the factor the rate is multiplied by on a failure, between
+	// 0 and 1. Defaults to 0.5.
+	Decrease float64
+	// Cooldown is how long the rate is held after a decrease, so that the
+	// failures of requests already sent at the old rate do not cut it again.
+	// Zero means no cooldown.
+	Cooldown time.Duration
+	// Burst is the most requests allowed at once. Defaults to 1.
+	Burst int
+}
+
+// AdaptiveLimiter is a token bucket whose rate follows additive-increase,
+// multiplicative-decrease (AIMD), the scheme TCP uses to find a link's
+// capacity. Callers report whether each request to the upstream service
+// succeeded; successes raise the rate slowly and failures, such as 429 or
+// 5xx responses, cut it sharply, so the rate settles just under what the
+// upstream can take and backs off quickly when it degrades.
+type AdaptiveLimiter struct {
+	*RateLimiter
+
+	mu       sync.Mutex
+	policy   AdaptivePolicy
+	rate     float64
+	cooldown time.Time
+}
+
+// NewAdaptiveLimiter creates a limiter following policy
+func NewAdaptiveLimiter(policy AdaptivePolicy) (*AdaptiveLimiter, error) {
+	if policy.MinRate <= 0 || policy.MaxRate < policy.MinRate {
+		return nil, errors.New("rates must be positive with min not above max")
+	}
+	if policy.Increase < 0 {
+		return nil, errors.New("increase must not be negative")
+	}
+	if policy.Increase == 0 {
+		policy.Increase = 1
+	}
+	if policy.Decrease < 0 || policy.Decrease >= 1 {
+		return nil, errors.New("decrease must be between 0 and 1")
+	}
+	if policy.Decrease == 0 {
+		policy.Decrease = 0.5
+	}
+	if policy.Cooldown < 0 {
+		return nil, errors.New("cooldown must not be negative")
+	}
+	if policy.Burst <= 0 {
+		policy.Burst = 1
+	}
+
+	rate, per := ratePer(policy.MaxRate, 0)
+	return &AdaptiveLimiter{
+		RateLimiter: NewRateLimiterPer(rate, per, policy.Burst),
+		policy:      policy,
+		rate:        policy.MaxRate,
+	}, nil
+}
+
+// ReportSuccess records a request the upstream service handled, raising
+// the rate unless it is cooling down after a failure
+func (a *AdaptiveLimiter) ReportSuccess() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.coolingDown() {
+		return
+	}
+	a.setRate(a.rate + a.policy.Increase/a.rate)
+}
+
+// ReportFailure records a request the upstream service rejected or failed,
+// cutting the rate unless it was cut less than Cooldown ago
+func (a *AdaptiveLimiter) ReportFailure() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.coolingDown() {
+		return
+	}
+	a.setRate(a.rate * a.policy.Decrease)
+	a.cooldown = a.clock.Now().Add(a.policy.Cooldown)
+}
+
+// Rate returns the current rate in requests per second
+func (a *AdaptiveLimiter) Rate() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.rate
+}
+
+// coolingDown reports whether the rate is being held after a decrease.
+// Must hold mu.
+func (a *AdaptiveLimiter) coolingDown() bool {
+	return a.clock.Now().Before(a.cooldown)
+}
+
+// setRate moves the rate to rate, within the policy's bounds. Must hold mu.
+func (a *AdaptiveLimiter) setRate(rate float64) {
+	a.rate = math.Min(math.Max(rate, a.policy.MinRate), a.policy.MaxRate)
+	a.RateLimiter.setRatePerSecond(a.rate)
+}
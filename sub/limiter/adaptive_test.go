@@ -0,0 +1,115 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestAdaptive(t *testing.T, clock *fakeClock, policy AdaptivePolicy) *AdaptiveLimiter {
+	t.Helper()
+	a, err := NewAdaptiveLimiter(policy)
+	if err != nil {
+		t.Fatalf("NewAdaptiveLimiter failed: %v", err)
+	}
+	a.clock = clock
+	a.lastUpdate = clock.Now()
+	return a
+}
+
+func TestAdaptiveLimiterBounds(t *testing.T) {
+	clock := newFakeClock()
+	a := newTestAdaptive(t, clock, AdaptivePolicy{MinRate: 2, MaxRate: 10, Cooldown: time.Second})
+
+	a.ReportFailure()
+	if rate := a.Rate(); rate != 5 {
+		t.Errorf("Expected a failure to halve the rate to 5, got %v", rate)
+	}
+	a.ReportFailure()
+	a.ReportSuccess()
+	if rate := a.Rate(); rate != 5 {
+		t.Errorf("Expected the rate to be held during the cooldown, got %v", rate)
+	}
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		a.ReportFailure()
+	}
+	if rate := a.Rate(); rate != 2 {
+		t.Errorf("Expected the rate to stop at the minimum, got %v", rate)
+	}
+
+	clock.Advance(time.Second)
+	for i := 0; i < 1000; i++ {
+		a.ReportSuccess()
+	}
+	if rate := a.Rate(); rate != 10 {
+		t.Errorf("Expected the rate to stop at the maximum, got %v", rate)
+	}
+}
+
+func TestAdaptiveLimiterInvalidPolicy(t *testing.T) {
+	for name, policy := range map[string]AdaptivePolicy{
+		"zero min":          {MaxRate: 10},
+		"min above max":     {MinRate: 10, MaxRate: 5},
+		"negative increase": {MinRate: 1, MaxRate: 10, Increase: -1},
+		"decrease of one":   {MinRate: 1, MaxRate: 10, Decrease: 1},
+		"negative cooldown": {MinRate: 1, MaxRate: 10, Cooldown: -time.Second},
+	} {
+		if _, err := NewAdaptiveLimiter(policy); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// TestAdaptiveLimiterConverges drives the limiter against a simulated
+// upstream that can take 100 requests per second and fails the rest
+func TestAdaptiveLimiterConverges(t *testing.T) {
+	const capacity = 100
+	clock := newFakeClock()
+	upstream := newTestRateLimiter(clock, capacity, 10)
+	a := newTestAdaptive(t, clock, AdaptivePolicy{
+		MinRate:  1,
+		MaxRate:  1000,
+		Increase: 10,
+		Cooldown: 200 * time.Millisecond,
+		Burst:    5,
+	})
+
+	outage := false
+	// run sends as much as the limiter allows for d and returns the
+	// requests per second the upstream handled
+	run := func(d time.Duration) float64 {
+		successes := 0
+		for end := clock.Now().Add(d); clock.Now().Before(end); clock.Advance(10 * time.Millisecond) {
+			for a.Allow() {
+				if !outage && upstream.Allow() {
+					successes++
+					a.ReportSuccess()
+				} else {
+					a.ReportFailure()
+				}
+			}
+		}
+		return float64(successes) / d.Seconds()
+	}
+
+	run(20 * time.Second)
+	if got := run(10 * time.Second); got < 0.7*capacity {
+		t.Errorf("Expected throughput near the capacity of %d, got %.1f per second", capacity, got)
+	}
+	if rate := a.Rate(); rate > 2*capacity {
+		t.Errorf("Expected the rate to stay near the capacity of %d, got %.1f", capacity, rate)
+	}
+
+	outage = true
+	run(2 * time.Second)
+	if rate := a.Rate(); rate > 5 {
+		t.Errorf("Expected an outage to drive the rate down, got %.1f", rate)
+	}
+
+	outage = false
+	run(20 * time.Second)
+	if got := run(10 * time.Second); got < 0.7*capacity {
+		t.Errorf("Expected throughput to recover after the outage, got %.1f per second", got)
+	}
+}
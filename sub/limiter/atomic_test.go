@@ -0,0 +1,130 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestAtomicRateLimiter(t *testing.T, clock Clock, rate, burst int) *AtomicRateLimiter {
+	t.Helper()
+	a, err := NewAtomicRateLimiter(rate, burst)
+	if err != nil {
+		t.Fatalf("NewAtomicRateLimiter failed: %v", err)
+	}
+	a.clock = clock
+	return a
+}
+
+func TestAtomicRateLimiterMatchesRateLimiter(t *testing.T) {
+	clock := newFakeClock()
+	a := newTestAtomicRateLimiter(t, clock, 10, 5)
+	rl := NewRateLimiter(10, 5)
+	rl.clock = clock
+	rl.lastUpdate = clock.Now()
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		clock.Advance(time.Duration(rng.Intn(200)) * time.Millisecond)
+		n := 1 + rng.Intn(3)
+		if got, want := a.AllowN(n), rl.AllowN(n); got != want {
+			t.Fatalf("Step %d: AllowN(%d) = %v, RateLimiter says %v", i, n, got, want)
+		}
+		if got, want := a.Tokens(), rl.Tokens(); got != want {
+			t.Fatalf("Step %d: Tokens() = %d, RateLimiter says %d", i, got, want)
+		}
+	}
+}
+
+func TestAtomicRateLimiterConcurrent(t *testing.T) {
+	clock := newFakeClock()
+	a := newTestAtomicRateLimiter(t, clock, 100, 1000)
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if a.Allow() {
+					allowed.Add(1)
+				}
+				if j%10 == 0 {
+					clock.Advance(time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 320 advances of 1ms accrue at most 32 more requests
+	if n := allowed.Load(); n < 1000 || n > 1032 {
+		t.Errorf("Expected between 1000 and 1032 allowed, got %d", n)
+	}
+}
+
+func TestAtomicRateLimiterWaitN(t *testing.T) {
+	a, err := NewAtomicRateLimiter(50, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.AllowN(2)
+
+	start := time.Now()
+	if err := a.WaitContext(context.Background()); err != nil {
+		t.Fatalf("WaitContext failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Expected to wait about 20ms, waited %v", elapsed)
+	}
+
+	if err := a.WaitN(context.Background(), 3); !errors.Is(err, ErrCostExceedsBurst) {
+		t.Errorf("Expected ErrCostExceedsBurst, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if err := a.WaitN(ctx, 2); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded at once, got %v", err)
+	}
+}
+
+func TestAtomicRateLimiterNonPositiveN(t *testing.T) {
+	for _, n := range []int{0, -5} {
+		a := newTestAtomicRateLimiter(t, newFakeClock(), 1, 10)
+		a.AllowN(4)
+
+		if a.AllowN(n) {
+			t.Errorf("AllowN(%d): expected a refusal", n)
+		}
+		if err := a.WaitN(context.Background(), n); !errors.Is(err, ErrInvalidCost) {
+			t.Errorf("WaitN(%d): expected ErrInvalidCost, got %v", n, err)
+		}
+		a.Penalize(n)
+		if tokens := a.Tokens(); tokens != 6 {
+			t.Errorf("n of %d: expected 6 tokens untouched, got %d", n, tokens)
+		}
+	}
+}
+
+func BenchmarkRateLimiterAllowParallel(b *testing.B) {
+	rl := NewRateLimiter(1000000000, 1000000)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rl.Allow()
+		}
+	})
+}
+
+func BenchmarkAtomicRateLimiterAllowParallel(b *testing.B) {
+	a, _ := NewAtomicRateLimiter(1000000000, 1000000)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			a.Allow()
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package limiter
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// BudgetPolicy describes how a BudgetLimiter sets its rate from the success
+// ratio reported by the service it calls
+type BudgetPolicy struct {
+	// Target is the service's success objective, such as 0.999
+	Target float64
+	// BudgetShare is the burn rate the limiter aims for: the fraction of
+	// the error budget it may consume, where 1 spends it exactly as fast as
+	// the objective allows
+	BudgetShare float64
+	// Window is how often the rate is recomputed from the reports received
+	Window time.Duration
+	// MinRate and MaxRate bound the rate in requests per second. The
+	// limiter starts at MaxRate.
+	MinRate float64
+	MaxRate float64
+	// Burst is the most requests allowed at once. Defaults to 1.
+	Burst int
+}
+
+// BudgetLimiter paces requests to a downstream service so they consume no
+// more than a share of its error budget. Callers report the outcome of each
+// request and, at the end of every window, the limiter compares the burn
+// rate, the error ratio divided by the error budget, with BudgetShare and
+// scales its rate by their ratio, at most doubling it in one window. Windows
+// without reports leave the rate unchanged.
+type BudgetLimiter struct {
+	mu          sync.Mutex
+	policy      BudgetPolicy
+	clock       Clock
+	rate        float64
+	tokens      float64
+	last        time.Time
+	windowStart time.Time
+	successes   int
+	failures    int
+}
+
+// NewBudgetLimiter creates a limiter following policy
+func NewBudgetLimiter(policy BudgetPolicy) (*BudgetLimiter, error) {
+	if policy.Target <= 0 || policy.Target >= 1 {
+		return nil, errors.New("target success ratio must be between 0 and 1")
+	}
+	if policy.BudgetShare <= 0 {
+		return nil, errors.New("budget share must be positive")
+	}
+	if policy.Window <= 0 {
+		return nil, errors.New("window must be positive")
+	}
+	if policy.MinRate <= 0 || policy.MaxRate < policy.MinRate {
+		return nil, errors.New("rates must be positive with min not above max")
+	}
+	if policy.Burst <= 0 {
+		policy.Burst = 1
+	}
+
+	b := &BudgetLimiter{policy: policy, clock: systemClock{}, rate: policy.MaxRate}
+	now := b.clock.Now()
+	b.last = now
+	b.windowStart = now
+	b.tokens = float64(policy.Burst)
+	return b, nil
+}
+
+// Report records the outcome of a request made to the downstream service
+func (b *BudgetLimiter) Report(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(b.clock.Now())
+	if success {
+		b.successes++
+	} else {
+		b.failures++
+	}
+}
+
+// Rate returns the current rate in requests per second
+func (b *BudgetLimiter) Rate() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(b.clock.Now())
+	return b.rate
+}
+
+// Allow reports whether a request may proceed at the current rate
+func (b *BudgetLimiter) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(b.clock.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// RetryAfter returns how long until a request would be allowed at the
+// current rate
+func (b *BudgetLimiter) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(b.clock.Now())
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+}
+
+// Wait blocks until a request is allowed
+func (b *BudgetLimiter) Wait() {
+	for !b.Allow() {
+		time.Sleep(max(b.RetryAfter(), time.Millisecond))
+	}
+}
+
+// advance accrues tokens and closes the window if it ended by now. Reports
+// only ever belong to the latest window, so later empty windows need no
+// evaluation. Must hold mu.
+func (b *BudgetLimiter) advance(now time.Time) {
+	if end := b.windowStart.Add(b.policy.Window); !now.Before(end) {
+		// Tokens accrue at the closed window's rate until its end
+		b.accrue(end)
+		b.recompute()
+		elapsed := now.Sub(b.windowStart)
+		b.windowStart = b.windowStart.Add(elapsed - elapsed%b.policy.Window)
+	}
+	b.accrue(now)
+}
+
+// accrue adds the tokens generated up to now. Must hold mu.
+func (b *BudgetLimiter) accrue(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*b.rate, float64(b.policy.Burst))
+		b.last = now
+	}
+}
+
+// recompute sets the rate from the window's reports and starts a new
+// window. Must hold mu.
+func (b *BudgetLimiter) recompute() {
+	total := b.successes + b.failures
+	if total == 0 {
+		return
+	}
+
+	errorRatio := float64(b.failures) / float64(total)
+	burn := errorRatio / (1 - b.policy.Target)
+	factor := 2.0
+	if burn > 0 {
+		factor = min(b.policy.BudgetShare/burn, 2)
+	}
+	b.rate = min(max(b.rate*factor, b.policy.MinRate), b.policy.MaxRate)
+	b.successes, b.failures = 0, 0
+}
@@ -0,0 +1,120 @@
+package limiter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func newTestBudgetLimiter(t *testing.T, clock *fakeClock, policy BudgetPolicy) *BudgetLimiter {
+	t.Helper()
+	b, err := NewBudgetLimiter(policy)
+	if err != nil {
+		t.Fatalf("NewBudgetLimiter failed: %v", err)
+	}
+	b.clock = clock
+	b.last = clock.Now()
+	b.windowStart = clock.Now()
+	return b
+}
+
+// reportWindow reports outcomes with the given success ratio and moves to
+// the next window
+func reportWindow(b *BudgetLimiter, clock *fakeClock, successRatio float64) {
+	const requests = 1000
+	successes := int(math.Round(successRatio * requests))
+	for i := 0; i < requests; i++ {
+		b.Report(i < successes)
+	}
+	clock.Advance(b.policy.Window)
+}
+
+func TestBudgetLimiterRateTrajectory(t *testing.T) {
+	clock := newFakeClock()
+	b := newTestBudgetLimiter(t, clock, BudgetPolicy{
+		Target:      0.99,
+		BudgetShare: 0.5,
+		Window:      time.Minute,
+		MinRate:     1,
+		MaxRate:     100,
+	})
+
+	steps := []struct {
+		success float64
+		rate    float64
+	}{
+		{0.98, 25},   // burn 2, four times the share
+		{0.995, 25},  // burn 0.5, exactly the share
+		{1, 50},      // no errors, growth capped at double
+		{0.999, 100}, // burn 0.1, capped at double and at max
+		{0.5, 1},     // burn 50, clamped to min
+		{0.998, 2},   // burn 0.2, capped at double
+	}
+	for i, step := range steps {
+		reportWindow(b, clock, step.success)
+		if got := b.Rate(); math.Abs(got-step.rate) > 1e-9 {
+			t.Errorf("Window %d (success %v): expected rate %v, got %v", i, step.success, step.rate, got)
+		}
+	}
+
+	// A window without reports leaves the rate unchanged
+	clock.Advance(3 * time.Minute)
+	if got := b.Rate(); math.Abs(got-2) > 1e-9 {
+		t.Errorf("Expected idle windows to keep rate 2, got %v", got)
+	}
+}
+
+func TestBudgetLimiterPacing(t *testing.T) {
+	clock := newFakeClock()
+	b := newTestBudgetLimiter(t, clock, BudgetPolicy{
+		Target:      0.9,
+		BudgetShare: 1,
+		Window:      10 * time.Second,
+		MinRate:     1,
+		MaxRate:     4,
+		Burst:       2,
+	})
+
+	if !b.Allow() || !b.Allow() {
+		t.Fatal("Expected the burst to be allowed")
+	}
+	if b.Allow() {
+		t.Fatal("Expected the third request to be denied")
+	}
+	if got := b.RetryAfter().Round(time.Millisecond); got != 250*time.Millisecond {
+		t.Errorf("Expected 250ms until the next token at 4/s, got %v", got)
+	}
+
+	// Halve the rate with a burn of 2
+	for i := 0; i < 10; i++ {
+		b.Report(i < 8)
+	}
+	clock.Advance(10 * time.Second)
+	b.Allow()
+	b.Allow()
+	if got := b.RetryAfter().Round(time.Millisecond); got != 500*time.Millisecond {
+		t.Errorf("Expected 500ms until the next token at 2/s, got %v", got)
+	}
+}
+
+func TestBudgetLimiterValidation(t *testing.T) {
+	valid := BudgetPolicy{Target: 0.99, BudgetShare: 1, Window: time.Minute, MinRate: 1, MaxRate: 10}
+	if _, err := NewBudgetLimiter(valid); err != nil {
+		t.Fatalf("Expected valid policy, got %v", err)
+	}
+
+	invalid := []func(*BudgetPolicy){
+		func(p *BudgetPolicy) { p.Target = 1 },
+		func(p *BudgetPolicy) { p.BudgetShare = 0 },
+		func(p *BudgetPolicy) { p.Window = 0 },
+		func(p *BudgetPolicy) { p.MinRate = 0 },
+		func(p *BudgetPolicy) { p.MaxRate = 0.5 },
+	}
+	for i, mutate := range invalid {
+		policy := valid
+		mutate(&policy)
+		if _, err := NewBudgetLimiter(policy); err == nil {
+			t.Errorf("Case %d: expected an error for %+v", i, policy)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// FixedWindowLimiter allows limit requests in each window, with windows
+// aligned to the Unix epoch so that, for example, a one minute window
+// resets on every calendar minute.
+//
+// The count resets all at once at each boundary, so up to twice the limit
+// can pass in quick succession: a full window's worth just before a
+// boundary and another just after it. Use SlidingWindowLimiter when that
+// matters.
+//
+// The limiter is lock-free.
+type FixedWindowLimiter struct {
+	limit  int64
+	window time.Duration
+	state  atomic.Pointer[fixedWindowState]
+	clock  Clock
+}
+
+// fixedWindowState is the count for one window. A new window gets a new
+// state, so an old window's count is never reused.
+type fixedWindowState struct {
+	index int64
+	count atomic.Int64
+}
+
+// NewFixedWindowLimiter creates a limiter allowing limit requests per
+// aligned window
+func NewFixedWindowLimiter(limit int, window time.Duration) (*FixedWindowLimiter, error) {
+	if limit <= 0 {
+		return nil, errors.New("limit must be positive")
+	}
+	if window <= 0 {
+		return nil, errors.New("window must be positive")
+	}
+	f := &FixedWindowLimiter{limit: int64(limit), window: window, clock: systemClock{}}
+	f.state.Store(&fixedWindowState{})
+	return f, nil
+}
+
+// Allow reports whether a request may proceed and counts it if so
+func (f *FixedWindowLimiter) Allow() bool {
+	return f.AllowN(1)
+}
+
+// AllowN reports whether n requests may proceed together and counts them if
+// so. It always fails if n is not positive.
+func (f *FixedWindowLimiter) AllowN(n int) bool {
+	return f.AllowNDetail(n).Allowed
+}
+
+// AllowDetail is like Allow but also reports the window's state
+func (f *FixedWindowLimiter) AllowDetail() Decision {
+	return f.AllowNDetail(1)
+}
+
+// AllowNDetail is like AllowN but also reports the window's state. A denied
+// request can retry when the window resets.
+func (f *FixedWindowLimiter) AllowNDetail(n int) Decision {
+	now := f.clock.Now()
+	index := f.index(now)
+	state := f.current(index)
+	decision := Decision{Limit: int(f.limit), Window: f.window}
+	if n <= 0 {
+		decision.Remaining = int(max(f.limit-state.count.Load(), 0))
+		return decision
+	}
+	for {
+		count := state.count.Load()
+		if count+int64(n) > f.limit {
+			decision.Remaining = int(f.limit - count)
+			decision.RetryAfter = f.start(index + 1).Sub(now)
+			if int64(n) > f.limit {
+				decision.RetryAfter = f.window
+			}
+			return decision
+		}
+		if state.count.CompareAndSwap(count, count+int64(n)) {
+			decision.Allowed = true
+			decision.Remaining = int(f.limit - count - int64(n))
+			return decision
+		}
+	}
+}
+
+// Remaining returns how many more requests the current window allows
+func (f *FixedWindowLimiter) Remaining() int {
+	state := f.current(f.index(f.clock.Now()))
+	return int(max(f.limit-state.count.Load(), 0))
+}
+
+// ResetAt returns when the current window ends and the count resets
+func (f *FixedWindowLimiter) ResetAt() time.Time {
+	return f.start(f.index(f.clock.Now()) + 1)
+}
+
+// ResetAfter returns how long until the current window ends, or zero if
+// nothing has been counted in it
+func (f *FixedWindowLimiter) ResetAfter() time.Duration {
+	now := f.clock.Now()
+	index := f.index(now)
+	if f.current(index).count.Load() == 0 {
+		return 0
+	}
+	return f.start(index + 1).Sub(now)
+}
+
+// RetryAfter returns how long until a request would be allowed, without
+// counting anything
+func (f *FixedWindowLimiter) RetryAfter() time.Duration {
+	now := f.clock.Now()
+	index := f.index(now)
+	if f.current(index).count.Load() < f.limit {
+		return 0
+	}
+	return f.start(index + 1).Sub(now)
+}
+
+// HealthFraction returns the fraction of the current window's limit still
+// available
+func (f *FixedWindowLimiter) HealthFraction() float64 {
+	return float64(f.Remaining()) / float64(f.limit)
+}
+
+// Wait blocks until a request is allowed
+func (f *FixedWindowLimiter) Wait() {
+	f.WaitContext(context.Background())
+}
+
+// WaitContext blocks until a request is allowed or ctx is done, in which
+// case it returns ctx's error
+func (f *FixedWindowLimiter) WaitContext(ctx context.Context) error {
+	return waitContext(ctx, f.AllowDetail)
+}
+
+// index returns the number of the window containing now
+func (f *FixedWindowLimiter) index(now time.Time) int64 {
+	return now.UnixNano() / int64(f.window)
+}
+
+// start returns when the window with the given number begins
+func (f *FixedWindowLimiter) start(index int64) time.Time {
+	return time.Unix(0, index*int64(f.window))
+}
+
+// current returns the state of the window with the given number, starting
+// it if the stored state is older. A stale caller never moves the state
+// back to an earlier window.
+func (f *FixedWindowLimiter) current(index int64) *fixedWindowState {
+	for {
+		state := f.state.Load()
+		if state.index >= index {
+			return state
+		}
+		next := &fixedWindowState{index: index}
+		if f.state.CompareAndSwap(state, next) {
+			return next
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package limiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestFixedWindow(t *testing.T, clock *fakeClock, limit int, window time.Duration) *FixedWindowLimiter {
+	t.Helper()
+	f, err := NewFixedWindowLimiter(limit, window)
+	if err != nil {
+		t.Fatalf("NewFixedWindowLimiter failed: %v", err)
+	}
+	f.clock = clock
+	return f
+}
+
+func TestFixedWindowResetsAtBoundary(t *testing.T) {
+	clock := newFakeClock()
+	clock.Advance(20 * time.Second)
+	f := newTestFixedWindow(t, clock, 3, time.Minute)
+
+	if !f.AllowN(3) {
+		t.Fatal("Expected the limit to be allowed")
+	}
+	d := f.AllowDetail()
+	if d.Allowed || d.RetryAfter != 40*time.Second {
+		t.Errorf("Expected denial until the minute ends in 40s, got %+v", d)
+	}
+	if f.Remaining() != 0 {
+		t.Errorf("Expected nothing remaining, got %d", f.Remaining())
+	}
+	if expected := clock.Now().Truncate(time.Minute).Add(time.Minute); !f.ResetAt().Equal(expected) {
+		t.Errorf("Expected reset at %v, got %v", expected, f.ResetAt())
+	}
+
+	clock.Advance(40*time.Second - time.Nanosecond)
+	if f.Allow() {
+		t.Error("Expected denial just before the boundary")
+	}
+	clock.Advance(time.Nanosecond)
+	if f.Remaining() != 3 || !f.Allow() {
+		t.Error("Expected a fresh window exactly at the boundary")
+	}
+}
+
+func TestFixedWindowPermitsStraddlingBursts(t *testing.T) {
+	clock := newFakeClock()
+	f := newTestFixedWindow(t, clock, 5, time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	if !f.AllowN(5) {
+		t.Fatal("Expected a full burst at the end of the window")
+	}
+	clock.Advance(2 * time.Millisecond)
+	if !f.AllowN(5) {
+		t.Error("Expected another full burst right after the boundary")
+	}
+}
+
+func TestFixedWindowNonPositiveN(t *testing.T) {
+	f := newTestFixedWindow(t, newFakeClock(), 2, time.Minute)
+	f.AllowN(2)
+
+	for _, n := range []int{0, -5} {
+		if d := f.AllowNDetail(n); d.Allowed || d.Remaining != 0 {
+			t.Errorf("AllowNDetail(%d): expected a denial leaving nothing, got %+v", n, d)
+		}
+	}
+	if f.Allow() {
+		t.Error("Expected a negative n not to free capacity")
+	}
+}
+
+func TestFixedWindowConcurrent(t *testing.T) {
+	clock := newFakeClock()
+	f := newTestFixedWindow(t, clock, 1000, time.Minute)
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 300; j++ {
+				if f.AllowN(n) {
+					allowed.Add(int64(n))
+				}
+			}
+		}(i%3 + 1)
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got+int64(f.Remaining()) != 1000 {
+		t.Errorf("Expected allowed and remaining to add up to 1000, got %d and %d", got, f.Remaining())
+	}
+}
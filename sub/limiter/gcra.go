@@ -0,0 +1,159 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// GCRALimiter implements the generic cell rate algorithm. It admits the
+// same traffic as a token bucket with the same rate and burst, but its
+// whole state is a single theoretical arrival time, which keeps it small
+// when there is one limiter per key, and it is lock-free.
+//
+// Each request pushes the theoretical arrival time (TAT) forward by one
+// emission interval; a request is allowed if that leaves the TAT no more
+// than burst intervals ahead of now. The distance by which it would be
+// exceeded is the exact retry delay.
+type GCRALimiter struct {
+	interval  int64 // emission interval, in nanoseconds
+	tolerance int64 // burst * interval
+	tat       atomic.Int64
+	clock     Clock
+}
+
+// NewGCRALimiter creates a limiter allowing rate requests per second with
+// bursts of up to burst. The rate must leave at least a nanosecond between
+// requests, so it is at most one billion.
+func NewGCRALimiter(rate float64, burst int) (*GCRALimiter, error) {
+	if rate <= 0 {
+		return nil, errors.New("rate must be positive")
+	}
+	if burst <= 0 {
+		return nil, errors.New("burst must be positive")
+	}
+	interval := int64(float64(time.Second) / rate)
+	if interval < 1 {
+		return nil, errors.New("rate must be at most 1e9 per second")
+	}
+	return &GCRALimiter{
+		interval:  interval,
+		tolerance: interval * int64(burst),
+		clock:     systemClock{},
+	}, nil
+}
+
+// Allow reports whether a request may proceed and records it if so
+func (g *GCRALimiter) Allow() bool {
+	return g.AllowN(1)
+}
+
+// AllowN reports whether n requests may proceed together and records them
+// if so
+func (g *GCRALimiter) AllowN(n int) bool {
+	return g.AllowNDetail(n).Allowed
+}
+
+// AllowDetail is like Allow but also reports the limiter's state
+func (g *GCRALimiter) AllowDetail() Decision {
+	return g.AllowNDetail(1)
+}
+
+// AllowNDetail is like AllowN but also reports the limiter's state. An n
+// that is not positive is denied.
+func (g *GCRALimiter) AllowNDetail(n int) Decision {
+	now := g.clock.Now().UnixNano()
+	decision := Decision{Limit: int(g.tolerance / g.interval)}
+	if n <= 0 {
+		decision.Remaining = g.remaining(g.tat.Load(), now)
+		return decision
+	}
+	for {
+		tat := g.tat.Load()
+		next := max(tat, now) + int64(n)*g.interval
+		if ahead := next - now; ahead > g.tolerance {
+			decision.RetryAfter = time.Duration(ahead - g.tolerance)
+			if int64(n)*g.interval > g.tolerance {
+				// Can never succeed; report a full burst as the best hint
+				decision.RetryAfter = time.Duration(g.tolerance)
+			}
+			decision.Remaining = g.remaining(tat, now)
+			return decision
+		}
+		if g.tat.CompareAndSwap(tat, next) {
+			decision.Allowed = true
+			decision.Remaining = g.remaining(next, now)
+			return decision
+		}
+	}
+}
+
+// RetryAfter returns how long until a request would be allowed, without
+// recording anything
+func (g *GCRALimiter) RetryAfter() time.Duration {
+	now := g.clock.Now().UnixNano()
+	ahead := max(g.tat.Load(), now) + g.interval - now
+	return time.Duration(max(ahead-g.tolerance, 0))
+}
+
+// ResetAfter returns how long until the full burst is available again if no
+// more requests are recorded
+func (g *GCRALimiter) ResetAfter() time.Duration {
+	return time.Duration(max(g.tat.Load()-g.clock.Now().UnixNano(), 0))
+}
+
+// HealthFraction returns the fraction of the burst still available
+func (g *GCRALimiter) HealthFraction() float64 {
+	now := g.clock.Now().UnixNano()
+	ahead := max(g.tat.Load()-now, 0)
+	return max(float64(g.tolerance-ahead)/float64(g.tolerance), 0)
+}
+
+// Refund moves the theoretical arrival time back by n intervals, never to
+// before now
+func (g *GCRALimiter) Refund(n int) {
+	now := g.clock.Now().UnixNano()
+	for {
+		tat := g.tat.Load()
+		if tat <= now {
+			return
+		}
+		if g.tat.CompareAndSwap(tat, max(tat-int64(n)*g.interval, now)) {
+			return
+		}
+	}
+}
+
+// Penalize moves the theoretical arrival time forward by n intervals, as
+// if n more requests had been admitted, even beyond the burst. An n that is
+// not positive does nothing.
+func (g *GCRALimiter) Penalize(n int) {
+	if n <= 0 {
+		return
+	}
+	now := g.clock.Now().UnixNano()
+	for {
+		tat := g.tat.Load()
+		if g.tat.CompareAndSwap(tat, max(tat, now)+int64(n)*g.interval) {
+			return
+		}
+	}
+}
+
+// Wait blocks until a request is allowed
+func (g *GCRALimiter) Wait() {
+	g.WaitContext(context.Background())
+}
+
+// WaitContext blocks until a request is allowed or ctx is done, in which
+// case it returns ctx's error
+func (g *GCRALimiter) WaitContext(ctx context.Context) error {
+	return waitContext(ctx, g.AllowDetail)
+}
+
+// remaining returns how many requests fit before tat runs too far ahead of
+// now
+func (g *GCRALimiter) remaining(tat, now int64) int {
+	return int(max(g.tolerance-max(tat-now, 0), 0) / g.interval)
+}
@@ -0,0 +1,91 @@
+package limiter
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func newTestGCRA(t *testing.T, clock *fakeClock, rate float64, burst int) *GCRALimiter {
+	t.Helper()
+	g, err := NewGCRALimiter(rate, burst)
+	if err != nil {
+		t.Fatalf("NewGCRALimiter failed: %v", err)
+	}
+	g.clock = clock
+	return g
+}
+
+func TestGCRABurstAndRetryAfter(t *testing.T) {
+	clock := newFakeClock()
+	g := newTestGCRA(t, clock, 10, 3)
+
+	if !g.AllowN(3) {
+		t.Fatal("Expected a full burst")
+	}
+	d := g.AllowDetail()
+	if d.Allowed || d.RetryAfter != 100*time.Millisecond || d.Remaining != 0 || d.Limit != 3 {
+		t.Errorf("Expected denial for exactly 100ms, got %+v", d)
+	}
+
+	clock.Advance(40 * time.Millisecond)
+	if r := g.RetryAfter(); r != 60*time.Millisecond {
+		t.Errorf("Expected 60ms left, got %v", r)
+	}
+	clock.Advance(60 * time.Millisecond)
+	if !g.Allow() || g.Allow() {
+		t.Error("Expected exactly one request after 100ms")
+	}
+	if d := g.AllowNDetail(4); d.Allowed || d.RetryAfter != 300*time.Millisecond {
+		t.Errorf("Expected n above the burst to report a full burst, got %+v", d)
+	}
+}
+
+func TestGCRARejectsSubNanosecondIntervals(t *testing.T) {
+	for _, rate := range []float64{2e9, math.Inf(1), math.NaN()} {
+		if _, err := NewGCRALimiter(rate, 10); err == nil {
+			t.Errorf("Expected a rate of %v to be refused", rate)
+		}
+	}
+
+	g, err := NewGCRALimiter(1e9, 10)
+	if err != nil {
+		t.Fatalf("Expected a rate of 1e9 to be accepted, got %v", err)
+	}
+	if d := g.AllowDetail(); !d.Allowed || d.Limit != 10 || d.Remaining != 9 {
+		t.Errorf("Expected the first request of 10 allowed, got %+v", d)
+	}
+}
+
+func TestGCRAMatchesTokenBucket(t *testing.T) {
+	for _, tt := range []struct {
+		rate  float64
+		burst int
+	}{{10, 1}, {10, 5}, {3, 10}, {100, 20}} {
+		clock := newFakeClock()
+		g := newTestGCRA(t, clock, tt.rate, tt.burst)
+		b := newTestRateLimiter(clock, tt.rate, tt.burst)
+
+		// Identical bursty traffic for a simulated minute
+		rng := rand.New(rand.NewSource(1))
+		var gAllowed, bAllowed int
+		for elapsed := time.Duration(0); elapsed < time.Minute; {
+			step := time.Duration(rng.Int63n(int64(200 * time.Millisecond)))
+			clock.Advance(step)
+			elapsed += step
+			for i := rng.Intn(8); i >= 0; i-- {
+				if g.Allow() {
+					gAllowed++
+				}
+				if b.Allow() {
+					bAllowed++
+				}
+			}
+		}
+
+		if diff := gAllowed - bAllowed; diff < -1 || diff > 1 {
+			t.Errorf("Rate %v burst %d: GCRA allowed %d, token bucket %d", tt.rate, tt.burst, gAllowed, bAllowed)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package limiter
+
+// HierarchicalLimiter caps a group of limiters, such as one per user, with
+// a shared parent budget, so each user gets 10 per second but the service
+// as a whole never passes 500 per second however many users are active.
+//
+// Each child checks its own limit first and then the parent's. A request
+// the parent denies is refunded to the child, so it does not use up the
+// child's budget; children that cannot take requests back (see Refunder)
+// keep it.
+type HierarchicalLimiter struct {
+	parent   Allower
+	newChild func() Allower
+}
+
+// NewHierarchicalLimiter creates a hierarchy whose children are built by
+// newChild and share parent
+func NewHierarchicalLimiter(parent Allower, newChild func() Allower) *HierarchicalLimiter {
+	return &HierarchicalLimiter{parent: parent, newChild: newChild}
+}
+
+// Parent returns the shared parent limiter
+func (h *HierarchicalLimiter) Parent() Allower {
+	return h.parent
+}
+
+// Child creates a new child limiter drawing from the parent
+func (h *HierarchicalLimiter) Child() *MultiLimiter {
+	return NewMultiLimiter(h.newChild(), h.parent)
+}
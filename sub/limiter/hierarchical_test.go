@@ -0,0 +1,75 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHierarchicalLimiterCapsKeysTogether(t *testing.T) {
+	clock := newFakeClock()
+	h := NewHierarchicalLimiter(newTestRateLimiter(clock, 50, 50), func() Allower {
+		return newTestRateLimiter(clock, 10, 10)
+	})
+
+	children := make([]*MultiLimiter, 100)
+	for i := range children {
+		children[i] = h.Child()
+	}
+
+	for second := 0; second < 3; second++ {
+		allowed := 0
+		for _, c := range children {
+			if c.Allow() {
+				allowed++
+			}
+		}
+		if allowed != 50 {
+			t.Errorf("Second %d: expected 100 keys to share 50 requests, got %d", second, allowed)
+		}
+		clock.Advance(time.Second)
+	}
+}
+
+func TestHierarchicalLimiterCapsSingleKey(t *testing.T) {
+	clock := newFakeClock()
+	h := NewHierarchicalLimiter(newTestRateLimiter(clock, 500, 500), func() Allower {
+		return newTestRateLimiter(clock, 10, 10)
+	})
+	child := h.Child()
+
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if child.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 10 {
+		t.Errorf("Expected one key to be held to its own 10, got %d", allowed)
+	}
+	if tokens := h.Parent().(*RateLimiter).tokens; tokens != 490 {
+		t.Errorf("Expected the parent to be charged only for allowed requests, got %v tokens left", tokens)
+	}
+}
+
+func TestHierarchicalLimiterParentDenialKeepsChildTokens(t *testing.T) {
+	clock := newFakeClock()
+	parent := newTestRateLimiter(clock, 1, 1)
+	var child *RateLimiter
+	h := NewHierarchicalLimiter(parent, func() Allower {
+		child = newTestRateLimiter(clock, 10, 10)
+		return child
+	})
+	c := h.Child()
+
+	if !c.Allow() {
+		t.Fatal("Expected the first request to be allowed")
+	}
+	for i := 0; i < 5; i++ {
+		if c.Allow() {
+			t.Fatal("Expected the empty parent to deny")
+		}
+	}
+	if tokens := child.tokens; tokens != 9 {
+		t.Errorf("Expected parent denials to be refunded to the child, got %v tokens", tokens)
+	}
+}
@@ -0,0 +1,113 @@
+package limiter
+
+import (
+	"context"
+	"io"
+)
+
+// Reader throttles reads from an underlying reader so that bytes pass at
+// the limiter's rate. By default each byte costs one token, so a limiter
+// with a rate of 1<<20 allows 1MiB per second; SetBytesPerToken makes
+// tokens coarser for high rates.
+type Reader struct {
+	r io.Reader
+	t throttle
+}
+
+// Writer throttles writes to an underlying writer the same way Reader
+// throttles reads
+type Writer struct {
+	w io.Writer
+	t throttle
+}
+
+// NewReader returns a Reader reading from r at l's rate
+func NewReader(r io.Reader, l *RateLimiter) *Reader {
+	return NewReaderContext(context.Background(), r, l)
+}
+
+// NewReaderContext is like NewReader, but reads return ctx's error once ctx
+// is done instead of waiting for tokens
+func NewReaderContext(ctx context.Context, r io.Reader, l *RateLimiter) *Reader {
+	return &Reader{r: r, t: throttle{ctx: ctx, l: l, bytesPerToken: 1}}
+}
+
+// NewWriter returns a Writer writing to w at l's rate
+func NewWriter(w io.Writer, l *RateLimiter) *Writer {
+	return NewWriterContext(context.Background(), w, l)
+}
+
+// NewWriterContext is like NewWriter, but writes return ctx's error once
+// ctx is done instead of waiting for tokens
+func NewWriterContext(ctx context.Context, w io.Writer, l *RateLimiter) *Writer {
+	return &Writer{w: w, t: throttle{ctx: ctx, l: l, bytesPerToken: 1}}
+}
+
+// SetBytesPerToken sets how many bytes one token pays for. Partial chunks
+// cost a whole token. It panics if n is not positive.
+func (r *Reader) SetBytesPerToken(n int) {
+	r.t.setBytesPerToken(n)
+}
+
+// SetBytesPerToken sets how many bytes one token pays for. Partial chunks
+// cost a whole token. It panics if n is not positive.
+func (w *Writer) SetBytesPerToken(n int) {
+	w.t.setBytesPerToken(n)
+}
+
+// Read reads at most a burst's worth of bytes into p and then waits until
+// the limiter has paid for them. If the wait fails the bytes are still
+// returned, with the error.
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:min(len(p), r.t.maxChunk())])
+	if n > 0 {
+		if werr := r.t.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Write writes p in chunks of at most a burst's worth of bytes, waiting for
+// each chunk's tokens before writing it
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), w.t.maxChunk())]
+		if err := w.t.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// throttle charges a limiter for bytes
+type throttle struct {
+	ctx           context.Context
+	l             *RateLimiter
+	bytesPerToken int
+}
+
+func (t *throttle) setBytesPerToken(n int) {
+	if n <= 0 {
+		panic("limiter: bytes per token must be positive")
+	}
+	t.bytesPerToken = n
+}
+
+// maxChunk returns the most bytes one wait can pay for
+func (t *throttle) maxChunk() int {
+	return t.l.State().Burst * t.bytesPerToken
+}
+
+// wait blocks until n bytes are paid for
+func (t *throttle) wait(n int) error {
+	tokens := (n + t.bytesPerToken - 1) / t.bytesPerToken
+	return t.l.WaitN(t.ctx, tokens)
+}
@@ -0,0 +1,71 @@
+package limiter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestReaderThrottles(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 3000)
+	// 1000 bytes pass at once, the other 2000 take 200ms at 10KB/s
+	r := NewReader(bytes.NewReader(payload), NewRateLimiter(10000, 1000))
+
+	var out bytes.Buffer
+	start := time.Now()
+	n, err := io.Copy(&out, r)
+	elapsed := time.Since(start)
+	if err != nil || n != 3000 || !bytes.Equal(out.Bytes(), payload) {
+		t.Fatalf("Expected the payload copied intact, got %d bytes, %v", n, err)
+	}
+	if elapsed < 160*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("Expected about 200ms, took %v", elapsed)
+	}
+}
+
+func TestWriterThrottles(t *testing.T) {
+	payload := bytes.Repeat([]byte("y"), 3000)
+	var out bytes.Buffer
+	w := NewWriter(&out, NewRateLimiter(100, 10))
+	w.SetBytesPerToken(100)
+
+	start := time.Now()
+	n, err := w.Write(payload)
+	elapsed := time.Since(start)
+	if err != nil || n != 3000 || !bytes.Equal(out.Bytes(), payload) {
+		t.Fatalf("Expected the payload written intact, got %d bytes, %v", n, err)
+	}
+	// 30 tokens: 10 at once, 20 more at 100 per second
+	if elapsed < 160*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("Expected about 200ms, took %v", elapsed)
+	}
+}
+
+func TestReaderSplitsLargeReads(t *testing.T) {
+	r := NewReader(bytes.NewReader(make([]byte, 1<<20)), NewRateLimiter(1000000, 4096))
+
+	n, err := r.Read(make([]byte, 1<<20))
+	if err != nil || n != 4096 {
+		t.Errorf("Expected a 1MiB read to be cut to the 4096 byte burst, got %d, %v", n, err)
+	}
+}
+
+func TestThrottleContext(t *testing.T) {
+	rl := NewRateLimiter(10, 10)
+	rl.AllowN(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := NewReaderContext(ctx, bytes.NewReader([]byte("abc")), rl)
+	if n, err := r.Read(make([]byte, 8)); n != 3 || err != context.Canceled {
+		t.Errorf("Expected the 3 bytes read with Canceled, got %d, %v", n, err)
+	}
+
+	var out bytes.Buffer
+	w := NewWriterContext(ctx, &out, rl)
+	if n, err := w.Write([]byte("abc")); n != 0 || err != context.Canceled || out.Len() != 0 {
+		t.Errorf("Expected nothing written with Canceled, got %d, %v", n, err)
+	}
+}
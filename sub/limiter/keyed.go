@@ -0,0 +1,555 @@
+package limiter
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// keyShards is the number of independently locked shards keys are spread
+// over, so a snapshot only ever holds up a small fraction of requests
+const keyShards = 64
+
+// ErrInvalidCursor is returned by Snapshot for a cursor it did not produce
+var ErrInvalidCursor = errors.New("invalid snapshot cursor")
+
+// ErrTooManyKeys is returned for a new key when a KeyedLimiter holds its
+// maximum number of keys and rejects new ones
+var ErrTooManyKeys = errors.New("limiter: too many keys")
+
+// minSweepInterval bounds how often the janitor looks for idle keys
+const minSweepInterval = 10 * time.Millisecond
+
+// KeyRecorder receives the number of keys a KeyedLimiter holds and how many
+// it just evicted, after every sweep for idle keys and every eviction to
+// stay within the maximum number of keys. *stats.Stats implements it.
+type KeyRecorder interface {
+	RecordKeys(keys, evicted int)
+}
+
+// KeyState describes a key's limiter as of the key's last request
+type KeyState struct {
+	Key string `json:"key"`
+	// Remaining is the number of requests the limiter reported it would
+	// still allow after the last request, or -1 if it does not report it
+	Remaining  int       `json:"remaining"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// KeyEntry is a key's limiter in a KeyedLimiter and what was last observed
+// about it
+type KeyEntry struct {
+	key        string
+	limiter    Allower
+	lastAccess atomic.Int64 // Unix nanoseconds
+	remaining  atomic.Int64
+	// referenced is set on every use and cleared as the eviction hand
+	// passes, so the hand evicts keys unused for a whole turn
+	referenced atomic.Bool
+	slot       int // index in the shard's ring
+}
+
+// Limiter returns the key's limiter
+func (e *KeyEntry) Limiter() Allower {
+	return e.limiter
+}
+
+// Observe records the decision made for a request from the entry's key, for
+// Snapshot
+func (e *KeyEntry) Observe(now time.Time, decision Decision) {
+	e.lastAccess.Store(now.UnixNano())
+	if decision.Limit == 0 {
+		e.remaining.Store(-1)
+	} else {
+		e.remaining.Store(int64(decision.Remaining))
+	}
+}
+
+// state describes the entry as of its last Observe
+func (e *KeyEntry) state() KeyState {
+	state := KeyState{Key: e.key, Remaining: int(e.remaining.Load())}
+	if nanos := e.lastAccess.Load(); nanos != 0 {
+		state.LastAccess = time.Unix(0, nanos)
+	}
+	return state
+}
+
+// keyShard holds its entries both in a map, to find them, and in a ring
+// swept by a clock hand, to pick which to evict when there are too many.
+// The clock algorithm approximates least-recently-used eviction in O(1)
+// amortized time without reordering a list on every request.
+type keyShard struct {
+	mu      sync.RWMutex
+	entries map[string]*KeyEntry
+	ring    []*KeyEntry
+	hand    int
+}
+
+// add stores a new entry. Must hold mu.
+func (s *keyShard) add(entry *KeyEntry) {
+	if s.entries == nil {
+		s.entries = make(map[string]*KeyEntry)
+	}
+	s.entries[entry.key] = entry
+	entry.slot = len(s.ring)
+	s.ring = append(s.ring, entry)
+}
+
+// remove deletes an entry, moving the last one in the ring into its slot.
+// Must hold mu.
+func (s *keyShard) remove(entry *KeyEntry) {
+	delete(s.entries, entry.key)
+	last := s.ring[len(s.ring)-1]
+	s.ring[entry.slot] = last
+	last.slot = entry.slot
+	s.ring[len(s.ring)-1] = nil
+	s.ring = s.ring[:len(s.ring)-1]
+}
+
+// victim advances the clock hand, for at most the given number of turns,
+// to an entry that has not been used since the hand last passed it,
+// clearing the marks of those that have, and returns it. It never picks
+// keep, and returns nil if it finds nothing. Must hold mu.
+func (s *keyShard) victim(keep *KeyEntry, turns int) *KeyEntry {
+	for range turns * len(s.ring) {
+		if s.hand >= len(s.ring) {
+			s.hand = 0
+		}
+		entry := s.ring[s.hand]
+		s.hand++
+		if entry != keep && !entry.referenced.Swap(false) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// KeyedLimiter keeps a limiter per key, such as one per tenant or client,
+// creating each on the key's first use. Keys are spread over shards by hash
+// so that walking every key locks one shard at a time instead of the whole
+// map.
+//
+// Limiters are kept until deleted unless an idle TTL is set, in which case
+// a background janitor evicts keys that have made no request for that long,
+// or a maximum number of keys, in which case new keys evict the least
+// recently used or are rejected.
+//
+// The per-key HTTP middleware is built on it; use it directly to limit
+// anything else by key, such as messages from a queue by tenant.
+type KeyedLimiter struct {
+	shards    [keyShards]keyShard
+	count     atomic.Int64
+	factory   atomic.Pointer[func(key string) Allower]
+	onEvict   atomic.Pointer[func(key string, l Allower)]
+	recorder  atomic.Pointer[KeyRecorder]
+	clock     Clock
+	ttl       atomic.Int64 // nanoseconds
+	maxKeys   atomic.Int64
+	rejectNew atomic.Bool
+	evictions atomic.Int64
+
+	janitorMu      sync.Mutex
+	janitorStarted bool
+	closed         bool
+	done           chan struct{}
+}
+
+// NewKeyedLimiter creates a keyed limiter whose limiters are built by
+// factory. It panics if factory is nil.
+func NewKeyedLimiter(factory func(key string) Allower) *KeyedLimiter {
+	k := &KeyedLimiter{clock: systemClock{}, done: make(chan struct{})}
+	k.SetFactory(factory)
+	return k
+}
+
+// SetFactory replaces the factory used to create limiters for keys seen
+// from now on. Keys that already have a limiter keep it. It panics if
+// factory is nil.
+func (k *KeyedLimiter) SetFactory(factory func(key string) Allower) {
+	if factory == nil {
+		panic("limiter: nil limiter factory")
+	}
+	k.factory.Store(&factory)
+}
+
+// SetEvictHook sets a function called with every key removed and its
+// limiter, such as to release resources the limiter holds. It is called
+// without any lock held. Pass nil to remove it.
+func (k *KeyedLimiter) SetEvictHook(hook func(key string, l Allower)) {
+	if hook == nil {
+		k.onEvict.Store(nil)
+		return
+	}
+	k.onEvict.Store(&hook)
+}
+
+// SetIdleTTL makes the limiter evict keys that have made no request for
+// ttl, so that memory stays bounded when keys are not, such as client IPs.
+// A key that returns after being evicted gets a fresh limiter. The janitor
+// that evicts keys starts with the next new key and runs until Close. Zero
+// keeps keys until they are deleted.
+func (k *KeyedLimiter) SetIdleTTL(ttl time.Duration) {
+	k.ttl.Store(int64(max(ttl, 0)))
+}
+
+// SetMaxKeys caps the number of keys at n, bounding memory even when many
+// new keys arrive faster than the idle TTL evicts them. A new key beyond the
+// cap evicts an approximately least recently used key or, if rejectNew is
+// set, is refused with ErrTooManyKeys. Zero removes the cap.
+func (k *KeyedLimiter) SetMaxKeys(n int, rejectNew bool) {
+	k.maxKeys.Store(int64(max(n, 0)))
+	k.rejectNew.Store(rejectNew)
+}
+
+// SetKeyRecorder sets a recorder told the key count and evictions after
+// every eviction. Pass nil to remove it.
+func (k *KeyedLimiter) SetKeyRecorder(recorder KeyRecorder) {
+	if recorder == nil {
+		k.recorder.Store(nil)
+		return
+	}
+	k.recorder.Store(&recorder)
+}
+
+// Evictions returns how many keys have been evicted for being idle or to
+// stay within the maximum number of keys
+func (k *KeyedLimiter) Evictions() int64 {
+	return k.evictions.Load()
+}
+
+// Close stops the janitor evicting idle keys. Keys are no longer evicted,
+// but the limiter can otherwise still be used. Close is idempotent and
+// always returns nil.
+func (k *KeyedLimiter) Close() error {
+	k.janitorMu.Lock()
+	defer k.janitorMu.Unlock()
+
+	if !k.closed {
+		k.closed = true
+		close(k.done)
+	}
+	return nil
+}
+
+// Allow reports whether a request from key may proceed. A new key refused
+// for exceeding the maximum number of keys is denied.
+func (k *KeyedLimiter) Allow(key string) bool {
+	entry, err := k.Entry(key)
+	if err != nil {
+		return false
+	}
+	decision := AllowDetail(entry.limiter)
+	entry.Observe(k.clock.Now(), decision)
+	return decision.Allowed
+}
+
+// Wait blocks until key's limiter allows a request or ctx is done, in which
+// case it returns ctx's error. A new key refused for exceeding the maximum
+// number of keys returns ErrTooManyKeys at once.
+func (k *KeyedLimiter) Wait(ctx context.Context, key string) error {
+	entry, err := k.Entry(key)
+	if err != nil {
+		return err
+	}
+	err = WaitContext(ctx, entry.limiter)
+	entry.lastAccess.Store(k.clock.Now().UnixNano())
+	return err
+}
+
+// Len returns the number of keys with a limiter
+func (k *KeyedLimiter) Len() int {
+	return int(k.count.Load())
+}
+
+// shardIndex returns the shard key belongs to
+func shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % keyShards)
+}
+
+// Lookup returns the entry for key without creating one
+func (k *KeyedLimiter) Lookup(key string) (*KeyEntry, bool) {
+	shard := &k.shards[shardIndex(key)]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	entry, ok := shard.entries[key]
+	return entry, ok
+}
+
+// State returns key's state as Snapshot would report it, and false if key
+// has no limiter
+func (k *KeyedLimiter) State(key string) (KeyState, bool) {
+	shard := &k.shards[shardIndex(key)]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	entry, ok := shard.entries[key]
+	if !ok {
+		return KeyState{}, false
+	}
+	return entry.state(), true
+}
+
+// Entry returns the entry for key, creating it with a limiter from the
+// factory if there is none. The factory is only called when the key is new,
+// under the lock of the key's shard, so concurrent first requests for a key
+// share one limiter rather than each building one and all but one being
+// dropped.
+// It returns ErrTooManyKeys if the key is new and the limiter is full and
+// rejects new keys.
+func (k *KeyedLimiter) Entry(key string) (*KeyEntry, error) {
+	if entry, ok := k.Lookup(key); ok {
+		if !entry.referenced.Load() {
+			entry.referenced.Store(true)
+		}
+		return entry, nil
+	}
+
+	index := shardIndex(key)
+	entry, err := k.create(&k.shards[index], key)
+	if err != nil {
+		return nil, err
+	}
+	if limit := k.maxKeys.Load(); limit > 0 && k.count.Load() > limit {
+		k.evictOverflow(index, entry)
+	}
+	if k.ttl.Load() > 0 {
+		k.startJanitor()
+	}
+	return entry, nil
+}
+
+// create returns the entry for key in shard, creating it if there is none
+func (k *KeyedLimiter) create(shard *keyShard, key string) (*KeyEntry, error) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if entry, ok := shard.entries[key]; ok {
+		return entry, nil
+	}
+	if limit := k.maxKeys.Load(); limit > 0 && k.rejectNew.Load() {
+		if k.count.Add(1) > limit {
+			k.count.Add(-1)
+			return nil, ErrTooManyKeys
+		}
+	} else {
+		k.count.Add(1)
+	}
+
+	entry := &KeyEntry{key: key, limiter: (*k.factory.Load())(key)}
+	entry.lastAccess.Store(k.clock.Now().UnixNano())
+	entry.remaining.Store(-1)
+	entry.referenced.Store(true)
+	shard.add(entry)
+	return entry, nil
+}
+
+// evictOverflow evicts keys until there are no more than the maximum,
+// starting with the shard at index and moving on to the next when a shard
+// has nothing to evict. It never evicts keep, the entry just created.
+//
+// The hand first makes one turn of each shard, evicting only entries
+// unused since it last passed. If every entry was used, the marks are all
+// cleared by then and a second round of two turns is sure to find one.
+func (k *KeyedLimiter) evictOverflow(index int, keep *KeyEntry) {
+	for turns := 1; turns <= 2; turns++ {
+		for tried := 0; tried < keyShards && k.count.Load() > k.maxKeys.Load(); {
+			shard := &k.shards[(index+tried)%keyShards]
+			shard.mu.Lock()
+			victim := shard.victim(keep, turns)
+			if victim != nil {
+				shard.remove(victim)
+				k.count.Add(-1)
+			}
+			shard.mu.Unlock()
+
+			if victim == nil {
+				tried++
+				continue
+			}
+			k.evictions.Add(1)
+			k.evicted(victim.key, victim)
+			if recorder := k.recorder.Load(); recorder != nil {
+				(*recorder).RecordKeys(k.Len(), 1)
+			}
+		}
+	}
+}
+
+// startJanitor starts the goroutine evicting idle keys unless it is already
+// running or the limiter is closed
+func (k *KeyedLimiter) startJanitor() {
+	k.janitorMu.Lock()
+	defer k.janitorMu.Unlock()
+
+	if k.janitorStarted || k.closed {
+		return
+	}
+	k.janitorStarted = true
+	go k.janitor()
+}
+
+// janitor sweeps for idle keys every half TTL until the limiter is closed
+func (k *KeyedLimiter) janitor() {
+	for {
+		timer := time.NewTimer(max(time.Duration(k.ttl.Load())/2, minSweepInterval))
+		select {
+		case <-k.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		k.sweep()
+	}
+}
+
+// sweep evicts every key idle for longer than the TTL and returns how many
+// it evicted. Entries are removed under their shard's lock, the same lock
+// Entry holds to create them, so a key is never evicted while its limiter
+// is being created; a request that found the entry just before it was
+// evicted finishes against the old limiter.
+func (k *KeyedLimiter) sweep() int {
+	ttl := time.Duration(k.ttl.Load())
+	if ttl <= 0 {
+		return 0
+	}
+	cutoff := k.clock.Now().Add(-ttl).UnixNano()
+
+	total := 0
+	for i := range k.shards {
+		shard := &k.shards[i]
+		var removed []*KeyEntry
+		shard.mu.Lock()
+		for _, entry := range shard.entries {
+			if entry.lastAccess.Load() < cutoff {
+				removed = append(removed, entry)
+			}
+		}
+		for _, entry := range removed {
+			shard.remove(entry)
+		}
+		k.count.Add(-int64(len(removed)))
+		shard.mu.Unlock()
+
+		for _, entry := range removed {
+			k.evicted(entry.key, entry)
+		}
+		total += len(removed)
+	}
+
+	k.evictions.Add(int64(total))
+	if recorder := k.recorder.Load(); recorder != nil {
+		(*recorder).RecordKeys(k.Len(), total)
+	}
+	return total
+}
+
+// Delete removes key and its limiter, calling the evict hook if it had
+// one. A later request from key gets a new limiter. It reports whether key
+// had a limiter.
+func (k *KeyedLimiter) Delete(key string) bool {
+	shard := &k.shards[shardIndex(key)]
+	shard.mu.Lock()
+	entry, ok := shard.entries[key]
+	if ok {
+		shard.remove(entry)
+		k.count.Add(-1)
+	}
+	shard.mu.Unlock()
+
+	if ok {
+		k.evicted(key, entry)
+	}
+	return ok
+}
+
+// evicted calls the evict hook, if there is one, for a removed entry
+func (k *KeyedLimiter) evicted(key string, entry *KeyEntry) {
+	if hook := k.onEvict.Load(); hook != nil {
+		(*hook)(key, entry.limiter)
+	}
+}
+
+// Snapshot returns the state of up to limit keys, starting after cursor,
+// and the cursor to pass for the next page. Pass an empty cursor for the
+// first page; an empty next cursor means there are no more keys. A limit of
+// zero or less returns every remaining key.
+//
+// Keys are copied one shard at a time, so requests are never blocked for
+// long even with many keys. Paging visits every key that exists for the
+// whole walk exactly once; keys added during the walk may or may not be
+// included.
+func (k *KeyedLimiter) Snapshot(limit int, cursor string) ([]KeyState, string, error) {
+	shard, after, hasAfter, err := parseCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var states []KeyState
+	for ; shard < keyShards; shard++ {
+		page := k.shards[shard].states(after, hasAfter)
+		hasAfter = false
+		if limit > 0 && len(states)+len(page) > limit {
+			page = page[:limit-len(states)]
+			states = append(states, page...)
+			return states, formatCursor(shard, page[len(page)-1].Key, true), nil
+		}
+		states = append(states, page...)
+		if limit > 0 && len(states) == limit && shard+1 < keyShards {
+			return states, formatCursor(shard+1, "", false), nil
+		}
+	}
+	return states, "", nil
+}
+
+// states copies the shard's states, in key order, for keys after the given
+// one, or for every key if hasAfter is false
+func (s *keyShard) states(after string, hasAfter bool) []KeyState {
+	s.mu.RLock()
+	states := make([]KeyState, 0, len(s.entries))
+	for key, entry := range s.entries {
+		if hasAfter && key <= after {
+			continue
+		}
+		states = append(states, entry.state())
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Key < states[j].Key
+	})
+	return states
+}
+
+// formatCursor encodes a position: the start of a shard, or just after a
+// key within it
+func formatCursor(shard int, after string, hasAfter bool) string {
+	position := strconv.Itoa(shard)
+	if hasAfter {
+		position += ":" + after
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(position))
+}
+
+func parseCursor(cursor string) (shard int, after string, hasAfter bool, err error) {
+	if cursor == "" {
+		return 0, "", false, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", false, ErrInvalidCursor
+	}
+	position, after, hasAfter := strings.Cut(string(raw), ":")
+	shard, err = strconv.Atoi(position)
+	if err != nil || shard < 0 || shard >= keyShards {
+		return 0, "", false, ErrInvalidCursor
+	}
+	return shard, after, hasAfter, nil
+}
@@ -0,0 +1,302 @@
+package limiter
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/internal/leaktest"
+)
+
+func TestKeyedLimiterManyKeysConcurrently(t *testing.T) {
+	const keys, workers = 5000, 8
+	var created atomic.Int64
+	k := NewKeyedLimiter(func(string) Allower {
+		created.Add(1)
+		return NewRateLimiter(1, 3)
+	})
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				if k.Allow("tenant-" + strconv.Itoa(i)) {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := k.Len(); n != keys {
+		t.Errorf("Expected %d keys, got %d", keys, n)
+	}
+	if n := created.Load(); n != keys {
+		t.Errorf("Expected one limiter per key, created %d", n)
+	}
+	if n := allowed.Load(); n != 3*keys {
+		t.Errorf("Expected each key to allow its burst of 3, got %d in total", n)
+	}
+}
+
+func TestKeyedLimiterFactoryGetsKey(t *testing.T) {
+	k := NewKeyedLimiter(func(key string) Allower {
+		return &stubLimiter{tokens: len(key)}
+	})
+	for i := 0; i < 3; i++ {
+		k.Allow("abc")
+	}
+	if k.Allow("abc") {
+		t.Error("Expected abc to have 3 tokens")
+	}
+	if !k.Allow("abcd") {
+		t.Error("Expected abcd to have 4 tokens")
+	}
+
+	k.SetFactory(func(string) Allower { return &stubLimiter{} })
+	if !k.Allow("abcd") {
+		t.Error("Expected an existing key to keep its limiter")
+	}
+	if k.Allow("new") {
+		t.Error("Expected a new key to use the new factory")
+	}
+}
+
+func TestKeyedLimiterWait(t *testing.T) {
+	clock := newFakeClock()
+	k := NewKeyedLimiter(func(string) Allower { return newTestRateLimiter(clock, 1, 1) })
+	k.clock = clock
+
+	if err := k.Wait(context.Background(), "a"); err != nil {
+		t.Fatalf("Expected the first wait to succeed, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := k.Wait(ctx, "a"); err != context.DeadlineExceeded {
+		t.Errorf("Expected the empty bucket to time out, got %v", err)
+	}
+	if err := k.Wait(context.Background(), "b"); err != nil {
+		t.Errorf("Expected another key to have its own bucket, got %v", err)
+	}
+}
+
+func TestKeyedLimiterDelete(t *testing.T) {
+	k := NewKeyedLimiter(func(string) Allower { return &stubLimiter{tokens: 1} })
+	var evicted []string
+	k.SetEvictHook(func(key string, l Allower) {
+		evicted = append(evicted, key)
+	})
+
+	k.Allow("a")
+	if k.Allow("a") {
+		t.Fatal("Expected a to be out of tokens")
+	}
+	if !k.Delete("a") || k.Delete("a") || k.Delete("unknown") {
+		t.Error("Expected Delete to report only keys that had a limiter")
+	}
+	if len(evicted) != 1 || evicted[0] != "a" {
+		t.Errorf("Expected the hook to see a once, got %v", evicted)
+	}
+	if k.Len() != 0 {
+		t.Errorf("Expected no keys, got %d", k.Len())
+	}
+	if !k.Allow("a") {
+		t.Error("Expected a returning key to get a fresh limiter")
+	}
+}
+
+func TestKeyedLimiterSnapshot(t *testing.T) {
+	clock := newFakeClock()
+	k := NewKeyedLimiter(func(string) Allower { return newTestRateLimiter(clock, 1, 5) })
+	k.clock = clock
+
+	k.Allow("a")
+	k.Allow("a")
+	k.Entry("b")
+
+	states, next, err := k.Snapshot(0, "")
+	if err != nil || next != "" {
+		t.Fatalf("Expected one page, got next %q and error %v", next, err)
+	}
+	got := make(map[string]KeyState)
+	for _, s := range states {
+		got[s.Key] = s
+	}
+	if len(got) != 2 || got["a"].Remaining != 3 || !got["a"].LastAccess.Equal(clock.Now()) {
+		t.Errorf("Expected a with 3 remaining at %v, got %+v", clock.Now(), got["a"])
+	}
+	if got["b"].Remaining != -1 || !got["b"].LastAccess.Equal(clock.Now()) {
+		t.Errorf("Expected b to have no observations since its creation, got %+v", got["b"])
+	}
+}
+
+// keyRecorder records what a KeyedLimiter reports after each sweep
+type keyRecorder struct {
+	keys, evicted int
+}
+
+func (r *keyRecorder) RecordKeys(keys, evicted int) {
+	r.keys = keys
+	r.evicted += evicted
+}
+
+func TestKeyedLimiterIdleTTL(t *testing.T) {
+	clock := newFakeClock()
+	k := NewKeyedLimiter(func(string) Allower { return &stubLimiter{tokens: 1} })
+	k.clock = clock
+	k.SetIdleTTL(time.Minute)
+	defer k.Close()
+	recorder := &keyRecorder{}
+	k.SetKeyRecorder(recorder)
+
+	k.Allow("idle")
+	k.Allow("busy")
+	clock.Advance(40 * time.Second)
+	k.Allow("busy")
+	if n := k.sweep(); n != 0 {
+		t.Errorf("Expected no key idle for a minute yet, evicted %d", n)
+	}
+
+	clock.Advance(30 * time.Second)
+	if n := k.sweep(); n != 1 {
+		t.Errorf("Expected the idle key to be evicted, evicted %d", n)
+	}
+	if _, ok := k.Lookup("idle"); ok {
+		t.Error("Expected the idle key to be gone")
+	}
+	if _, ok := k.Lookup("busy"); !ok {
+		t.Error("Expected the busy key to be kept")
+	}
+	if k.Len() != 1 || k.Evictions() != 1 {
+		t.Errorf("Expected 1 key and 1 eviction, got %d and %d", k.Len(), k.Evictions())
+	}
+	if recorder.keys != 1 || recorder.evicted != 1 {
+		t.Errorf("Expected the recorder to see 1 key and 1 eviction, got %+v", recorder)
+	}
+	if !k.Allow("idle") {
+		t.Error("Expected a returning key to get a fresh limiter")
+	}
+}
+
+func TestKeyedLimiterJanitor(t *testing.T) {
+	baseline := leaktest.BaselineGoroutines()
+	k := NewKeyedLimiter(func(string) Allower { return &stubLimiter{} })
+	k.SetIdleTTL(20 * time.Millisecond)
+	var evicted atomic.Int64
+	k.SetEvictHook(func(string, Allower) { evicted.Add(1) })
+
+	for i := 0; i < 100; i++ {
+		k.Allow("key-" + strconv.Itoa(i))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for k.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if k.Len() != 0 || evicted.Load() != 100 {
+		t.Errorf("Expected the janitor to evict all 100 keys, %d left and %d evicted", k.Len(), evicted.Load())
+	}
+
+	k.Close()
+	k.Close()
+	leaktest.AssertNoLeak(t, baseline, time.Second)
+
+	// Closed limiters keep working without a janitor
+	k.Allow("after")
+	leaktest.AssertNoLeak(t, baseline, time.Second)
+	if k.Len() != 1 {
+		t.Errorf("Expected keys to be kept after Close, got %d", k.Len())
+	}
+}
+
+func TestKeyedLimiterMaxKeysKeepsHotKeys(t *testing.T) {
+	const maxKeys = 200
+	k := NewKeyedLimiter(func(string) Allower { return &stubLimiter{tokens: 1 << 30} })
+	k.SetMaxKeys(maxKeys, false)
+	var evicted atomic.Int64
+	k.SetEvictHook(func(string, Allower) { evicted.Add(1) })
+
+	hot := []string{"hot-1", "hot-2", "hot-3", "hot-4", "hot-5"}
+	for i := 0; i < 10*maxKeys; i++ {
+		for _, key := range hot {
+			k.Allow(key)
+		}
+		k.Allow("cold-" + strconv.Itoa(i))
+		if n := k.Len(); n > maxKeys {
+			t.Fatalf("Expected at most %d keys, got %d", maxKeys, n)
+		}
+	}
+
+	for _, key := range hot {
+		if _, ok := k.Lookup(key); !ok {
+			t.Errorf("Expected hot key %s to be kept", key)
+		}
+	}
+	if want := int64(10*maxKeys + len(hot) - maxKeys); evicted.Load() != want || k.Evictions() != want {
+		t.Errorf("Expected %d evictions, hook saw %d and Evictions reports %d", want, evicted.Load(), k.Evictions())
+	}
+}
+
+func TestKeyedLimiterMaxKeysConcurrently(t *testing.T) {
+	const maxKeys, workers = 500, 8
+	k := NewKeyedLimiter(func(string) Allower { return NewRateLimiter(1, 1) })
+	k.SetMaxKeys(maxKeys, false)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10*maxKeys/workers; i++ {
+				k.Allow("spray-" + strconv.Itoa(w) + "-" + strconv.Itoa(i))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := k.Len(); n > maxKeys {
+		t.Errorf("Expected at most %d keys, got %d", maxKeys, n)
+	}
+	held := 0
+	for i := range k.shards {
+		shard := &k.shards[i]
+		if len(shard.entries) != len(shard.ring) {
+			t.Fatalf("Shard %d has %d entries but %d in its ring", i, len(shard.entries), len(shard.ring))
+		}
+		held += len(shard.entries)
+	}
+	if held != k.Len() {
+		t.Errorf("Expected Len to match the %d entries held, got %d", held, k.Len())
+	}
+}
+
+func TestKeyedLimiterMaxKeysRejectsNew(t *testing.T) {
+	k := NewKeyedLimiter(func(string) Allower { return &stubLimiter{tokens: 10} })
+	k.SetMaxKeys(2, true)
+
+	if !k.Allow("a") || !k.Allow("b") {
+		t.Fatal("Expected the first two keys to be allowed")
+	}
+	if k.Allow("c") {
+		t.Error("Expected a third key to be rejected")
+	}
+	if err := k.Wait(context.Background(), "c"); err != ErrTooManyKeys {
+		t.Errorf("Expected ErrTooManyKeys, got %v", err)
+	}
+	if !k.Allow("a") {
+		t.Error("Expected existing keys to keep working")
+	}
+	if k.Len() != 2 || k.Evictions() != 0 {
+		t.Errorf("Expected 2 keys and no evictions, got %d and %d", k.Len(), k.Evictions())
+	}
+
+	k.Delete("b")
+	if !k.Allow("c") {
+		t.Error("Expected a new key to fit once another is deleted")
+	}
+}
@@ -0,0 +1,143 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// LeakyBucketLimiter admits requests at a constant rate. Requests join a
+// virtual queue that drains one request every 1/rate seconds, however they
+// arrive.
+//
+// Wait gives each caller its own drain slot and sleeps until it, so ten
+// callers arriving together at 10 per second are released 100ms apart.
+// Allow admits a request immediately if the queue has room for it, which
+// meters traffic to the rate with bursts of at most capacity.
+type LeakyBucketLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	capacity int
+	// free is when the queue will have drained every request admitted so
+	// far, which is the next request's slot
+	free  time.Time
+	clock Clock
+}
+
+// NewLeakyBucketLimiter creates a limiter draining rate requests per second
+// from a queue holding at most capacity requests
+func NewLeakyBucketLimiter(rate float64, capacity int) (*LeakyBucketLimiter, error) {
+	if rate <= 0 {
+		return nil, errors.New("rate must be positive")
+	}
+	if capacity <= 0 {
+		return nil, errors.New("capacity must be positive")
+	}
+	return &LeakyBucketLimiter{
+		interval: time.Duration(float64(time.Second) / rate),
+		capacity: capacity,
+		clock:    systemClock{},
+	}, nil
+}
+
+// Allow reports whether the queue has room for a request and adds it if so
+func (l *LeakyBucketLimiter) Allow() bool {
+	return l.AllowDetail().Allowed
+}
+
+// AllowDetail is like Allow but also reports the queue's state
+func (l *LeakyBucketLimiter) AllowDetail() Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	decision := Decision{Limit: l.capacity}
+	if retryAfter := l.retryAfter(now); retryAfter > 0 {
+		decision.RetryAfter = retryAfter
+	} else {
+		l.free = l.slot(now).Add(l.interval)
+		decision.Allowed = true
+	}
+	decision.Remaining = l.capacity - l.depth(now)
+	return decision
+}
+
+// RetryAfter returns how long until the queue has room for a request
+func (l *LeakyBucketLimiter) RetryAfter() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.retryAfter(l.clock.Now())
+}
+
+// ResetAfter returns how long until the queue has drained
+func (l *LeakyBucketLimiter) ResetAfter() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return max(l.free.Sub(l.clock.Now()), 0)
+}
+
+// HealthFraction returns the fraction of the queue that is empty
+func (l *LeakyBucketLimiter) HealthFraction() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return float64(l.capacity-l.depth(l.clock.Now())) / float64(l.capacity)
+}
+
+// Wait blocks until the caller's drain slot
+func (l *LeakyBucketLimiter) Wait() {
+	l.WaitContext(context.Background())
+}
+
+// WaitContext blocks until the caller's drain slot or until ctx is done, in
+// which case it returns ctx's error. A cancelled caller gives its slot back
+// if no later caller has queued behind it.
+func (l *LeakyBucketLimiter) WaitContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	now := l.clock.Now()
+	slot := l.slot(now)
+	l.free = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	if slot.Equal(now) {
+		return nil
+	}
+	if err := sleepContext(ctx, slot.Sub(now)); err != nil {
+		l.mu.Lock()
+		if l.free.Equal(slot.Add(l.interval)) {
+			l.free = slot
+		}
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// slot returns the drain slot of a request arriving now. Must hold mu.
+func (l *LeakyBucketLimiter) slot(now time.Time) time.Time {
+	if l.free.Before(now) {
+		return now
+	}
+	return l.free
+}
+
+// depth returns how many requests are queued, rounded up. Must hold mu.
+func (l *LeakyBucketLimiter) depth(now time.Time) int {
+	if !l.free.After(now) {
+		return 0
+	}
+	return int(math.Ceil(float64(l.free.Sub(now)) / float64(l.interval)))
+}
+
+// retryAfter returns how long until the queue has room for one more
+// request. Must hold mu.
+func (l *LeakyBucketLimiter) retryAfter(now time.Time) time.Duration {
+	// Room opens once the queue drains to capacity-1 requests
+	room := l.free.Add(-time.Duration(l.capacity-1) * l.interval)
+	return max(room.Sub(now), 0)
+}
@@ -0,0 +1,90 @@
+package limiter
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLeakyBucket(t *testing.T, clock *fakeClock, rate float64, capacity int) *LeakyBucketLimiter {
+	t.Helper()
+	l, err := NewLeakyBucketLimiter(rate, capacity)
+	if err != nil {
+		t.Fatalf("NewLeakyBucketLimiter failed: %v", err)
+	}
+	l.clock = clock
+	return l
+}
+
+func TestLeakyBucketAllow(t *testing.T) {
+	clock := newFakeClock()
+	l := newTestLeakyBucket(t, clock, 10, 3)
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow() {
+			t.Fatalf("Expected request %d to fit in the queue", i)
+		}
+	}
+	d := l.AllowDetail()
+	if d.Allowed || d.RetryAfter != 100*time.Millisecond || d.Remaining != 0 {
+		t.Errorf("Expected a full queue draining in 100ms, got %+v", d)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	if !l.Allow() || l.Allow() {
+		t.Error("Expected exactly one drained slot after 100ms")
+	}
+
+	clock.Advance(time.Hour)
+	if h := l.HealthFraction(); h != 1 {
+		t.Errorf("Expected an empty queue after an hour, got health %v", h)
+	}
+}
+
+func TestLeakyBucketWaitSpacing(t *testing.T) {
+	l, err := NewLeakyBucketLimiter(20, 1)
+	if err != nil {
+		t.Fatalf("NewLeakyBucketLimiter failed: %v", err)
+	}
+
+	const callers = 5
+	var mu sync.Mutex
+	var times []time.Time
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Wait()
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for i := 1; i < callers; i++ {
+		gap := times[i].Sub(times[i-1])
+		if gap < 40*time.Millisecond || gap > 60*time.Millisecond {
+			t.Errorf("Expected admissions 50ms apart, gap %d was %v", i, gap)
+		}
+	}
+}
+
+func TestLeakyBucketWaitCancelReturnsSlot(t *testing.T) {
+	clock := newFakeClock()
+	l := newTestLeakyBucket(t, clock, 1, 5)
+	l.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected DeadlineExceeded, got %v", err)
+	}
+	if d := l.AllowDetail(); !d.Allowed || d.Remaining != 3 {
+		t.Errorf("Expected the cancelled slot to be free again, got %+v", d)
+	}
+}
@@ -0,0 +1,270 @@
+// Package limiter provides rate limiting algorithms.
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// Clock provides the current time. Limiters use the system clock unless
+// another one is supplied, which lets tests control time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Decision describes the outcome of an admission check
+type Decision struct {
+	Allowed bool
+	// Limit is the number of requests permitted by the binding constraint
+	Limit int
+	// Remaining is the number of requests that would still be allowed
+	Remaining int
+	// RetryAfter is how long to wait before a denied request could succeed.
+	// It is zero when the request was allowed.
+	RetryAfter time.Duration
+	// Window is the window of the binding constraint, if the limiter has one
+	Window time.Duration
+}
+
+// Allower is the minimal interface every limiter implements
+type Allower interface {
+	Allow() bool
+}
+
+// Waiter is implemented by limiters that can block until a request is
+// allowed
+type Waiter interface {
+	Wait()
+}
+
+// ContextWaiter is implemented by limiters that can block until a request is
+// allowed or a context is done
+type ContextWaiter interface {
+	WaitContext(ctx context.Context) error
+}
+
+// AllowWaiter is implemented by limiters that can both admit requests and
+// wait for them
+type AllowWaiter interface {
+	Allower
+	Waiter
+}
+
+// Named is implemented by limiters that carry a name
+type Named interface {
+	Name() string
+}
+
+// Detailer is implemented by limiters that can explain their decisions.
+// Limiters composed of other limiters must aggregate their children: for
+// AND-composition a denial reports the longest RetryAfter of any child,
+// since that is the earliest time a retry could pass every layer.
+type Detailer interface {
+	AllowDetail() Decision
+}
+
+// NDetailer is implemented by limiters that can explain decisions about
+// several requests at once
+type NDetailer interface {
+	AllowNDetail(n int) Decision
+}
+
+// NAllower is implemented by limiters that can admit several requests at
+// once
+type NAllower interface {
+	AllowN(n int) bool
+}
+
+// RetryAfterer is implemented by limiters that can estimate, without
+// consuming anything, how long until a request would be allowed
+type RetryAfterer interface {
+	RetryAfter() time.Duration
+}
+
+// AllowDetail asks l for a decision, using its AllowDetail method when it has
+// one. Otherwise the decision is built from Allow and, on denial,
+// RetryAfter if l provides it.
+func AllowDetail(l Allower) Decision {
+	if d, ok := l.(Detailer); ok {
+		return d.AllowDetail()
+	}
+
+	decision := Decision{Allowed: l.Allow()}
+	if !decision.Allowed {
+		decision.RetryAfter = RetryAfter(l)
+	}
+	return decision
+}
+
+// AllowNDetail asks l for a decision about n requests together, using the
+// richest method l has. A limiter that can only admit one request at a time
+// is asked once, whatever n is.
+func AllowNDetail(l Allower, n int) Decision {
+	switch v := l.(type) {
+	case NDetailer:
+		return v.AllowNDetail(n)
+	case NAllower:
+		decision := Decision{Allowed: v.AllowN(n)}
+		if !decision.Allowed {
+			decision.RetryAfter = RetryAfter(l)
+		}
+		return decision
+	}
+	return AllowDetail(l)
+}
+
+// AllowN reports whether l admits n requests together, degrading the same
+// way as AllowNDetail
+func AllowN(l Allower, n int) bool {
+	if a, ok := l.(NAllower); ok {
+		return a.AllowN(n)
+	}
+	return AllowNDetail(l, n).Allowed
+}
+
+// Wait blocks until l allows a request, using l's own Wait method when it
+// has one
+func Wait(l Allower) {
+	if w, ok := l.(Waiter); ok {
+		w.Wait()
+		return
+	}
+	WaitContext(context.Background(), l)
+}
+
+// WaitContext blocks until l allows a request or ctx is done, in which case
+// it returns ctx's error. Limiters without a WaitContext method are polled,
+// sleeping for their RetryAfter estimate between attempts.
+func WaitContext(ctx context.Context, l Allower) error {
+	if w, ok := l.(ContextWaiter); ok {
+		return w.WaitContext(ctx)
+	}
+	return waitContext(ctx, func() Decision { return AllowDetail(l) })
+}
+
+// waitContext calls allow until it allows a request, sleeping for the
+// reported retry delay in between
+func waitContext(ctx context.Context, allow func() Decision) error {
+	for {
+		decision := allow()
+		if decision.Allowed {
+			return nil
+		}
+		timer := time.NewTimer(max(decision.RetryAfter, time.Millisecond))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Name returns l's name, or the empty string if it has none
+func Name(l Allower) string {
+	if n, ok := l.(Named); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+// RetryAfter returns l's estimate of how long until a request would be
+// allowed, or zero if l cannot estimate it
+func RetryAfter(l Allower) time.Duration {
+	if r, ok := l.(RetryAfterer); ok {
+		return r.RetryAfter()
+	}
+	return 0
+}
+
+// Resetter is implemented by limiters that can report how long until they
+// are back to full capacity if no more requests arrive, such as a token
+// bucket refilling or a window ending
+type Resetter interface {
+	ResetAfter() time.Duration
+}
+
+// ResetAfter returns how long until l is back to full capacity. The second
+// result is false if l cannot report it.
+func ResetAfter(l Allower) (time.Duration, bool) {
+	if r, ok := l.(Resetter); ok {
+		return r.ResetAfter(), true
+	}
+	return 0, false
+}
+
+// HealthReporter is implemented by limiters that can report how much of
+// their capacity is left, from 1 when idle to 0 when exhausted
+type HealthReporter interface {
+	HealthFraction() float64
+}
+
+// HealthFraction returns l's remaining capacity fraction. The second result
+// is false if l cannot report it.
+func HealthFraction(l Allower) (float64, bool) {
+	if h, ok := l.(HealthReporter); ok {
+		return h.HealthFraction(), true
+	}
+	return 0, false
+}
+
+// OutcomeReporter is implemented by limiters that adapt their rate to the
+// outcomes of the requests they admit, such as AdaptiveLimiter
+type OutcomeReporter interface {
+	ReportSuccess()
+	ReportFailure()
+}
+
+// Refunder is implemented by limiters that can give back requests they
+// admitted, so a request admitted here but refused elsewhere does not use
+// up capacity
+type Refunder interface {
+	Refund(n int)
+}
+
+// Refund gives n admitted requests back to l. It reports false, and does
+// nothing, if l cannot take them back.
+func Refund(l Allower, n int) bool {
+	if r, ok := l.(Refunder); ok {
+		r.Refund(n)
+		return true
+	}
+	return false
+}
+
+// Penalizer is implemented by limiters that can charge requests after the
+// fact, beyond what they have available, such as to make failed login
+// attempts cost more than they did when admitted
+type Penalizer interface {
+	Penalize(n int)
+}
+
+// Penalize charges l n more requests. It reports false, and does nothing,
+// if l cannot be charged after the fact.
+func Penalize(l Allower, n int) bool {
+	if p, ok := l.(Penalizer); ok {
+		p.Penalize(n)
+		return true
+	}
+	return false
+}
+
+// Pauser is implemented by limiters that can be paused, such as RateLimiter
+type Pauser interface {
+	IsPaused() bool
+}
+
+// IsPaused reports whether l is paused. Limiters that cannot be paused
+// never are.
+func IsPaused(l Allower) bool {
+	if p, ok := l.(Pauser); ok {
+		return p.IsPaused()
+	}
+	return false
+}
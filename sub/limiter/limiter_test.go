@@ -0,0 +1,116 @@
+package limiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for tests
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// clockFunc adapts a function to a Clock
+type clockFunc func() time.Time
+
+func (f clockFunc) Now() time.Time {
+	return f()
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestResetAfter(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(2, 4)
+	rl.clock = clock
+	rl.lastUpdate = clock.Now()
+	fixed := newTestFixedWindow(t, clock, 4, time.Minute)
+	clock.Advance(10 * time.Second) // 50s left in the fixed window
+
+	limiters := map[string]struct {
+		l    Allower
+		want time.Duration
+	}{
+		"rate limiter":   {rl, 2 * time.Second},
+		"token bucket":   {newTestRateLimiter(clock, 2, 4), 2 * time.Second},
+		"gcra":           {newTestGCRA(t, clock, 2, 4), 2 * time.Second},
+		"leaky bucket":   {newTestLeakyBucket(t, clock, 2, 4), 2 * time.Second},
+		"sliding window": {newTestSlidingWindow(t, clock, 4, time.Minute), time.Minute},
+		"fixed window":   {fixed, 50 * time.Second},
+	}
+	for name, tc := range limiters {
+		if got, ok := ResetAfter(tc.l); !ok || got != 0 {
+			t.Errorf("%s: expected an idle limiter to report zero, got %v, %v", name, got, ok)
+		}
+		tc.l.Allow()
+		tc.l.Allow()
+		tc.l.Allow()
+		tc.l.Allow()
+		if got, _ := ResetAfter(tc.l); got != tc.want {
+			t.Errorf("%s: expected %v to reset after four requests, got %v", name, tc.want, got)
+		}
+	}
+
+	m := NewMultiLimiter(limiters["token bucket"].l, limiters["fixed window"].l, &stubLimiter{})
+	if got := m.ResetAfter(); got != 50*time.Second {
+		t.Errorf("Expected the multi limiter to report its slowest child, got %v", got)
+	}
+	if _, ok := ResetAfter(&stubLimiter{}); ok {
+		t.Error("Expected a limiter without ResetAfter to report it cannot")
+	}
+}
+
+func TestPenalize(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(2, 4)
+	rl.clock = clock
+	rl.lastUpdate = clock.Now()
+
+	limiters := map[string]Allower{
+		"rate limiter": rl,
+		"token bucket": newTestRateLimiter(clock, 2, 4),
+		"gcra":         newTestGCRA(t, clock, 2, 4),
+	}
+	for name, l := range limiters {
+		l.Allow()
+		// Three tokens left; a penalty of five leaves a debt of two
+		if !Penalize(l, 5) {
+			t.Fatalf("%s: expected the limiter to take a penalty", name)
+		}
+		if decision := AllowDetail(l); decision.Allowed || decision.Remaining != 0 {
+			t.Errorf("%s: expected a limiter in debt to deny, got %+v", name, decision)
+		}
+		if health, _ := HealthFraction(l); health != 0 {
+			t.Errorf("%s: expected no health while in debt, got %v", name, health)
+		}
+		// The debt of two and a token to spend take 1.5s at 2/s
+		clock.Advance(time.Second)
+		if l.Allow() {
+			t.Errorf("%s: expected the debt to outlast a second", name)
+		}
+		clock.Advance(500 * time.Millisecond)
+		if !l.Allow() {
+			t.Errorf("%s: expected a request once the debt was paid", name)
+		}
+	}
+
+	if Penalize(&stubLimiter{}, 1) {
+		t.Error("Expected a limiter without Penalize to report it cannot")
+	}
+}
@@ -0,0 +1,149 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+// MultiLimiter admits a request only if every child limiter admits it,
+// such as a per second limit for bursts and a per hour limit for sustained
+// traffic. When a child denies, the children that already admitted the
+// request are refunded, so a denied request does not use up the longer
+// limits. Children that cannot take requests back (see Refunder) keep them.
+type MultiLimiter struct {
+	limiters []Allower
+}
+
+// NewMultiLimiter creates a limiter that requires all of the given limiters
+// to allow a request. Children are asked in order and evaluation stops at
+// the first denial.
+func NewMultiLimiter(limiters ...Allower) *MultiLimiter {
+	return &MultiLimiter{limiters: limiters}
+}
+
+// NewMultiLimiterFromConfigs creates a limiter enforcing every config, each
+// built with New
+func NewMultiLimiterFromConfigs(configs []config.Config) (*MultiLimiter, error) {
+	limiters := make([]Allower, len(configs))
+	for i := range configs {
+		l, err := New(WithConfig(&configs[i]))
+		if err != nil {
+			return nil, fmt.Errorf("limit %d: %w", i, err)
+		}
+		limiters[i] = l
+	}
+	return NewMultiLimiter(limiters...), nil
+}
+
+// Allow reports whether every child allows the request
+func (m *MultiLimiter) Allow() bool {
+	return m.AllowDetail().Allowed
+}
+
+// AllowN reports whether every child allows n requests together
+func (m *MultiLimiter) AllowN(n int) bool {
+	return m.AllowNDetail(n).Allowed
+}
+
+// AllowDetail asks each child in turn. On denial the decision reports the
+// longest RetryAfter across all children, not just the one that denied, so
+// a retry is not suggested before a later layer would admit it too.
+func (m *MultiLimiter) AllowDetail() Decision {
+	return m.AllowNDetail(1)
+}
+
+// AllowNDetail is like AllowDetail for n requests together. Children that
+// cannot admit several requests at once are asked once.
+func (m *MultiLimiter) AllowNDetail(n int) Decision {
+	var decision Decision
+	for i, l := range m.limiters {
+		d := AllowNDetail(l, n)
+		if !d.Allowed {
+			for _, admitted := range m.limiters[:i] {
+				Refund(admitted, granted(admitted, n))
+			}
+			for j, other := range m.limiters {
+				if j != i {
+					d.RetryAfter = max(d.RetryAfter, RetryAfter(other))
+				}
+			}
+			return d
+		}
+		if i == 0 || d.Remaining < decision.Remaining {
+			decision = d
+		}
+	}
+	decision.Allowed = true
+	return decision
+}
+
+// Refund gives n requests back to every child that can take them
+func (m *MultiLimiter) Refund(n int) {
+	for _, l := range m.limiters {
+		Refund(l, granted(l, n))
+	}
+}
+
+// Penalize charges every child that can be charged n requests
+func (m *MultiLimiter) Penalize(n int) {
+	for _, l := range m.limiters {
+		Penalize(l, n)
+	}
+}
+
+// granted returns how many requests l was charged when asked for n, which
+// is one if it cannot admit several at once
+func granted(l Allower, n int) int {
+	switch l.(type) {
+	case NDetailer, NAllower:
+		return n
+	}
+	return 1
+}
+
+// Wait blocks until every child allows a request
+func (m *MultiLimiter) Wait() {
+	m.WaitContext(context.Background())
+}
+
+// WaitContext blocks until every child allows a request or ctx is done, in
+// which case it returns ctx's error
+func (m *MultiLimiter) WaitContext(ctx context.Context) error {
+	return waitContext(ctx, m.AllowDetail)
+}
+
+// RetryAfter returns the longest RetryAfter of any child
+func (m *MultiLimiter) RetryAfter() time.Duration {
+	var retryAfter time.Duration
+	for _, l := range m.limiters {
+		retryAfter = max(retryAfter, RetryAfter(l))
+	}
+	return retryAfter
+}
+
+// ResetAfter returns the longest reset time of the children that report
+// it, when every child is back to full capacity
+func (m *MultiLimiter) ResetAfter() time.Duration {
+	var resetAfter time.Duration
+	for _, l := range m.limiters {
+		if r, ok := ResetAfter(l); ok {
+			resetAfter = max(resetAfter, r)
+		}
+	}
+	return resetAfter
+}
+
+// HealthFraction returns the lowest health of the children that report it,
+// or 1 if none do
+func (m *MultiLimiter) HealthFraction() float64 {
+	health := 1.0
+	for _, l := range m.limiters {
+		if h, ok := HealthFraction(l); ok {
+			health = min(health, h)
+		}
+	}
+	return health
+}
@@ -0,0 +1,233 @@
+package limiter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+// stubLimiter allows while it has tokens and reports a fixed retry-after
+type stubLimiter struct {
+	tokens     int
+	retryAfter time.Duration
+	calls      int
+}
+
+func (s *stubLimiter) Allow() bool {
+	s.calls++
+	if s.tokens > 0 {
+		s.tokens--
+		return true
+	}
+	return false
+}
+
+func (s *stubLimiter) RetryAfter() time.Duration {
+	if s.tokens > 0 {
+		return 0
+	}
+	return s.retryAfter
+}
+
+func TestMultiLimiterAllowsWhenAllAllow(t *testing.T) {
+	a := &stubLimiter{tokens: 2}
+	b := &stubLimiter{tokens: 2}
+	m := NewMultiLimiter(a, b)
+
+	if !m.Allow() || !m.Allow() {
+		t.Fatal("Expected both requests to be allowed")
+	}
+	if m.Allow() {
+		t.Error("Expected third request to be denied")
+	}
+}
+
+func TestMultiLimiterStopsAtFirstDenial(t *testing.T) {
+	a := &stubLimiter{tokens: 0, retryAfter: time.Second}
+	b := &stubLimiter{tokens: 5}
+	m := NewMultiLimiter(a, b)
+
+	if m.Allow() {
+		t.Fatal("Expected denial from the first child")
+	}
+	if b.calls != 0 {
+		t.Errorf("Expected later children not to be asked, got %d calls", b.calls)
+	}
+}
+
+func TestMultiLimiterAggregatesRetryAfter(t *testing.T) {
+	// The first child denies with a short wait, but a later child is also
+	// exhausted and needs much longer
+	short := &stubLimiter{tokens: 0, retryAfter: time.Second}
+	long := &stubLimiter{tokens: 0, retryAfter: time.Minute}
+	m := NewMultiLimiter(short, long)
+
+	decision := m.AllowDetail()
+	if decision.Allowed {
+		t.Fatal("Expected denial")
+	}
+	if decision.RetryAfter != time.Minute {
+		t.Errorf("Expected aggregate retry-after of 1m, got %v", decision.RetryAfter)
+	}
+	if m.RetryAfter() != time.Minute {
+		t.Errorf("Expected RetryAfter of 1m, got %v", m.RetryAfter())
+	}
+}
+
+func TestMultiLimiterNested(t *testing.T) {
+	clock := newFakeClock()
+	perSecond := newTestMultiWindow(t, clock, WindowLimit{Count: 3, Window: time.Second})
+	perMinute := newTestMultiWindow(t, clock, WindowLimit{Count: 3, Window: time.Minute})
+	perHour := newTestMultiWindow(t, clock, WindowLimit{Count: 100, Window: time.Hour})
+	m := NewMultiLimiter(perSecond, NewMultiLimiter(perMinute, perHour))
+
+	for i := 0; i < 3; i++ {
+		if !m.Allow() {
+			t.Fatalf("Expected request %d to be allowed", i)
+		}
+	}
+
+	// Both the per-second and per-minute layers are exhausted; the
+	// per-second layer denies first but the minute decides the retry
+	decision := m.AllowDetail()
+	if decision.Allowed {
+		t.Fatal("Expected denial")
+	}
+	if decision.RetryAfter < time.Minute {
+		t.Errorf("Expected retry-after of over a minute, got %v", decision.RetryAfter)
+	}
+
+	clock.Advance(decision.RetryAfter - time.Second)
+	if m.Allow() {
+		t.Error("Expected denial before the aggregate retry-after")
+	}
+	clock.Advance(time.Second)
+	if !m.Allow() {
+		t.Error("Expected request to be allowed at the aggregate retry-after")
+	}
+}
+
+func TestAllowDetailHelper(t *testing.T) {
+	plain := &stubLimiter{tokens: 1, retryAfter: 3 * time.Second}
+
+	if d := AllowDetail(plain); !d.Allowed || d.RetryAfter != 0 {
+		t.Errorf("Expected allowed decision without retry-after, got %+v", d)
+	}
+	if d := AllowDetail(plain); d.Allowed || d.RetryAfter != 3*time.Second {
+		t.Errorf("Expected denial with 3s retry-after, got %+v", d)
+	}
+}
+
+func TestMultiLimiterHealthFraction(t *testing.T) {
+	clock := newFakeClock()
+	short := newTestMultiWindow(t, clock, WindowLimit{Count: 4, Window: time.Second})
+	long := newTestMultiWindow(t, clock, WindowLimit{Count: 10, Window: time.Minute})
+	m := NewMultiLimiter(short, long, &stubLimiter{tokens: 100})
+
+	m.Allow()
+	if h := m.HealthFraction(); h != 0.75 {
+		t.Errorf("Expected lowest child health 0.75, got %v", h)
+	}
+
+	if h := NewMultiLimiter(&stubLimiter{}).HealthFraction(); h != 1 {
+		t.Errorf("Expected full health without reporting children, got %v", h)
+	}
+}
+
+func TestMultiLimiterRollsBackOnDenial(t *testing.T) {
+	clock := newFakeClock()
+	perHour := newTestRateLimiter(clock, 1000.0/3600, 1000)
+	perSecond := newTestRateLimiter(clock, 10, 10)
+	m := NewMultiLimiter(perHour, perSecond)
+
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if m.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 10 {
+		t.Fatalf("Expected 10 allowed by the per-second limit, got %d", allowed)
+	}
+	// Only the admitted requests may count against the hour
+	if h := perHour.HealthFraction(); h != 0.99 {
+		t.Errorf("Expected the hour bucket at 990 of 1000, got %v", h)
+	}
+
+	if m.AllowN(5) {
+		t.Fatal("Expected AllowN to be denied by the per-second limit")
+	}
+	if h := perHour.HealthFraction(); h != 0.99 {
+		t.Errorf("Expected a denied AllowN to be rolled back, got %v", h)
+	}
+}
+
+func TestMultiLimiterRollbackAcrossAlgorithms(t *testing.T) {
+	clock := newFakeClock()
+	window := newTestMultiWindow(t, clock, WindowLimit{Count: 100, Window: time.Hour})
+	gcra := newTestGCRA(t, clock, 1, 100)
+	rl := NewRateLimiter(1, 100)
+	rl.clock = clock
+	rl.lastUpdate = clock.Now()
+	denying := &stubLimiter{}
+	m := NewMultiLimiter(window, gcra, rl, denying)
+
+	for i := 0; i < 10; i++ {
+		m.AllowN(3)
+	}
+	if r := window.AllowNDetail(0).Remaining; r != 100 {
+		t.Errorf("Expected the window to be rolled back, %d remaining", r)
+	}
+	if h := gcra.HealthFraction(); h != 1 {
+		t.Errorf("Expected GCRA to be rolled back, health %v", h)
+	}
+	if rl.Tokens() != 100 {
+		t.Errorf("Expected the bucket to be rolled back, %d tokens", rl.Tokens())
+	}
+}
+
+func TestMultiLimiterRollbackSkipsUnrefundable(t *testing.T) {
+	plain := &stubLimiter{tokens: 5}
+	m := NewMultiLimiter(plain, &stubLimiter{})
+
+	m.Allow()
+	if plain.tokens != 4 {
+		t.Errorf("Expected a child without Refund to keep the request, got %d tokens", plain.tokens)
+	}
+}
+
+func TestMultiLimiterWaitsForSlowestChild(t *testing.T) {
+	fast := NewRateLimiter(1000, 1)
+	slow := NewRateLimiter(20, 1)
+	m := NewMultiLimiter(fast, slow)
+	m.Allow()
+
+	start := time.Now()
+	if err := m.WaitContext(context.Background()); err != nil {
+		t.Fatalf("WaitContext failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Expected to wait about 50ms for the slow child, waited %v", elapsed)
+	}
+}
+
+func TestNewMultiLimiterFromConfigs(t *testing.T) {
+	m, err := NewMultiLimiterFromConfigs([]config.Config{
+		{Rate: 2, Burst: 2},
+		{Rate: 3, Window: time.Hour, Burst: 3},
+	})
+	if err != nil {
+		t.Fatalf("NewMultiLimiterFromConfigs failed: %v", err)
+	}
+	if !m.Allow() || !m.Allow() || m.Allow() {
+		t.Error("Expected the per-second config to allow exactly 2")
+	}
+
+	_, err = NewMultiLimiterFromConfigs([]config.Config{{Rate: 1, Burst: 1}, {Rate: -1}})
+	if err == nil || !strings.HasPrefix(err.Error(), "limit 1:") {
+		t.Errorf("Expected an error naming limit 1, got %v", err)
+	}
+}
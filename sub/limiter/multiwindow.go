@@ -0,0 +1,260 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WindowLimit allows Count requests per Window
+type WindowLimit struct {
+	Count  int
+	Window time.Duration
+}
+
+// MultiWindowLimiter enforces several limits at once, such as 10 per second
+// and 100 per minute, denying a request when any of them is exhausted.
+//
+// Each window is a sliding window counter: it keeps the counts of the
+// current and previous fixed windows and weights the previous count by how
+// much of it still overlaps the trailing window. Memory use is constant and
+// the estimate is exact when traffic is evenly spread.
+type MultiWindowLimiter struct {
+	mu      sync.Mutex
+	windows []*slidingWindow
+	clock   Clock
+	last    time.Time
+	skews   int64
+	onSkew  func(time.Duration)
+}
+
+type slidingWindow struct {
+	limit    int
+	size     time.Duration
+	start    time.Time
+	current  int
+	previous int
+}
+
+// NewMultiWindowLimiter creates a limiter enforcing every given limit
+func NewMultiWindowLimiter(limits ...WindowLimit) (*MultiWindowLimiter, error) {
+	if len(limits) == 0 {
+		return nil, errors.New("at least one window limit is required")
+	}
+
+	m := &MultiWindowLimiter{clock: systemClock{}}
+	now := m.clock.Now()
+	m.last = now
+	for _, l := range limits {
+		if l.Count <= 0 {
+			return nil, fmt.Errorf("count for window %v must be positive", l.Window)
+		}
+		if l.Window <= 0 {
+			return nil, errors.New("window must be positive")
+		}
+		m.windows = append(m.windows, &slidingWindow{limit: l.Count, size: l.Window, start: now})
+	}
+
+	sort.Slice(m.windows, func(i, j int) bool {
+		return m.windows[i].size < m.windows[j].size
+	})
+	return m, nil
+}
+
+// Allow reports whether a request may proceed and records it if so
+func (m *MultiWindowLimiter) Allow() bool {
+	return m.AllowN(1)
+}
+
+// AllowN reports whether n requests may proceed together and records them
+// if so. It always fails if n is not positive.
+func (m *MultiWindowLimiter) AllowN(n int) bool {
+	return m.AllowNDetail(n).Allowed
+}
+
+// AllowDetail is like Allow but also reports the binding constraint
+func (m *MultiWindowLimiter) AllowDetail() Decision {
+	return m.AllowNDetail(1)
+}
+
+// AllowNDetail is like AllowN but also reports the binding constraint. On
+// denial the decision describes the window that forces the longest wait; on
+// success it describes the window with the fewest remaining requests.
+func (m *MultiWindowLimiter) AllowNDetail(n int) Decision {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.observe(m.clock.Now())
+	decision := m.check(now, n)
+	if n <= 0 {
+		decision.Allowed = false
+		return decision
+	}
+	if decision.Allowed {
+		for _, w := range m.windows {
+			w.current += n
+		}
+		decision.Remaining -= n
+	}
+	return decision
+}
+
+// RetryAfter returns how long until a single request would be allowed,
+// without recording anything
+func (m *MultiWindowLimiter) RetryAfter() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.check(m.observe(m.clock.Now()), 1).RetryAfter
+}
+
+// HealthFraction returns the remaining fraction of the most used window
+func (m *MultiWindowLimiter) HealthFraction() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.observe(m.clock.Now())
+	health := 1.0
+	for _, w := range m.windows {
+		w.advance(now)
+		health = min(health, max(float64(w.limit)-w.estimate(now), 0)/float64(w.limit))
+	}
+	return health
+}
+
+// SkewObserved returns how many times the limiter saw its clock go backwards
+func (m *MultiWindowLimiter) SkewObserved() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.skews
+}
+
+// OnSkew registers a callback invoked with the size of the step whenever the
+// limiter sees its clock go backwards. The callback runs with the limiter
+// locked and must not call back into it.
+func (m *MultiWindowLimiter) OnSkew(fn func(skew time.Duration)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onSkew = fn
+}
+
+// observe returns now, or the latest time seen so far if the clock went
+// backwards, so windows never move back and counts never shrink. Must
+// hold mu.
+func (m *MultiWindowLimiter) observe(now time.Time) time.Time {
+	if now.Before(m.last) {
+		m.skews++
+		if m.onSkew != nil {
+			m.onSkew(m.last.Sub(now))
+		}
+		return m.last
+	}
+	m.last = now
+	return now
+}
+
+// Refund removes n requests from the current count of every window. Counts
+// that have already rolled into a previous window are left alone.
+func (m *MultiWindowLimiter) Refund(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.observe(m.clock.Now())
+	for _, w := range m.windows {
+		w.advance(now)
+		w.current = max(w.current-n, 0)
+	}
+}
+
+// Wait blocks until a request is allowed
+func (m *MultiWindowLimiter) Wait() {
+	m.WaitContext(context.Background())
+}
+
+// WaitContext blocks until a request is allowed or ctx is done, in which
+// case it returns ctx's error
+func (m *MultiWindowLimiter) WaitContext(ctx context.Context) error {
+	return waitContext(ctx, m.AllowDetail)
+}
+
+// check evaluates n requests against every window without recording them.
+// Must hold mu.
+func (m *MultiWindowLimiter) check(now time.Time, n int) Decision {
+	var decision Decision
+	denied := false
+	for i, w := range m.windows {
+		w.advance(now)
+		remaining := w.remaining(now)
+		if n > remaining {
+			retryAfter := w.retryAfter(now, n)
+			if !denied || retryAfter > decision.RetryAfter {
+				decision = Decision{Limit: w.limit, Remaining: remaining, RetryAfter: retryAfter, Window: w.size}
+			}
+			denied = true
+			continue
+		}
+		if !denied && (i == 0 || remaining < decision.Remaining) {
+			decision = Decision{Allowed: true, Limit: w.limit, Remaining: remaining, Window: w.size}
+		}
+	}
+	return decision
+}
+
+// advance rolls the window forward to contain now
+func (w *slidingWindow) advance(now time.Time) {
+	elapsed := now.Sub(w.start)
+	if elapsed < w.size {
+		return
+	}
+
+	periods := elapsed / w.size
+	if periods == 1 {
+		w.previous = w.current
+	} else {
+		w.previous = 0
+	}
+	w.current = 0
+	w.start = w.start.Add(periods * w.size)
+}
+
+// estimate returns the weighted count of requests in the trailing window
+func (w *slidingWindow) estimate(now time.Time) float64 {
+	overlap := 1 - float64(now.Sub(w.start))/float64(w.size)
+	return float64(w.previous)*overlap + float64(w.current)
+}
+
+// remaining returns how many more requests fit in the window. The epsilon
+// keeps floating point error from hiding a slot at the exact instant
+// retryAfter reports.
+func (w *slidingWindow) remaining(now time.Time) int {
+	return max(int(float64(w.limit)-w.estimate(now)+1e-9), 0)
+}
+
+// retryAfter returns how long until n more requests fit in the window
+func (w *slidingWindow) retryAfter(now time.Time, n int) time.Duration {
+	if n > w.limit {
+		// Can never succeed; report a full window as the best hint
+		return w.size
+	}
+
+	// While the current window lasts, only the previous count decays
+	if room := float64(w.limit - n - w.current); room >= 0 && w.previous > 0 {
+		fraction := 1 - room/float64(w.previous)
+		at := w.start.Add(time.Duration(math.Ceil(fraction * float64(w.size))))
+		return max(at.Sub(now), 0)
+	}
+
+	if w.current == 0 {
+		return 0
+	}
+
+	// Otherwise wait for the next window, where the current count decays
+	next := w.start.Add(w.size)
+	fraction := 1 - float64(w.limit-n)/float64(w.current)
+	at := next.Add(time.Duration(math.Ceil(fraction * float64(w.size))))
+	return max(at.Sub(now), 0)
+}
@@ -0,0 +1,236 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestMultiWindow(t *testing.T, clock *fakeClock, limits ...WindowLimit) *MultiWindowLimiter {
+	t.Helper()
+	m, err := NewMultiWindowLimiter(limits...)
+	if err != nil {
+		t.Fatalf("NewMultiWindowLimiter failed: %v", err)
+	}
+	m.clock = clock
+	m.last = clock.Now()
+	for _, w := range m.windows {
+		w.start = clock.Now()
+	}
+	return m
+}
+
+func TestMultiWindowShortWindowBinds(t *testing.T) {
+	clock := newFakeClock()
+	m := newTestMultiWindow(t, clock,
+		WindowLimit{Count: 10, Window: time.Second},
+		WindowLimit{Count: 100, Window: time.Minute},
+	)
+
+	for i := 0; i < 10; i++ {
+		if !m.Allow() {
+			t.Fatalf("Expected request %d to be allowed", i)
+		}
+	}
+
+	decision := m.AllowDetail()
+	if decision.Allowed {
+		t.Fatal("Expected per-second window to deny the 11th request")
+	}
+	if decision.Window != time.Second || decision.Limit != 10 {
+		t.Errorf("Expected per-second window to bind, got %+v", decision)
+	}
+	// The next second starts in 1s, and 9 of its 10 slots are taken by the
+	// weighted previous count until a tenth of it has passed
+	if decision.RetryAfter.Round(time.Millisecond) != 1100*time.Millisecond {
+		t.Errorf("Expected retry-after of 1.1s, got %v", decision.RetryAfter)
+	}
+
+	// The per-minute window still has room when the second frees up
+	clock.Advance(decision.RetryAfter)
+	if !m.Allow() {
+		t.Error("Expected request to be allowed after the reported retry-after")
+	}
+}
+
+func TestMultiWindowLongWindowBinds(t *testing.T) {
+	clock := newFakeClock()
+	m := newTestMultiWindow(t, clock,
+		WindowLimit{Count: 10, Window: time.Second},
+		WindowLimit{Count: 25, Window: time.Minute},
+	)
+
+	allowed := 0
+	for second := 0; second < 5; second++ {
+		for i := 0; i < 10; i++ {
+			if m.Allow() {
+				allowed++
+			}
+		}
+		clock.Advance(time.Second)
+	}
+	if allowed != 25 {
+		t.Errorf("Expected per-minute window to cap admissions at 25, got %d", allowed)
+	}
+
+	decision := m.AllowDetail()
+	if decision.Allowed {
+		t.Fatal("Expected per-minute window to deny")
+	}
+	if decision.Window != time.Minute || decision.Limit != 25 {
+		t.Errorf("Expected per-minute window to bind, got %+v", decision)
+	}
+	// The next minute starts 55s from now; 24 of its 25 slots are still
+	// taken by the weighted previous count until 4% of it has passed
+	if decision.RetryAfter.Round(time.Millisecond) != 57400*time.Millisecond {
+		t.Errorf("Expected retry-after of 57.4s, got %v", decision.RetryAfter)
+	}
+
+	// Retrying at the reported time succeeds
+	clock.Advance(decision.RetryAfter)
+	if !m.Allow() {
+		t.Error("Expected request to be allowed after the reported retry-after")
+	}
+}
+
+func TestMultiWindowSlidingEstimate(t *testing.T) {
+	clock := newFakeClock()
+	m := newTestMultiWindow(t, clock, WindowLimit{Count: 10, Window: time.Minute})
+
+	for i := 0; i < 10; i++ {
+		m.Allow()
+	}
+
+	// Halfway through the next minute, half of the previous count still
+	// overlaps the trailing window
+	clock.Advance(90 * time.Second)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if m.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("Expected 5 requests allowed by the sliding estimate, got %d", allowed)
+	}
+
+	decision := m.AllowDetail()
+	if decision.RetryAfter <= 0 {
+		t.Errorf("Expected positive retry-after, got %v", decision.RetryAfter)
+	}
+	clock.Advance(decision.RetryAfter)
+	if !m.Allow() {
+		t.Error("Expected request to be allowed after the reported retry-after")
+	}
+}
+
+func TestMultiWindowAllowN(t *testing.T) {
+	clock := newFakeClock()
+	m := newTestMultiWindow(t, clock,
+		WindowLimit{Count: 10, Window: time.Second},
+		WindowLimit{Count: 100, Window: time.Minute},
+	)
+
+	if !m.AllowN(8) {
+		t.Fatal("Expected AllowN(8) to succeed")
+	}
+	if m.AllowN(3) {
+		t.Error("Expected AllowN(3) to fail without partial consumption")
+	}
+	if !m.AllowN(2) {
+		t.Error("Expected AllowN(2) to take the remaining tokens")
+	}
+	if m.RetryAfter() <= 0 {
+		t.Error("Expected positive RetryAfter when exhausted")
+	}
+}
+
+func TestMultiWindowNonPositiveN(t *testing.T) {
+	m := newTestMultiWindow(t, newFakeClock(), WindowLimit{Count: 2, Window: time.Second})
+	m.AllowN(2)
+
+	for _, n := range []int{0, -5} {
+		if d := m.AllowNDetail(n); d.Allowed || d.Remaining != 0 {
+			t.Errorf("AllowNDetail(%d): expected a denial leaving nothing, got %+v", n, d)
+		}
+	}
+	if m.Allow() {
+		t.Error("Expected a negative n not to free capacity")
+	}
+}
+
+func TestNewMultiWindowLimiterErrors(t *testing.T) {
+	if _, err := NewMultiWindowLimiter(); err == nil {
+		t.Error("Expected error for no limits")
+	}
+	if _, err := NewMultiWindowLimiter(WindowLimit{Count: 0, Window: time.Second}); err == nil {
+		t.Error("Expected error for zero count")
+	}
+	if _, err := NewMultiWindowLimiter(WindowLimit{Count: 1, Window: 0}); err == nil {
+		t.Error("Expected error for zero window")
+	}
+}
+
+func TestMultiWindowClockSkew(t *testing.T) {
+	clock := newFakeClock()
+	m := newTestMultiWindow(t, clock, WindowLimit{Count: 5, Window: time.Second})
+
+	var observed []time.Duration
+	m.OnSkew(func(skew time.Duration) {
+		observed = append(observed, skew)
+	})
+
+	clock.Advance(1500 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		m.Allow()
+	}
+
+	// Stepping back must not roll the window back and forget the requests
+	clock.Advance(-time.Second)
+	if m.Allow() {
+		t.Error("Expected denial after the clock stepped back")
+	}
+	if decision := m.AllowDetail(); decision.RetryAfter <= 0 || decision.RetryAfter > 2*time.Second {
+		t.Errorf("Expected bounded retry-after despite skew, got %v", decision.RetryAfter)
+	}
+	if m.SkewObserved() != 2 || len(observed) != 2 || observed[0] != time.Second {
+		t.Errorf("Expected two 1s skew observations, got %d with %v", m.SkewObserved(), observed)
+	}
+
+	// Time resumes from the latest observed instant
+	clock.Advance(3 * time.Second)
+	if !m.Allow() {
+		t.Error("Expected request to be allowed once time moved past the window")
+	}
+}
+
+func TestMultiWindowHealthFraction(t *testing.T) {
+	clock := newFakeClock()
+	m := newTestMultiWindow(t, clock,
+		WindowLimit{Count: 10, Window: time.Second},
+		WindowLimit{Count: 20, Window: time.Minute},
+	)
+
+	if h := m.HealthFraction(); h != 1 {
+		t.Errorf("Expected full health when idle, got %v", h)
+	}
+
+	for i := 0; i < 5; i++ {
+		m.Allow()
+	}
+	if h := m.HealthFraction(); h != 0.5 {
+		t.Errorf("Expected per-second window to report half health, got %v", h)
+	}
+
+	// The per-second window recovers but the per-minute one now binds
+	clock.Advance(2 * time.Second)
+	for i := 0; i < 10; i++ {
+		m.Allow()
+	}
+	if h := m.HealthFraction(); h != 0 {
+		t.Errorf("Expected exhausted per-second window to report zero health, got %v", h)
+	}
+	clock.Advance(2 * time.Second)
+	if h := m.HealthFraction(); h != 0.25 {
+		t.Errorf("Expected per-minute window to report 5 of 20 remaining, got %v", h)
+	}
+}
@@ -0,0 +1,478 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+// Algorithms accepted by WithAlgorithm
+const (
+	AlgorithmTokenBucket   = "token_bucket"
+	AlgorithmSlidingWindow = "sliding_window"
+	AlgorithmGCRA          = "gcra"
+)
+
+// ErrMaxWait is returned by WaitContext when a request would have to wait
+// longer than the limit set with WithMaxWait
+var ErrMaxWait = errors.New("limiter: wait would exceed maximum")
+
+// Limiter is the interface of limiters built by New
+type Limiter interface {
+	AllowWaiter
+	ContextWaiter
+	Detailer
+	AllowN(n int) bool
+}
+
+// StatsRecorder receives the outcome of every decision. *stats.Stats
+// implements it.
+type StatsRecorder interface {
+	RecordAllowed()
+	RecordDenied()
+}
+
+// Option configures a limiter built by New
+type Option func(*settings)
+
+type settings struct {
+	rate      float64
+	window    time.Duration
+	burst     int
+	initial   *int
+	fraction  *float64
+	limits    []WindowLimit
+	algorithm string
+	lockFree  bool
+	clock     Clock
+	collector StatsRecorder
+	name      string
+	maxWait   time.Duration
+	recorder  *Recorder
+	key       string
+	err       error
+}
+
+// WithRate sets the token bucket's refill rate in requests per second, or
+// per window if WithWindow is given
+func WithRate(rate float64) Option {
+	return func(s *settings) { s.rate = rate }
+}
+
+// WithWindow makes the rate count requests per window, such as 100 per
+// minute, instead of per second
+func WithWindow(window time.Duration) Option {
+	return func(s *settings) { s.window = window }
+}
+
+// WithInitialTokens starts the token bucket with n tokens instead of a full
+// burst, so a new limiter ramps up rather than admitting a burst at once
+func WithInitialTokens(n int) Option {
+	return func(s *settings) { s.initial = &n }
+}
+
+// WithBurst sets the token bucket's capacity. It defaults to the rate
+// rounded up, and at least 1.
+func WithBurst(burst int) Option {
+	return func(s *settings) { s.burst = burst }
+}
+
+// WithLimits sets the windows enforced by the sliding window algorithm
+func WithLimits(limits ...WindowLimit) Option {
+	return func(s *settings) { s.limits = limits }
+}
+
+// WithAlgorithm selects the algorithm. It defaults to the sliding window
+// when limits are set and to the token bucket otherwise.
+func WithAlgorithm(algorithm string) Option {
+	return func(s *settings) { s.algorithm = algorithm }
+}
+
+// WithLockFree makes the token bucket algorithm lock-free, for limiters
+// shared by many goroutines. Decisions are the same; it is implemented with
+// GCRA, as used by AtomicRateLimiter.
+func WithLockFree() Option {
+	return func(s *settings) { s.lockFree = true }
+}
+
+// WithInitialFraction starts the token bucket with fraction of its burst,
+// rounded down, from 0 for empty to 1 for full
+func WithInitialFraction(fraction float64) Option {
+	return func(s *settings) { s.fraction = &fraction }
+}
+
+// WithConfig takes the algorithm, rate, window, burst, initial tokens,
+// limits and name from c. Rate is per Window, or per second if Window is
+// zero. Options after it override it. Enabled is ignored; see
+// NewFromConfig.
+func WithConfig(c *config.Config) Option {
+	return func(s *settings) {
+		if err := c.Validate(); err != nil {
+			s.err = fmt.Errorf("invalid config: %w", err)
+			return
+		}
+		s.name = c.Name
+		s.algorithm = c.Algorithm
+		if len(c.Limits) > 0 {
+			s.limits = make([]WindowLimit, len(c.Limits))
+			for i, l := range c.Limits {
+				s.limits[i] = WindowLimit{Count: l.Count, Window: l.Window}
+			}
+			return
+		}
+		s.rate = float64(c.Rate)
+		s.window = c.Window
+		s.burst = c.Burst
+		if c.InitialTokens != nil {
+			initial := *c.InitialTokens
+			s.initial = &initial
+		}
+	}
+}
+
+// WithClock sets the clock the limiter reads time from
+func WithClock(clock Clock) Option {
+	return func(s *settings) { s.clock = clock }
+}
+
+// WithStats records every decision in collector
+func WithStats(collector StatsRecorder) Option {
+	return func(s *settings) { s.collector = collector }
+}
+
+// WithName sets the name the limiter reports
+func WithName(name string) Option {
+	return func(s *settings) { s.name = name }
+}
+
+// WithMaxWait makes WaitContext return ErrMaxWait instead of waiting longer
+// than d in total. Wait is not affected.
+func WithMaxWait(d time.Duration) Option {
+	return func(s *settings) { s.maxWait = d }
+}
+
+// WithRecorder records every decision in r under key, so it can be replayed
+// later with Replay
+func WithRecorder(r *Recorder, key string) Option {
+	return func(s *settings) {
+		s.recorder = r
+		s.key = key
+	}
+}
+
+// engine is the algorithm behind a limiter built by New
+type engine interface {
+	AllowNDetail(n int) Decision
+	RetryAfter() time.Duration
+}
+
+// New builds a limiter from options, validating that they fit together.
+// Statistics, when requested, record the algorithm's decisions; the name
+// and maximum wait apply on top. A token bucket without statistics, maximum
+// wait or recorder is returned as the *RateLimiter itself, so it can be
+// boosted, paused, saved and so on.
+func New(opts ...Option) (Limiter, error) {
+	f, err := newFacade(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if rl, ok := f.engine.(*RateLimiter); ok && f.collector == nil && f.maxWait == 0 && f.recorder == nil {
+		return rl, nil
+	}
+	return f, nil
+}
+
+func newFacade(opts ...Option) (*facade, error) {
+	s := settings{clock: systemClock{}}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.maxWait < 0 {
+		return nil, errors.New("max wait must not be negative")
+	}
+	if s.clock == nil {
+		return nil, errors.New("clock must not be nil")
+	}
+	if s.window < 0 {
+		return nil, errors.New("window must not be negative")
+	}
+	if s.algorithm == "" {
+		s.algorithm = AlgorithmTokenBucket
+		if len(s.limits) > 0 {
+			s.algorithm = AlgorithmSlidingWindow
+		}
+	}
+
+	var e engine
+	switch s.algorithm {
+	case AlgorithmTokenBucket, AlgorithmGCRA:
+		if len(s.limits) > 0 {
+			return nil, errors.New("window limits require the sliding window algorithm")
+		}
+		if s.rate <= 0 {
+			return nil, errors.New("rate must be positive")
+		}
+		rate, per := ratePer(s.rate, s.window)
+		if s.window > 0 {
+			s.rate /= s.window.Seconds()
+		}
+		if s.burst == 0 {
+			s.burst = max(int(math.Ceil(s.rate)), 1)
+		}
+		if s.burst < 0 {
+			return nil, errors.New("burst must be positive")
+		}
+		initial := s.burst
+		if s.initial != nil {
+			if *s.initial < 0 || *s.initial > s.burst {
+				return nil, errors.New("initial tokens must be between 0 and the burst")
+			}
+			initial = *s.initial
+		}
+		if s.fraction != nil {
+			if *s.fraction < 0 || *s.fraction > 1 {
+				return nil, errors.New("initial fraction must be between 0 and 1")
+			}
+			initial = int(*s.fraction * float64(s.burst))
+		}
+		if s.algorithm == AlgorithmGCRA || s.lockFree {
+			g, err := NewGCRALimiter(s.rate, s.burst)
+			if err != nil {
+				return nil, err
+			}
+			g.clock = s.clock
+			g.tat.Store(s.clock.Now().UnixNano() + int64(s.burst-initial)*g.interval)
+			e = g
+			break
+		}
+		rl := NewRateLimiterPer(rate, per, s.burst)
+		rl.name = s.name
+		rl.clock = s.clock
+		rl.lastUpdate = s.clock.Now()
+		rl.tokens = initial
+		e = rl
+	case AlgorithmSlidingWindow:
+		if s.rate != 0 || s.burst != 0 {
+			return nil, errors.New("rate and burst only apply to the token bucket and GCRA algorithms")
+		}
+		if s.lockFree {
+			return nil, errors.New("lock-free mode only applies to the token bucket algorithm")
+		}
+		if s.window != 0 || s.initial != nil || s.fraction != nil {
+			return nil, errors.New("window and initial tokens only apply to the token bucket and GCRA algorithms")
+		}
+		if len(s.limits) == 0 {
+			return nil, errors.New("the sliding window algorithm requires window limits")
+		}
+		m, err := NewMultiWindowLimiter(s.limits...)
+		if err != nil {
+			return nil, err
+		}
+		m.clock = s.clock
+		m.last = s.clock.Now()
+		for _, w := range m.windows {
+			w.start = m.last
+		}
+		e = m
+	default:
+		if _, ok := registered(s.algorithm); ok {
+			return nil, fmt.Errorf("algorithm %q is only built by NewFromConfig", s.algorithm)
+		}
+		return nil, fmt.Errorf("unknown algorithm %q", s.algorithm)
+	}
+
+	return &facade{
+		engine:    e,
+		name:      s.name,
+		collector: s.collector,
+		maxWait:   s.maxWait,
+		clock:     s.clock,
+		recorder:  s.recorder,
+		key:       s.key,
+	}, nil
+}
+
+// NewFromConfig builds a limiter from c with New, or with the factory
+// registered for c's Algorithm if it is not built in. A disabled config
+// yields a limiter that allows everything. It returns a Limiter rather than
+// a *RateLimiter because c may select another algorithm or be disabled; a
+// token bucket config yields a *RateLimiter, which NewRateLimiterFromConfig
+// returns as such.
+func NewFromConfig(c *config.Config) (Limiter, error) {
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if !c.Enabled {
+		return unlimited{name: c.Name}, nil
+	}
+	if factory, ok := registered(c.Algorithm); ok {
+		return factory(c.Clone())
+	}
+	return New(WithConfig(c))
+}
+
+// NewRateLimiterFromConfig builds the token bucket c describes. It fails if
+// c selects another algorithm, and ignores Enabled; use NewFromConfig for a
+// limiter that honors it.
+func NewRateLimiterFromConfig(c *config.Config) (*RateLimiter, error) {
+	l, err := New(WithConfig(c))
+	if err != nil {
+		return nil, err
+	}
+	rl, ok := l.(*RateLimiter)
+	if !ok {
+		algorithm := c.Algorithm
+		if algorithm == "" {
+			algorithm = AlgorithmSlidingWindow
+		}
+		return nil, fmt.Errorf("config selects the %s algorithm, not a token bucket", algorithm)
+	}
+	return rl, nil
+}
+
+// unlimited is the Limiter of a disabled config
+type unlimited struct {
+	name string
+}
+
+func (u unlimited) Allow() bool                           { return true }
+func (u unlimited) AllowN(n int) bool                     { return true }
+func (u unlimited) AllowDetail() Decision                 { return Decision{Allowed: true} }
+func (u unlimited) AllowNDetail(n int) Decision           { return Decision{Allowed: true} }
+func (u unlimited) Wait()                                 {}
+func (u unlimited) WaitContext(ctx context.Context) error { return nil }
+func (u unlimited) RetryAfter() time.Duration             { return 0 }
+func (u unlimited) HealthFraction() float64               { return 1 }
+func (u unlimited) Name() string                          { return u.name }
+
+// facade is the Limiter returned by New
+type facade struct {
+	engine    engine
+	name      string
+	collector StatsRecorder
+	maxWait   time.Duration
+	clock     Clock
+	recorder  *Recorder
+	key       string
+}
+
+func (f *facade) Allow() bool {
+	return f.AllowN(1)
+}
+
+func (f *facade) AllowN(n int) bool {
+	return f.allowN(n).Allowed
+}
+
+func (f *facade) AllowDetail() Decision {
+	return f.allowN(1)
+}
+
+func (f *facade) AllowNDetail(n int) Decision {
+	return f.allowN(n)
+}
+
+func (f *facade) RetryAfter() time.Duration {
+	return f.engine.RetryAfter()
+}
+
+// HealthFraction returns the algorithm's health; every algorithm New
+// builds reports it
+func (f *facade) HealthFraction() float64 {
+	return f.engine.(HealthReporter).HealthFraction()
+}
+
+// ResetAfter returns the algorithm's reset time; every algorithm New
+// builds reports it
+func (f *facade) ResetAfter() time.Duration {
+	return f.engine.(Resetter).ResetAfter()
+}
+
+// Refund gives n requests back to the algorithm if it can take them
+func (f *facade) Refund(n int) {
+	if r, ok := f.engine.(Refunder); ok {
+		r.Refund(n)
+	}
+}
+
+// Penalize charges the algorithm n requests if it can be charged after the
+// fact
+func (f *facade) Penalize(n int) {
+	if p, ok := f.engine.(Penalizer); ok {
+		p.Penalize(n)
+	}
+}
+
+func (f *facade) Name() string {
+	return f.name
+}
+
+func (f *facade) Wait() {
+	f.wait(context.Background(), 0)
+}
+
+func (f *facade) WaitContext(ctx context.Context) error {
+	return f.wait(ctx, f.maxWait)
+}
+
+// wait blocks until a request is allowed, ctx is done or, if maxWait is
+// positive, the next retry would end after maxWait in total
+func (f *facade) wait(ctx context.Context, maxWait time.Duration) error {
+	start := time.Now()
+	for {
+		decision := f.decide(1)
+		if decision.Allowed {
+			f.record(true)
+			return nil
+		}
+		delay := max(decision.RetryAfter, time.Millisecond)
+		if maxWait > 0 && time.Since(start)+delay > maxWait {
+			f.record(false)
+			return ErrMaxWait
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			f.record(false)
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (f *facade) allowN(n int) Decision {
+	decision := f.decide(n)
+	f.record(decision.Allowed)
+	return decision
+}
+
+// decide asks the algorithm about n requests, recording the decision if a
+// recorder is set
+func (f *facade) decide(n int) Decision {
+	if f.recorder == nil {
+		return f.engine.AllowNDetail(n)
+	}
+	now := f.clock.Now()
+	decision := f.engine.AllowNDetail(n)
+	f.recorder.Record(Record{Time: now, Key: f.key, N: n, Allowed: decision.Allowed})
+	return decision
+}
+
+func (f *facade) record(allowed bool) {
+	if f.collector == nil {
+		return
+	}
+	if allowed {
+		f.collector.RecordAllowed()
+	} else {
+		f.collector.RecordDenied()
+	}
+}
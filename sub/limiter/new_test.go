@@ -0,0 +1,311 @@
+package limiter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+func TestNewDefaults(t *testing.T) {
+	l, err := New(WithRate(2.5))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if d := l.AllowDetail(); d.Limit != 3 {
+		t.Errorf("Expected burst to default to the rate rounded up, got %+v", d)
+	}
+
+	l, err = New(WithLimits(WindowLimit{Count: 5, Window: time.Second}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if d := l.AllowDetail(); d.Window != time.Second {
+		t.Errorf("Expected limits to select the sliding window, got %+v", d)
+	}
+}
+
+func TestNewConflicts(t *testing.T) {
+	limits := WithLimits(WindowLimit{Count: 5, Window: time.Second})
+	tests := []struct {
+		name   string
+		opts   []Option
+		errMsg string
+	}{
+		{"nothing", nil, "rate must be positive"},
+		{"limits with token bucket", []Option{WithRate(1), limits, WithAlgorithm(AlgorithmTokenBucket)}, "require the sliding window"},
+		{"burst with sliding window", []Option{limits, WithBurst(10)}, "only apply to the token bucket"},
+		{"sliding window without limits", []Option{WithAlgorithm(AlgorithmSlidingWindow)}, "requires window limits"},
+		{"unknown algorithm", []Option{WithRate(1), WithAlgorithm("magic")}, `unknown algorithm "magic"`},
+		{"negative burst", []Option{WithRate(1), WithBurst(-1)}, "burst must be positive"},
+		{"negative max wait", []Option{WithRate(1), WithMaxWait(-time.Second)}, "max wait"},
+		{"nil clock", []Option{WithRate(1), WithClock(nil)}, "clock"},
+		{"invalid config", []Option{WithConfig(&config.Config{Rate: 0})}, "invalid config"},
+		{"lock-free sliding window", []Option{limits, WithLockFree()}, "lock-free"},
+		{"window with sliding window", []Option{limits, WithWindow(time.Minute)}, "window and initial tokens"},
+		{"negative window", []Option{WithRate(1), WithWindow(-time.Second)}, "window must not be negative"},
+		{"initial above burst", []Option{WithRate(1), WithBurst(2), WithInitialTokens(3)}, "initial tokens"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.opts...)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestNewMatchesHandWired(t *testing.T) {
+	clock := newFakeClock()
+	built, err := New(WithRate(5), WithBurst(3), WithClock(clock))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	bucket := newTestRateLimiter(clock, 5, 3)
+
+	limits := []WindowLimit{{Count: 3, Window: time.Second}, {Count: 10, Window: time.Minute}}
+	builtWindow, err := New(WithLimits(limits...), WithClock(clock))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	window := newTestMultiWindow(t, clock, limits...)
+
+	for i := 0; i < 50; i++ {
+		if got, want := built.AllowDetail(), bucket.AllowDetail(); got != want {
+			t.Fatalf("Step %d: token bucket facade decided %+v, hand-wired %+v", i, got, want)
+		}
+		if got, want := builtWindow.AllowDetail(), window.AllowDetail(); got != want {
+			t.Fatalf("Step %d: sliding window facade decided %+v, hand-wired %+v", i, got, want)
+		}
+		clock.Advance(70 * time.Millisecond)
+	}
+}
+
+func TestNewWithConfig(t *testing.T) {
+	cfg := &config.Config{Name: "api", Rate: 60, Burst: 60, Window: time.Minute}
+	l, err := New(WithConfig(cfg), WithClock(newFakeClock()))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if named, ok := l.(interface{ Name() string }); !ok || named.Name() != "api" {
+		t.Error("Expected the config's name")
+	}
+	if !l.AllowN(60) {
+		t.Error("Expected the config's burst")
+	}
+	if got := RetryAfter(l); got != time.Second {
+		t.Errorf("Expected 60 per minute to refill one per second, got %v", got)
+	}
+
+	cfg = &config.Config{Name: "windows", Limits: []config.WindowLimit{{Count: 2, Window: time.Second}}}
+	l, err = New(WithConfig(cfg), WithName("override"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if l.(interface{ Name() string }).Name() != "override" {
+		t.Error("Expected later options to override the config")
+	}
+	if !l.AllowN(2) || l.Allow() {
+		t.Error("Expected the config's window limit")
+	}
+}
+
+// countingRecorder counts the decisions reported to it
+type countingRecorder struct {
+	allowed, denied int
+}
+
+func (c *countingRecorder) RecordAllowed() { c.allowed++ }
+func (c *countingRecorder) RecordDenied()  { c.denied++ }
+
+func TestNewWithStatsAndMaxWait(t *testing.T) {
+	collector := &countingRecorder{}
+	l, err := New(WithRate(1), WithBurst(1), WithStats(collector), WithMaxWait(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	l.Allow()
+	l.Allow()
+	if err := l.WaitContext(context.Background()); err != ErrMaxWait {
+		t.Errorf("Expected ErrMaxWait for a 1s wait, got %v", err)
+	}
+
+	if collector.allowed != 1 || collector.denied != 2 {
+		t.Errorf("Expected 1 allowed and 2 denied, got %+v", collector)
+	}
+	if _, ok := l.(HealthReporter); !ok {
+		t.Error("Expected the facade to report health")
+	}
+}
+
+func TestNewGCRAFromConfig(t *testing.T) {
+	cfg, err := config.NewBuilder().WithRate(2).WithBurst(2).WithAlgorithm(AlgorithmGCRA).Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	clock := newFakeClock()
+	l, err := newFacade(WithConfig(cfg), WithClock(clock))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, ok := l.engine.(*GCRALimiter); !ok {
+		t.Fatalf("Expected a GCRA engine, got %T", l.engine)
+	}
+	if !l.AllowN(2) || l.Allow() {
+		t.Error("Expected a burst of 2")
+	}
+	clock.Advance(500 * time.Millisecond)
+	if !l.Allow() {
+		t.Error("Expected a request after one emission interval")
+	}
+}
+
+func TestNewLockFree(t *testing.T) {
+	l, err := newFacade(WithRate(5), WithLockFree())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, ok := l.engine.(*GCRALimiter); !ok {
+		t.Errorf("Expected a lock-free engine, got %T", l.engine)
+	}
+}
+
+func TestNewWindowAndInitialTokens(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmTokenBucket, AlgorithmGCRA} {
+		clock := newFakeClock()
+		l, err := New(WithRate(120), WithWindow(time.Minute), WithBurst(10), WithInitialTokens(3),
+			WithAlgorithm(algorithm), WithClock(clock))
+		if err != nil {
+			t.Fatalf("%s: New failed: %v", algorithm, err)
+		}
+		if !l.AllowN(3) || l.Allow() {
+			t.Errorf("%s: expected 3 initial tokens", algorithm)
+		}
+		// 120 per minute is one every 500ms
+		clock.Advance(500 * time.Millisecond)
+		if !l.Allow() || l.Allow() {
+			t.Errorf("%s: expected one token per 500ms", algorithm)
+		}
+	}
+}
+
+func TestNewTokenBucketIsRateLimiter(t *testing.T) {
+	clock := newFakeClock()
+	l, err := New(WithRate(2.5), WithBurst(5), WithName("api"), WithClock(clock))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	rl, ok := l.(*RateLimiter)
+	if !ok {
+		t.Fatalf("Expected a *RateLimiter, got %T", l)
+	}
+	if !rl.AllowN(5) || rl.Allow() || rl.Name() != "api" {
+		t.Fatal("Expected a named burst of 5")
+	}
+	// 2.5 per second is one every 400ms
+	clock.Advance(399 * time.Millisecond)
+	if rl.Allow() {
+		t.Error("Expected no token before 400ms")
+	}
+	clock.Advance(time.Millisecond)
+	if !rl.Allow() {
+		t.Error("Expected a token after 400ms")
+	}
+
+	clock.Advance(2 * time.Second)
+	rl.SetBoost(2)
+	if !rl.AllowN(10) {
+		t.Error("Expected the boost to double the burst")
+	}
+
+	l, err = New(WithRate(2.5), WithStats(&countingRecorder{}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, ok := l.(*RateLimiter); ok {
+		t.Error("Expected statistics to wrap the bucket")
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	cfg := &config.Config{Name: "api", Rate: 2, Burst: 2, Enabled: true}
+	l, err := NewFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+	if !l.AllowN(2) || l.Allow() || Name(l) != "api" {
+		t.Error("Expected the config's burst and name")
+	}
+
+	cfg.Enabled = false
+	l, err = NewFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if !l.AllowN(5) {
+			t.Fatal("Expected a disabled config to allow everything")
+		}
+	}
+	if err := l.WaitContext(context.Background()); err != nil || RetryAfter(l) != 0 {
+		t.Errorf("Expected a disabled config never to wait, got %v", err)
+	}
+
+	if _, err := NewFromConfig(&config.Config{Rate: -1, Enabled: true}); err == nil {
+		t.Error("Expected an invalid config to fail")
+	}
+}
+
+func TestNewRateLimiterFromConfig(t *testing.T) {
+	rl, err := NewRateLimiterFromConfig(&config.Config{Name: "api", Rate: 2, Window: time.Minute, Burst: 2})
+	if err != nil {
+		t.Fatalf("NewRateLimiterFromConfig failed: %v", err)
+	}
+	if state := rl.State(); state.Name != "api" || state.Rate != 2 || state.Per != time.Minute || state.Burst != 2 {
+		t.Errorf("Expected 2 per minute with a burst of 2, got %+v", state)
+	}
+
+	for _, cfg := range []*config.Config{
+		{Rate: 2, Burst: 2, Algorithm: AlgorithmGCRA},
+		{Limits: []config.WindowLimit{{Count: 10, Window: time.Minute}}},
+		{Rate: -1},
+	} {
+		if _, err := NewRateLimiterFromConfig(cfg); err == nil {
+			t.Errorf("Expected %+v to be refused", cfg)
+		}
+	}
+}
+
+func TestNewColdStart(t *testing.T) {
+	cfg, err := config.NewBuilder().WithRate(10).WithBurst(20).WithInitialTokens(0).Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	clock := newFakeClock()
+	l, err := New(WithConfig(cfg), WithClock(clock))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if l.Allow() {
+		t.Fatal("Expected a cold limiter to start empty")
+	}
+	clock.Advance(100 * time.Millisecond)
+	if !l.Allow() || l.Allow() {
+		t.Error("Expected exactly one request after 100ms")
+	}
+
+	l, err = New(WithRate(10), WithBurst(20), WithInitialFraction(0.25), WithClock(clock))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if !l.AllowN(5) || l.Allow() {
+		t.Error("Expected a quarter of the burst")
+	}
+	if _, err := New(WithRate(10), WithInitialFraction(1.5)); err == nil {
+		t.Error("Expected a fraction above 1 to be rejected")
+	}
+}
@@ -0,0 +1,726 @@
+package limiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrCostExceedsBurst is returned when a request costs more tokens than the
+// bucket can ever hold
+var ErrCostExceedsBurst = errors.New("cost exceeds burst")
+
+// ErrInvalidCost is returned by waits for no tokens or a negative number of
+// them
+var ErrInvalidCost = errors.New("cost must be positive")
+
+// ErrPaused is returned by waits on a paused limiter that was set to fail
+// them with SetFailWhilePaused
+var ErrPaused = errors.New("rate limiter paused")
+
+// ErrClosed is returned by waits on a limiter that has been closed
+var ErrClosed = errors.New("rate limiter closed")
+
+// RateLimiter implements a token bucket algorithm for rate limiting
+type RateLimiter struct {
+	rate       int           // tokens per period
+	per        time.Duration // period over which rate tokens accrue
+	burst      int           // maximum number of tokens
+	tokens     int           // current number of tokens, negative while WaitN reservations are owed
+	lastUpdate time.Time     // last time tokens were updated
+	name       string        // identifies the limiter in stats, logs and errors
+	boost      float64       // multiplier applied to rate and burst, 0 means none
+	skews      int64         // number of times the clock was seen going backwards
+	onSkew     func(time.Duration)
+	clock      Clock
+	paused     bool          // whether admissions are stopped
+	pausedAt   time.Time     // when the limiter was paused
+	resumed    chan struct{} // closed on Resume to wake waiters
+	failPaused bool          // whether waits fail with ErrPaused while paused
+	closed     bool          // whether Close has been called
+	done       chan struct{} // closed by Close to wake waiters
+	mu         sync.Mutex    // mutex for thread safety
+}
+
+// NewRateLimiter creates a new rate limiter with the specified rate and
+// burst size. It panics if either is not positive.
+func NewRateLimiter(rate, burst int) *RateLimiter {
+	return NewRateLimiterPer(rate, time.Second, burst)
+}
+
+// NewRateLimiterPer creates a rate limiter that adds rate tokens every per,
+// such as 100 per minute, for limits below one token per second. It panics
+// if rate, per or burst is not positive, as SetRate and SetBurst do.
+func NewRateLimiterPer(rate int, per time.Duration, burst int) *RateLimiter {
+	switch {
+	case rate <= 0:
+		panic("rate must be positive")
+	case per <= 0:
+		panic("period must be positive")
+	case burst <= 0:
+		panic("burst must be positive")
+	}
+	return &RateLimiter{
+		rate:       rate,
+		per:        per,
+		burst:      burst,
+		tokens:     burst, // start with full bucket
+		lastUpdate: time.Now(),
+		clock:      systemClock{},
+		done:       make(chan struct{}),
+	}
+}
+
+// ratePer expresses rate tokens per window, or per second if window is
+// zero, as the whole number of tokens per period a RateLimiter counts: the
+// rate itself when it is whole, and otherwise one token per the time it
+// takes to accrue, at least a nanosecond
+func ratePer(rate float64, window time.Duration) (int, time.Duration) {
+	if window == 0 {
+		window = time.Second
+	}
+	if rate == math.Trunc(rate) && rate <= math.MaxInt32 {
+		return int(rate), window
+	}
+	return 1, max(time.Duration(float64(window)/rate), 1)
+}
+
+// NewNamedRateLimiter creates a new rate limiter that reports the given name
+func NewNamedRateLimiter(name string, rate, burst int) *RateLimiter {
+	rl := NewRateLimiter(rate, burst)
+	rl.name = name
+	return rl
+}
+
+// Name returns the name the limiter was created with
+func (rl *RateLimiter) Name() string {
+	return rl.name
+}
+
+// Allow checks if a request can be processed and consumes a token if available
+func (rl *RateLimiter) Allow() bool {
+	return rl.AllowN(1)
+}
+
+// AllowN consumes n tokens if all of them are available and reports whether
+// it did. It never consumes part of n, and always fails if n exceeds the
+// burst or is not positive.
+func (rl *RateLimiter) AllowN(n int) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.closed || rl.paused || n <= 0 || n > rl.effectiveBurst() {
+		return false
+	}
+	rl.refill()
+
+	// Check if we have tokens available
+	if rl.tokens >= n {
+		rl.tokens -= n
+		return true
+	}
+	return false
+}
+
+// AllowDetail is like Allow but also reports the bucket's state
+func (rl *RateLimiter) AllowDetail() Decision {
+	return rl.AllowNDetail(1)
+}
+
+// AllowNDetail is like AllowN but also reports the bucket's state. A
+// paused or closed limiter, or an n that is not positive, is denied without
+// a retry estimate.
+func (rl *RateLimiter) AllowNDetail(n int) Decision {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	burst := rl.effectiveBurst()
+	decision := Decision{Limit: burst}
+	if !rl.closed && !rl.paused {
+		rl.refill()
+		switch {
+		case n <= 0:
+			// Denied; waiting would not help
+		case n > burst:
+			// Can never succeed; report a full refill as the best hint
+			decision.RetryAfter = rl.until(burst)
+		case rl.tokens >= n:
+			rl.tokens -= n
+			decision.Allowed = true
+		default:
+			decision.RetryAfter = rl.until(n)
+		}
+	}
+	decision.Remaining = max(rl.tokens, 0)
+	return decision
+}
+
+// AllowCost is AllowN for a request that costs cost tokens. A cost above
+// the burst always fails; WaitCost reports that case as
+// ErrCostExceedsBurst.
+func (rl *RateLimiter) AllowCost(cost int) bool {
+	return rl.AllowN(cost)
+}
+
+// AllowUpTo consumes as many tokens as are available, up to n, and returns
+// how many it took. It may return zero.
+func (rl *RateLimiter) AllowUpTo(n int) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.closed || rl.paused {
+		return 0
+	}
+	rl.refill()
+	return rl.take(n)
+}
+
+// WaitUpTo blocks until at least minimum tokens are available, then consumes
+// as many as are available up to n and returns how many it took. It returns
+// an error if ctx is done first or if minimum can never be satisfied.
+func (rl *RateLimiter) WaitUpTo(ctx context.Context, n, minimum int) (int, error) {
+	minimum = max(min(minimum, n), 1)
+	for {
+		rl.mu.Lock()
+		if err := rl.ready(ctx); err != nil {
+			rl.mu.Unlock()
+			return 0, err
+		}
+		rl.refill()
+		if burst := rl.effectiveBurst(); minimum > burst {
+			rl.mu.Unlock()
+			return 0, fmt.Errorf("minimum of %d tokens exceeds burst of %d", minimum, burst)
+		}
+		if rl.tokens >= minimum {
+			taken := rl.take(n)
+			rl.mu.Unlock()
+			return taken, nil
+		}
+		delay := rl.delay(minimum)
+		rl.mu.Unlock()
+
+		if err := rl.sleep(ctx, delay); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// WaitN blocks until n tokens are available and consumes them together. It
+// returns an error at once if n exceeds the burst or is not positive, and
+// ctx's error if ctx is done first, in which case nothing is consumed. If
+// the tokens cannot accrue before ctx's deadline it returns
+// context.DeadlineExceeded at once rather than waiting just to fail.
+//
+// WaitN reserves its tokens up front, leaving the bucket in debt until they
+// have accrued, so callers that arrive later, including Allow, wait behind
+// it instead of taking tokens one by one as they appear. Waiters are
+// therefore served in the order they arrive, each sleeping once until its
+// own tokens are due.
+func (rl *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("%w: %d tokens", ErrInvalidCost, n)
+	}
+	rl.mu.Lock()
+	if burst := rl.effectiveBurst(); n > burst {
+		rl.mu.Unlock()
+		return fmt.Errorf("%w: %d tokens exceed burst of %d", ErrCostExceedsBurst, n, burst)
+	}
+	if err := ctx.Err(); err != nil {
+		rl.mu.Unlock()
+		return err
+	}
+	if err := rl.ready(ctx); err != nil {
+		rl.mu.Unlock()
+		return err
+	}
+	rl.refill()
+	rl.tokens -= n
+	wait := rl.until(0)
+	if deadline, ok := ctx.Deadline(); ok && wait > time.Until(deadline) {
+		rl.tokens += n
+		rl.mu.Unlock()
+		return context.DeadlineExceeded
+	}
+	rl.mu.Unlock()
+
+	err := rl.sleep(ctx, wait)
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if err == nil {
+		// The limiter may have been paused while sleeping
+		err = rl.ready(ctx)
+	}
+	if err != nil {
+		// Give the reservation back
+		rl.refill()
+		rl.tokens = min(rl.tokens+n, rl.effectiveBurst())
+	}
+	return err
+}
+
+// WaitCost is WaitN for a request that costs cost tokens
+func (rl *RateLimiter) WaitCost(ctx context.Context, cost int) error {
+	return rl.WaitN(ctx, cost)
+}
+
+// Refund returns n tokens to the bucket, up to its burst
+func (rl *RateLimiter) Refund(n int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	rl.tokens = min(rl.tokens+n, rl.effectiveBurst())
+}
+
+// Penalize takes n tokens from the bucket, leaving it in debt if it has
+// fewer. Requests are denied until the debt is paid off at the refill rate.
+// An n that is not positive takes nothing.
+func (rl *RateLimiter) Penalize(n int) {
+	if n <= 0 {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	rl.tokens -= n
+}
+
+// delay returns how long to sleep before n tokens may be available. Must
+// hold mu.
+func (rl *RateLimiter) delay(n int) time.Duration {
+	return max(rl.until(n), time.Millisecond)
+}
+
+// until returns how long until n tokens are available, or zero if they
+// already are. Must hold mu, after a refill.
+func (rl *RateLimiter) until(n int) time.Duration {
+	if rl.tokens >= n {
+		return 0
+	}
+	// Time until the missing tokens accrue, less the fraction of a token
+	// already carried since the last refill
+	missing := time.Duration(float64(n-rl.tokens) * rl.interval())
+	return max(missing-rl.clock.Now().Sub(rl.lastUpdate), 0)
+}
+
+// RetryAfter returns how long until a token is available, without
+// consuming anything. It is zero if a token is available now.
+func (rl *RateLimiter) RetryAfter() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	return rl.until(1)
+}
+
+// ResetAfter returns how long until the bucket is full again if no more
+// tokens are taken
+func (rl *RateLimiter) ResetAfter() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	return rl.until(rl.effectiveBurst())
+}
+
+// sleepContext waits for d, or returns ctx's error if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// sleep is like sleepContext but returns ErrClosed early if the limiter is
+// closed
+func (rl *RateLimiter) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-rl.done:
+		return ErrClosed
+	case <-timer.C:
+		return nil
+	}
+}
+
+// take consumes up to n tokens and returns how many it took. Must hold mu.
+func (rl *RateLimiter) take(n int) int {
+	taken := max(min(n, rl.tokens), 0)
+	rl.tokens -= taken
+	return taken
+}
+
+// refill adds the tokens generated since the last update. Must hold mu.
+func (rl *RateLimiter) refill() {
+	if rl.paused {
+		// Accrual is frozen until Resume
+		return
+	}
+
+	// Calculate tokens to add based on elapsed time
+	now := rl.clock.Now()
+	elapsed := now.Sub(rl.lastUpdate)
+	if elapsed < 0 {
+		// The clock went backwards (clock step, VM resume, or a last
+		// update restored from another host). Keep the tokens as they
+		// are and accrue from now on, so none are taken away, none are
+		// granted twice and the step is only observed once.
+		rl.skews++
+		if rl.onSkew != nil {
+			rl.onSkew(-elapsed)
+		}
+		rl.lastUpdate = now
+		return
+	}
+
+	// Add tokens based on rate and elapsed time, never more than a full
+	// bucket so long gaps cannot overflow
+	burst := rl.effectiveBurst()
+	interval := rl.interval()
+	accrued := float64(elapsed) / interval
+	if accrued >= float64(burst-rl.tokens) {
+		rl.tokens = burst
+		rl.lastUpdate = now
+		return
+	}
+
+	// Only advance by the time that produced whole tokens, so the fraction
+	// of a token accrued so far carries over to the next refill
+	if whole := int(accrued); whole > 0 {
+		rl.tokens += whole
+		rl.lastUpdate = rl.lastUpdate.Add(time.Duration(float64(whole) * interval))
+	}
+}
+
+// HealthFraction returns the fraction of the bucket that is currently full
+func (rl *RateLimiter) HealthFraction() float64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	return float64(max(rl.tokens, 0)) / float64(rl.effectiveBurst())
+}
+
+// Tokens returns how many tokens are available now
+func (rl *RateLimiter) Tokens() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	return max(rl.tokens, 0)
+}
+
+// LimiterState is a point-in-time view of a RateLimiter
+type LimiterState struct {
+	Name string
+	// Rate tokens are added every Per. Rate and Burst include any boost.
+	Rate       int
+	Per        time.Duration
+	Burst      int
+	Tokens     int
+	LastUpdate time.Time
+}
+
+// State returns the limiter's current state. It is safe to call while the
+// limiter is in use, for example from a metrics goroutine.
+func (rl *RateLimiter) State() LimiterState {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	return LimiterState{
+		Name:       rl.name,
+		Rate:       rl.effectiveRate(),
+		Per:        rl.per,
+		Burst:      rl.effectiveBurst(),
+		Tokens:     max(rl.tokens, 0),
+		LastUpdate: rl.lastUpdate,
+	}
+}
+
+// savedState is the JSON form of a RateLimiter. Rate and burst exclude any
+// boost, which is not saved.
+type savedState struct {
+	Name       string        `json:"name,omitempty"`
+	Rate       int           `json:"rate"`
+	Per        time.Duration `json:"per"`
+	Burst      int           `json:"burst"`
+	Tokens     int           `json:"tokens"`
+	LastUpdate time.Time     `json:"last_update"`
+}
+
+// MarshalJSON saves the limiter's configuration and tokens, so that a
+// restarted process can carry on with the same bucket instead of a full
+// one
+func (rl *RateLimiter) MarshalJSON() ([]byte, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	return json.Marshal(savedState{
+		Name:       rl.name,
+		Rate:       rl.rate,
+		Per:        rl.per,
+		Burst:      rl.burst,
+		Tokens:     max(rl.tokens, 0),
+		LastUpdate: rl.lastUpdate,
+	})
+}
+
+// UnmarshalJSON restores a limiter saved with MarshalJSON. Tokens accrue
+// for the time since it was saved, up to the burst, as if the limiter had
+// kept running. The limiter may be a zero RateLimiter.
+func (rl *RateLimiter) UnmarshalJSON(data []byte) error {
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if state.Rate <= 0 || state.Burst <= 0 || state.Per <= 0 {
+		return errors.New("rate limiter state: rate, per and burst must be positive")
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.clock == nil {
+		rl.clock = systemClock{}
+	}
+	if rl.done == nil {
+		rl.done = make(chan struct{})
+	}
+	rl.name = state.Name
+	rl.rate = state.Rate
+	rl.per = state.Per
+	rl.burst = state.Burst
+	rl.tokens = min(max(state.Tokens, 0), state.Burst)
+	rl.lastUpdate = state.LastUpdate
+	rl.refill()
+	return nil
+}
+
+// SkewObserved returns how many times the limiter saw its clock go backwards
+func (rl *RateLimiter) SkewObserved() int64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.skews
+}
+
+// OnSkew registers a callback invoked with the size of the step whenever the
+// limiter sees its clock go backwards. The callback runs with the limiter
+// locked and must not call back into it.
+func (rl *RateLimiter) OnSkew(fn func(skew time.Duration)) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.onSkew = fn
+}
+
+// Wait blocks until a token is available
+func (rl *RateLimiter) Wait() {
+	rl.WaitContext(context.Background())
+}
+
+// WaitContext blocks until a token is available and consumes it. If ctx is
+// done first it returns ctx's error without consuming anything. Like WaitN,
+// it serves waiters in the order they arrive.
+func (rl *RateLimiter) WaitContext(ctx context.Context) error {
+	return rl.WaitN(ctx, 1)
+}
+
+// WaitTimeout waits up to d for a token and reports whether it got one. It
+// gives up as soon as the next token cannot arrive within d, and consumes
+// nothing when it gives up.
+func (rl *RateLimiter) WaitTimeout(d time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return rl.WaitN(ctx, 1) == nil
+}
+
+// SetRate changes the refill rate, in tokens per the limiter's period.
+// Tokens accrued at the old rate up to now are kept. It is safe to call
+// while the limiter is in use, and panics if rate is not positive.
+func (rl *RateLimiter) SetRate(rate int) {
+	if rate <= 0 {
+		panic("rate must be positive")
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	rl.rate = rate
+}
+
+// setRatePerSecond is SetRate for a rate in tokens per second that need
+// not be whole, as AdaptiveLimiter moves it
+func (rl *RateLimiter) setRatePerSecond(rate float64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	rl.rate, rl.per = ratePer(rate, 0)
+}
+
+// SetBurst changes the bucket capacity. Tokens accrued up to now are kept,
+// less any above the new burst. It is safe to call while the limiter is in
+// use, and panics if burst is not positive.
+func (rl *RateLimiter) SetBurst(burst int) {
+	if burst <= 0 {
+		panic("burst must be positive")
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	rl.burst = burst
+	rl.tokens = min(rl.tokens, rl.effectiveBurst())
+}
+
+// SetBoost multiplies the rate and burst by factor until it is called again.
+// A factor of 1 removes the boost. Available tokens are scaled so the bucket
+// stays as full, proportionally, as it was before the change, rounded to
+// the nearest token, so boosting and then reverting gives back the tokens
+// there were.
+func (rl *RateLimiter) SetBoost(factor float64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	oldBurst := rl.effectiveBurst()
+	rl.boost = factor
+	if newBurst := rl.effectiveBurst(); newBurst != oldBurst {
+		rl.tokens = int(math.Round(float64(rl.tokens) * float64(newBurst) / float64(oldBurst)))
+	}
+}
+
+// Pause stops the limiter admitting requests until Resume. Allow returns
+// false and waits block, or fail with ErrPaused if SetFailWhilePaused was
+// set. Tokens do not accrue while paused. Pausing a paused limiter does
+// nothing.
+func (rl *RateLimiter) Pause() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.paused {
+		return
+	}
+	rl.refill()
+	rl.paused = true
+	rl.pausedAt = rl.clock.Now()
+	rl.resumed = make(chan struct{})
+}
+
+// Resume undoes Pause, waking any blocked waiters. The bucket picks up with
+// the tokens it had when paused. Resuming a running limiter does nothing.
+func (rl *RateLimiter) Resume() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if !rl.paused {
+		return
+	}
+	if paused := rl.clock.Now().Sub(rl.pausedAt); paused > 0 {
+		rl.lastUpdate = rl.lastUpdate.Add(paused)
+	}
+	rl.paused = false
+	close(rl.resumed)
+}
+
+// IsPaused reports whether the limiter is paused
+func (rl *RateLimiter) IsPaused() bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.paused
+}
+
+// SetFailWhilePaused sets whether waits return ErrPaused while the limiter
+// is paused instead of blocking until it is resumed
+func (rl *RateLimiter) SetFailWhilePaused(fail bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.failPaused = fail
+}
+
+// Close stops the limiter for good. Blocked waits return ErrClosed, as do
+// any later ones, and Allow returns false. Closing a closed limiter does
+// nothing. The error is always nil; it is there to satisfy io.Closer.
+func (rl *RateLimiter) Close() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if !rl.closed {
+		rl.closed = true
+		close(rl.done)
+	}
+	return nil
+}
+
+// ready reports whether a wait may go on. It returns ErrClosed once the
+// limiter is closed, and while it is paused blocks until it is resumed or
+// returns ErrPaused if waits should fail instead. It releases mu while
+// blocked and returns ctx's error if ctx is done first. Must hold mu; still
+// holds it on return.
+func (rl *RateLimiter) ready(ctx context.Context) error {
+	for {
+		if rl.closed {
+			return ErrClosed
+		}
+		if !rl.paused {
+			return nil
+		}
+		if rl.failPaused {
+			return ErrPaused
+		}
+		resumed := rl.resumed
+		rl.mu.Unlock()
+		select {
+		case <-resumed:
+			rl.mu.Lock()
+		case <-rl.done:
+			rl.mu.Lock()
+		case <-ctx.Done():
+			rl.mu.Lock()
+			return ctx.Err()
+		}
+	}
+}
+
+// effectiveRate returns the rate with any boost applied. Must hold mu.
+func (rl *RateLimiter) effectiveRate() int {
+	if rl.boost == 0 {
+		return rl.rate
+	}
+	return max(int(float64(rl.rate)*rl.boost), 1)
+}
+
+// interval returns how long one token takes to accrue at the effective
+// rate, in nanoseconds. Unlike effectiveRate it keeps the fraction of a
+// boosted rate. Must hold mu.
+func (rl *RateLimiter) interval() float64 {
+	rate := float64(rl.rate)
+	if rl.boost != 0 {
+		rate = max(rate*rl.boost, 1)
+	}
+	return float64(rl.per) / rate
+}
+
+// effectiveBurst returns the burst with any boost applied. Must hold mu.
+func (rl *RateLimiter) effectiveBurst() int {
+	if rl.boost == 0 {
+		return rl.burst
+	}
+	return max(int(float64(rl.burst)*rl.boost), 1)
+}
@@ -0,0 +1,1053 @@
+package limiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+// newTestRateLimiter creates a full bucket refilling at rate tokens per
+// second, which need not be whole, reading time from clock
+func newTestRateLimiter(clock *fakeClock, rate float64, burst int) *RateLimiter {
+	r, per := ratePer(rate, 0)
+	rl := NewRateLimiterPer(r, per, burst)
+	rl.clock = clock
+	rl.lastUpdate = clock.Now()
+	return rl
+}
+
+func TestNamedRateLimiter(t *testing.T) {
+	rl := NewNamedRateLimiter("api", 10, 20)
+	if rl.Name() != "api" {
+		t.Errorf("Expected name 'api', got %q", rl.Name())
+	}
+}
+
+func TestSetBoost(t *testing.T) {
+	rl := NewRateLimiter(1, 10)
+
+	// Use half of the bucket
+	for i := 0; i < 5; i++ {
+		rl.Allow()
+	}
+
+	rl.SetBoost(2)
+	rl.mu.Lock()
+	burst, tokens := rl.effectiveBurst(), rl.tokens
+	rl.mu.Unlock()
+	if burst != 20 || tokens != 10 {
+		t.Errorf("Expected 10 of 20 tokens after boost, got %d of %d", tokens, burst)
+	}
+
+	for i := 0; i < 10; i++ {
+		if !rl.Allow() {
+			t.Fatalf("Expected boosted tokens to be available, denied at %d", i)
+		}
+	}
+
+	rl.SetBoost(1)
+	rl.mu.Lock()
+	burst, tokens = rl.effectiveBurst(), rl.tokens
+	rl.mu.Unlock()
+	if burst != 10 || tokens != 0 {
+		t.Errorf("Expected 0 of 10 tokens after revert, got %d of %d", tokens, burst)
+	}
+}
+
+func TestSetBoostRoundsTokens(t *testing.T) {
+	rl := newTestRateLimiter(newFakeClock(), 1, 10)
+	rl.AllowN(7)
+
+	rl.SetBoost(1.5)
+	if tokens := rl.Tokens(); tokens != 5 {
+		t.Errorf("Expected 4.5 tokens to round to 5, got %d", tokens)
+	}
+	rl.SetBoost(1)
+	if tokens := rl.Tokens(); tokens != 3 {
+		t.Errorf("Expected the 3 tokens from before the boost, got %d", tokens)
+	}
+}
+
+func TestRefillClockSkew(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := NewRateLimiter(10, 10)
+	rl.clock = clockFunc(func() time.Time { return now })
+	rl.lastUpdate = now
+
+	var observed []time.Duration
+	rl.OnSkew(func(skew time.Duration) {
+		observed = append(observed, skew)
+	})
+
+	for i := 0; i < 5; i++ {
+		rl.Allow()
+	}
+
+	// Restored state dated in the future: the clock appears to step back
+	rl.lastUpdate = now.Add(time.Minute)
+	if !rl.Allow() {
+		t.Fatal("Expected remaining tokens to be usable despite skew")
+	}
+	if rl.tokens < 0 || rl.tokens > rl.burst {
+		t.Errorf("Expected tokens within [0, %d], got %d", rl.burst, rl.tokens)
+	}
+	if rl.tokens != 4 {
+		t.Errorf("Expected skew to neither add nor remove tokens, got %d", rl.tokens)
+	}
+	if rl.SkewObserved() != 1 || len(observed) != 1 || observed[0] != time.Minute {
+		t.Errorf("Expected one 1m skew observation, got %d with %v", rl.SkewObserved(), observed)
+	}
+
+	// Accrual resumes right away from the stepped-back time, without
+	// waiting for the clock to catch up or observing the step again
+	now = now.Add(200 * time.Millisecond)
+	rl.Allow()
+	if rl.tokens != 5 {
+		t.Errorf("Expected 2 tokens accrued and 1 used, got %d", rl.tokens)
+	}
+	if rl.SkewObserved() != 1 || len(observed) != 1 {
+		t.Errorf("Expected the step to be observed once, got %d", rl.SkewObserved())
+	}
+}
+
+func TestRefillCapsLongGaps(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := NewRateLimiter(1000000, 10)
+	rl.clock = clockFunc(func() time.Time { return now })
+	rl.lastUpdate = now.Add(-100 * 365 * 24 * time.Hour)
+	rl.tokens = 0
+
+	if !rl.Allow() {
+		t.Fatal("Expected a refilled bucket after a long gap")
+	}
+	if rl.tokens != 9 {
+		t.Errorf("Expected accrual capped at burst, got %d tokens", rl.tokens)
+	}
+}
+
+func TestHealthFraction(t *testing.T) {
+	rl := NewRateLimiter(1, 10)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+
+	if h := rl.HealthFraction(); h != 1 {
+		t.Errorf("Expected full bucket, got %v", h)
+	}
+	for i := 0; i < 8; i++ {
+		rl.Allow()
+	}
+	if h := rl.HealthFraction(); h != 0.2 {
+		t.Errorf("Expected 2 of 10 tokens, got %v", h)
+	}
+}
+
+func TestAllowUpTo(t *testing.T) {
+	rl := NewRateLimiter(10, 20)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+
+	if got := rl.AllowUpTo(500); got != 20 {
+		t.Errorf("Expected the full burst of 20, got %d", got)
+	}
+	if got := rl.AllowUpTo(500); got != 0 {
+		t.Errorf("Expected nothing from an empty bucket, got %d", got)
+	}
+
+	// Fractions of a token carry over between refills
+	for i := 0; i < 3; i++ {
+		now = now.Add(50 * time.Millisecond)
+		if got := rl.AllowUpTo(5); got != 0 {
+			t.Errorf("Expected no whole token after %d half-token steps, got %d", i+1, got)
+		}
+		now = now.Add(50 * time.Millisecond)
+		if got := rl.AllowUpTo(5); got != 1 {
+			t.Errorf("Expected one token after %d whole-token steps, got %d", i+1, got)
+		}
+	}
+
+	now = now.Add(time.Second)
+	if got := rl.AllowUpTo(4); got != 4 {
+		t.Errorf("Expected n to cap the tokens taken, got %d", got)
+	}
+	if got := rl.AllowUpTo(100); got != 6 {
+		t.Errorf("Expected the remaining 6 tokens, got %d", got)
+	}
+}
+
+func TestAllowUpToConservation(t *testing.T) {
+	const (
+		rate  = 1000
+		burst = 5000
+		steps = 1000
+		step  = 1300 * time.Microsecond
+	)
+
+	rl := NewRateLimiter(rate, burst)
+	var clock atomic.Int64
+	start := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return start.Add(time.Duration(clock.Load())) })
+
+	if got := rl.AllowUpTo(burst); got != burst {
+		t.Fatalf("Expected to drain the initial burst, got %d", got)
+	}
+
+	var (
+		total atomic.Int64
+		done  atomic.Bool
+		wg    sync.WaitGroup
+	)
+	for c := 0; c < 8; c++ {
+		wg.Add(1)
+		go func(batch int) {
+			defer wg.Done()
+			for !done.Load() {
+				total.Add(int64(rl.AllowUpTo(batch)))
+				runtime.Gosched()
+			}
+		}(c*3 + 1)
+	}
+	for i := 0; i < steps; i++ {
+		clock.Add(int64(step))
+		time.Sleep(10 * time.Microsecond)
+	}
+	done.Store(true)
+	wg.Wait()
+	total.Add(int64(rl.AllowUpTo(burst)))
+
+	// The bucket never fills, so every accrued token must be handed out
+	expected := int64(float64(steps) * step.Seconds() * rate)
+	if got := total.Load(); got < expected-1 || got > expected {
+		t.Errorf("Expected %d tokens handed out over %v, got %d", expected, steps*step, got)
+	}
+}
+
+func TestWaitUpTo(t *testing.T) {
+	rl := NewRateLimiter(100, 20)
+	rl.AllowUpTo(20)
+
+	start := time.Now()
+	got, err := rl.WaitUpTo(context.Background(), 20, 10)
+	if err != nil {
+		t.Fatalf("WaitUpTo failed: %v", err)
+	}
+	if got < 10 || got > 20 {
+		t.Errorf("Expected between 10 and 20 tokens, got %d", got)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected to wait about 100ms for 10 tokens, waited %v", elapsed)
+	}
+
+	if _, err := rl.WaitUpTo(context.Background(), 50, 30); err == nil {
+		t.Error("Expected an error for a minimum above the burst")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	rl.AllowUpTo(20)
+	if _, err := rl.WaitUpTo(ctx, 20, 15); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestAllowN(t *testing.T) {
+	rl := NewRateLimiter(1, 10)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+
+	if rl.AllowN(11) {
+		t.Error("Expected n above the burst to be denied")
+	}
+	if !rl.AllowN(8) {
+		t.Fatal("Expected 8 of 10 tokens to be allowed")
+	}
+	if rl.AllowN(3) {
+		t.Error("Expected 3 tokens to be denied with 2 left")
+	}
+	if rl.tokens != 2 {
+		t.Errorf("Expected a denied AllowN to consume nothing, %d tokens left", rl.tokens)
+	}
+	if !rl.AllowN(2) || rl.Allow() {
+		t.Error("Expected exactly the last 2 tokens to be allowed")
+	}
+}
+
+func TestNonPositiveN(t *testing.T) {
+	for _, n := range []int{0, -5} {
+		rl := NewRateLimiter(1, 10)
+		now := rl.lastUpdate
+		rl.clock = clockFunc(func() time.Time { return now })
+		rl.AllowN(4)
+
+		for name, call := range map[string]func() bool{
+			"AllowN":       func() bool { return rl.AllowN(n) },
+			"AllowNDetail": func() bool { return rl.AllowNDetail(n).Allowed },
+			"WaitN":        func() bool { return !errors.Is(rl.WaitN(context.Background(), n), ErrInvalidCost) },
+			"Penalize":     func() bool { rl.Penalize(n); return false },
+		} {
+			if call() {
+				t.Errorf("%s(%d): expected a refusal", name, n)
+			}
+			if rl.tokens != 6 {
+				t.Errorf("%s(%d): expected 6 tokens untouched, got %d", name, n, rl.tokens)
+			}
+		}
+	}
+}
+
+func TestAllowNConcurrent(t *testing.T) {
+	rl := NewRateLimiter(1, 1000)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+
+	var consumed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(batch bool) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if batch && rl.AllowN(3) {
+					consumed.Add(3)
+				} else if !batch && rl.Allow() {
+					consumed.Add(1)
+				}
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+
+	if got := consumed.Load() + int64(rl.tokens); got != 1000 {
+		t.Errorf("Expected consumed and remaining tokens to add up to 1000, got %d", got)
+	}
+}
+
+func TestWaitContext(t *testing.T) {
+	rl := NewRateLimiter(10, 1)
+	rl.Allow()
+
+	start := time.Now()
+	if err := rl.WaitContext(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected to wait about 100ms for a token, waited %v", elapsed)
+	}
+}
+
+func TestWaitContextCancel(t *testing.T) {
+	rl := NewRateLimiter(1, 1)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+	rl.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := rl.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected to return promptly at the deadline, took %v", elapsed)
+	}
+
+	// A token that accrues after cancellation is still there
+	now = now.Add(time.Second)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := rl.WaitContext(ctx); err != context.Canceled {
+		t.Errorf("Expected Canceled, got %v", err)
+	}
+	if !rl.Allow() {
+		t.Error("Expected a cancelled wait not to consume the token")
+	}
+}
+
+func TestFractionalAccrual(t *testing.T) {
+	tests := []struct {
+		rate, burst int
+		poll        time.Duration
+	}{
+		{1, 1, 200 * time.Millisecond},
+		{3, 5, 70 * time.Millisecond},
+		{10, 2, 30 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		rl := NewRateLimiter(tt.rate, tt.burst)
+		now := rl.lastUpdate
+		rl.clock = clockFunc(func() time.Time { return now })
+		for rl.Allow() {
+		}
+
+		// Poll faster than tokens accrue; every fraction must carry over
+		const duration = 100 * time.Second
+		admitted := 0
+		for elapsed := time.Duration(0); elapsed < duration; elapsed += tt.poll {
+			now = now.Add(tt.poll)
+			if rl.Allow() {
+				admitted++
+			}
+		}
+
+		expected := tt.rate * int(duration/time.Second)
+		if admitted < expected*99/100 || admitted > expected+1 {
+			t.Errorf("Rate %d polled every %v: expected about %d admitted, got %d", tt.rate, tt.poll, expected, admitted)
+		}
+	}
+}
+
+func TestSetRate(t *testing.T) {
+	rl := NewRateLimiter(10, 10)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+	for rl.Allow() {
+	}
+
+	// admitted polls every 10ms for a simulated second
+	admitted := func() int {
+		n := 0
+		for i := 0; i < 100; i++ {
+			now = now.Add(10 * time.Millisecond)
+			if rl.Allow() {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := admitted(); n != 10 {
+		t.Errorf("Expected 10 admitted at rate 10, got %d", n)
+	}
+	rl.SetRate(50)
+	if n := admitted(); n != 50 {
+		t.Errorf("Expected 50 admitted at rate 50, got %d", n)
+	}
+	rl.SetRate(5)
+	if n := admitted(); n != 5 {
+		t.Errorf("Expected 5 admitted at rate 5, got %d", n)
+	}
+}
+
+func TestSetRateKeepsAccrual(t *testing.T) {
+	rl := NewRateLimiter(10, 10)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+	for rl.Allow() {
+	}
+
+	// Half a second at rate 10 is 5 tokens, whatever the rate becomes
+	now = now.Add(500 * time.Millisecond)
+	rl.SetRate(1)
+	if got := rl.AllowUpTo(10); got != 5 {
+		t.Errorf("Expected the 5 tokens accrued before the change, got %d", got)
+	}
+}
+
+func TestSetBurst(t *testing.T) {
+	rl := NewRateLimiter(10, 10)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+
+	rl.SetBurst(4)
+	if got := rl.AllowUpTo(10); got != 4 {
+		t.Errorf("Expected the excess to be dropped down to 4 tokens, got %d", got)
+	}
+
+	rl.SetBurst(20)
+	now = now.Add(5 * time.Second)
+	if got := rl.AllowUpTo(30); got != 20 {
+		t.Errorf("Expected the bucket to fill to the new burst of 20, got %d", got)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	rl := NewRateLimiter(10, 10)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+
+	if got := rl.Tokens(); got != 10 {
+		t.Errorf("Expected a full bucket of 10, got %d", got)
+	}
+	rl.AllowN(7)
+	if got := rl.Tokens(); got != 3 {
+		t.Errorf("Expected 3 tokens after taking 7, got %d", got)
+	}
+	now = now.Add(250 * time.Millisecond)
+	if got := rl.Tokens(); got != 5 {
+		t.Errorf("Expected 5 tokens after 250ms at rate 10, got %d", got)
+	}
+}
+
+func TestState(t *testing.T) {
+	rl := NewNamedRateLimiter("api", 10, 20)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+	rl.AllowN(5)
+	rl.SetBoost(2)
+
+	state := rl.State()
+	expected := LimiterState{Name: "api", Rate: 20, Per: time.Second, Burst: 40, Tokens: 30, LastUpdate: now}
+	if state != expected {
+		t.Errorf("Expected %+v, got %+v", expected, state)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	rl := NewRateLimiter(5, 1)
+	rl.Allow()
+
+	// The next token is 200ms away
+	start := time.Now()
+	if rl.WaitTimeout(50 * time.Millisecond) {
+		t.Error("Expected a 50ms timeout to give up")
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("Expected to give up without waiting, took %v", elapsed)
+	}
+
+	start = time.Now()
+	if !rl.WaitTimeout(time.Second) {
+		t.Fatal("Expected a token within a second")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("Expected the token after about 200ms, got it after %v", elapsed)
+	}
+	if rl.Tokens() != 0 {
+		t.Error("Expected the token to be consumed")
+	}
+}
+
+func TestWaitHighRateDoesNotSpin(t *testing.T) {
+	rl := NewRateLimiter(5000, 1)
+	var reads atomic.Int64
+	rl.clock = clockFunc(func() time.Time {
+		reads.Add(1)
+		return time.Now()
+	})
+
+	for i := 0; i < 100; i++ {
+		rl.Wait()
+	}
+	// Each wait reads the clock a few times per attempt; a spinning wait
+	// would read it thousands of times
+	if n := reads.Load(); n > 1000 {
+		t.Errorf("Expected at most 1000 clock reads for 100 waits, got %d", n)
+	}
+}
+
+func TestWaitLowRatePrecision(t *testing.T) {
+	rl := NewRateLimiter(3, 1)
+	rl.Allow()
+
+	start := time.Now()
+	rl.Wait()
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > 380*time.Millisecond {
+		t.Errorf("Expected to wait about 333ms at rate 3, waited %v", elapsed)
+	}
+}
+
+func TestRateLimiterPer(t *testing.T) {
+	cfg := &config.Config{Rate: 2, Burst: 2, Window: time.Minute}
+	rl := NewRateLimiterPer(cfg.Rate, cfg.Window, cfg.Burst)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+	for rl.Allow() {
+	}
+
+	// Poll every second for a simulated minute
+	admitted := 0
+	for i := 0; i < 60; i++ {
+		now = now.Add(time.Second)
+		if rl.Allow() {
+			admitted++
+		}
+	}
+	if admitted != 2 {
+		t.Errorf("Expected 2 admitted in a minute, got %d", admitted)
+	}
+
+	// The next token is 30 seconds after the last one
+	rl.mu.Lock()
+	delay := rl.delay(1)
+	rl.mu.Unlock()
+	if delay != 30*time.Second {
+		t.Errorf("Expected the next token in 30s, got %v", delay)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	rl := NewRateLimiter(4, 1)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+
+	if d := rl.RetryAfter(); d != 0 {
+		t.Errorf("Expected no wait with a token available, got %v", d)
+	}
+	rl.Allow()
+	if d := rl.RetryAfter(); d != 250*time.Millisecond {
+		t.Errorf("Expected a full 250ms interval, got %v", d)
+	}
+
+	// Progress toward the next token counts
+	now = now.Add(100 * time.Millisecond)
+	if d := rl.RetryAfter(); d != 150*time.Millisecond {
+		t.Errorf("Expected 150ms left, got %v", d)
+	}
+	if rl.Tokens() != 0 {
+		t.Error("Expected RetryAfter not to consume anything")
+	}
+}
+
+func TestWaitNExceedsBurst(t *testing.T) {
+	rl := NewRateLimiter(10, 5)
+	if err := rl.WaitN(context.Background(), 6); err == nil {
+		t.Error("Expected an error for more tokens than the burst")
+	}
+	if rl.Tokens() != 5 {
+		t.Error("Expected nothing to be consumed")
+	}
+}
+
+func TestWaitNCompetesWithAllow(t *testing.T) {
+	rl := NewRateLimiter(100, 5)
+	for rl.Allow() {
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				rl.Allow()
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	// 5 tokens take 50ms to accrue; without a reservation the Allow loop
+	// would take each one as it appeared
+	start := time.Now()
+	err := rl.WaitN(context.Background(), 5)
+	elapsed := time.Since(start)
+	close(stop)
+	wg.Wait()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed > 200*time.Millisecond {
+		t.Errorf("Expected WaitN(5) within 4x the 50ms refill time, took %v", elapsed)
+	}
+}
+
+func TestWaitNCancelReturnsReservation(t *testing.T) {
+	rl := NewRateLimiter(1, 5)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+	rl.AllowN(3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rl.WaitN(ctx, 5); err != context.DeadlineExceeded {
+		t.Fatalf("Expected DeadlineExceeded, got %v", err)
+	}
+	if got := rl.Tokens(); got != 2 {
+		t.Errorf("Expected the 2 tokens from before the wait, got %d", got)
+	}
+}
+
+func TestWaitCostExceedsBurst(t *testing.T) {
+	rl := NewRateLimiter(10, 5)
+	if rl.AllowCost(6) {
+		t.Error("Expected a cost above the burst to be denied")
+	}
+	if err := rl.WaitCost(context.Background(), 6); !errors.Is(err, ErrCostExceedsBurst) {
+		t.Errorf("Expected ErrCostExceedsBurst, got %v", err)
+	}
+}
+
+func TestMixedCostsConcurrent(t *testing.T) {
+	const rate, burst = 1000, 50
+	rl := NewRateLimiter(rate, burst)
+
+	var consumed atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(cost int) {
+			defer wg.Done()
+			for time.Since(start) < 100*time.Millisecond {
+				if rl.AllowCost(cost) {
+					consumed.Add(int64(cost))
+				}
+				runtime.Gosched()
+			}
+		}([]int{1, 5, 10}[i%3])
+	}
+	wg.Wait()
+
+	accrued := int64(time.Since(start).Seconds() * rate)
+	if got := consumed.Load(); got > accrued+burst {
+		t.Errorf("Consumed %d tokens, more than %d accrued plus a burst of %d", got, accrued, burst)
+	}
+}
+
+func TestPauseFreezesAccrual(t *testing.T) {
+	rl := NewRateLimiter(10, 10)
+	now := time.Now()
+	rl.clock = clockFunc(func() time.Time { return now })
+	rl.AllowN(10)
+
+	now = now.Add(200 * time.Millisecond)
+	rl.Pause()
+	if !rl.IsPaused() || rl.Allow() {
+		t.Fatal("Expected a paused limiter to deny")
+	}
+	now = now.Add(time.Hour)
+	if rl.Tokens() != 2 {
+		t.Errorf("Expected accrual frozen at 2 tokens, got %d", rl.Tokens())
+	}
+
+	rl.Resume()
+	if rl.IsPaused() || rl.Tokens() != 2 {
+		t.Fatalf("Expected 2 tokens on resume, got %d", rl.Tokens())
+	}
+	now = now.Add(100 * time.Millisecond)
+	if rl.Tokens() != 3 {
+		t.Errorf("Expected accrual to continue after resume, got %d", rl.Tokens())
+	}
+}
+
+func TestPauseBlocksWaiters(t *testing.T) {
+	rl := NewRateLimiter(1000, 1)
+	rl.Pause()
+
+	done := make(chan error, 1)
+	go func() { done <- rl.WaitContext(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected Wait to block while paused, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	rl.Resume()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Wait to succeed after resume, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Resume to wake the waiter")
+	}
+
+	rl.Pause()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := rl.WaitN(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context's error while paused, got %v", err)
+	}
+	if rl.WaitTimeout(20 * time.Millisecond) {
+		t.Error("Expected WaitTimeout to give up while paused")
+	}
+}
+
+func TestPauseFailsWaits(t *testing.T) {
+	rl := NewRateLimiter(10, 10)
+	rl.SetFailWhilePaused(true)
+	rl.Pause()
+
+	if err := rl.WaitContext(context.Background()); !errors.Is(err, ErrPaused) {
+		t.Errorf("Expected ErrPaused from WaitContext, got %v", err)
+	}
+	if err := rl.WaitN(context.Background(), 2); !errors.Is(err, ErrPaused) {
+		t.Errorf("Expected ErrPaused from WaitN, got %v", err)
+	}
+	if _, err := rl.WaitUpTo(context.Background(), 2, 1); !errors.Is(err, ErrPaused) {
+		t.Errorf("Expected ErrPaused from WaitUpTo, got %v", err)
+	}
+	rl.Resume()
+	if rl.Tokens() != 10 {
+		t.Errorf("Expected failed waits to leave the bucket full, got %d", rl.Tokens())
+	}
+}
+
+func TestPauseConcurrent(t *testing.T) {
+	rl := NewRateLimiter(1000000, 1000)
+	stop := make(chan struct{})
+	var pausedAllowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				rl.Allow()
+				rl.WaitTimeout(time.Millisecond)
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		rl.Pause()
+		if rl.Allow() {
+			pausedAllowed.Add(1)
+		}
+		rl.Resume()
+	}
+	close(stop)
+	wg.Wait()
+
+	if n := pausedAllowed.Load(); n != 0 {
+		t.Errorf("Expected no admissions while paused, got %d", n)
+	}
+}
+
+func TestCloseReleasesWaiters(t *testing.T) {
+	rl := NewRateLimiterPer(1, time.Hour, 1)
+	rl.Allow()
+
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() { errs <- rl.WaitContext(context.Background()) }()
+	}
+	time.Sleep(20 * time.Millisecond)
+	rl.Close()
+
+	deadline := time.After(time.Second)
+	for i := 0; i < 10; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrClosed) {
+				t.Errorf("Expected ErrClosed, got %v", err)
+			}
+		case <-deadline:
+			t.Fatalf("Only %d of 10 waiters returned after Close", i)
+		}
+	}
+
+	if rl.Allow() || rl.AllowUpTo(1) != 0 {
+		t.Error("Expected a closed limiter to deny")
+	}
+	if err := rl.WaitN(context.Background(), 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed from a wait after Close, got %v", err)
+	}
+	if rl.WaitTimeout(time.Second) {
+		t.Error("Expected WaitTimeout to fail after Close")
+	}
+	if err := rl.Close(); err != nil {
+		t.Errorf("Expected a second Close to succeed, got %v", err)
+	}
+}
+
+func TestCloseReleasesPausedWaiters(t *testing.T) {
+	rl := NewRateLimiter(10, 10)
+	rl.Pause()
+
+	done := make(chan error, 1)
+	go func() { done <- rl.WaitContext(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+	rl.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to wake a paused waiter")
+	}
+}
+
+func TestCloseConcurrentWithWaits(t *testing.T) {
+	rl := NewRateLimiter(1000, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if err := rl.WaitN(context.Background(), 1); err != nil {
+					if !errors.Is(err, ErrClosed) {
+						t.Errorf("Expected ErrClosed, got %v", err)
+					}
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		go rl.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected every waiter to return after Close")
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	now := time.Now()
+	clock := clockFunc(func() time.Time { return now })
+	rl := NewNamedRateLimiter("proxy", 10, 20)
+	rl.clock = clock
+	rl.lastUpdate = now
+	rl.AllowN(10)
+
+	data, err := json.Marshal(rl)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	// Restart 300ms later: 3 tokens accrued while down
+	now = now.Add(300 * time.Millisecond)
+	restored := &RateLimiter{clock: clock}
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if restored.Name() != "proxy" || restored.State().Burst != 20 {
+		t.Errorf("Expected name and burst restored, got %+v", restored.State())
+	}
+	allowed := 0
+	for restored.Allow() {
+		allowed++
+	}
+	if allowed != 13 {
+		t.Errorf("Expected 13 admitted after restart, got %d", allowed)
+	}
+
+	// A long outage refills the bucket but no further
+	now = now.Add(time.Hour)
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if restored.Tokens() != 20 {
+		t.Errorf("Expected a full bucket after a long outage, got %d", restored.Tokens())
+	}
+}
+
+func TestUnmarshalZeroRateLimiter(t *testing.T) {
+	data, err := json.Marshal(NewRateLimiterPer(100, time.Minute, 5))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var rl RateLimiter
+	if err := json.Unmarshal(data, &rl); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !rl.AllowN(5) || rl.Allow() {
+		t.Error("Expected the restored burst of 5")
+	}
+	if err := rl.WaitContext(canceledContext()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a usable WaitContext, got %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"rate":0,"per":1,"burst":1}`), &rl); err == nil {
+		t.Error("Expected an error for a zero rate")
+	}
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func BenchmarkWaitContended(b *testing.B) {
+	rl := NewRateLimiter(1000000, 100)
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rl.Wait()
+		}
+	})
+}
+
+func TestWaitFIFO(t *testing.T) {
+	rl := NewRateLimiter(50, 1)
+	now := rl.lastUpdate
+	rl.clock = clockFunc(func() time.Time { return now })
+	rl.Allow()
+
+	// debt reports how many tokens queued waiters have reserved. The clock
+	// is stopped so nothing pays it off; each waiter still sleeps for real
+	// until its turn.
+	debt := func() int {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		return -rl.tokens
+	}
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rl.Wait()
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}()
+		// Let waiter i queue before starting the next one
+		for debt() < i+1 {
+			runtime.Gosched()
+		}
+	}
+	wg.Wait()
+
+	for i, waiter := range order {
+		if waiter != i {
+			t.Fatalf("Expected waiters to finish in arrival order, got %v", order)
+		}
+	}
+}
+
+func TestNewRateLimiterPerPanics(t *testing.T) {
+	tests := []struct {
+		rate  int
+		per   time.Duration
+		burst int
+	}{
+		{0, time.Second, 1},
+		{-1, time.Second, 1},
+		{1, 0, 1},
+		{1, time.Second, 0},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic for rate %d per %v, burst %d", tt.rate, tt.per, tt.burst)
+				}
+			}()
+			NewRateLimiterPer(tt.rate, tt.per, tt.burst)
+		}()
+	}
+}
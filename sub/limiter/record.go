@@ -0,0 +1,219 @@
+package limiter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+// traceMagic starts every trace written by a Recorder
+const traceMagic = "RLT1"
+
+// Record is one recorded decision: n requests for key at Time, and whether
+// they were allowed
+type Record struct {
+	Time    time.Time
+	Key     string
+	N       int
+	Allowed bool
+}
+
+// Recorder writes decisions to a compact binary trace in the background.
+// Recording never blocks the limiter: when the buffer is full, records are
+// dropped and counted instead.
+type Recorder struct {
+	mu      sync.RWMutex
+	closed  bool
+	records chan Record
+	done    chan error
+	dropped atomic.Int64
+}
+
+// NewRecorder starts a recorder writing to w, buffering up to buffer
+// records in memory
+func NewRecorder(w io.Writer, buffer int) *Recorder {
+	r := &Recorder{
+		records: make(chan Record, max(buffer, 1)),
+		done:    make(chan error, 1),
+	}
+	go r.run(bufio.NewWriter(w))
+	return r
+}
+
+// Record queues rec for writing, or drops it if the buffer is full or the
+// recorder is closed
+func (r *Recorder) Record(rec Record) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		r.dropped.Add(1)
+		return
+	}
+	select {
+	case r.records <- rec:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many records were dropped
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Close writes the queued records, flushes the trace and returns the first
+// write error, if any. It does not close the underlying writer.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return errors.New("recorder already closed")
+	}
+	r.closed = true
+	close(r.records)
+	r.mu.Unlock()
+
+	return <-r.done
+}
+
+func (r *Recorder) run(w *bufio.Writer) {
+	_, err := w.WriteString(traceMagic)
+	var prev int64
+	buf := make([]byte, 0, 64)
+	for rec := range r.records {
+		if err != nil {
+			continue
+		}
+		// Times are stored as deltas from the previous record, which keeps
+		// them to a few bytes
+		nanos := rec.Time.UnixNano()
+		buf = binary.AppendVarint(buf[:0], nanos-prev)
+		prev = nanos
+		buf = binary.AppendUvarint(buf, uint64(rec.N))
+		if rec.Allowed {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(rec.Key)))
+		buf = append(buf, rec.Key...)
+		_, err = w.Write(buf)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	r.done <- err
+}
+
+// ReadTrace reads a trace written by a Recorder
+func ReadTrace(r io.Reader) ([]Record, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != traceMagic {
+		return nil, errors.New("not a limiter trace")
+	}
+
+	var (
+		records []Record
+		prev    int64
+	)
+	for {
+		delta, err := binary.ReadVarint(br)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(records), err)
+		}
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(records), unexpectedEOF(err))
+		}
+		allowed, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(records), unexpectedEOF(err))
+		}
+		keyLen, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(records), unexpectedEOF(err))
+		}
+		if keyLen > maxTraceKey {
+			return nil, fmt.Errorf("record %d: key of %d bytes is too long", len(records), keyLen)
+		}
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(br, key); err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(records), unexpectedEOF(err))
+		}
+
+		prev += delta
+		records = append(records, Record{
+			Time:    time.Unix(0, prev),
+			Key:     string(key),
+			N:       int(n),
+			Allowed: allowed == 1,
+		})
+	}
+}
+
+// maxTraceKey bounds key lengths so a corrupt trace cannot cause a huge
+// allocation
+const maxTraceKey = 1 << 16
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Divergence is a recorded decision that came out differently on replay
+type Divergence struct {
+	Index    int
+	Record   Record
+	Replayed Decision
+}
+
+// replayClock is set to each record's time before its decision is replayed
+type replayClock struct {
+	now time.Time
+}
+
+func (c *replayClock) Now() time.Time {
+	return c.now
+}
+
+// Replay feeds trace through limiters built from c, one per key, created
+// when the key is first seen, and returns every decision that differs from
+// the recorded outcome. A trace replayed against the config it was recorded
+// with has no divergences, barring dropped records.
+func Replay(trace []Record, c *config.Config) ([]Divergence, error) {
+	clock := &replayClock{}
+	limiters := make(map[string]*facade)
+
+	var divergences []Divergence
+	for i, rec := range trace {
+		clock.now = rec.Time
+		l, ok := limiters[rec.Key]
+		if !ok {
+			var err error
+			if l, err = newFacade(WithConfig(c), WithClock(clock)); err != nil {
+				return nil, err
+			}
+			limiters[rec.Key] = l
+		}
+
+		decision := l.engine.AllowNDetail(rec.N)
+		if decision.Allowed != rec.Allowed {
+			divergences = append(divergences, Divergence{Index: i, Record: rec, Replayed: decision})
+		}
+	}
+	return divergences, nil
+}
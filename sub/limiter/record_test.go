@@ -0,0 +1,159 @@
+package limiter
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/internal/leaktest"
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+// recordRun drives two keys through limiters built from cfg, recording
+// every decision, and returns the trace and the recorded outcomes
+func recordRun(t *testing.T, cfg *config.Config) ([]Record, []bool) {
+	t.Helper()
+	clock := newFakeClock()
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf, 1024)
+
+	alice, err := New(WithConfig(cfg), WithClock(clock), WithRecorder(rec, "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := New(WithConfig(cfg), WithClock(clock), WithRecorder(rec, "bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outcomes []bool
+	for i := 0; i < 40; i++ {
+		outcomes = append(outcomes, alice.Allow())
+		if i%3 == 0 {
+			outcomes = append(outcomes, bob.AllowN(2))
+		}
+		clock.Advance(37 * time.Millisecond)
+	}
+
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if rec.Dropped() != 0 {
+		t.Fatalf("Expected no dropped records, got %d", rec.Dropped())
+	}
+	trace, err := ReadTrace(&buf)
+	if err != nil {
+		t.Fatalf("ReadTrace failed: %v", err)
+	}
+	return trace, outcomes
+}
+
+func TestRecordAndReplaySameConfig(t *testing.T) {
+	cfg := &config.Config{Rate: 10, Burst: 10}
+	trace, outcomes := recordRun(t, cfg)
+
+	if len(trace) != len(outcomes) {
+		t.Fatalf("Expected %d records, got %d", len(outcomes), len(trace))
+	}
+	denied := 0
+	for i, rec := range trace {
+		if rec.Allowed != outcomes[i] {
+			t.Fatalf("Record %d: expected allowed=%v, got %+v", i, outcomes[i], rec)
+		}
+		if !rec.Allowed {
+			denied++
+		}
+	}
+	if denied == 0 {
+		t.Fatal("Expected the synthetic run to include denials")
+	}
+
+	divergences, err := Replay(trace, cfg)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if len(divergences) != 0 {
+		t.Errorf("Expected no divergences against the recorded config, got %+v", divergences)
+	}
+}
+
+func TestReplayChangedConfig(t *testing.T) {
+	trace, _ := recordRun(t, &config.Config{Rate: 10, Burst: 10})
+
+	// A larger bucket allows everything the original denied
+	divergences, err := Replay(trace, &config.Config{Rate: 100, Burst: 100})
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	var denied []int
+	for i, rec := range trace {
+		if !rec.Allowed {
+			denied = append(denied, i)
+		}
+	}
+	if len(divergences) != len(denied) {
+		t.Fatalf("Expected %d divergences, got %d", len(denied), len(divergences))
+	}
+	for i, d := range divergences {
+		if d.Index != denied[i] || !d.Replayed.Allowed || d.Record != trace[d.Index] {
+			t.Errorf("Expected divergence at %d allowed on replay, got %+v", denied[i], d)
+		}
+	}
+}
+
+func TestRecorderDropsUnderPressure(t *testing.T) {
+	pr, pw := io.Pipe()
+	rec := NewRecorder(pw, 1)
+
+	// Nothing reads the pipe, so the writer stalls and the buffer fills
+	for i := 0; i < 10000; i++ {
+		rec.Record(Record{Time: time.Now(), Key: "k", N: 1, Allowed: true})
+	}
+	if rec.Dropped() == 0 {
+		t.Error("Expected records to be dropped while the writer is blocked")
+	}
+
+	go io.Copy(io.Discard, pr)
+	if err := rec.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	dropped := rec.Dropped()
+	rec.Record(Record{})
+	if rec.Dropped() != dropped+1 {
+		t.Error("Expected records after Close to be dropped")
+	}
+}
+
+func TestReadTraceRejectsCorruptInput(t *testing.T) {
+	if _, err := ReadTrace(bytes.NewReader([]byte("nope"))); err == nil {
+		t.Error("Expected an error for a missing header")
+	}
+
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf, 8)
+	rec.Record(Record{Time: time.Unix(0, 42), Key: "key", N: 3, Allowed: true})
+	rec.Close()
+
+	full := buf.Bytes()
+	if _, err := ReadTrace(bytes.NewReader(full[:len(full)-1])); err == nil {
+		t.Error("Expected an error for a truncated record")
+	}
+	records, err := ReadTrace(bytes.NewReader(full))
+	if err != nil || len(records) != 1 || records[0] != (Record{Time: time.Unix(0, 42), Key: "key", N: 3, Allowed: true}) {
+		t.Errorf("Expected the record back intact, got %+v, %v", records, err)
+	}
+}
+
+func TestRecorderSoak(t *testing.T) {
+	leaktest.Soak(t, 100, 25, 2*time.Second, func(i int) {
+		rec := NewRecorder(io.Discard, 4)
+		for j := 0; j < 50; j++ {
+			rec.Record(Record{Time: time.Unix(int64(j), 0), Key: "k", N: 1, Allowed: j%2 == 0})
+		}
+		if err := rec.Close(); err != nil {
+			t.Fatalf("Cycle %d: unexpected error from Close: %v", i, err)
+		}
+	})
+}
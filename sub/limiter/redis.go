@@ -0,0 +1,241 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// RedisClient is the part of a Redis client RedisRateLimiter needs: running
+// a Lua script and returning its reply. With go-redis, wrap the client so
+// Eval returns client.Eval(ctx, script, keys, args...).Result().
+type RedisClient interface {
+	Eval(ctx context.Context, script string, keys []string, args ...any) (any, error)
+}
+
+// BackendRecorder receives the latency and outcome of every call a limiter
+// makes to a remote store. *stats.Stats implements it.
+type BackendRecorder interface {
+	RecordBackendCall(latency time.Duration, err error)
+}
+
+// RedisOptions configures a RedisRateLimiter
+type RedisOptions struct {
+	// Rate is the refill rate in requests per second
+	Rate float64
+	// Burst is the bucket's capacity
+	Burst int
+	// FailOpen allows requests while Redis cannot be reached. By default
+	// they are denied.
+	FailOpen bool
+	// Timeout bounds each call to Redis. Zero means one second.
+	Timeout time.Duration
+	// Recorder, if set, receives the latency of every call to Redis
+	Recorder BackendRecorder
+}
+
+// redisTokenBucket refills and consumes a token bucket stored in a hash in
+// one step, so every replica sharing the key shares the bucket. Time comes
+// from the Redis server so replicas with skewed clocks agree.
+//
+// KEYS[1] is the bucket; ARGV is the rate per second, the burst and the
+// number of tokens wanted. The reply is {allowed, remaining, retry after in
+// microseconds}.
+const redisTokenBucket = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) / 1000000 * rate)
+	ts = now
+end
+local allowed = 0
+local retry = 0
+if n <= tokens then
+	tokens = tokens - n
+	allowed = 1
+elseif n > burst then
+	retry = math.ceil(burst / rate * 1000000)
+else
+	retry = math.ceil((n - tokens) / rate * 1000000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', ts)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, math.floor(tokens), retry}
+`
+
+// RedisRateLimiter is a token bucket kept in Redis, so that replicas behind
+// a load balancer share one limit instead of each enforcing its own. Every
+// decision is one round trip running a Lua script.
+//
+// When Redis cannot be reached the limiter allows or denies according to
+// FailOpen; the error is reported to the Recorder and by Err.
+type RedisRateLimiter struct {
+	client   RedisClient
+	key      string
+	rate     float64
+	burst    int
+	failOpen bool
+	timeout  time.Duration
+	recorder BackendRecorder
+	now      func() time.Time
+	lastErr  atomic.Pointer[error]
+}
+
+// NewRedisRateLimiter creates a limiter whose bucket is stored under key
+func NewRedisRateLimiter(client RedisClient, key string, opts RedisOptions) (*RedisRateLimiter, error) {
+	if client == nil {
+		return nil, errors.New("redis client must not be nil")
+	}
+	if key == "" {
+		return nil, errors.New("key must not be empty")
+	}
+	if opts.Rate <= 0 {
+		return nil, errors.New("rate must be positive")
+	}
+	if opts.Burst <= 0 {
+		return nil, errors.New("burst must be positive")
+	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	return &RedisRateLimiter{
+		client:   client,
+		key:      key,
+		rate:     opts.Rate,
+		burst:    opts.Burst,
+		failOpen: opts.FailOpen,
+		timeout:  timeout,
+		recorder: opts.Recorder,
+		now:      time.Now,
+	}, nil
+}
+
+// WithKey returns a limiter sharing r's client and options that stores its
+// bucket under another key, such as one per client for the per-key
+// middleware
+func (r *RedisRateLimiter) WithKey(key string) *RedisRateLimiter {
+	return &RedisRateLimiter{
+		client:   r.client,
+		key:      key,
+		rate:     r.rate,
+		burst:    r.burst,
+		failOpen: r.failOpen,
+		timeout:  r.timeout,
+		recorder: r.recorder,
+		now:      r.now,
+	}
+}
+
+// Name returns the key the bucket is stored under
+func (r *RedisRateLimiter) Name() string {
+	return r.key
+}
+
+// Allow reports whether a request may proceed and consumes a token if so
+func (r *RedisRateLimiter) Allow() bool {
+	return r.AllowN(1)
+}
+
+// AllowN reports whether n requests may proceed together and consumes n
+// tokens if so. It always fails if n is not positive, without calling
+// Redis.
+func (r *RedisRateLimiter) AllowN(n int) bool {
+	return r.AllowNDetail(n).Allowed
+}
+
+// AllowDetail is like Allow but also reports the bucket's state
+func (r *RedisRateLimiter) AllowDetail() Decision {
+	return r.AllowNDetail(1)
+}
+
+// AllowNDetail is like AllowN but also reports the bucket's state
+func (r *RedisRateLimiter) AllowNDetail(n int) Decision {
+	if n <= 0 {
+		// The script would add -n tokens to the shared bucket
+		return Decision{Limit: r.burst}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	decision, err := r.eval(ctx, n)
+	if err != nil {
+		return Decision{Allowed: r.failOpen, Limit: r.burst}
+	}
+	return decision
+}
+
+// Err returns the error of the last call to Redis, or nil if it succeeded
+func (r *RedisRateLimiter) Err() error {
+	if err := r.lastErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Wait blocks until a request is allowed
+func (r *RedisRateLimiter) Wait() {
+	r.WaitContext(context.Background())
+}
+
+// WaitContext blocks until a request is allowed or ctx is done, in which
+// case it returns ctx's error. While Redis cannot be reached it retries
+// every second, or returns at once if the limiter fails open.
+func (r *RedisRateLimiter) WaitContext(ctx context.Context) error {
+	return waitContext(ctx, func() Decision {
+		decision := r.AllowDetail()
+		if !decision.Allowed && decision.RetryAfter == 0 {
+			decision.RetryAfter = time.Second
+		}
+		return decision
+	})
+}
+
+// eval runs the script for n tokens, recording the call
+func (r *RedisRateLimiter) eval(ctx context.Context, n int) (Decision, error) {
+	start := r.now()
+	reply, err := r.client.Eval(ctx, redisTokenBucket, []string{r.key}, r.rate, r.burst, n)
+	var decision Decision
+	if err == nil {
+		decision, err = parseRedisReply(reply)
+	}
+	if err != nil {
+		err = fmt.Errorf("redis rate limiter %q: %w", r.key, err)
+		r.lastErr.Store(&err)
+	} else {
+		r.lastErr.Store(nil)
+	}
+	if r.recorder != nil {
+		r.recorder.RecordBackendCall(r.now().Sub(start), err)
+	}
+	decision.Limit = r.burst
+	return decision, err
+}
+
+// parseRedisReply decodes the script's reply into a decision
+func parseRedisReply(reply any) (Decision, error) {
+	values, ok := reply.([]any)
+	if !ok || len(values) != 3 {
+		return Decision{}, fmt.Errorf("unexpected reply %v", reply)
+	}
+	var ints [3]int64
+	for i, v := range values {
+		n, ok := v.(int64)
+		if !ok {
+			return Decision{}, fmt.Errorf("unexpected reply %v", reply)
+		}
+		ints[i] = n
+	}
+	return Decision{
+		Allowed:    ints[0] == 1,
+		Remaining:  int(ints[1]),
+		RetryAfter: time.Duration(ints[2]) * time.Microsecond,
+	}, nil
+}
@@ -0,0 +1,226 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis runs the token bucket script's logic in Go against in-memory
+// hashes, with time from clock. It checks the arguments the way the script
+// reads them.
+type fakeRedis struct {
+	mu      sync.Mutex
+	clock   Clock
+	buckets map[string]*fakeBucket
+	err     error
+	calls   int
+}
+
+type fakeBucket struct {
+	tokens float64
+	ts     int64
+}
+
+func newFakeRedis(clock Clock) *fakeRedis {
+	return &fakeRedis{clock: clock, buckets: make(map[string]*fakeBucket)}
+}
+
+func (f *fakeRedis) Eval(ctx context.Context, script string, keys []string, args ...any) (any, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if !strings.Contains(script, "redis.call('TIME')") || len(keys) != 1 || len(args) != 3 {
+		return nil, errors.New("ERR wrong script or arguments")
+	}
+	rate := args[0].(float64)
+	burst := float64(args[1].(int))
+	n := float64(args[2].(int))
+
+	now := f.clock.Now().UnixMicro()
+	b, ok := f.buckets[keys[0]]
+	if !ok {
+		b = &fakeBucket{tokens: burst, ts: now}
+		f.buckets[keys[0]] = b
+	}
+	if now > b.ts {
+		b.tokens = math.Min(burst, b.tokens+float64(now-b.ts)/1e6*rate)
+		b.ts = now
+	}
+	var allowed, retry int64
+	switch {
+	case n <= b.tokens:
+		b.tokens -= n
+		allowed = 1
+	case n > burst:
+		retry = int64(math.Ceil(burst / rate * 1e6))
+	default:
+		retry = int64(math.Ceil((n - b.tokens) / rate * 1e6))
+	}
+	return []any{allowed, int64(math.Floor(b.tokens)), retry}, nil
+}
+
+// backendCalls records what a RedisRateLimiter reports about its calls
+type backendCalls struct {
+	mu     sync.Mutex
+	calls  int
+	errors int
+}
+
+func (b *backendCalls) RecordBackendCall(latency time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls++
+	if err != nil {
+		b.errors++
+	}
+}
+
+func newTestRedisLimiter(t *testing.T, client RedisClient, opts RedisOptions) *RedisRateLimiter {
+	t.Helper()
+	r, err := NewRedisRateLimiter(client, "test", opts)
+	if err != nil {
+		t.Fatalf("NewRedisRateLimiter failed: %v", err)
+	}
+	return r
+}
+
+func TestRedisRateLimiter(t *testing.T) {
+	clock := newFakeClock()
+	redis := newFakeRedis(clock)
+	r := newTestRedisLimiter(t, redis, RedisOptions{Rate: 2, Burst: 3})
+
+	if !r.AllowN(3) {
+		t.Fatal("Expected a full burst to be allowed")
+	}
+	d := r.AllowDetail()
+	if d.Allowed || d.Limit != 3 || d.RetryAfter != 500*time.Millisecond {
+		t.Errorf("Expected denial with RetryAfter 500ms, got %+v", d)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if !r.Allow() {
+		t.Error("Expected a token after 500ms")
+	}
+	if r.Err() != nil {
+		t.Errorf("Expected no error, got %v", r.Err())
+	}
+}
+
+func TestRedisRateLimiterNonPositiveN(t *testing.T) {
+	redis := newFakeRedis(newFakeClock())
+	r := newTestRedisLimiter(t, redis, RedisOptions{Rate: 1, Burst: 2})
+	r.AllowN(2)
+
+	for _, n := range []int{0, -5} {
+		if d := r.AllowNDetail(n); d.Allowed {
+			t.Errorf("AllowNDetail(%d): expected a denial, got %+v", n, d)
+		}
+	}
+	if redis.calls != 1 {
+		t.Errorf("Expected non-positive counts not to reach Redis, got %d calls", redis.calls)
+	}
+	if r.Allow() {
+		t.Error("Expected a negative n not to add tokens")
+	}
+}
+
+func TestRedisRateLimiterSharedAcrossReplicas(t *testing.T) {
+	clock := newFakeClock()
+	redis := newFakeRedis(clock)
+	opts := RedisOptions{Rate: 1, Burst: 10}
+
+	var replicas []*RedisRateLimiter
+	for i := 0; i < 12; i++ {
+		replicas = append(replicas, newTestRedisLimiter(t, redis, opts))
+	}
+	allowed := 0
+	for _, r := range replicas {
+		for j := 0; j < 5; j++ {
+			if r.Allow() {
+				allowed++
+			}
+		}
+	}
+	if allowed != 10 {
+		t.Errorf("Expected replicas to share a burst of 10, allowed %d", allowed)
+	}
+
+	other := replicas[0].WithKey("other")
+	if !other.AllowN(10) || other.Name() != "other" {
+		t.Error("Expected another key to have its own bucket")
+	}
+}
+
+func TestRedisRateLimiterFailureModes(t *testing.T) {
+	redis := newFakeRedis(newFakeClock())
+	redis.err = errors.New("dial tcp: connection refused")
+
+	for _, failOpen := range []bool{true, false} {
+		calls := &backendCalls{}
+		r := newTestRedisLimiter(t, redis, RedisOptions{Rate: 1, Burst: 1, FailOpen: failOpen, Recorder: calls})
+		if r.Allow() != failOpen {
+			t.Errorf("FailOpen %v: expected Allow to return %v", failOpen, failOpen)
+		}
+		if err := r.Err(); err == nil || !strings.Contains(err.Error(), "connection refused") {
+			t.Errorf("Expected the Redis error from Err, got %v", err)
+		}
+		if calls.calls != 1 || calls.errors != 1 {
+			t.Errorf("Expected one failed call recorded, got %+v", calls)
+		}
+	}
+
+	// Failing closed, a waiter gives up only when its context does
+	r := newTestRedisLimiter(t, redis, RedisOptions{Rate: 1, Burst: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.WaitContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context's error, got %v", err)
+	}
+
+	redis.err = nil
+	if !r.Allow() || r.Err() != nil {
+		t.Errorf("Expected recovery once Redis is back, got %v", r.Err())
+	}
+}
+
+func TestRedisRateLimiterBadReply(t *testing.T) {
+	client := redisFunc(func() (any, error) { return "OK", nil })
+	r := newTestRedisLimiter(t, client, RedisOptions{Rate: 1, Burst: 1})
+	if r.Allow() || r.Err() == nil {
+		t.Error("Expected an unexpected reply to fail closed with an error")
+	}
+}
+
+func TestNewRedisRateLimiterErrors(t *testing.T) {
+	redis := newFakeRedis(newFakeClock())
+	tests := []struct {
+		client RedisClient
+		key    string
+		opts   RedisOptions
+	}{
+		{nil, "k", RedisOptions{Rate: 1, Burst: 1}},
+		{redis, "", RedisOptions{Rate: 1, Burst: 1}},
+		{redis, "k", RedisOptions{Rate: 0, Burst: 1}},
+		{redis, "k", RedisOptions{Rate: 1, Burst: 0}},
+	}
+	for _, tt := range tests {
+		if _, err := NewRedisRateLimiter(tt.client, tt.key, tt.opts); err == nil {
+			t.Errorf("Expected an error for key %q and %+v", tt.key, tt.opts)
+		}
+	}
+}
+
+// redisFunc is a RedisClient replying with whatever fn returns
+type redisFunc func() (any, error)
+
+func (f redisFunc) Eval(ctx context.Context, script string, keys []string, args ...any) (any, error) {
+	return f()
+}
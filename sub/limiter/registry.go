@@ -0,0 +1,43 @@
+package limiter
+
+import (
+	"sync"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+// Factory builds a limiter from a valid, enabled config whose Algorithm
+// names the algorithm it was registered under
+type Factory func(c *config.Config) (Limiter, error)
+
+// registry holds the algorithms added with Register. The built-in ones are
+// built by New instead.
+var registry = struct {
+	sync.RWMutex
+	factories map[string]Factory
+}{factories: make(map[string]Factory)}
+
+// Register makes NewFromConfig build limiters for configs whose Algorithm
+// is name with factory, and config validation accept name. Factories read
+// their settings from the config, typically from AlgorithmParams. Register
+// is meant to be called from init functions and panics if factory is nil
+// or name is empty or already taken, including by a built-in algorithm.
+func Register(name string, factory func(*config.Config) (Limiter, error)) {
+	if factory == nil {
+		panic("limiter: nil factory for algorithm " + name)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if err := config.RegisterAlgorithm(name); err != nil {
+		panic("limiter: " + err.Error())
+	}
+	registry.factories[name] = factory
+}
+
+// registered returns the factory registered for algorithm, if any
+func registered(algorithm string) (Factory, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	factory, ok := registry.factories[algorithm]
+	return factory, ok
+}
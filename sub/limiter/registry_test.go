@@ -0,0 +1,70 @@
+package limiter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+// fakeAlgorithm is registered once for the package's tests, allowing
+// AlgorithmParams["allow"] requests in total
+const fakeAlgorithm = "test_fake"
+
+func init() {
+	Register(fakeAlgorithm, func(c *config.Config) (Limiter, error) {
+		allow, _ := c.AlgorithmParams["allow"].(float64)
+		return New(WithRate(1e-9), WithBurst(max(int(allow), 1)), WithName(c.Name))
+	})
+}
+
+func TestRegister(t *testing.T) {
+	cfg := &config.Config{
+		Rate: 1, Burst: 1, Enabled: true, Name: "fake",
+		Algorithm: fakeAlgorithm, AlgorithmParams: map[string]any{"allow": 3.0},
+	}
+	l, err := NewFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+	if !l.AllowN(3) || l.Allow() {
+		t.Error("Expected the registered factory to build the limiter from AlgorithmParams")
+	}
+
+	if _, err := New(WithConfig(cfg)); err == nil || !strings.Contains(err.Error(), "only built by NewFromConfig") {
+		t.Errorf("Expected New to refuse a registered algorithm, got %v", err)
+	}
+
+	cfg.Algorithm = "no_such_algorithm"
+	_, err = NewFromConfig(cfg)
+	if err == nil || !strings.Contains(err.Error(), `unknown algorithm "no_such_algorithm"`) {
+		t.Fatalf("Expected an unknown algorithm to fail validation, got %v", err)
+	}
+	for _, name := range []string{AlgorithmGCRA, AlgorithmSlidingWindow, AlgorithmTokenBucket, fakeAlgorithm} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected the error to list %q, got %v", name, err)
+		}
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	factory := func(c *config.Config) (Limiter, error) { return nil, nil }
+	for _, tt := range []struct {
+		name    string
+		factory func(*config.Config) (Limiter, error)
+	}{
+		{fakeAlgorithm, factory},
+		{AlgorithmTokenBucket, factory},
+		{"", factory},
+		{"test_nil", nil},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected Register(%q) to panic", tt.name)
+				}
+			}()
+			Register(tt.name, tt.factory)
+		}()
+	}
+}
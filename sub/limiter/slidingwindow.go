@@ -0,0 +1,152 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// SlidingWindowLimiter allows at most limit requests in any trailing window.
+// Unlike a token bucket it never allows a burst of more than limit in any
+// window, even after an idle period.
+//
+// It keeps the time of each admitted request in a ring buffer, so memory is
+// bounded by limit. Requests older than the window are pruned lazily.
+type SlidingWindowLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	times  []time.Time
+	head   int
+	count  int
+	clock  Clock
+}
+
+// NewSlidingWindowLimiter creates a limiter allowing limit requests per
+// trailing window
+func NewSlidingWindowLimiter(limit int, window time.Duration) (*SlidingWindowLimiter, error) {
+	if limit <= 0 {
+		return nil, errors.New("limit must be positive")
+	}
+	if window <= 0 {
+		return nil, errors.New("window must be positive")
+	}
+	return &SlidingWindowLimiter{
+		limit:  limit,
+		window: window,
+		times:  make([]time.Time, limit),
+		clock:  systemClock{},
+	}, nil
+}
+
+// Allow reports whether a request may proceed and records it if so
+func (s *SlidingWindowLimiter) Allow() bool {
+	return s.AllowN(1)
+}
+
+// AllowN reports whether n requests may proceed together and records them
+// if so. It always fails if n is not positive.
+func (s *SlidingWindowLimiter) AllowN(n int) bool {
+	return s.AllowNDetail(n).Allowed
+}
+
+// AllowDetail is like Allow but also reports the window's state
+func (s *SlidingWindowLimiter) AllowDetail() Decision {
+	return s.AllowNDetail(1)
+}
+
+// AllowNDetail is like AllowN but also reports the window's state
+func (s *SlidingWindowLimiter) AllowNDetail(n int) Decision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.prune(now)
+	decision := Decision{Limit: s.limit, Window: s.window}
+	if n <= 0 {
+		decision.Remaining = s.limit - s.count
+		return decision
+	}
+	if s.count+n <= s.limit {
+		for i := 0; i < n; i++ {
+			s.times[(s.head+s.count)%s.limit] = now
+			s.count++
+		}
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = s.retryAfter(now, n)
+	}
+	decision.Remaining = s.limit - s.count
+	return decision
+}
+
+// RetryAfter returns how long until a request would be allowed, without
+// recording anything
+func (s *SlidingWindowLimiter) RetryAfter() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.prune(now)
+	return s.retryAfter(now, 1)
+}
+
+// ResetAfter returns how long until every recorded request has left the
+// window
+func (s *SlidingWindowLimiter) ResetAfter() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.prune(now)
+	if s.count == 0 {
+		return 0
+	}
+	newest := s.times[(s.head+s.count-1)%s.limit]
+	return newest.Add(s.window).Sub(now)
+}
+
+// HealthFraction returns the fraction of the window's limit still available
+func (s *SlidingWindowLimiter) HealthFraction() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(s.clock.Now())
+	return float64(s.limit-s.count) / float64(s.limit)
+}
+
+// Wait blocks until a request is allowed
+func (s *SlidingWindowLimiter) Wait() {
+	s.WaitContext(context.Background())
+}
+
+// WaitContext blocks until a request is allowed or ctx is done, in which
+// case it returns ctx's error
+func (s *SlidingWindowLimiter) WaitContext(ctx context.Context) error {
+	return waitContext(ctx, s.AllowDetail)
+}
+
+// prune forgets requests that have left the window. Must hold mu.
+func (s *SlidingWindowLimiter) prune(now time.Time) {
+	for s.count > 0 && now.Sub(s.times[s.head]) >= s.window {
+		s.head = (s.head + 1) % s.limit
+		s.count--
+	}
+}
+
+// retryAfter returns how long until n more requests fit in the window. Must
+// hold mu, after a prune.
+func (s *SlidingWindowLimiter) retryAfter(now time.Time, n int) time.Duration {
+	if n > s.limit {
+		// Can never succeed; report a full window as the best hint
+		return s.window
+	}
+	excess := s.count + n - s.limit
+	if excess <= 0 {
+		return 0
+	}
+	// The excess oldest requests have to leave the window first
+	oldest := s.times[(s.head+excess-1)%s.limit]
+	return max(oldest.Add(s.window).Sub(now), 0)
+}
@@ -0,0 +1,133 @@
+package limiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSlidingWindow(t testing.TB, clock *fakeClock, limit int, window time.Duration) *SlidingWindowLimiter {
+	t.Helper()
+	s, err := NewSlidingWindowLimiter(limit, window)
+	if err != nil {
+		t.Fatalf("NewSlidingWindowLimiter failed: %v", err)
+	}
+	s.clock = clock
+	return s
+}
+
+func TestSlidingWindowBoundary(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestSlidingWindow(t, clock, 3, time.Minute)
+
+	s.Allow()
+	clock.Advance(10 * time.Second)
+	s.Allow()
+	s.Allow()
+	if s.Allow() {
+		t.Fatal("Expected the fourth request in the window to be denied")
+	}
+
+	// The first request leaves the window at exactly 60s
+	clock.Advance(50*time.Second - time.Millisecond)
+	if d := s.AllowDetail(); d.Allowed || d.RetryAfter != time.Millisecond {
+		t.Errorf("Expected denial 1ms before the first request expires, got %+v", d)
+	}
+	clock.Advance(2 * time.Millisecond)
+	if !s.Allow() {
+		t.Error("Expected a slot at 60.001s")
+	}
+	if s.Allow() {
+		t.Error("Expected only one slot to be freed")
+	}
+}
+
+func TestSlidingWindowNoBurstAfterIdle(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestSlidingWindow(t, clock, 2, time.Second)
+
+	clock.Advance(time.Hour)
+	if !s.AllowN(2) || s.Allow() {
+		t.Error("Expected exactly the limit after an idle period")
+	}
+}
+
+func TestSlidingWindowAllowN(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestSlidingWindow(t, clock, 5, time.Second)
+
+	s.AllowN(2)
+	clock.Advance(100 * time.Millisecond)
+	s.AllowN(2)
+
+	d := s.AllowNDetail(3)
+	if d.Allowed || d.Remaining != 1 {
+		t.Errorf("Expected denial with 1 remaining, got %+v", d)
+	}
+	// Two of the requests have to leave; the first two go at 1s
+	if d.RetryAfter != 900*time.Millisecond {
+		t.Errorf("Expected RetryAfter 900ms, got %v", d.RetryAfter)
+	}
+	if d := s.AllowNDetail(6); d.Allowed || d.RetryAfter != time.Second {
+		t.Errorf("Expected n above the limit to report a full window, got %+v", d)
+	}
+}
+
+func TestSlidingWindowNonPositiveN(t *testing.T) {
+	s := newTestSlidingWindow(t, newFakeClock(), 3, time.Minute)
+	s.Allow()
+
+	for _, n := range []int{0, -5} {
+		if d := s.AllowNDetail(n); d.Allowed || d.Remaining != 2 {
+			t.Errorf("AllowNDetail(%d): expected a denial leaving 2, got %+v", n, d)
+		}
+	}
+}
+
+func TestSlidingWindowConcurrent(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestSlidingWindow(t, clock, 100, time.Minute)
+
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if s.Allow() {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 100 {
+		t.Errorf("Expected exactly 100 allowed, got %d", allowed)
+	}
+}
+
+func BenchmarkSlidingWindowAllow(b *testing.B) {
+	s, _ := NewSlidingWindowLimiter(1000, time.Millisecond)
+	for i := 0; i < b.N; i++ {
+		s.Allow()
+	}
+}
+
+func BenchmarkTokenBucketAllow(b *testing.B) {
+	tb := NewRateLimiter(1000000, 1000)
+	for i := 0; i < b.N; i++ {
+		tb.Allow()
+	}
+}
+
+func BenchmarkRateLimiterAllow(b *testing.B) {
+	rl := NewRateLimiter(1000000, 1000)
+	for i := 0; i < b.N; i++ {
+		rl.Allow()
+	}
+}
@@ -0,0 +1,314 @@
+// Package metrics exports rate limiting metrics in the Prometheus text
+// exposition format. It depends only on the standard library, so the core
+// packages stay free of the Prometheus client: serve a Registry on /metrics
+// and point Prometheus at it.
+//
+// Registered sources export
+//
+//	ratelimit_requests_total{decision="allowed|denied",limiter="<name>"}  counter
+//	ratelimit_active_keys{limiter="<name>"}                                gauge, per-key middleware only
+//	ratelimit_limited_keys{limiter="<name>"}                               gauge, per-key middleware only
+//	ratelimit_wait_seconds{limiter="<name>"}                               histogram, middleware only
+//
+// where the limited keys are those whose limiter had no requests left after
+// their last request, and the wait histogram counts requests that waited in
+// ModeWait.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+	"github.com/rRateLimit/arg/sub/middleware"
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+// DefaultWaitBuckets are the upper bounds, in seconds, of the wait
+// histogram's buckets
+var DefaultWaitBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// Registry holds named metric sources and writes their metrics when
+// scraped. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	sources map[string]*source
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{sources: make(map[string]*source)}
+}
+
+// observable is implemented by the middleware limiters
+type observable interface {
+	AddObserver(middleware.Observer)
+}
+
+// keyCounter is implemented by the per-key middleware
+type keyCounter interface {
+	KeyCount() int
+	SnapshotStates(limit int, cursor string) ([]middleware.KeyState, string, error)
+}
+
+// keyStatesPage is how many key states are copied at a time when counting
+// limited keys, so a scrape never holds a shard for long
+const keyStatesPage = 1000
+
+// source is a registered source of metrics, labeled with its name
+type source struct {
+	name string
+
+	// Counted by the observer for middleware sources
+	observed bool
+	allowed  atomic.Int64
+	denied   atomic.Int64
+	wait     *histogram
+
+	// Read at scrape time for stats sources
+	stats stats.Collector
+	keys  keyCounter
+}
+
+func (s *source) ObserveDecision(r *http.Request, allowed bool) {
+	if allowed {
+		s.allowed.Add(1)
+	} else {
+		s.denied.Add(1)
+	}
+}
+
+func (s *source) ObserveWait(r *http.Request, waited time.Duration) {
+	s.wait.observe(waited)
+}
+
+// Register adds the metrics of src under the limiter label of its name,
+// the same name the middleware's LimitInfo and stats snapshots carry. src
+// is a *middleware.HTTPRateLimiter or *middleware.PerKeyHTTPRateLimiter,
+// whose decisions and waits are observed from now on, or a
+// stats.Collector, such as *stats.Stats, whose counts are read when
+// scraped. The name is taken when registering, from Name or, for a
+// collector, its snapshot. It returns an error for other sources, for
+// sources without a name, such as per-key middleware, which need
+// RegisterNamed, and as RegisterNamed does.
+func (reg *Registry) Register(src any) error {
+	name := sourceName(src)
+	if name == "" {
+		return fmt.Errorf("metrics: %T has no name; use RegisterNamed", src)
+	}
+	return reg.RegisterNamed(name, src)
+}
+
+// RegisterNamed is like Register but labels the metrics of src with name
+// instead of its own name. It returns an error for names already
+// registered and for names that are not valid UTF-8, which the text format
+// cannot carry.
+func (reg *Registry) RegisterNamed(name string, src any) error {
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("metrics: limiter name %q is not valid UTF-8", name)
+	}
+	s := &source{name: name}
+	var observed observable
+	switch src := src.(type) {
+	case observable:
+		observed = src
+		s.observed = true
+		s.wait = newHistogram(DefaultWaitBuckets)
+		if keys, ok := src.(keyCounter); ok {
+			s.keys = keys
+		}
+	case stats.Collector:
+		s.stats = src
+	default:
+		return fmt.Errorf("metrics: cannot collect from %T", src)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.sources[name]; ok {
+		return fmt.Errorf("metrics: limiter %q already registered", name)
+	}
+	reg.sources[name] = s
+	if observed != nil {
+		// Only once registered, so a refused source is not observed
+		observed.AddObserver(s)
+	}
+	return nil
+}
+
+// sourceName returns the name of src, or an empty string if it has none
+func sourceName(src any) string {
+	switch src := src.(type) {
+	case limiter.Named:
+		return src.Name()
+	case stats.Collector:
+		return src.GetSnapshot().Name
+	}
+	return ""
+}
+
+// MustRegister is like Register but panics on error
+func MustRegister(reg *Registry, src any) {
+	if err := reg.Register(src); err != nil {
+		panic(err)
+	}
+}
+
+// MustRegisterNamed is like RegisterNamed but panics on error
+func MustRegisterNamed(reg *Registry, name string, src any) {
+	if err := reg.RegisterNamed(name, src); err != nil {
+		panic(err)
+	}
+}
+
+// WriteTo writes every source's metrics in the Prometheus text format
+func (reg *Registry) WriteTo(w io.Writer) (int64, error) {
+	reg.mu.Lock()
+	sources := make([]*source, 0, len(reg.sources))
+	for _, s := range reg.sources {
+		sources = append(sources, s)
+	}
+	reg.mu.Unlock()
+	sort.Slice(sources, func(i, j int) bool { return sources[i].name < sources[j].name })
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	fmt.Fprintln(cw, "# HELP ratelimit_requests_total Requests decided by the rate limiter.")
+	fmt.Fprintln(cw, "# TYPE ratelimit_requests_total counter")
+	for _, s := range sources {
+		allowed, denied := s.allowed.Load(), s.denied.Load()
+		if s.stats != nil {
+			snapshot := s.stats.GetSnapshot()
+			allowed, denied = snapshot.AllowedRequests, snapshot.DeniedRequests
+		}
+		label := quote(s.name)
+		fmt.Fprintf(cw, "ratelimit_requests_total{decision=\"allowed\",limiter=%s} %d\n", label, allowed)
+		fmt.Fprintf(cw, "ratelimit_requests_total{decision=\"denied\",limiter=%s} %d\n", label, denied)
+	}
+
+	fmt.Fprintln(cw, "# HELP ratelimit_active_keys Per-key limiters currently held.")
+	fmt.Fprintln(cw, "# TYPE ratelimit_active_keys gauge")
+	for _, s := range sources {
+		if s.keys != nil {
+			fmt.Fprintf(cw, "ratelimit_active_keys{limiter=%s} %d\n", quote(s.name), s.keys.KeyCount())
+		}
+	}
+
+	fmt.Fprintln(cw, "# HELP ratelimit_limited_keys Per-key limiters with no requests left.")
+	fmt.Fprintln(cw, "# TYPE ratelimit_limited_keys gauge")
+	for _, s := range sources {
+		if s.keys != nil {
+			fmt.Fprintf(cw, "ratelimit_limited_keys{limiter=%s} %d\n", quote(s.name), limitedKeys(s.keys))
+		}
+	}
+
+	fmt.Fprintln(cw, "# HELP ratelimit_wait_seconds Time requests spent waiting for the limiter.")
+	fmt.Fprintln(cw, "# TYPE ratelimit_wait_seconds histogram")
+	for _, s := range sources {
+		if s.wait != nil {
+			s.wait.write(cw, "ratelimit_wait_seconds", "limiter="+quote(s.name))
+		}
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// limitedKeys counts the keys with no requests left, paging through their
+// states
+func limitedKeys(keys keyCounter) int {
+	n := 0
+	cursor := ""
+	for {
+		states, next, err := keys.SnapshotStates(keyStatesPage, cursor)
+		if err != nil {
+			return n
+		}
+		for _, state := range states {
+			if state.Remaining == 0 {
+				n++
+			}
+		}
+		if next == "" {
+			return n
+		}
+		cursor = next
+	}
+}
+
+// ServeHTTP serves the metrics for Prometheus to scrape
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	reg.WriteTo(w)
+}
+
+// quote quotes a label value, escaping as the text format requires
+func quote(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return `"` + value + `"`
+}
+
+// histogram counts durations into buckets, lock free
+type histogram struct {
+	bounds []float64
+	counts []atomic.Uint64 // per bucket, plus one for +Inf
+	sum    atomic.Int64    // nanoseconds
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.SearchFloat64s(h.bounds, d.Seconds())
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// write writes the histogram's cumulative buckets, sum and count
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, cumulative)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(time.Duration(h.sum.Load()).Seconds()))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, cumulative)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written and keeps the first error
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,333 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+	"github.com/rRateLimit/arg/sub/middleware"
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+func TestRegistryScrape(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	api := middleware.NewHTTPRateLimiter(limiter.NewRateLimiter(1, 2), &middleware.Options{
+		Mode:    middleware.ModeWait,
+		MaxWait: time.Millisecond,
+	})
+	perKey := middleware.NewPerKeyHTTPRateLimiter(func() middleware.RateLimiter { return limiter.NewRateLimiter(1, 1) }, &middleware.Options{
+		KeyFunc: middleware.KeyFuncs.ByAPIKey("X-API-Key"),
+	})
+	collector := stats.NewStats()
+	collector.RecordAllowed()
+	collector.RecordDenied()
+
+	reg := NewRegistry()
+	MustRegisterNamed(reg, "api", api)
+	MustRegisterNamed(reg, "tenants", perKey)
+	MustRegisterNamed(reg, "batch", collector)
+
+	apiHandler, perKeyHandler := api.Middleware(ok), perKey.Middleware(ok)
+	for i := 0; i < 3; i++ {
+		apiHandler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	for _, key := range []string{"alice", "alice", "bob"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", key)
+		perKeyHandler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to scrape: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Expected the text exposition format, got %q", ct)
+	}
+
+	for _, line := range []string{
+		"# TYPE ratelimit_requests_total counter",
+		`ratelimit_requests_total{decision="allowed",limiter="api"} 2`,
+		`ratelimit_requests_total{decision="denied",limiter="api"} 1`,
+		`ratelimit_requests_total{decision="allowed",limiter="tenants"} 2`,
+		`ratelimit_requests_total{decision="denied",limiter="tenants"} 1`,
+		`ratelimit_requests_total{decision="allowed",limiter="batch"} 1`,
+		`ratelimit_requests_total{decision="denied",limiter="batch"} 1`,
+		"# TYPE ratelimit_active_keys gauge",
+		`ratelimit_active_keys{limiter="tenants"} 2`,
+		"# TYPE ratelimit_limited_keys gauge",
+		`ratelimit_limited_keys{limiter="tenants"} 2`,
+		"# TYPE ratelimit_wait_seconds histogram",
+		`ratelimit_wait_seconds_bucket{limiter="api",le="+Inf"} `,
+		`ratelimit_wait_seconds_count{limiter="api"} `,
+		`ratelimit_wait_seconds_count{limiter="tenants"} 0`,
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("Expected the scrape to contain %q, got:\n%s", line, body)
+		}
+	}
+	if strings.Contains(string(body), `ratelimit_active_keys{limiter="api"}`) {
+		t.Error("Expected no key gauge for a global limiter")
+	}
+}
+
+// pagedKeys is a per-key source holding n keys, every third of them with
+// no requests left, served a page at a time
+type pagedKeys struct {
+	n     int
+	pages int
+}
+
+func (p *pagedKeys) AddObserver(middleware.Observer) {}
+
+func (p *pagedKeys) KeyCount() int { return p.n }
+
+func (p *pagedKeys) SnapshotStates(limit int, cursor string) ([]middleware.KeyState, string, error) {
+	p.pages++
+	start := 0
+	if cursor != "" {
+		start, _ = strconv.Atoi(cursor)
+	}
+	var states []middleware.KeyState
+	for i := start; i < p.n && len(states) < limit; i++ {
+		states = append(states, middleware.KeyState{Key: strconv.Itoa(i), Remaining: i % 3})
+	}
+	if end := start + len(states); end < p.n {
+		return states, strconv.Itoa(end), nil
+	}
+	return states, "", nil
+}
+
+func TestLimitedKeysAcrossPages(t *testing.T) {
+	keys := &pagedKeys{n: 2*keyStatesPage + 1}
+	reg := NewRegistry()
+	MustRegisterNamed(reg, "tenants", keys)
+
+	var b strings.Builder
+	reg.WriteTo(&b)
+	want := fmt.Sprintf(`ratelimit_limited_keys{limiter="tenants"} %d`, (keys.n+2)/3)
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("Expected the scrape to contain %q, got:\n%s", want, b.String())
+	}
+	if keys.pages != 3 {
+		t.Errorf("Expected the states to be read in 3 pages, got %d", keys.pages)
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.RegisterNamed("api", stats.NewStats()); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if err := reg.RegisterNamed("api", stats.NewStats()); err == nil {
+		t.Error("Expected a duplicate name to be rejected")
+	}
+	if err := reg.RegisterNamed("other", 42); err == nil {
+		t.Error("Expected an unsupported source to be rejected")
+	}
+}
+
+// observerCounter counts the observers added to it
+type observerCounter struct {
+	added int
+}
+
+func (o *observerCounter) AddObserver(middleware.Observer) {
+	o.added++
+}
+
+func TestRegisterLabelsByName(t *testing.T) {
+	l := limiter.NewNamedRateLimiter("search-api", 1, 1)
+	api := middleware.NewHTTPRateLimiter(l, nil)
+	collector := stats.NewStats()
+	collector.Name = "batch"
+
+	reg := NewRegistry()
+	MustRegister(reg, api)
+	MustRegister(reg, collector)
+	api.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var b strings.Builder
+	reg.WriteTo(&b)
+	for _, line := range []string{
+		fmt.Sprintf(`ratelimit_requests_total{decision="allowed",limiter=%q} 1`, l.Name()),
+		`ratelimit_requests_total{decision="allowed",limiter="batch"} 0`,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("Expected the scrape to contain %q, got:\n%s", line, b.String())
+		}
+	}
+
+	perKey := middleware.NewPerKeyHTTPRateLimiter(func() middleware.RateLimiter { return limiter.NewRateLimiter(1, 1) }, nil)
+	if err := reg.Register(perKey); err == nil {
+		t.Error("Expected a source without a name to be rejected")
+	}
+	if err := reg.Register(middleware.NewHTTPRateLimiter(limiter.NewNamedRateLimiter("search-api", 1, 1), nil)); err == nil {
+		t.Error("Expected a second source of the same name to be rejected")
+	}
+}
+
+func TestLimiterNameEndToEnd(t *testing.T) {
+	named := limiter.NewNamedRateLimiter("search-api", 1, 1)
+	wrapped := stats.NewRateLimiterWithStats(named)
+
+	var handlerName string
+	rl := middleware.NewHTTPRateLimiter(wrapped, &middleware.Options{
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request) {
+			if info, ok := middleware.InfoFromContext(r.Context()); ok {
+				handlerName = info.LimiterName
+			}
+			middleware.DefaultErrorHandler(w, r)
+		},
+	})
+	reg := NewRegistry()
+	MustRegister(reg, rl)
+
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	if handlerName != named.Name() {
+		t.Errorf("Expected the error handler to see %q, got %q", named.Name(), handlerName)
+	}
+	if snapshot := wrapped.GetStats().GetSnapshot(); snapshot.Name != named.Name() || snapshot.DeniedRequests != 1 {
+		t.Errorf("Expected stats for %q with 1 denial, got %+v", named.Name(), snapshot)
+	}
+	var b strings.Builder
+	reg.WriteTo(&b)
+	if line := fmt.Sprintf(`ratelimit_requests_total{decision="denied",limiter=%q} 1`, named.Name()); !strings.Contains(b.String(), line) {
+		t.Errorf("Expected the scrape to contain %q, got:\n%s", line, b.String())
+	}
+}
+
+func TestRegisterRefusedSourceIsNotObserved(t *testing.T) {
+	reg := NewRegistry()
+	MustRegisterNamed(reg, "api", &observerCounter{})
+	other := &observerCounter{}
+	for i := 0; i < 3; i++ {
+		if err := reg.RegisterNamed("api", other); err == nil {
+			t.Fatal("Expected a duplicate name to be rejected")
+		}
+	}
+	if other.added != 0 {
+		t.Errorf("Expected no observer added by refused registrations, got %d", other.added)
+	}
+	if err := reg.RegisterNamed("other", other); err != nil || other.added != 1 {
+		t.Errorf("Expected one observer once registered, got %d (%v)", other.added, err)
+	}
+}
+
+func TestQuoteEscapes(t *testing.T) {
+	if got := quote("a\"b\\c\nd"); got != `"a\"b\\c\nd"` {
+		t.Errorf("Unexpected escaping %s", got)
+	}
+}
+
+func TestScrapeEscapesLabelValues(t *testing.T) {
+	names := []string{`back\slash`, `"quoted"`, "new\nline", `\"` + "\n\\n"}
+	reg := NewRegistry()
+	for _, name := range names {
+		perKey := middleware.NewPerKeyHTTPRateLimiter(func() middleware.RateLimiter { return limiter.NewRateLimiter(1, 1) }, nil)
+		MustRegisterNamed(reg, name, perKey)
+	}
+	var b strings.Builder
+	if _, err := reg.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	// Every sample is on one line, and its limiter label unescapes to a
+	// registered name
+	seen := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		labels, err := parseLabels(line)
+		if err != nil {
+			t.Fatalf("Malformed sample %q: %v", line, err)
+		}
+		seen[labels["limiter"]]++
+	}
+	for _, name := range names {
+		// Two request counters, two key gauges and the wait histogram
+		if want := 2 + 2 + len(DefaultWaitBuckets) + 3; seen[name] != want {
+			t.Errorf("Expected %d samples for %q, got %d", want, name, seen[name])
+		}
+	}
+	if len(seen) != len(names) {
+		t.Errorf("Expected only the registered names as labels, got %q", seen)
+	}
+}
+
+func TestRegisterRejectsInvalidUTF8(t *testing.T) {
+	if err := NewRegistry().RegisterNamed("bad\xff", stats.NewStats()); err == nil {
+		t.Error("Expected a name that is not UTF-8 to be rejected")
+	}
+}
+
+// parseLabels parses the labels of a sample line of the text format,
+// undoing the escapes label values may hold: \\, \" and \n
+func parseLabels(line string) (map[string]string, error) {
+	open := strings.IndexByte(line, '{')
+	if open < 0 {
+		return nil, errors.New("no labels")
+	}
+	labels := make(map[string]string)
+	i := open + 1
+	for {
+		eq := strings.IndexByte(line[i:], '=')
+		if eq < 0 || i+eq+1 >= len(line) || line[i+eq+1] != '"' {
+			return nil, errors.New("expected name=\"value\"")
+		}
+		name := line[i : i+eq]
+		i += eq + 2
+
+		var value strings.Builder
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] != '\\' {
+				value.WriteByte(line[i])
+				continue
+			}
+			if i++; i == len(line) {
+				return nil, errors.New("unterminated escape")
+			}
+			switch line[i] {
+			case '\\', '"':
+				value.WriteByte(line[i])
+			case 'n':
+				value.WriteByte('\n')
+			default:
+				return nil, fmt.Errorf("invalid escape \\%c", line[i])
+			}
+		}
+		if i == len(line) {
+			return nil, errors.New("unterminated value")
+		}
+		labels[name] = value.String()
+
+		switch i++; {
+		case strings.HasPrefix(line[i:], ","):
+			i++
+		case strings.HasPrefix(line[i:], "} "):
+			if _, err := strconv.ParseFloat(line[i+2:], 64); err != nil {
+				return nil, fmt.Errorf("invalid value: %w", err)
+			}
+			return labels, nil
+		default:
+			return nil, errors.New("expected , or }")
+		}
+	}
+}
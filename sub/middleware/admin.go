@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+// DefaultAdminPageSize is how many keys a page of the admin key listing
+// holds when the request does not say
+const DefaultAdminPageSize = 100
+
+// AdminKeysPage is one page of the admin key listing. Pass Next as the
+// cursor parameter to get the following page.
+type AdminKeysPage struct {
+	Keys []KeyState `json:"keys"`
+	Next string     `json:"next,omitempty"`
+}
+
+// AdminStats is the aggregate view served by the admin handler
+type AdminStats struct {
+	Keys         int              `json:"keys"`
+	Evictions    int64            `json:"evictions"`
+	Draining     bool             `json:"draining"`
+	Enabled      bool             `json:"enabled"`
+	TopConsumers []stats.KeyCount `json:"top_consumers,omitempty"`
+}
+
+// AdminHandler returns a JSON API for operators to see and reset the
+// per-key limiters while the server runs:
+//
+//	GET    /keys?limit=N&cursor=C&limited=true  a page of key states, only
+//	                                            keys with no requests left
+//	                                            if limited is set
+//	GET    /keys/{key}                          one key's state
+//	DELETE /keys/{key}                          reset a key, giving it a
+//	                                            new limiter on its next request
+//	GET    /stats                               aggregate counts
+//	PUT    /enabled                             turn limiting off or on
+//	                                            with {"enabled": false}
+//
+// {key} is the key the limiter is held under, as listed by GET /keys; with
+// key hashing or Options.MethodFactories it differs from the client key,
+// so look it up with LimiterKey.
+//
+// Paths are relative, so mount it under a prefix with http.StripPrefix,
+// such as http.StripPrefix("/admin", rl.AdminHandler()). It reads keys the
+// same way eviction does, under the per-shard locks, so it is safe to use
+// while the middleware serves requests. It does no authentication of its
+// own; wrap it in whatever protects the rest of the admin surface.
+func (rl *PerKeyHTTPRateLimiter) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys", rl.adminListKeys)
+	mux.HandleFunc("GET /keys/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		state, ok := rl.limiters.State(r.PathValue("key"))
+		if !ok {
+			http.Error(w, "unknown key", http.StatusNotFound)
+			return
+		}
+		writeAdminJSON(w, state)
+	})
+	mux.HandleFunc("DELETE /keys/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		if !rl.limiters.Delete(r.PathValue("key")) {
+			http.Error(w, "unknown key", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, AdminStats{
+			Keys:         rl.KeyCount(),
+			Evictions:    rl.Evictions(),
+			Draining:     rl.IsDraining(),
+			Enabled:      rl.Enabled(),
+			TopConsumers: rl.TopConsumers(10),
+		})
+	})
+	mux.HandleFunc("PUT /enabled", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+			http.Error(w, `body must be {"enabled": true} or {"enabled": false}`, http.StatusBadRequest)
+			return
+		}
+		rl.SetEnabled(*body.Enabled)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// adminListKeys serves a page of the key listing. Filtering for limited
+// keys happens within the page, so a filtered page may hold fewer keys
+// than asked for while still having a next cursor.
+func (rl *PerKeyHTTPRateLimiter) adminListKeys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := DefaultAdminPageSize
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	states, next, err := rl.SnapshotStates(limit, query.Get("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Get("limited") == "true" {
+		limited := states[:0]
+		for _, state := range states {
+			if state.Remaining == 0 {
+				limited = append(limited, state)
+			}
+		}
+		states = limited
+	}
+	if states == nil {
+		states = []KeyState{}
+	}
+	writeAdminJSON(w, AdminKeysPage{Keys: states, Next: next})
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
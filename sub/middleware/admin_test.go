@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+func TestAdminHandler(t *testing.T) {
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return limiter.NewRateLimiter(1, 3) }, &Options{
+		KeyFunc:      KeyFuncs.ByAPIKey("X-API-Key"),
+		TopConsumers: 5,
+	})
+	api := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	mux := http.NewServeMux()
+	mux.Handle("/", api)
+	mux.Handle("/ops/ratelimit/", http.StripPrefix("/ops/ratelimit", rl.AdminHandler()))
+
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder, v any) {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("Failed to decode %s: %v", rec.Body.String(), err)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		do("GET", "/api", "alice")
+	}
+	do("GET", "/api", "bob")
+
+	var page AdminKeysPage
+	decode(do("GET", "/ops/ratelimit/keys", ""), &page)
+	states := make(map[string]KeyState)
+	for _, state := range page.Keys {
+		states[state.Key] = state
+	}
+	if len(states) != 2 || states["alice"].Remaining != 0 || states["bob"].Remaining != 2 {
+		t.Errorf("Expected alice exhausted and bob with 2 left, got %+v", page.Keys)
+	}
+	if states["alice"].LastAccess.IsZero() {
+		t.Error("Expected keys to report when they were last seen")
+	}
+
+	var limited AdminKeysPage
+	decode(do("GET", "/ops/ratelimit/keys?limited=true", ""), &limited)
+	if len(limited.Keys) != 1 || limited.Keys[0].Key != "alice" {
+		t.Errorf("Expected only alice to be limited, got %+v", limited.Keys)
+	}
+
+	var first, second AdminKeysPage
+	decode(do("GET", "/ops/ratelimit/keys?limit=1", ""), &first)
+	decode(do("GET", "/ops/ratelimit/keys?limit=1&cursor="+first.Next, ""), &second)
+	if len(first.Keys) != 1 || len(second.Keys) != 1 || first.Keys[0].Key == second.Keys[0].Key {
+		t.Errorf("Expected two pages of one key each, got %+v and %+v", first, second)
+	}
+
+	var state KeyState
+	decode(do("GET", "/ops/ratelimit/keys/bob", ""), &state)
+	if state.Key != "bob" || state.Remaining != 2 {
+		t.Errorf("Expected bob's state, got %+v", state)
+	}
+
+	var aggregate AdminStats
+	decode(do("GET", "/ops/ratelimit/stats", ""), &aggregate)
+	if aggregate.Keys != 2 || len(aggregate.TopConsumers) != 2 || aggregate.TopConsumers[0].Key != "alice" {
+		t.Errorf("Unexpected stats %+v", aggregate)
+	}
+
+	// Resetting alice gives back a full burst at once
+	if rec := do("DELETE", "/ops/ratelimit/keys/alice", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the reset to succeed, got %d", rec.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if rec := do("GET", "/api", "alice"); rec.Code != http.StatusOK {
+			t.Errorf("Expected request %d after the reset to be allowed, got %d", i, rec.Code)
+		}
+	}
+	if rec := do("GET", "/api", "alice"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the new burst to run out, got %d", rec.Code)
+	}
+
+	for _, tt := range []struct {
+		method, path string
+		code         int
+	}{
+		{"GET", "/ops/ratelimit/keys/carol", http.StatusNotFound},
+		{"DELETE", "/ops/ratelimit/keys/carol", http.StatusNotFound},
+		{"GET", "/ops/ratelimit/keys?cursor=bogus", http.StatusBadRequest},
+		{"GET", "/ops/ratelimit/keys?limit=-1", http.StatusBadRequest},
+		{"POST", "/ops/ratelimit/stats", http.StatusMethodNotAllowed},
+	} {
+		if rec := do(tt.method, tt.path, ""); rec.Code != tt.code {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.code, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotBoostable is returned by BoostKey when the key's limiter cannot be scaled
+var ErrNotBoostable = errors.New("rate limiter does not support boosting")
+
+// Boostable is implemented by rate limiters whose rate and burst can be scaled
+// at runtime. SetBoost(1) must restore the original limits.
+type Boostable interface {
+	SetBoost(factor float64)
+}
+
+// Boost describes a temporary rate multiplier granted to a key
+type Boost struct {
+	Key       string
+	Factor    float64
+	ExpiresAt time.Time
+}
+
+// activeBoost is a boost and the limiter it was applied to. It is never
+// modified once stored, so requests can check its expiry without a lock.
+type activeBoost struct {
+	Boost
+	limiter RateLimiter
+}
+
+// BoostKey multiplies the rate and burst of the key's limiter by factor for
+// the given duration, creating the limiter if the key has not been seen yet.
+// Boosts do not stack: boosting a key that already has an active boost
+// replaces it, so the latest factor and expiry win. key is the key the
+// limiter is held under, which is the client key unless key hashing is on
+// or Options.MethodFactories is set; LimiterKey returns it for a client
+// key. For middleware built by NewTieredHTTPRateLimiter, pass the key made
+// by TierKey to LimiterKey.
+//
+// Expired boosts are reverted lazily, the next time the key is used or the
+// boosts are listed. A boost ends early if its key is evicted, and does not
+// carry over to the fresh limiter the key gets when it returns.
+func (rl *PerKeyHTTPRateLimiter) BoostKey(key string, factor float64, duration time.Duration) error {
+	if factor <= 0 {
+		return errors.New("boost factor must be positive")
+	}
+	if duration <= 0 {
+		return errors.New("boost duration must be positive")
+	}
+
+	l, err := rl.getLimiter(key)
+	if err != nil {
+		return err
+	}
+	limiter, ok := l.(Boostable)
+	if !ok {
+		return ErrNotBoostable
+	}
+
+	rl.boostMu.Lock()
+	defer rl.boostMu.Unlock()
+
+	limiter.SetBoost(factor)
+	boost := &activeBoost{Boost: Boost{Key: key, Factor: factor, ExpiresAt: rl.now().Add(duration)}, limiter: l}
+	if _, replaced := rl.boosts.Swap(key, boost); !replaced {
+		rl.boosted.Add(1)
+	}
+	return nil
+}
+
+// CancelBoost reverts the key's boost immediately. It reports whether the key
+// had an active boost.
+func (rl *PerKeyHTTPRateLimiter) CancelBoost(key string) bool {
+	rl.boostMu.Lock()
+	defer rl.boostMu.Unlock()
+
+	boost, ok := rl.boosts.Load(key)
+	if !ok {
+		return false
+	}
+	rl.revertBoost(boost.(*activeBoost))
+	return true
+}
+
+// Boosts returns the active boosts ordered by key
+func (rl *PerKeyHTTPRateLimiter) Boosts() []Boost {
+	rl.boostMu.Lock()
+	defer rl.boostMu.Unlock()
+
+	now := rl.now()
+	var boosts []Boost
+	rl.boosts.Range(func(_, v any) bool {
+		boost := v.(*activeBoost)
+		if !now.Before(boost.ExpiresAt) {
+			rl.revertBoost(boost)
+			return true
+		}
+		boosts = append(boosts, boost.Boost)
+		return true
+	})
+
+	sort.Slice(boosts, func(i, j int) bool {
+		return boosts[i].Key < boosts[j].Key
+	})
+	return boosts
+}
+
+// expireBoost reverts the key's boost if it has run out, and forgets it if
+// it was applied to another limiter than l, the key's current one. Only a
+// boost that has to go takes boostMu.
+func (rl *PerKeyHTTPRateLimiter) expireBoost(key string, l RateLimiter) {
+	if rl.boosted.Load() == 0 {
+		return
+	}
+	v, ok := rl.boosts.Load(key)
+	if !ok {
+		return
+	}
+	boost := v.(*activeBoost)
+	stale := boost.limiter != l
+	if !stale && rl.now().Before(boost.ExpiresAt) {
+		return
+	}
+
+	rl.boostMu.Lock()
+	defer rl.boostMu.Unlock()
+	if stale {
+		rl.dropBoost(boost)
+	} else {
+		rl.revertBoost(boost)
+	}
+}
+
+// evictBoost forgets the boost of an evicted key. It is the evict hook of
+// the middleware's limiters.
+func (rl *PerKeyHTTPRateLimiter) evictBoost(key string, l RateLimiter) {
+	if rl.boosted.Load() == 0 {
+		return
+	}
+	if v, ok := rl.boosts.Load(key); ok && v.(*activeBoost).limiter == l {
+		rl.boostMu.Lock()
+		defer rl.boostMu.Unlock()
+		rl.dropBoost(v.(*activeBoost))
+	}
+}
+
+// revertBoost restores the original limits of the boosted limiter and
+// forgets the boost. Must hold boostMu.
+func (rl *PerKeyHTTPRateLimiter) revertBoost(boost *activeBoost) {
+	if rl.dropBoost(boost) {
+		boost.limiter.(Boostable).SetBoost(1)
+	}
+}
+
+// dropBoost forgets the boost unless it has been replaced, and reports
+// whether it did. Must hold boostMu.
+func (rl *PerKeyHTTPRateLimiter) dropBoost(boost *activeBoost) bool {
+	if !rl.boosts.CompareAndDelete(boost.Key, boost) {
+		return false
+	}
+	rl.boosted.Add(-1)
+	return true
+}
+
+// TierKey returns the key NewTieredHTTPRateLimiter limits a client key by
+// in tier, for use with BoostKey, CancelBoost and other methods taking a
+// key
+func TierKey(tier, key string) string {
+	var b strings.Builder
+	writeEscaped(&b, tier, CombinationSeparator)
+	b.WriteString(CombinationSeparator)
+	writeEscaped(&b, key, CombinationSeparator)
+	return b.String()
+}
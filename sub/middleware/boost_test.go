@@ -0,0 +1,236 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+// boostableMockRateLimiter is a token bucket without refill that scales its
+// tokens proportionally when boosted
+type boostableMockRateLimiter struct {
+	mu     sync.Mutex
+	burst  float64
+	tokens float64
+	factor float64
+}
+
+func newBoostableMock(burst int) *boostableMockRateLimiter {
+	return &boostableMockRateLimiter{burst: float64(burst), tokens: float64(burst), factor: 1}
+}
+
+func (m *boostableMockRateLimiter) Allow() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tokens >= 1 {
+		m.tokens--
+		return true
+	}
+	return false
+}
+
+func (m *boostableMockRateLimiter) SetBoost(factor float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens = m.tokens * factor / m.factor
+	m.factor = factor
+}
+
+func (m *boostableMockRateLimiter) state() (factor, tokens float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.factor, m.tokens
+}
+
+// fakeClock is a manually advanced clock for tests
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestBoostKey(t *testing.T) {
+	clock := newFakeClock()
+	mocks := make(map[string]*boostableMockRateLimiter)
+	var created string
+	factory := func() RateLimiter {
+		mock := newBoostableMock(10)
+		mocks[created] = mock
+		return mock
+	}
+
+	rl := NewPerKeyHTTPRateLimiter(factory, &Options{KeyFunc: KeyFuncs.ByUserID("X-User-ID")})
+	rl.now = clock.Now
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(user string) int {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-User-ID", user)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	created = "alice"
+	send("alice")
+	mock := mocks["alice"]
+
+	// Use half of the remaining tokens before boosting
+	for i := 0; i < 4; i++ {
+		send("alice")
+	}
+
+	if err := rl.BoostKey("alice", 2, time.Minute); err != nil {
+		t.Fatalf("BoostKey failed: %v", err)
+	}
+	if factor, tokens := mock.state(); factor != 2 || tokens != 10 {
+		t.Errorf("Expected factor 2 with 10 tokens after boost, got %v with %v", factor, tokens)
+	}
+
+	boosts := rl.Boosts()
+	if len(boosts) != 1 || boosts[0].Key != "alice" || boosts[0].Factor != 2 {
+		t.Fatalf("Expected one boost for alice, got %+v", boosts)
+	}
+
+	send("alice")
+	clock.Advance(30 * time.Second)
+	send("alice")
+	if factor, _ := mock.state(); factor != 2 {
+		t.Errorf("Expected boost to still apply after 30s, got factor %v", factor)
+	}
+
+	// After expiry the next request reverts the boost, keeping the bucket
+	// just as full proportionally: 8 of 20 becomes 4 of 10
+	clock.Advance(31 * time.Second)
+	send("alice")
+	if factor, tokens := mock.state(); factor != 1 || tokens != 3 {
+		t.Errorf("Expected factor 1 with 3 tokens after expiry, got %v with %v", factor, tokens)
+	}
+	if boosts := rl.Boosts(); len(boosts) != 0 {
+		t.Errorf("Expected no active boosts after expiry, got %+v", boosts)
+	}
+}
+
+func TestBoostKeyLatestWins(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return newBoostableMock(10) }, nil)
+	rl.now = clock.Now
+
+	if err := rl.BoostKey("bob", 2, time.Minute); err != nil {
+		t.Fatalf("BoostKey failed: %v", err)
+	}
+	if err := rl.BoostKey("bob", 3, time.Hour); err != nil {
+		t.Fatalf("BoostKey failed: %v", err)
+	}
+
+	boosts := rl.Boosts()
+	if len(boosts) != 1 || boosts[0].Factor != 3 || !boosts[0].ExpiresAt.Equal(clock.Now().Add(time.Hour)) {
+		t.Errorf("Expected latest boost to replace the first, got %+v", boosts)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if boosts := rl.Boosts(); len(boosts) != 1 {
+		t.Errorf("Expected replaced boost to outlive the original expiry, got %+v", boosts)
+	}
+}
+
+func TestCancelBoost(t *testing.T) {
+	mock := newBoostableMock(10)
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return mock }, nil)
+
+	if rl.CancelBoost("carol") {
+		t.Error("Expected CancelBoost to report no boost for unknown key")
+	}
+	if err := rl.BoostKey("carol", 4, time.Hour); err != nil {
+		t.Fatalf("BoostKey failed: %v", err)
+	}
+	if !rl.CancelBoost("carol") {
+		t.Error("Expected CancelBoost to report an active boost")
+	}
+	if factor, tokens := mock.state(); factor != 1 || tokens != 10 {
+		t.Errorf("Expected limits restored after cancel, got factor %v with %v tokens", factor, tokens)
+	}
+	if boosts := rl.Boosts(); len(boosts) != 0 {
+		t.Errorf("Expected no boosts after cancel, got %+v", boosts)
+	}
+}
+
+func TestBoostEndsOnEviction(t *testing.T) {
+	clock := newFakeClock()
+	var mocks []*boostableMockRateLimiter
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter {
+		mock := newBoostableMock(10)
+		mocks = append(mocks, mock)
+		return mock
+	}, nil)
+	rl.now = clock.Now
+
+	if err := rl.BoostKey("alice", 2, time.Minute); err != nil {
+		t.Fatalf("BoostKey failed: %v", err)
+	}
+	rl.limiters.Delete("alice")
+	if boosts := rl.Boosts(); len(boosts) != 0 {
+		t.Errorf("Expected the boost to end with its key, got %+v", boosts)
+	}
+
+	// The key's fresh limiter is neither boosted nor reverted
+	l, _ := rl.getLimiter("alice")
+	clock.Advance(2 * time.Minute)
+	rl.expireBoost("alice", l)
+	if factor, tokens := mocks[1].state(); factor != 1 || tokens != 10 {
+		t.Errorf("Expected the fresh limiter untouched, got factor %v with %v tokens", factor, tokens)
+	}
+	if rl.boosted.Load() != 0 {
+		t.Errorf("Expected no boosts counted, got %d", rl.boosted.Load())
+	}
+}
+
+func TestBoostKeyFromConfig(t *testing.T) {
+	cfg := &config.Config{Rate: 1, Burst: 2, Enabled: true}
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter {
+		l, _ := limiter.NewFromConfig(cfg)
+		return l
+	}, nil)
+
+	if err := rl.BoostKey("dave", 2, time.Minute); err != nil {
+		t.Fatalf("Expected limiters built from a config to be boostable, got %v", err)
+	}
+	l, _ := rl.getLimiter("dave")
+	if !limiter.AllowN(l, 4) {
+		t.Error("Expected the boost to double the burst")
+	}
+}
+
+func TestBoostKeyErrors(t *testing.T) {
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return &mockRateLimiter{} }, nil)
+
+	if err := rl.BoostKey("dave", 2, time.Minute); err != ErrNotBoostable {
+		t.Errorf("Expected ErrNotBoostable, got %v", err)
+	}
+	if err := rl.BoostKey("dave", 0, time.Minute); err == nil {
+		t.Error("Expected error for non-positive factor")
+	}
+	if err := rl.BoostKey("dave", 2, 0); err == nil {
+		t.Error("Expected error for non-positive duration")
+	}
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// NewTrustedKeyFunc returns a KeyFunc keying requests by client IP that,
+// unlike DefaultKeyFunc, cannot be fooled by clients sending their own
+// X-Forwarded-For. Forwarding headers are only consulted when the peer,
+// RemoteAddr, is within one of trustedCIDRs, such as "10.0.0.0/8" or a
+// single load balancer address; the client is then the right-most
+// X-Forwarded-For hop that is not itself trusted, since hops to its left
+// could have been written by anyone. X-Real-IP is used only if a trusted
+// peer sends no X-Forwarded-For.
+//
+// Keys are normalized addresses without ports: "[::1]:5432" and "::1" are
+// the same key, as are "::ffff:10.0.0.1" and "10.0.0.1". Set it as
+// Options.ClientIP as well as KeyFunc so that Exclusions judge a request
+// by the same client it is limited as.
+func NewTrustedKeyFunc(trustedCIDRs []string) (KeyFunc, error) {
+	trusted := make([]netip.Prefix, 0, len(trustedCIDRs))
+	for _, cidr := range trustedCIDRs {
+		var prefix netip.Prefix
+		var err error
+		if strings.Contains(cidr, "/") {
+			prefix, err = netip.ParsePrefix(cidr)
+		} else {
+			var addr netip.Addr
+			addr, err = netip.ParseAddr(cidr)
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		trusted = append(trusted, prefix.Masked())
+	}
+	isTrusted := func(ip netip.Addr) bool {
+		for _, prefix := range trusted {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) string {
+		peer, ok := parseIP(r.RemoteAddr)
+		if !ok {
+			return r.RemoteAddr
+		}
+		if !isTrusted(peer) {
+			return peer.String()
+		}
+
+		hops := forwardedHops(r)
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip, ok := parseIP(hops[i])
+			if !ok {
+				// Garbage comes from the client, so key by the trusted
+				// proxy that forwarded it rather than by what it wrote
+				break
+			}
+			if !isTrusted(ip) {
+				return ip.String()
+			}
+			peer = ip
+		}
+		if len(hops) == 0 {
+			if ip, ok := parseIP(r.Header.Get("X-Real-IP")); ok {
+				return ip.String()
+			}
+		}
+		return peer.String()
+	}, nil
+}
+
+// forwardedHops returns the addresses of every X-Forwarded-For header of
+// r, in order
+func forwardedHops(r *http.Request) []string {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// parseIP parses an address with or without a port, normalizing IPv4
+// addresses embedded in IPv6 to IPv4 and dropping IPv6 zones
+func parseIP(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap().WithZone(""), true
+}
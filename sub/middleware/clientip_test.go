@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+func TestTrustedKeyFunc(t *testing.T) {
+	keyFunc, err := NewTrustedKeyFunc([]string{"10.0.0.0/8", "192.0.2.1", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("NewTrustedKeyFunc failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "203.0.113.5:4321", nil, "", "203.0.113.5"},
+		{"spoofed header from untrusted peer", "203.0.113.5:4321", []string{"1.2.3.4"}, "", "203.0.113.5"},
+		{"spoofed real IP from untrusted peer", "203.0.113.5:4321", nil, "1.2.3.4", "203.0.113.5"},
+		{"one trusted proxy", "10.0.0.1:80", []string{"203.0.113.5"}, "", "203.0.113.5"},
+		{"client prepends a spoofed hop", "10.0.0.1:80", []string{"1.2.3.4, 203.0.113.5"}, "", "203.0.113.5"},
+		{"multi-hop through trusted proxies", "192.0.2.1:80", []string{"1.2.3.4, 203.0.113.5, 10.1.1.1"}, "", "203.0.113.5"},
+		{"hops split across headers", "10.0.0.1:80", []string{"1.2.3.4", "203.0.113.5, 10.2.2.2"}, "", "203.0.113.5"},
+		{"every hop trusted", "10.0.0.1:80", []string{"10.9.9.9, 10.1.1.1"}, "", "10.9.9.9"},
+		{"garbage hop", "10.0.0.1:80", []string{"203.0.113.5, bogus, 10.1.1.1"}, "", "10.1.1.1"},
+		{"real IP from trusted peer", "10.0.0.1:80", nil, "203.0.113.5", "203.0.113.5"},
+		{"trusted peer without headers", "10.0.0.1:80", nil, "", "10.0.0.1"},
+		{"hop with port", "10.0.0.1:80", []string{"203.0.113.5:5555"}, "", "203.0.113.5"},
+		{"IPv6 peer with port", "[2001:db8::1]:5432", nil, "", "2001:db8::1"},
+		{"IPv6 loopback", "[::1]:5432", nil, "", "::1"},
+		{"IPv4-mapped IPv6", "[::ffff:203.0.113.5]:80", nil, "", "203.0.113.5"},
+		{"IPv6 zone", "[fe80::1%eth0]:80", nil, "", "fe80::1"},
+		{"trusted IPv6 proxy", "[fd00::1]:80", []string{"[2001:db8::2]:443"}, "", "2001:db8::2"},
+		{"unparseable peer", "pipe", nil, "", "pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, header := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", header)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := keyFunc(req); got != tt.want {
+				t.Errorf("Expected key %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := NewTrustedKeyFunc([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected an invalid CIDR to be rejected")
+	}
+}
+
+func TestTrustedClientIPExclusions(t *testing.T) {
+	keyFunc, err := NewTrustedKeyFunc([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("NewTrustedKeyFunc failed: %v", err)
+	}
+	exclusions, err := config.CompileExclusions(nil, []string{"192.168.0.0/16"})
+	if err != nil {
+		t.Fatalf("CompileExclusions failed: %v", err)
+	}
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return &mockRateLimiter{allowReturn: false} }, &Options{
+		KeyFunc:    keyFunc,
+		ClientIP:   keyFunc,
+		Exclusions: exclusions,
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name, remoteAddr, forwarded string
+		want                        int
+	}{
+		{"excluded client through a trusted proxy", "10.0.0.1:80", "192.168.1.5", http.StatusOK},
+		{"spoofed header from an untrusted peer", "203.0.113.5:4321", "192.168.1.5", http.StatusTooManyRequests},
+		{"spoofed hop left of the real client", "10.0.0.1:80", "192.168.1.5, 203.0.113.5", http.StatusTooManyRequests},
+		{"excluded direct client", "192.168.7.7:4321", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
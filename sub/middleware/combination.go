@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CombinationSeparator separates the keys KeyFuncs.Combination joins
+const CombinationSeparator = "|"
+
+// combineKeys returns a KeyFunc that joins the keys of funcs with sep. A
+// backslash or the first byte of sep inside a key is escaped with a
+// backslash, so different combinations of keys never join to the same
+// string.
+func combineKeys(sep string, funcs []KeyFunc) KeyFunc {
+	if sep == "" || strings.Contains(sep, `\`) {
+		panic("middleware: combination separator must be non-empty and contain no backslash")
+	}
+	return func(r *http.Request) string {
+		var buf [4]string
+		keys := buf[:0]
+		size := 0
+		for i, fn := range funcs {
+			key := fn(r)
+			keys = append(keys, key)
+			if i > 0 {
+				size += len(sep)
+			}
+			size += len(key)
+		}
+
+		var b strings.Builder
+		b.Grow(size)
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteString(sep)
+			}
+			writeEscaped(&b, key, sep)
+		}
+		return b.String()
+	}
+}
+
+// writeEscaped writes key to b with a backslash before every backslash and
+// every byte that starts sep. Escaping the first byte rather than sep
+// itself keeps the join unambiguous when sep can overlap with the end of a
+// key, as "::" does with "a:".
+func writeEscaped(b *strings.Builder, key, sep string) {
+	start := 0
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c == '\\' || c == sep[0] {
+			b.WriteString(key[start:i])
+			b.WriteByte('\\')
+			start = i
+		}
+	}
+	b.WriteString(key[start:])
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// constKey returns a KeyFunc that always returns key
+func constKey(key string) KeyFunc {
+	return func(*http.Request) string { return key }
+}
+
+func TestCombination(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/data", nil)
+	req.Header.Set("X-User-ID", "user456")
+	if got := KeyFuncs.Combination(KeyFuncs.ByPath, KeyFuncs.ByUserID("X-User-ID"))(req); got != "/api/data|user456" {
+		t.Errorf("Expected /api/data|user456, got %q", got)
+	}
+
+	tests := []struct {
+		sep  string
+		keys []string
+		want string
+	}{
+		{"|", nil, ""},
+		{"|", []string{"a"}, "a"},
+		{"|", []string{"a|b", "c"}, `a\|b|c`},
+		{"|", []string{`a\`, "b"}, `a\\|b`},
+		{"::", []string{"a::b:", ":c"}, `a\:\:b\:::\:c`},
+		{"\x1f", []string{"a b", "[c]"}, "a b\x1f[c]"},
+	}
+	for _, tt := range tests {
+		var funcs []KeyFunc
+		for _, key := range tt.keys {
+			funcs = append(funcs, constKey(key))
+		}
+		if got := KeyFuncs.CombinationSep(tt.sep, funcs...)(req); got != tt.want {
+			t.Errorf("%q joined with %q: expected %q, got %q", tt.keys, tt.sep, tt.want, got)
+		}
+	}
+}
+
+func TestCombinationCollisions(t *testing.T) {
+	// Each pair collides when formatted with %v or joined without escaping
+	pairs := [][2][]string{
+		{{"a b", "c"}, {"a", "b c"}},
+		{{"a|b", "c"}, {"a", "b|c"}},
+		{{`a\`, "b"}, {`a\|b`}},
+		{{`a\|`, "b"}, {"a", `|b`}},
+	}
+	for _, pair := range pairs {
+		checkCombinationsDiffer(t, KeyFuncs.Combination, pair)
+	}
+	// A separator that can overlap with the end of a key
+	checkCombinationsDiffer(t, func(funcs ...KeyFunc) KeyFunc {
+		return KeyFuncs.CombinationSep("::", funcs...)
+	}, [2][]string{{"a:", "b"}, {"a", ":b"}})
+
+	if old := fmt.Sprintf("%v", []string{"a b", "c"}); old != fmt.Sprintf("%v", []string{"a", "b c"}) {
+		t.Errorf("Expected the old format to be ambiguous, got %q", old)
+	}
+}
+
+// checkCombinationsDiffer checks that combine joins the two lists of keys to
+// different strings
+func checkCombinationsDiffer(t *testing.T, combine func(...KeyFunc) KeyFunc, pair [2][]string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/", nil)
+	var keys [2]string
+	for i, parts := range pair {
+		var funcs []KeyFunc
+		for _, part := range parts {
+			funcs = append(funcs, constKey(part))
+		}
+		keys[i] = combine(funcs...)(req)
+	}
+	if keys[0] == keys[1] {
+		t.Errorf("%q and %q both combine to %q", pair[0], pair[1], keys[0])
+	}
+}
+
+func TestCombinationSepRejectsBadSeparators(t *testing.T) {
+	for _, sep := range []string{"", `\`, `a\b`} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected separator %q to panic", sep)
+				}
+			}()
+			KeyFuncs.CombinationSep(sep, KeyFuncs.ByPath)
+		}()
+	}
+}
+
+// Compare with fmt.Sprintf("%v", keys), the previous implementation, with
+// -benchmem
+func BenchmarkCombination(b *testing.B) {
+	fn := KeyFuncs.Combination(KeyFuncs.ByPath, KeyFuncs.ByUserID("X-User-ID"))
+	req := httptest.NewRequest("GET", "/api/data", nil)
+	req.Header.Set("X-User-ID", "user456")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fn(req)
+	}
+}
+
+func BenchmarkCombinationSprintf(b *testing.B) {
+	funcs := []KeyFunc{KeyFuncs.ByPath, KeyFuncs.ByUserID("X-User-ID")}
+	req := httptest.NewRequest("GET", "/api/data", nil)
+	req.Header.Set("X-User-ID", "user456")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var keys []string
+		for _, fn := range funcs {
+			keys = append(keys, fn(req))
+		}
+		_ = fmt.Sprintf("%v", keys)
+	}
+}
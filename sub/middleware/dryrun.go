@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+// DryRunHeader is set on every response in dry run to "allow" or "deny",
+// the decision the limiter would have enforced
+const DryRunHeader = "X-RateLimit-DryRun-Decision"
+
+// DecisionFunc receives a decision of the middleware. The request carries
+// the decision's LimitInfo; see InfoFromContext.
+type DecisionFunc func(r *http.Request, allowed bool)
+
+// Observer is told about every decision the middleware makes and, in
+// ModeWait, how long each request waited, such as to export metrics. Its
+// methods are called on the request path, so they must be fast and safe
+// for concurrent use.
+type Observer interface {
+	ObserveDecision(r *http.Request, allowed bool)
+	ObserveWait(r *http.Request, waited time.Duration)
+}
+
+// shadow holds the settings for observing decisions instead of, or as well
+// as, enforcing them
+type shadow struct {
+	dryRun     bool
+	onDecision DecisionFunc
+	onAllowed  func(r *http.Request, key string)
+	onDenied   func(r *http.Request, key string)
+	keyedStats *stats.KeyedStats
+	observers  atomic.Pointer[[]Observer]
+	// pauseKeyedStats stops keyedStats counting while set
+	pauseKeyedStats atomic.Bool
+}
+
+// hooksKey reports whether the hooks or KeyedStats need the request's key
+func (s *shadow) hooksKey() bool {
+	return s.onAllowed != nil || s.onDenied != nil || s.keyedStats != nil
+}
+
+// addObserver adds o to the observers, copying on write so requests never
+// wait for it
+func (s *shadow) addObserver(o Observer) {
+	for {
+		old := s.observers.Load()
+		var observers []Observer
+		if old != nil {
+			observers = append(observers, *old...)
+		}
+		observers = append(observers, o)
+		if s.observers.CompareAndSwap(old, &observers) {
+			return
+		}
+	}
+}
+
+// observeWait tells the observers r waited for waited
+func (s *shadow) observeWait(r *http.Request, waited time.Duration) {
+	if observers := s.observers.Load(); observers != nil {
+		for _, o := range *observers {
+			o.ObserveWait(r, waited)
+		}
+	}
+}
+
+// enforce reports the decision for the request with the given key to the
+// callbacks and the observers and reports whether to refuse the request,
+// which is never the case in dry run
+func (s *shadow) enforce(w http.ResponseWriter, r *http.Request, key string, allowed bool) bool {
+	if s.onDecision != nil {
+		s.onDecision(r, allowed)
+	}
+	if allowed && s.onAllowed != nil {
+		s.onAllowed(r, key)
+	} else if !allowed && s.onDenied != nil {
+		s.onDenied(r, key)
+	}
+	if s.keyedStats != nil && !s.pauseKeyedStats.Load() {
+		if allowed {
+			s.keyedStats.RecordAllowed(key)
+		} else {
+			s.keyedStats.RecordDenied(key)
+		}
+	}
+	if observers := s.observers.Load(); observers != nil {
+		for _, o := range *observers {
+			o.ObserveDecision(r, allowed)
+		}
+	}
+	if !s.dryRun {
+		return !allowed
+	}
+	if allowed {
+		w.Header().Set(DryRunHeader, "allow")
+	} else {
+		w.Header().Set(DryRunHeader, "deny")
+	}
+	return false
+}
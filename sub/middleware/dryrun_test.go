@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/limiter"
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+func TestDryRun(t *testing.T) {
+	type decision struct {
+		key     string
+		allowed bool
+	}
+	var decisions []decision
+	opts := &Options{
+		KeyFunc: KeyFuncs.ByUserID("X-User-ID"),
+		DryRun:  true,
+		OnDecision: func(r *http.Request, allowed bool) {
+			info, _ := InfoFromContext(r.Context())
+			decisions = append(decisions, decision{info.Key, allowed})
+		},
+	}
+	global := stats.NewRateLimiterWithStats(limiter.NewRateLimiter(1, 1))
+	handlers := map[string]http.Handler{
+		"":      NewHTTPRateLimiter(global, opts).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+		"alice": NewPerKeyHTTPRateLimiter(func() RateLimiter { return limiter.NewRateLimiter(1, 1) }, opts).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+	}
+
+	for key, handler := range handlers {
+		decisions = nil
+		for i, want := range []string{"allow", "deny"} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-User-ID", "alice")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Errorf("%q: expected request %d to reach the handler in dry run, got %d", key, i, rec.Code)
+			}
+			if got := rec.Header().Get(DryRunHeader); got != want {
+				t.Errorf("%q: expected request %d to be marked %q, got %q", key, i, want, got)
+			}
+			if rec.Header().Get("Retry-After") != "" {
+				t.Errorf("%q: expected no Retry-After in dry run", key)
+			}
+		}
+		if len(decisions) != 2 || decisions[0] != (decision{key, true}) || decisions[1] != (decision{key, false}) {
+			t.Errorf("%q: expected OnDecision to see an allow then a deny, got %+v", key, decisions)
+		}
+	}
+	if s := global.GetStats().GetSnapshot(); s.DeniedRequests != 1 {
+		t.Errorf("Expected the would-be denial in the limiter's stats, got %+v", s)
+	}
+}
+
+func TestOnDecisionWhenEnforcing(t *testing.T) {
+	var denials int
+	rl := NewHTTPRateLimiter(&mockRateLimiter{allowReturn: false}, &Options{
+		OnDecision: func(r *http.Request, allowed bool) {
+			if !allowed {
+				denials++
+			}
+		},
+	})
+	rec := httptest.NewRecorder()
+	rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusTooManyRequests || denials != 1 || rec.Header().Get(DryRunHeader) != "" {
+		t.Errorf("Expected an enforced denial reported once and unmarked, got %d, %d denials, header %q",
+			rec.Code, denials, rec.Header().Get(DryRunHeader))
+	}
+}
+
+func TestNewFromConfigDryRun(t *testing.T) {
+	mw, err := NewFromConfig(&config.Config{Rate: 1, Burst: 1, Enabled: true, DryRun: true}, nil)
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected dry_run to let request %d through, got %d", i, rec.Code)
+		}
+	}
+}
+
+func TestOnAllowedOnDenied(t *testing.T) {
+	var events []string
+	opts := &Options{
+		KeyFunc: KeyFuncs.ByUserID("X-User-ID"),
+		OnAllowed: func(r *http.Request, key string) {
+			events = append(events, "allow "+key)
+		},
+		OnDenied: func(r *http.Request, key string) {
+			events = append(events, "deny "+key)
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events = append(events, "next")
+	})
+	handlers := map[string]http.Handler{
+		"global":  NewHTTPRateLimiter(limiter.NewRateLimiter(1, 1), opts).Middleware(next),
+		"per-key": NewPerKeyHTTPRateLimiter(func() RateLimiter { return limiter.NewRateLimiter(1, 1) }, opts).Middleware(next),
+	}
+
+	for name, handler := range handlers {
+		events = nil
+		for _, user := range []string{"alice", "bob"} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-User-ID", user)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		// The global limiter denies bob, whose key has no limit of its own
+		want := []string{"allow alice", "next", "deny bob"}
+		if name == "per-key" {
+			want = []string{"allow alice", "next", "allow bob", "next"}
+		}
+		if len(events) != len(want) {
+			t.Fatalf("%s: expected %v, got %v", name, want, events)
+		}
+		for i := range want {
+			if events[i] != want[i] {
+				t.Errorf("%s: expected %v, got %v", name, want, events)
+				break
+			}
+		}
+	}
+}
+
+func TestNilHooksSkipKeyFunc(t *testing.T) {
+	calls := 0
+	rl := NewHTTPRateLimiter(limiter.NewRateLimiter(1, 1), &Options{
+		KeyFunc: func(r *http.Request) string {
+			calls++
+			return "k"
+		},
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, _ := InfoFromContext(r.Context()); info.Key != "" {
+			t.Errorf("Expected no key without hooks, got %q", info.Key)
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if calls != 0 {
+		t.Errorf("Expected the key func not to run without hooks, ran %d times", calls)
+	}
+}
+
+func TestKeyedStatsOption(t *testing.T) {
+	keyed := stats.NewKeyedStats(10)
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return limiter.NewRateLimiter(1, 2) }, &Options{
+		KeyFunc:    KeyFuncs.ByUserID("X-User-ID"),
+		KeyedStats: keyed,
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, user := range []string{"alice", "alice", "alice", "alice", "bob"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User-ID", user)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	top := keyed.TopDenied(10)
+	if len(top) != 1 || top[0].Key != "alice" || top[0].Allowed != 2 || top[0].Denied != 2 {
+		t.Errorf("Expected alice allowed twice and denied twice, got %+v", top)
+	}
+	if s, ok := keyed.Get("bob"); !ok || s.Allowed != 1 {
+		t.Errorf("Expected bob allowed once, got %+v, %v", s, ok)
+	}
+}
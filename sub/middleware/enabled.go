@@ -0,0 +1,47 @@
+package middleware
+
+// BypassRecorder is implemented by limiters that count the requests let
+// through while limiting is turned off, such as stats.RateLimiterWithStats
+type BypassRecorder interface {
+	RecordBypassed()
+}
+
+// recordBypassed counts a bypassed request against l if it keeps such
+// counts
+func recordBypassed(l RateLimiter) {
+	if r, ok := l.(BypassRecorder); ok {
+		r.RecordBypassed()
+	}
+}
+
+// enabler is middleware whose limiting can be turned off and on
+type enabler interface {
+	SetEnabled(enabled bool)
+	Enabled() bool
+}
+
+// SetEnabled turns limiting off or on. While off, every request reaches
+// the next handler without touching the limiter, and is counted as
+// bypassed rather than allowed by limiters that implement BypassRecorder.
+// The limiter keeps its state, so turning limiting back on resumes from
+// where it was.
+func (rl *HTTPRateLimiter) SetEnabled(enabled bool) {
+	rl.disabled.Store(!enabled)
+}
+
+// Enabled reports whether limiting is on
+func (rl *HTTPRateLimiter) Enabled() bool {
+	return !rl.disabled.Load()
+}
+
+// SetEnabled turns limiting off or on, as HTTPRateLimiter.SetEnabled does.
+// While off no limiters are created for new keys, and keys keep the
+// limiters they have.
+func (rl *PerKeyHTTPRateLimiter) SetEnabled(enabled bool) {
+	rl.disabled.Store(!enabled)
+}
+
+// Enabled reports whether limiting is on
+func (rl *PerKeyHTTPRateLimiter) Enabled() bool {
+	return !rl.disabled.Load()
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/limiter"
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+func TestDisabledConfigPassesThrough(t *testing.T) {
+	for _, perKey := range []bool{false, true} {
+		mw, err := NewFromConfig(&config.Config{Rate: 1, Burst: 1, PerKeyLimits: perKey}, nil)
+		if err != nil {
+			t.Fatalf("NewFromConfig failed: %v", err)
+		}
+		handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		for i := 0; i < 100; i++ {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("per key %v: Expected request %d through, got %d", perKey, i, rec.Code)
+			}
+		}
+	}
+}
+
+func TestSetEnabled(t *testing.T) {
+	limited := stats.NewRateLimiterWithStats(limiter.NewRateLimiter(1, 1))
+	rl := NewHTTPRateLimiter(limited, nil)
+	rl.SetEnabled(false)
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec.Code
+	}
+
+	for i := 0; i < 100; i++ {
+		if code := send(); code != http.StatusOK {
+			t.Fatalf("Expected request %d through while disabled, got %d", i, code)
+		}
+	}
+	snapshot := limited.GetStats().GetSnapshot()
+	if snapshot.BypassedRequests != 100 || snapshot.TotalRequests != 0 {
+		t.Errorf("Expected 100 bypassed and no allowed requests, got %+v", snapshot)
+	}
+
+	rl.SetEnabled(true)
+	if !rl.Enabled() {
+		t.Error("Expected Enabled to report the change")
+	}
+	if code := send(); code != http.StatusOK {
+		t.Errorf("Expected the burst to be untouched, got %d", code)
+	}
+	if code := send(); code != http.StatusTooManyRequests {
+		t.Errorf("Expected limiting to resume, got %d", code)
+	}
+}
+
+func TestPerKeySetEnabled(t *testing.T) {
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return limiter.NewRateLimiter(1, 1) }, nil)
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	admin := rl.AdminHandler()
+	setEnabled := func(body string) int {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest("PUT", "/enabled", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := setEnabled(`{"enabled": false}`); code != http.StatusNoContent {
+		t.Fatalf("Expected the admin handler to turn limiting off, got %d", code)
+	}
+	for i := 0; i < 10; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected request %d through while disabled, got %d", i, rec.Code)
+		}
+	}
+	if rl.KeyCount() != 0 {
+		t.Errorf("Expected no limiters created while disabled, got %d", rl.KeyCount())
+	}
+
+	if code := setEnabled(`{}`); code != http.StatusBadRequest {
+		t.Errorf("Expected a body without enabled to be refused, got %d", code)
+	}
+	if code := setEnabled(`{"enabled": true}`); code != http.StatusNoContent || !rl.Enabled() {
+		t.Fatalf("Expected the admin handler to turn limiting on, got %d", code)
+	}
+	var last int
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		last = rec.Code
+	}
+	if last != http.StatusTooManyRequests {
+		t.Errorf("Expected limiting to resume, got %d", last)
+	}
+}
+
+func TestReloadableSetEnabled(t *testing.T) {
+	rl, err := NewReloadable(&config.Config{Rate: 1, Burst: 2}, nil)
+	if err != nil {
+		t.Fatalf("NewReloadable failed: %v", err)
+	}
+	handler := rl.MiddlewareFunc(func(w http.ResponseWriter, r *http.Request) {})
+	send := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec.Code
+	}
+	for i := 0; i < 10; i++ {
+		if code := send(); code != http.StatusOK {
+			t.Fatalf("Expected request %d through while disabled, got %d", i, code)
+		}
+	}
+
+	rl.SetEnabled(true)
+	if !rl.Enabled() || !rl.Config().Enabled {
+		t.Error("Expected the config to record that limiting is on")
+	}
+	send()
+	if code := send(); code != http.StatusOK {
+		t.Errorf("Expected the burst of 2, got %d", code)
+	}
+	if code := send(); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected limiting to start, got %d", code)
+	}
+
+	// Reloading with only enabled changed keeps the limiter's state
+	if err := rl.Reload(&config.Config{Rate: 1, Burst: 2}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if err := rl.Reload(&config.Config{Rate: 1, Burst: 2, Enabled: true}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if code := send(); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the exhausted limiter to be kept, got %d", code)
+	}
+}
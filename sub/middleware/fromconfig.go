@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+// CostFuncFromConfig returns a CostFunc charging requests the costs cfg
+// declares, or nil if it declares none
+func CostFuncFromConfig(cfg *config.Config) (CostFunc, error) {
+	if len(cfg.Costs) == 0 && cfg.DefaultCost == 0 {
+		return nil, nil
+	}
+	table, err := config.CompileCosts(cfg.Costs, cfg.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return func(r *http.Request) int {
+		return table.Cost(r.Method, r.URL.Path)
+	}, nil
+}
+
+// ExclusionsFromConfig returns the Exclusions cfg declares, or nil if it
+// declares none
+func ExclusionsFromConfig(cfg *config.Config) (*config.Exclusions, error) {
+	if len(cfg.ExcludedPaths) == 0 && len(cfg.ExcludedIPs) == 0 {
+		return nil, nil
+	}
+	return config.CompileExclusions(cfg.ExcludedPaths, cfg.ExcludedIPs)
+}
+
+// isExcluded reports whether r skips rate limiting under exclusions, by
+// its path or by the client IP clientIP reports for it
+func isExcluded(r *http.Request, exclusions *config.Exclusions, clientIP KeyFunc) bool {
+	if exclusions == nil {
+		return false
+	}
+	if exclusions.ExcludesPath(r.URL.Path) {
+		return true
+	}
+	ip, ok := parseIP(clientIP(r))
+	return ok && exclusions.ExcludesIP(ip)
+}
+
+// PeerIP reports the address of the peer that sent r, RemoteAddr, ignoring
+// forwarding headers. It is the default Options.ClientIP.
+func PeerIP(r *http.Request) string {
+	return r.RemoteAddr
+}
+
+// NewHTTPRateLimiterFromConfig creates middleware applying l to every
+// request except those cfg's ExcludedPaths and ExcludedIPs exclude, with
+// the error handler from ErrorHandlerFromConfig and cfg's DryRun
+func NewHTTPRateLimiterFromConfig(l RateLimiter, cfg *config.Config) (*HTTPRateLimiter, error) {
+	opts, err := optionsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewHTTPRateLimiter(l, opts), nil
+}
+
+// NewPerKeyHTTPRateLimiterFromConfig is like NewHTTPRateLimiterFromConfig
+// but gives every key its own limiter built by factory
+func NewPerKeyHTTPRateLimiterFromConfig(factory LimiterFactory, cfg *config.Config) (*PerKeyHTTPRateLimiter, error) {
+	opts, err := optionsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewPerKeyHTTPRateLimiter(factory, opts), nil
+}
+
+// optionsFromConfig returns the error handler, exclusions and dry run cfg
+// declares
+func optionsFromConfig(cfg *config.Config) (*Options, error) {
+	handler, err := ErrorHandlerFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	exclusions, err := ExclusionsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Options{ErrorHandler: handler, Exclusions: exclusions, DryRun: cfg.DryRun}, nil
+}
+
+// NewFromConfig builds rate limiting middleware from cfg, with limiters
+// built by limiter.NewFromConfig. With PerKeyLimits every key gets its own limiter.
+// Unless opts sets them, the error handler comes from ErrorHandlerFromConfig,
+// request costs from the config's Costs, exclusions from its ExcludedPaths
+// and ExcludedIPs and the key cap and idle TTL from its MaxKeys and KeyTTL.
+// DryRun is set if either sets it. A disabled config yields middleware that
+// lets every request through, counting it as bypassed; see SetEnabled.
+func NewFromConfig(cfg *config.Config, opts *Options) (func(http.Handler) http.Handler, error) {
+	middleware, _, _, err := buildFromConfig(cfg, opts)
+	return middleware, err
+}
+
+// buildFromConfig builds the middleware NewFromConfig returns, along with
+// the per-key limiter to close once it is no longer used, if there is one,
+// and the switch turning it off and on. The limiters are built even for a
+// disabled config, so limiting can be turned on without rebuilding.
+func buildFromConfig(cfg *config.Config, opts *Options) (func(http.Handler) http.Handler, io.Closer, enabler, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, nil, err
+	}
+	enabled := cfg.Enabled
+	cfg = cfg.Clone()
+	cfg.Enabled = true
+
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.ErrorHandler == nil {
+		handler, err := ErrorHandlerFromConfig(cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		o.ErrorHandler = handler
+	}
+	o.DryRun = o.DryRun || cfg.DryRun
+	if o.MaxKeys == 0 {
+		o.MaxKeys = cfg.MaxKeys
+		o.RejectNewKeys = cfg.RejectNewKeys
+	}
+	if o.IdleTTL == 0 {
+		o.IdleTTL = cfg.KeyTTL
+	}
+	if o.Exclusions == nil {
+		exclusions, err := ExclusionsFromConfig(cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		o.Exclusions = exclusions
+	}
+	if o.CostFunc == nil {
+		costFunc, err := CostFuncFromConfig(cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		o.CostFunc = costFunc
+	}
+
+	l, err := limiter.NewFromConfig(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !cfg.PerKeyLimits {
+		rl := NewHTTPRateLimiter(l, &o)
+		rl.SetEnabled(enabled)
+		return rl.Middleware, nil, rl, nil
+	}
+
+	// Building the first limiter succeeded, so building more cannot fail
+	factory := func() RateLimiter {
+		l, _ := limiter.NewFromConfig(cfg)
+		return l
+	}
+	rl := NewPerKeyHTTPRateLimiter(factory, &o)
+	rl.SetEnabled(enabled)
+	return rl.Middleware, rl, rl, nil
+}
+
+// KeyedFactoryFromConfigSet returns a KeyedLimiterFactory building each
+// key's limiter from the config of cs that tier names for the key, such as
+// "premium" for a paying customer's API key, or from the fallback config if
+// cs has no config of that name. Configs are looked up as each limiter is
+// built, so changes to cs apply to limiters built after them. The fallback
+// must resolve now; should it stop resolving later, its config as of now is
+// used.
+func KeyedFactoryFromConfigSet(cs *config.ConfigSet, tier func(key string) string, fallback string) (KeyedLimiterFactory, error) {
+	fallbackCfg, err := cs.Resolve(fallback)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := limiter.NewFromConfig(fallbackCfg); err != nil {
+		return nil, err
+	}
+	return func(key string) RateLimiter {
+		for _, name := range []string{tier(key), fallback} {
+			if cfg, err := cs.Resolve(name); err == nil {
+				if l, err := limiter.NewFromConfig(cfg); err == nil {
+					return l
+				}
+			}
+		}
+		// Building from the fallback succeeded above, so it cannot fail
+		l, _ := limiter.NewFromConfig(fallbackCfg)
+		return l
+	}, nil
+}
+
+// NewHierarchicalHTTPRateLimiter creates per-key middleware in which every
+// key's limiter, built by factory, also draws from one global limiter built
+// from global, capping the total however many keys are active. A disabled
+// global config leaves only the per-key limits.
+func NewHierarchicalHTTPRateLimiter(global *config.Config, factory LimiterFactory, opts *Options) (*PerKeyHTTPRateLimiter, error) {
+	parent, err := limiter.NewFromConfig(global)
+	if err != nil {
+		return nil, err
+	}
+	h := limiter.NewHierarchicalLimiter(parent, factory)
+	return NewPerKeyHTTPRateLimiter(func() RateLimiter { return h.Child() }, opts), nil
+}
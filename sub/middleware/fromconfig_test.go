@@ -0,0 +1,333 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+func TestNewFromConfigCosts(t *testing.T) {
+	cfg := &config.Config{
+		Rate:    1,
+		Burst:   12,
+		Enabled: true,
+		Costs:   map[string]int{"POST /v1/search": 10},
+	}
+	mw, err := NewFromConfig(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(method, path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code
+	}
+
+	if code := send("POST", "/v1/search"); code != http.StatusOK {
+		t.Fatalf("Expected the first search to fit the burst, got %d", code)
+	}
+	if code := send("POST", "/v1/search"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected a second search costing 10 to be denied, got %d", code)
+	}
+	if code := send("GET", "/v1/search"); code != http.StatusOK {
+		t.Errorf("Expected a GET at the default cost to fit, got %d", code)
+	}
+	if code := send("GET", "/other"); code != http.StatusOK {
+		t.Errorf("Expected the last token to be available, got %d", code)
+	}
+	if code := send("GET", "/other"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the bucket to be empty, got %d", code)
+	}
+}
+
+func TestNewFromConfigPerKeyAndDisabled(t *testing.T) {
+	cfg := &config.Config{Rate: 1, Burst: 1, Enabled: true, PerKeyLimits: true, ErrorMessage: "per key"}
+	mw, err := NewFromConfig(cfg, &Options{KeyFunc: KeyFuncs.ByPath})
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, path := range []string{"/a", "/b"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected first request to %s to be allowed, got %d", path, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/a", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Body.String() != "per key\n" {
+		t.Errorf("Expected the config's error message, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	cfg.Enabled = false
+	mw, err = NewFromConfig(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+	handler = mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected a disabled config to let requests through, got %d", rec.Code)
+		}
+	}
+
+	if _, err := NewFromConfig(&config.Config{Rate: 1, Burst: 1, Costs: map[string]int{"/": 5}}, nil); err == nil {
+		t.Error("Expected an error for a cost above the burst")
+	}
+}
+
+func TestCostFuncWithoutBatchSupport(t *testing.T) {
+	mock := &mockRateLimiter{allowReturn: true}
+	handler := NewHTTPRateLimiter(mock, &Options{
+		CostFunc: func(r *http.Request) int { return 5 },
+	}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if mock.getCallCount() != 1 {
+		t.Errorf("Expected a single-request limiter to be asked once, got %d", mock.getCallCount())
+	}
+}
+
+func TestNewFromConfigColdStartKeys(t *testing.T) {
+	initial := 0
+	cfg := &config.Config{Rate: 10, Burst: 20, Enabled: true, PerKeyLimits: true, InitialTokens: &initial}
+	mw, err := NewFromConfig(cfg, &Options{KeyFunc: KeyFuncs.ByPath})
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// A new key has to ramp up instead of getting a burst of 20
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/new-key", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a new key to start empty, got %d", rec.Code)
+	}
+}
+
+func TestNewHierarchicalHTTPRateLimiter(t *testing.T) {
+	global := &config.Config{Rate: 1, Burst: 3, Enabled: true}
+	factory := func() RateLimiter { return limiter.NewRateLimiter(1, 2) }
+	rl, err := NewHierarchicalHTTPRateLimiter(global, factory, &Options{KeyFunc: KeyFuncs.ByPath})
+	if err != nil {
+		t.Fatalf("NewHierarchicalHTTPRateLimiter failed: %v", err)
+	}
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+
+	// /a is held to its own burst of 2, leaving one global token for /b
+	want := []struct {
+		path string
+		code int
+	}{
+		{"/a", http.StatusOK},
+		{"/a", http.StatusOK},
+		{"/a", http.StatusTooManyRequests},
+		{"/b", http.StatusOK},
+		{"/b", http.StatusTooManyRequests},
+		{"/c", http.StatusTooManyRequests},
+	}
+	for i, w := range want {
+		if code := send(w.path); code != w.code {
+			t.Errorf("Request %d to %s: expected %d, got %d", i, w.path, w.code, code)
+		}
+	}
+
+	if _, err := NewHierarchicalHTTPRateLimiter(&config.Config{Enabled: true}, factory, nil); err == nil {
+		t.Error("Expected an invalid global config to be rejected")
+	}
+}
+
+func TestHTTPRateLimiterFromConfigExclusions(t *testing.T) {
+	cfg := &config.Config{
+		Rate:          1,
+		Burst:         1,
+		ExcludedPaths: []string{"/health", "/static/*"},
+		ExcludedIPs:   []string{"10.0.0.0/8", "::1"},
+	}
+	mock := &mockRateLimiter{allowReturn: false}
+	rl, err := NewHTTPRateLimiterFromConfig(mock, cfg)
+	if err != nil {
+		t.Fatalf("NewHTTPRateLimiterFromConfig failed: %v", err)
+	}
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		path, remoteAddr, forwarded string
+		excluded                    bool
+	}{
+		{"/health", "192.0.2.1:1234", "", true},
+		{"/static/app.js", "192.0.2.1:1234", "", true},
+		{"/healthz", "192.0.2.1:1234", "", false},
+		{"/api", "10.20.30.40:1234", "", true},
+		{"/api", "[::1]:1234", "", true},
+		{"/api", "192.0.2.1:1234", "10.0.0.7", false}, // a spoofed header from an untrusted peer
+		{"/api", "192.0.2.1:1234", "10.0.0.7, 192.0.2.1", false},
+		{"/api", "10.0.0.1:1234", "192.0.2.9", true}, // the peer is excluded whatever it forwards
+		{"/api", "192.0.2.1:1234", "", false},
+	}
+	for _, tt := range tests {
+		before := mock.getCallCount()
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		touched := mock.getCallCount() != before
+		if tt.excluded && (rec.Code != http.StatusOK || touched) {
+			t.Errorf("%s from %s (%q): expected to bypass the limiter, got %d, limiter called %v", tt.path, tt.remoteAddr, tt.forwarded, rec.Code, touched)
+		}
+		if !tt.excluded && (rec.Code != http.StatusTooManyRequests || !touched) {
+			t.Errorf("%s from %s (%q): expected to be limited, got %d, limiter called %v", tt.path, tt.remoteAddr, tt.forwarded, rec.Code, touched)
+		}
+	}
+}
+
+func TestPerKeyHTTPRateLimiterFromConfigExclusions(t *testing.T) {
+	cfg := &config.Config{Rate: 1, Burst: 1, ExcludedPaths: []string{"/health"}, ExcludedIPs: []string{"10.0.0.0/8"}}
+	rl, err := NewPerKeyHTTPRateLimiterFromConfig(func() RateLimiter { return &mockRateLimiter{} }, cfg)
+	if err != nil {
+		t.Fatalf("NewPerKeyHTTPRateLimiterFromConfig failed: %v", err)
+	}
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(path, remoteAddr string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := send("/health", "192.0.2.1:1234"); code != http.StatusOK {
+		t.Errorf("Expected an excluded path to pass, got %d", code)
+	}
+	if code := send("/api", "10.1.1.1:1234"); code != http.StatusOK {
+		t.Errorf("Expected an excluded IP to pass, got %d", code)
+	}
+	if n := rl.KeyCount(); n != 0 {
+		t.Errorf("Expected excluded requests to create no limiters, got %d", n)
+	}
+	if code := send("/api", "192.0.2.1:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected other requests to be limited, got %d", code)
+	}
+
+	if _, err := NewPerKeyHTTPRateLimiterFromConfig(nil, &config.Config{ExcludedPaths: []string{"health"}}); err == nil {
+		t.Error("Expected a malformed excluded path to be rejected")
+	}
+}
+
+func TestKeyedFactoryFromConfigSet(t *testing.T) {
+	cs := config.NewConfigSet()
+	cs.Add("free", &config.Config{Rate: 1, Burst: 2, Enabled: true})
+	cs.Add("premium", &config.Config{Rate: 1, Burst: 5, Enabled: true})
+	tiers := map[string]string{"key-premium": "premium"}
+
+	factory, err := KeyedFactoryFromConfigSet(cs, func(key string) string { return tiers[key] }, "free")
+	if err != nil {
+		t.Fatalf("KeyedFactoryFromConfigSet failed: %v", err)
+	}
+	rl := NewPerKeyHTTPRateLimiterKeyed(factory, &Options{KeyFunc: KeyFuncs.ByAPIKey("X-API-Key")})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	admitted := make(map[string]int)
+	for _, key := range []string{"key-premium", "key-free"} {
+		for i := 0; i < 10; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-API-Key", key)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code == http.StatusOK {
+				admitted[key]++
+			}
+		}
+	}
+	if admitted["key-premium"] != 5 || admitted["key-free"] != 2 {
+		t.Errorf("Expected the premium key its burst of 5 and others the free burst of 2, got %v", admitted)
+	}
+
+	if _, err := KeyedFactoryFromConfigSet(cs, func(string) string { return "" }, "missing"); err == nil {
+		t.Error("Expected an unknown fallback to be rejected")
+	}
+}
+
+func TestNewFromConfigErrorStatusAndRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		status     int
+		retryAfter string
+		body       string
+		limitedBy  string
+	}{
+		{
+			name:       "static",
+			config:     `{"rate": 1, "burst": 1, "enabled": true, "error_status_code": 503, "retry_after_mode": "static:30", "error_message": "Internal limit", "custom_headers": {"X-Limited-By": "gateway"}}`,
+			status:     http.StatusServiceUnavailable,
+			retryAfter: "30",
+			body:       "Internal limit\n",
+			limitedBy:  "gateway",
+		},
+		{
+			name:       "auto",
+			config:     `{"rate": 1, "burst": 1, "enabled": true, "error_status_code": 503, "retry_after_mode": "auto"}`,
+			status:     http.StatusServiceUnavailable,
+			retryAfter: "1",
+			body:       "Too Many Requests\n",
+		},
+		{
+			name:       "defaults",
+			config:     `{"rate": 1, "burst": 1, "enabled": true, "response_template": "{\"error\": \"slow down\"}"}`,
+			status:     http.StatusTooManyRequests,
+			retryAfter: "1",
+			body:       `{"error": "slow down"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.LoadFromReader(strings.NewReader(tt.config))
+			if err != nil {
+				t.Fatalf("LoadFromReader failed: %v", err)
+			}
+			mw, err := NewFromConfig(cfg, nil)
+			if err != nil {
+				t.Fatalf("NewFromConfig failed: %v", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Expected Retry-After %q, got %q", tt.retryAfter, got)
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("Expected body %q, got %q", tt.body, rec.Body.String())
+			}
+			if got := rec.Header().Get("X-Limited-By"); got != tt.limitedBy {
+				t.Errorf("Expected X-Limited-By %q, got %q", tt.limitedBy, got)
+			}
+		})
+	}
+}
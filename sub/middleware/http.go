@@ -1,28 +1,193 @@
 package middleware
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"maps"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/limiter"
+	"github.com/rRateLimit/arg/sub/stats"
 )
 
-// RateLimiter interface that the rate limiter should implement
-type RateLimiter interface {
-	Allow() bool
+// RateLimiter interface that the rate limiter should implement. Optional
+// capabilities are the interfaces of the limiter package.
+type RateLimiter = limiter.Allower
+
+// ContextWaiter is implemented by rate limiters that can wait for a request
+// to be allowed, which ModeWait requires
+type ContextWaiter = limiter.ContextWaiter
+
+// Mode selects what the middleware does with a request over the limit
+type Mode int
+
+const (
+	// ModeReject responds to requests over the limit with the error handler
+	ModeReject Mode = iota
+	// ModeWait holds requests over the limit until the limiter admits them,
+	// smoothing bursts instead of rejecting them. Limiters that do not
+	// implement ContextWaiter are used as in ModeReject.
+	ModeWait
+)
+
+// Named is implemented by rate limiters that carry a name
+type Named = limiter.Named
+
+// LimitInfo describes the rate limit decision made for a request. Limit,
+// Remaining, RetryAfter and Window are only known when the limiter
+// implements limiter.Detailer; Limit is zero otherwise.
+type LimitInfo struct {
+	Key         string
+	LimiterName string
+	Limit       int
+	Remaining   int
+	RetryAfter  time.Duration
+	Window      time.Duration
+	// Route is the name of the RouteRateLimiter rule the request matched,
+	// if any
+	Route string
+}
+
+// newLimitInfo describes a decision made by the given limiter
+func newLimitInfo(key string, l RateLimiter, decision limiter.Decision) LimitInfo {
+	return LimitInfo{
+		Key:         key,
+		LimiterName: limiterName(l),
+		Limit:       decision.Limit,
+		Remaining:   decision.Remaining,
+		RetryAfter:  decision.RetryAfter,
+		Window:      decision.Window,
+	}
+}
+
+type limitInfoKey struct{}
+
+// InfoFromContext returns the rate limit decision stored in the context
+// by the middleware
+func InfoFromContext(ctx context.Context) (LimitInfo, bool) {
+	info, ok := ctx.Value(limitInfoKey{}).(LimitInfo)
+	return info, ok
+}
+
+func withLimitInfo(r *http.Request, info LimitInfo) *http.Request {
+	info.Route, _ = RouteFromContext(r.Context())
+	return r.WithContext(context.WithValue(r.Context(), limitInfoKey{}, info))
+}
+
+// decide asks the limiter for a decision. Limiters that implement
+// limiter.Detailer or limiter.RetryAfterer also report how long a denied
+// client should wait; composed limiters report the aggregate across layers.
+func decide(l RateLimiter, cost int) limiter.Decision {
+	if cost > 1 {
+		return limiter.AllowNDetail(l, cost)
+	}
+	return limiter.AllowDetail(l)
+}
+
+// decideWaiting is decide for ModeWait: a limiter that can wait is given
+// until maxWait, if positive, or until r's context is done to admit r, and
+// r is denied only if it cannot. Other limiters, and requests costing more
+// than one token, are decided at once as in ModeReject. The second result
+// reports whether r waited.
+func decideWaiting(r *http.Request, l RateLimiter, cost int, maxWait time.Duration) (limiter.Decision, bool) {
+	w, ok := l.(ContextWaiter)
+	if !ok || cost > 1 {
+		return decide(l, cost), false
+	}
+	ctx := r.Context()
+	if maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxWait)
+		defer cancel()
+	}
+	if err := w.WaitContext(ctx); err != nil {
+		return limiter.Decision{RetryAfter: limiter.RetryAfter(l)}, true
+	}
+	return limiter.Decision{Allowed: true}, true
+}
+
+// requestCost returns the cost of r under fn, which may be nil. Costs below
+// one are treated as one.
+func requestCost(fn CostFunc, r *http.Request) int {
+	if fn == nil {
+		return 1
+	}
+	return max(fn(r), 1)
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounded up
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+}
+
+// setLimitHeaders sets the X-RateLimit headers for a decision made by l.
+// Decisions from limiters that do not report their limits set none.
+func setLimitHeaders(w http.ResponseWriter, l RateLimiter, decision limiter.Decision) {
+	if decision.Limit == 0 {
+		return
+	}
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(max(decision.Remaining, 0)))
+	if resetAfter, ok := limiter.ResetAfter(l); ok {
+		h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(resetAfter.Seconds()))))
+	}
+}
+
+// limiterName returns the limiter's name, or an empty string if it has none
+func limiterName(l RateLimiter) string {
+	return limiter.Name(l)
+}
+
+// isPaused reports whether the limiter has been paused
+func isPaused(l RateLimiter) bool {
+	return limiter.IsPaused(l)
 }
 
 // HTTPRateLimiter provides HTTP middleware for rate limiting
 type HTTPRateLimiter struct {
-	limiter      RateLimiter
-	keyFunc      KeyFunc
-	errorHandler ErrorHandler
-	limiters     map[string]RateLimiter
-	mu           sync.RWMutex
+	slot          atomic.Pointer[limiterSlot]
+	keyFunc       KeyFunc
+	errorHandler  ErrorHandler
+	pausedHandler ErrorHandler
+	limiters      map[string]RateLimiter
+	mu            sync.RWMutex
+	emitPressure  bool
+	emitHeaders   bool
+	prepaidSecret []byte
+	costFunc      CostFunc
+	exclusions    *config.Exclusions
+	clientIP      KeyFunc
+	skipFunc      SkipFunc
+	mode          Mode
+	maxWait       time.Duration
+	methodLimits  map[string]RateLimiter
+	disabled      atomic.Bool
+	shadow
 }
 
 // KeyFunc extracts a key from the request for per-key rate limiting
 type KeyFunc func(r *http.Request) string
 
+// CostFunc returns how many tokens a request consumes
+type CostFunc func(r *http.Request) int
+
+// SkipFunc reports whether a request bypasses rate limiting
+type SkipFunc func(r *http.Request) bool
+
 // ErrorHandler handles rate limit errors
 type ErrorHandler func(w http.ResponseWriter, r *http.Request)
 
@@ -30,9 +195,106 @@ type ErrorHandler func(w http.ResponseWriter, r *http.Request)
 type Options struct {
 	KeyFunc      KeyFunc
 	ErrorHandler ErrorHandler
+	// DrainHandler responds to requests from unknown keys while a per-key
+	// limiter is draining. Defaults to DefaultDrainHandler.
+	DrainHandler ErrorHandler
+	// PausedHandler responds to requests denied because their limiter is
+	// paused. Defaults to DefaultPausedHandler.
+	PausedHandler ErrorHandler
+	// TopConsumers enables hot-key detection in the per-key limiter by
+	// tracking approximately this many of the keys with the most allowed
+	// requests. Zero disables tracking.
+	TopConsumers int
+	// EmitPressure adds an X-RateLimit-Pressure header to every response
+	// whose limiter reports its health, so upstream proxies can shed load
+	// before clients are denied
+	EmitPressure bool
+	// EmitHeaders adds X-RateLimit-Limit and X-RateLimit-Remaining to every
+	// response whose limiter implements limiter.Detailer, and
+	// X-RateLimit-Reset, the seconds until the limiter is back to full
+	// capacity, if it implements limiter.Resetter, so clients can pace
+	// themselves
+	EmitHeaders bool
+	// PrepaidSecret enables the PrepaidHeader: requests carrying a token
+	// signed with this secret by SignPrepaid for their key, method and
+	// path skip the limiter. The header is removed before the request is
+	// passed on. Requests marked with MarkPrepaid skip it regardless.
+	PrepaidSecret []byte
+	// CostFunc sets how many tokens each request consumes. Limiters that
+	// cannot admit several requests at once are charged one token.
+	CostFunc CostFunc
+	// IdleTTL makes the per-key limiter evict the limiter of a key that has
+	// made no request for this long, bounding memory when keys are
+	// unbounded, such as client IPs. Zero keeps limiters forever.
+	IdleTTL time.Duration
+	// MaxKeys caps the number of keys the per-key limiter holds, so that a
+	// flood of new keys cannot exhaust memory before IdleTTL evicts them. A
+	// new key beyond the cap evicts the least recently used one, or is
+	// refused by the error handler with RejectNewKeys. Zero means no cap.
+	MaxKeys       int
+	RejectNewKeys bool
+	// KeyRecorder, if set, receives the per-key limiter's key count and
+	// evictions after every eviction. *stats.Stats implements it.
+	KeyRecorder limiter.KeyRecorder
+	// Exclusions lets requests for the paths and from the client IPs it
+	// excludes through without touching the limiter
+	Exclusions *config.Exclusions
+	// ClientIP reports the client IP of a request that Exclusions matches
+	// against. It defaults to PeerIP, since forwarding headers can be
+	// written by any client to pass for an excluded address; behind
+	// proxies, use a KeyFunc made by NewTrustedKeyFunc.
+	ClientIP KeyFunc
+	// SkipFunc lets the requests it returns true for through without
+	// touching the limiter, so they are neither limited nor counted. It
+	// composes with Exclusions: a request either one skips goes through.
+	SkipFunc SkipFunc
+	// Mode selects whether requests over the limit are rejected, the
+	// default, or wait for the limiter. In ModeWait a request is rejected
+	// only once it has waited MaxWait, if positive, or its client has gone
+	// away.
+	Mode    Mode
+	MaxWait time.Duration
+	// DryRun evaluates limits without enforcing them: every request
+	// reaches the next handler, and DryRunHeader tells what the limiter
+	// would have decided. Limiters still count the requests they would
+	// deny, so their stats show the effect of enforcing.
+	DryRun bool
+	// OnDecision, if set, is called with every decision the limiter makes,
+	// including those DryRun does not enforce
+	OnDecision DecisionFunc
+	// OnAllowed and OnDenied, if set, are called with each request the
+	// limiter allows or denies and its key, after the decision and before
+	// the response or the next handler. They run on the request path, so
+	// they must not block. The single-limiter middleware only resolves the
+	// key with KeyFunc when one of them is set.
+	OnAllowed func(r *http.Request, key string)
+	OnDenied  func(r *http.Request, key string)
+	// KeyedStats, if set, counts the requests allowed and denied for each
+	// key, to find the clients being throttled with TopDenied
+	KeyedStats *stats.KeyedStats
+	// MethodLimits makes the single-limiter middleware limit requests with
+	// the listed methods, such as http.MethodPost, with the method's
+	// limiter instead of the default one, so writes can be held to a
+	// tighter limit than reads of the same paths. Other methods use the
+	// default limiter. Requests Exclusions or SkipFunc let through skip
+	// these limiters as well.
+	MethodLimits map[string]RateLimiter
+	// MethodFactories is MethodLimits for the per-key middleware: requests
+	// with a listed method are limited by a limiter built by the method's
+	// factory for their method and key, joined as by KeyFuncs.Combination
+	// in the keys the middleware reports. Keys of other methods are
+	// escaped the same way, so they stay apart from a method's keys.
+	MethodFactories map[string]LimiterFactory
+	// PenaltyFunc makes the per-key limiter charge a key extra for the
+	// responses it gets, such as failed logins, once they are written.
+	// Limiters that cannot be charged after the fact (see Penalizer) are
+	// not charged.
+	PenaltyFunc PenaltyFunc
 }
 
-// DefaultKeyFunc uses the client IP as the key
+// DefaultKeyFunc uses the client IP as the key. It believes forwarding
+// headers from anyone, so clients that reach the server directly can pick
+// their own key; use NewTrustedKeyFunc there.
 func DefaultKeyFunc(r *http.Request) string {
 	// Try to get the real IP from X-Forwarded-For or X-Real-IP headers
 	if ip := r.Header.Get("X-Forwarded-For"); ip != "" {
@@ -50,13 +312,28 @@ func DefaultErrorHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 }
 
+// DefaultDrainHandler returns a 503 Service Unavailable response asking the
+// client to retry, presumably against another node
+func DefaultDrainHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+}
+
+// DefaultPausedHandler returns a 503 Service Unavailable response. It sets
+// no Retry-After since a paused limiter has no known resume time.
+func DefaultPausedHandler(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+}
+
 // NewHTTPRateLimiter creates a new HTTP rate limiter middleware
 func NewHTTPRateLimiter(limiter RateLimiter, opts *Options) *HTTPRateLimiter {
 	rl := &HTTPRateLimiter{
-		limiter:      limiter,
-		keyFunc:      DefaultKeyFunc,
-		errorHandler: DefaultErrorHandler,
+		keyFunc:       DefaultKeyFunc,
+		errorHandler:  DefaultErrorHandler,
+		pausedHandler: DefaultPausedHandler,
+		clientIP:      PeerIP,
 	}
+	rl.slot.Store(&limiterSlot{limiter: limiter})
 	
 	if opts != nil {
 		if opts.KeyFunc != nil {
@@ -65,6 +342,26 @@ func NewHTTPRateLimiter(limiter RateLimiter, opts *Options) *HTTPRateLimiter {
 		if opts.ErrorHandler != nil {
 			rl.errorHandler = opts.ErrorHandler
 		}
+		if opts.PausedHandler != nil {
+			rl.pausedHandler = opts.PausedHandler
+		}
+		rl.emitPressure = opts.EmitPressure
+		rl.emitHeaders = opts.EmitHeaders
+		rl.prepaidSecret = opts.PrepaidSecret
+		rl.costFunc = opts.CostFunc
+		rl.exclusions = opts.Exclusions
+		if opts.ClientIP != nil {
+			rl.clientIP = opts.ClientIP
+		}
+		rl.skipFunc = opts.SkipFunc
+		rl.mode = opts.Mode
+		rl.maxWait = opts.MaxWait
+		rl.methodLimits = maps.Clone(opts.MethodLimits)
+		rl.dryRun = opts.DryRun
+		rl.onDecision = opts.OnDecision
+		rl.onAllowed = opts.OnAllowed
+		rl.onDenied = opts.OnDenied
+		rl.keyedStats = opts.KeyedStats
 	}
 	
 	return rl
@@ -73,43 +370,211 @@ func NewHTTPRateLimiter(limiter RateLimiter, opts *Options) *HTTPRateLimiter {
 // Middleware returns an HTTP middleware function
 func (rl *HTTPRateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !rl.limiter.Allow() {
-			rl.errorHandler(w, r)
-			return
+		if r, ok := rl.check(w, r); ok {
+			next.ServeHTTP(w, r)
 		}
-		next.ServeHTTP(w, r)
 	})
 }
 
 // MiddlewareFunc returns an HTTP middleware function for use with http.HandlerFunc
 func (rl *HTTPRateLimiter) MiddlewareFunc(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !rl.limiter.Allow() {
-			rl.errorHandler(w, r)
-			return
+		if r, ok := rl.check(w, r); ok {
+			next(w, r)
 		}
-		next(w, r)
 	}
 }
 
+// check applies the current limiter to r and reports whether r may
+// proceed, responding to it if not. The request to pass on carries the
+// decision's LimitInfo. The limiter is read once, so a request
+// finishes against the limiter it started with even if it is swapped.
+func (rl *HTTPRateLimiter) check(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	prepaid := takePrepaid(r, rl.prepaidSecret)
+	if skips(r, rl.skipFunc, rl.exclusions, rl.clientIP) {
+		return r, true
+	}
+	slot := rl.acquire()
+	defer slot.inflight.Add(-1)
+	limiter := rl.methodLimiter(r, slot.limiter)
+
+	if rl.disabled.Load() {
+		recordBypassed(limiter)
+		return r, true
+	}
+	if isPrepaid(r, rl.prepaidSecret, prepaid, rl.keyFunc) {
+		recordPrepaid(limiter)
+		return r, true
+	}
+	decision := rl.decide(r, limiter)
+	if rl.emitPressure {
+		setPressure(w, limiter)
+	}
+	if rl.emitHeaders {
+		setLimitHeaders(w, limiter, decision)
+	}
+	var key string
+	if rl.hooksKey() {
+		key = rl.keyFunc(r)
+	}
+	r = withLimitInfo(r, newLimitInfo(key, limiter, decision))
+	if rl.enforce(w, r, key, decision.Allowed) {
+		if isPaused(limiter) {
+			rl.pausedHandler(w, r)
+			return r, false
+		}
+		setRetryAfter(w, decision.RetryAfter)
+		rl.errorHandler(w, r)
+		return r, false
+	}
+	return r, true
+}
+
+// decide applies l to r in the configured mode
+func (rl *HTTPRateLimiter) decide(r *http.Request, l RateLimiter) limiter.Decision {
+	if rl.mode == ModeWait {
+		start := time.Now()
+		decision, waited := decideWaiting(r, l, requestCost(rl.costFunc, r), rl.maxWait)
+		if waited {
+			rl.observeWait(r, time.Since(start))
+		}
+		return decision
+	}
+	return decide(l, requestCost(rl.costFunc, r))
+}
+
+// AddObserver makes o observe the middleware's decisions from now on
+func (rl *HTTPRateLimiter) AddObserver(o Observer) {
+	rl.addObserver(o)
+}
+
+// limiterSlot holds a limiter and counts the requests using it
+type limiterSlot struct {
+	limiter  RateLimiter
+	inflight atomic.Int64
+}
+
+// acquire returns the current slot with its in-flight count incremented.
+// The caller must decrement it when done with the limiter.
+func (rl *HTTPRateLimiter) acquire() *limiterSlot {
+	for {
+		slot := rl.slot.Load()
+		slot.inflight.Add(1)
+		// Retry if the slot was swapped out before the count was taken, so
+		// a drain never misses a request
+		if rl.slot.Load() == slot {
+			return slot
+		}
+		slot.inflight.Add(-1)
+	}
+}
+
+// Name returns the name of the limiter currently applied to requests, or
+// an empty string if it has none
+func (rl *HTTPRateLimiter) Name() string {
+	return limiterName(rl.GetLimiter())
+}
+
+// GetLimiter returns the limiter currently applied to requests
+func (rl *HTTPRateLimiter) GetLimiter() RateLimiter {
+	return rl.slot.Load().limiter
+}
+
+// SetLimiter replaces the limiter applied to new requests and returns the
+// previous one. Requests already being checked finish against the previous
+// limiter. It panics if l is nil.
+func (rl *HTTPRateLimiter) SetLimiter(l RateLimiter) RateLimiter {
+	return rl.swap(l).limiter
+}
+
+// SetLimiterAndDrain is like SetLimiter but also waits until no request is
+// using the previous limiter, so it can safely be closed. It returns ctx's
+// error if ctx is done first; the swap has happened regardless.
+func (rl *HTTPRateLimiter) SetLimiterAndDrain(ctx context.Context, l RateLimiter) (RateLimiter, error) {
+	old := rl.swap(l)
+
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for old.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return old.limiter, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return old.limiter, nil
+}
+
+func (rl *HTTPRateLimiter) swap(l RateLimiter) *limiterSlot {
+	if l == nil {
+		panic("middleware: nil limiter")
+	}
+	return rl.slot.Swap(&limiterSlot{limiter: l})
+}
+
 // PerKeyHTTPRateLimiter provides per-key HTTP rate limiting
 type PerKeyHTTPRateLimiter struct {
-	limiterFactory LimiterFactory
 	keyFunc        KeyFunc
 	errorHandler   ErrorHandler
-	limiters       sync.Map
+	drainHandler   ErrorHandler
+	pausedHandler  ErrorHandler
+	limiters       *limiter.KeyedLimiter
+	draining       atomic.Bool
+	disabled       atomic.Bool
+	topConsumers   *stats.TopK
+	boosts         sync.Map // key to *activeBoost
+	boosted        atomic.Int32
+	boostMu        sync.Mutex
+	now            func() time.Time
+	emitPressure   bool
+	emitHeaders    bool
+	prepaidSecret  []byte
+	costFunc       CostFunc
+	exclusions     *config.Exclusions
+	clientIP       KeyFunc
+	skipFunc       SkipFunc
+	mode           Mode
+	maxWait        time.Duration
+	methods        map[string]LimiterFactory
+	penaltyFunc    PenaltyFunc
+	shadow
+	hashKeys       atomic.Bool
+	pauseTracking  atomic.Bool
+	maxKeys        int
+	rejectNewKeys  bool
+	idleTTL        time.Duration
 }
 
 // LimiterFactory creates new rate limiters for each key
 type LimiterFactory func() RateLimiter
 
+// KeyedLimiterFactory creates the rate limiter for a key, for limiters that
+// need to know it, such as a RedisRateLimiter storing a bucket per key
+type KeyedLimiterFactory func(key string) RateLimiter
+
+// keyed adapts factory to a KeyedLimiterFactory that ignores the key
+func keyed(factory LimiterFactory) KeyedLimiterFactory {
+	return func(string) RateLimiter { return factory() }
+}
+
 // NewPerKeyHTTPRateLimiter creates a new per-key HTTP rate limiter
 func NewPerKeyHTTPRateLimiter(factory LimiterFactory, opts *Options) *PerKeyHTTPRateLimiter {
+	return NewPerKeyHTTPRateLimiterKeyed(keyed(factory), opts)
+}
+
+// NewPerKeyHTTPRateLimiterKeyed is like NewPerKeyHTTPRateLimiter but passes
+// each key to the factory
+func NewPerKeyHTTPRateLimiterKeyed(factory KeyedLimiterFactory, opts *Options) *PerKeyHTTPRateLimiter {
 	rl := &PerKeyHTTPRateLimiter{
-		limiterFactory: factory,
-		keyFunc:        DefaultKeyFunc,
-		errorHandler:   DefaultErrorHandler,
+		keyFunc:       DefaultKeyFunc,
+		errorHandler:  DefaultErrorHandler,
+		drainHandler:  DefaultDrainHandler,
+		pausedHandler: DefaultPausedHandler,
+		clientIP:      PeerIP,
+		limiters:      limiter.NewKeyedLimiter(factory),
+		now:           time.Now,
 	}
+	rl.limiters.SetEvictHook(rl.evictBoost)
 	
 	if opts != nil {
 		if opts.KeyFunc != nil {
@@ -118,69 +583,475 @@ func NewPerKeyHTTPRateLimiter(factory LimiterFactory, opts *Options) *PerKeyHTTP
 		if opts.ErrorHandler != nil {
 			rl.errorHandler = opts.ErrorHandler
 		}
+		if opts.PausedHandler != nil {
+			rl.pausedHandler = opts.PausedHandler
+		}
+		if opts.DrainHandler != nil {
+			rl.drainHandler = opts.DrainHandler
+		}
+		if opts.TopConsumers > 0 {
+			rl.topConsumers = stats.NewTopK(opts.TopConsumers)
+		}
+		rl.emitPressure = opts.EmitPressure
+		rl.emitHeaders = opts.EmitHeaders
+		rl.prepaidSecret = opts.PrepaidSecret
+		rl.costFunc = opts.CostFunc
+		rl.exclusions = opts.Exclusions
+		if opts.ClientIP != nil {
+			rl.clientIP = opts.ClientIP
+		}
+		rl.skipFunc = opts.SkipFunc
+		rl.mode = opts.Mode
+		rl.maxWait = opts.MaxWait
+		rl.dryRun = opts.DryRun
+		rl.onDecision = opts.OnDecision
+		rl.onAllowed = opts.OnAllowed
+		rl.onDenied = opts.OnDenied
+		rl.keyedStats = opts.KeyedStats
+		rl.penaltyFunc = opts.PenaltyFunc
+		if len(opts.MethodFactories) > 0 {
+			rl.methods = maps.Clone(opts.MethodFactories)
+			rl.limiters.SetFactory(rl.methodFactory(factory))
+		}
+		rl.maxKeys = max(opts.MaxKeys, 0)
+		rl.rejectNewKeys = opts.RejectNewKeys
+		rl.idleTTL = max(opts.IdleTTL, 0)
+		rl.limiters.SetIdleTTL(rl.idleTTL)
+		rl.limiters.SetMaxKeys(rl.maxKeys, rl.rejectNewKeys)
+		if opts.KeyRecorder != nil {
+			rl.limiters.SetKeyRecorder(opts.KeyRecorder)
+		}
 	}
 	
 	return rl
 }
 
+// Close stops evicting idle keys. The middleware keeps working. Close is
+// idempotent and always returns nil.
+func (rl *PerKeyHTTPRateLimiter) Close() error {
+	return rl.limiters.Close()
+}
+
+// getLimiter returns the limiter for key, creating it if necessary
+func (rl *PerKeyHTTPRateLimiter) getLimiter(key string) (RateLimiter, error) {
+	entry, err := rl.limiters.Entry(key)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Limiter(), nil
+}
+
+// admit returns the entry for key, or the handler to refuse the request
+// with if the key cannot have one. While draining, only keys that already
+// have a limiter are admitted and no new limiters are created. With
+// Options.RejectNewKeys, new keys beyond Options.MaxKeys are refused as
+// over the limit.
+func (rl *PerKeyHTTPRateLimiter) admit(key string) (*limiter.KeyEntry, ErrorHandler) {
+	if rl.draining.Load() {
+		if entry, ok := rl.limiters.Lookup(key); ok {
+			return entry, nil
+		}
+		return nil, rl.drainHandler
+	}
+	entry, err := rl.limiters.Entry(key)
+	if err != nil {
+		return nil, rl.errorHandler
+	}
+	return entry, nil
+}
+
+// SetFactory replaces the factory used to create limiters for keys seen
+// from now on. Keys that already have a limiter keep it. It panics if
+// factory is nil.
+func (rl *PerKeyHTTPRateLimiter) SetFactory(factory LimiterFactory) {
+	if factory == nil {
+		panic("middleware: nil limiter factory")
+	}
+	rl.SetKeyedFactory(keyed(factory))
+}
+
+// SetKeyedFactory is like SetFactory for a factory that is passed each key
+func (rl *PerKeyHTTPRateLimiter) SetKeyedFactory(factory KeyedLimiterFactory) {
+	if factory == nil {
+		panic("middleware: nil limiter factory")
+	}
+	rl.limiters.SetFactory(rl.methodFactory(factory))
+}
+
+// key returns the limiter key for r
+func (rl *PerKeyHTTPRateLimiter) key(r *http.Request) string {
+	return rl.LimiterKey(r.Method, rl.keyFunc(r))
+}
+
+// LimiterKey returns the key the middleware holds the limiter of requests
+// with the given method and client key, as KeyFunc reports it, under: the
+// client key hashed if key hashing is on and joined with the method if
+// Options.MethodFactories lists it. Pass it, rather than the client key,
+// to BoostKey, CancelBoost and the admin routes.
+func (rl *PerKeyHTTPRateLimiter) LimiterKey(method, key string) string {
+	if rl.hashKeys.Load() {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		key = strconv.FormatUint(h.Sum64(), 16)
+	}
+	return rl.methodKey(method, key)
+}
+
+// SetKeyHashing turns key hashing on or off. While on, keys are replaced by
+// a 64-bit hash before use, which bounds the memory each key takes when
+// clients send long or random keys. Hashed keys do not match the limiters,
+// boosts or top consumers of the original keys, so switching either way
+// gives every client a fresh limiter.
+func (rl *PerKeyHTTPRateLimiter) SetKeyHashing(enabled bool) {
+	rl.hashKeys.Store(enabled)
+}
+
+// SetConsumerTracking pauses or resumes counting requests for
+// TopConsumers. Counts gathered so far are kept.
+func (rl *PerKeyHTTPRateLimiter) SetConsumerTracking(enabled bool) {
+	rl.pauseTracking.Store(!enabled)
+}
+
+// SetKeyedStatsRecording pauses or resumes counting requests per key in
+// Options.KeyedStats. Counts gathered so far are kept.
+func (rl *PerKeyHTTPRateLimiter) SetKeyedStatsRecording(enabled bool) {
+	rl.pauseKeyedStats.Store(!enabled)
+}
+
+// KeyCount returns how many keys have a limiter
+func (rl *PerKeyHTTPRateLimiter) KeyCount() int {
+	return rl.limiters.Len()
+}
+
+// Evictions returns how many keys have had their limiter evicted for being
+// idle longer than Options.IdleTTL
+func (rl *PerKeyHTTPRateLimiter) Evictions() int64 {
+	return rl.limiters.Evictions()
+}
+
+// SetDraining turns drain mode on or off. While draining, requests from keys
+// that already have a limiter are limited as usual, but requests from keys
+// that have not been seen are answered by the drain handler, so new clients
+// move to other nodes during a rolling deploy.
+func (rl *PerKeyHTTPRateLimiter) SetDraining(draining bool) {
+	rl.draining.Store(draining)
+}
+
+// IsDraining reports whether drain mode is on
+func (rl *PerKeyHTTPRateLimiter) IsDraining() bool {
+	return rl.draining.Load()
+}
+
+// TopConsumers returns up to k keys with the most allowed requests since
+// creation or the last reset, with approximate counts. It returns nil unless
+// Options.TopConsumers was set.
+func (rl *PerKeyHTTPRateLimiter) TopConsumers(k int) []stats.KeyCount {
+	if rl.topConsumers == nil {
+		return nil
+	}
+	return rl.topConsumers.Top(k)
+}
+
+// ResetTopConsumers forgets the keys tracked for TopConsumers
+func (rl *PerKeyHTTPRateLimiter) ResetTopConsumers() {
+	if rl.topConsumers != nil {
+		rl.topConsumers.Reset()
+	}
+}
+
+// decide applies l to r in the configured mode
+func (rl *PerKeyHTTPRateLimiter) decide(r *http.Request, l RateLimiter) limiter.Decision {
+	if rl.mode == ModeWait {
+		start := time.Now()
+		decision, waited := decideWaiting(r, l, requestCost(rl.costFunc, r), rl.maxWait)
+		if waited {
+			rl.observeWait(r, time.Since(start))
+		}
+		return decision
+	}
+	return decide(l, requestCost(rl.costFunc, r))
+}
+
+// AddObserver makes o observe the middleware's decisions from now on
+func (rl *PerKeyHTTPRateLimiter) AddObserver(o Observer) {
+	rl.addObserver(o)
+}
+
 // Middleware returns an HTTP middleware function with per-key rate limiting
 func (rl *PerKeyHTTPRateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := rl.keyFunc(r)
-		
-		// Get or create limiter for this key
-		limiterInterface, _ := rl.limiters.LoadOrStore(key, rl.limiterFactory())
-		limiter := limiterInterface.(RateLimiter)
-		
-		if !limiter.Allow() {
-			rl.errorHandler(w, r)
-			return
+		if r, limiter, ok := rl.check(w, r); ok {
+			rl.serveNext(w, r, limiter, next.ServeHTTP)
 		}
-		next.ServeHTTP(w, r)
 	})
 }
 
 // MiddlewareFunc returns an HTTP middleware function for use with http.HandlerFunc
 func (rl *PerKeyHTTPRateLimiter) MiddlewareFunc(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		key := rl.keyFunc(r)
-		
-		// Get or create limiter for this key
-		limiterInterface, _ := rl.limiters.LoadOrStore(key, rl.limiterFactory())
-		limiter := limiterInterface.(RateLimiter)
-		
-		if !limiter.Allow() {
-			rl.errorHandler(w, r)
-			return
+		if r, limiter, ok := rl.check(w, r); ok {
+			rl.serveNext(w, r, limiter, next)
 		}
-		next(w, r)
 	}
 }
 
-// CustomErrorHandler creates an error handler with custom message and headers
+// check applies the limiter of r's key to r and reports whether r may
+// proceed, responding to it if not. The request to pass on carries the
+// decision's LimitInfo, and the limiter is the one that admitted it, or
+// nil if r was let through without asking one.
+func (rl *PerKeyHTTPRateLimiter) check(w http.ResponseWriter, r *http.Request) (*http.Request, RateLimiter, bool) {
+	prepaid := takePrepaid(r, rl.prepaidSecret)
+	if skips(r, rl.skipFunc, rl.exclusions, rl.clientIP) {
+		return r, nil, true
+	}
+	key := rl.key(r)
+	if rl.disabled.Load() {
+		// Only count against a limiter the key already has
+		if entry, ok := rl.limiters.Lookup(key); ok {
+			recordBypassed(entry.Limiter())
+		}
+		return r, nil, true
+	}
+	if isPrepaid(r, rl.prepaidSecret, prepaid, rl.keyFunc) {
+		// Only count against a limiter the key already has
+		if entry, ok := rl.limiters.Lookup(key); ok {
+			recordPrepaid(entry.Limiter())
+		}
+		return r, nil, true
+	}
+	entry, refuse := rl.admit(key)
+	if refuse != nil {
+		refuse(w, withLimitInfo(r, LimitInfo{Key: key}))
+		return r, nil, false
+	}
+	limiter := entry.Limiter()
+	rl.expireBoost(key, limiter)
+	decision := rl.decide(r, limiter)
+	entry.Observe(rl.now(), decision)
+	if rl.emitPressure {
+		setPressure(w, limiter)
+	}
+	if rl.emitHeaders {
+		setLimitHeaders(w, limiter, decision)
+	}
+	r = withLimitInfo(r, newLimitInfo(key, limiter, decision))
+	if rl.enforce(w, r, key, decision.Allowed) {
+		if isPaused(limiter) {
+			rl.pausedHandler(w, r)
+			return r, nil, false
+		}
+		setRetryAfter(w, decision.RetryAfter)
+		rl.errorHandler(w, r)
+		return r, nil, false
+	}
+	if rl.topConsumers != nil && !rl.pauseTracking.Load() {
+		rl.topConsumers.Observe(key)
+	}
+	return r, limiter, true
+}
+
+// CustomErrorHandler creates an error handler with custom message and
+// headers. The placeholders {retry_after}, the seconds to wait, {key} and
+// {limit} in message are replaced with the values of the request's
+// LimitInfo, or with nothing when the limiter does not know them. The body
+// is plain text, so a key holding markup or quotes is written as is.
 func CustomErrorHandler(message string, headers map[string]string) ErrorHandler {
+	return customErrorHandler(message, headers, http.StatusTooManyRequests)
+}
+
+// customErrorHandler is CustomErrorHandler responding with status
+func customErrorHandler(message string, headers map[string]string, status int) ErrorHandler {
+	placeholders := strings.Contains(message, "{")
+	return func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range headers {
+			w.Header().Set(k, v)
+		}
+		info, ok := InfoFromContext(r.Context())
+		if ok && w.Header().Get("Retry-After") == "" {
+			setRetryAfter(w, info.RetryAfter)
+		}
+		body := message
+		if placeholders {
+			body = expandPlaceholders(message, info)
+		}
+		http.Error(w, body, status)
+	}
+}
+
+// expandPlaceholders replaces the placeholders of a CustomErrorHandler
+// message in one pass, so placeholders within the values are left alone
+func expandPlaceholders(message string, info LimitInfo) string {
+	var retryAfter, limit string
+	if info.RetryAfter > 0 {
+		retryAfter = strconv.Itoa(int(math.Ceil(info.RetryAfter.Seconds())))
+	}
+	if info.Limit > 0 {
+		limit = strconv.Itoa(info.Limit)
+	}
+	return strings.NewReplacer("{retry_after}", retryAfter, "{key}", info.Key, "{limit}", limit).Replace(message)
+}
+
+// TemplateErrorHandler creates an error handler that renders tmpl with a
+// config.ResponseData built from the request's LimitInfo
+func TemplateErrorHandler(tmpl *template.Template, contentType, docsURL string, headers map[string]string) ErrorHandler {
+	return templateErrorHandler(tmpl, contentType, docsURL, headers, http.StatusTooManyRequests)
+}
+
+// templateErrorHandler is TemplateErrorHandler responding with status
+func templateErrorHandler(tmpl *template.Template, contentType, docsURL string, headers map[string]string, status int) ErrorHandler {
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
+		data := config.ResponseData{DocsURL: docsURL}
+		if info, ok := InfoFromContext(r.Context()); ok {
+			data.Key = info.Key
+			data.Name = info.LimiterName
+			if info.Limit > 0 {
+				data.Limit = strconv.Itoa(info.Limit)
+				data.Remaining = strconv.Itoa(info.Remaining)
+			}
+			if info.RetryAfter > 0 {
+				data.RetryAfterSeconds = strconv.Itoa(int(math.Ceil(info.RetryAfter.Seconds())))
+			}
+		}
+
+		var body bytes.Buffer
+		if err := tmpl.Execute(&body, data); err != nil {
+			DefaultErrorHandler(w, r)
+			return
+		}
+
 		for k, v := range headers {
 			w.Header().Set(k, v)
 		}
-		http.Error(w, message, http.StatusTooManyRequests)
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		w.Write(body.Bytes())
+	}
+}
+
+// ErrorHandlerFromConfig builds the error handler described by cfg: a
+// template handler when ResponseTemplate is set, otherwise a custom handler
+// with ErrorMessage and CustomHeaders. Either responds with ErrorStatusCode
+// and a Retry-After following RetryAfterMode.
+func ErrorHandlerFromConfig(cfg *config.Config) (ErrorHandler, error) {
+	status := cfg.ErrorStatusCode
+	if status == 0 {
+		status = http.StatusTooManyRequests
+	}
+	retryAfter, static, err := config.ParseRetryAfterMode(cfg.RetryAfterMode)
+	if err != nil {
+		return nil, err
+	}
+
+	var handler ErrorHandler
+	if cfg.ResponseTemplate == "" {
+		message := cfg.ErrorMessage
+		if message == "" {
+			message = "Too Many Requests"
+		}
+		handler = customErrorHandler(message, cfg.CustomHeaders, status)
+	} else {
+		tmpl, err := config.ParseResponseTemplate(cfg.ResponseTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response template: %w", err)
+		}
+		handler = templateErrorHandler(tmpl, cfg.ResponseContentType, cfg.DocsURL, cfg.CustomHeaders, status)
+	}
+	if !static {
+		return handler, nil
 	}
+
+	value := strconv.Itoa(int(retryAfter.Seconds()))
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Replaces the limiter's estimate set before the handler runs
+		w.Header().Set("Retry-After", value)
+		handler(w, r)
+	}, nil
 }
 
-// JSONErrorHandler returns a JSON error response
+// JSONErrorHandler returns a JSON error response, with the time to wait
+// when the limiter knows it:
+//
+//	{"error":"too many requests","status":429,"retry_after_ms":1234}
 func JSONErrorHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusTooManyRequests)
+	if info, ok := InfoFromContext(r.Context()); ok && info.RetryAfter > 0 {
+		fmt.Fprintf(w, `{"error":"too many requests","status":429,"retry_after_ms":%d}`, retryAfterMS(info.RetryAfter))
+		return
+	}
 	fmt.Fprintf(w, `{"error":"too many requests","status":429}`)
 }
 
+// retryAfterMS converts d to milliseconds, rounding up so clients never
+// retry before the limiter's estimate
+func retryAfterMS(d time.Duration) int64 {
+	return (d + time.Millisecond - 1).Milliseconds()
+}
+
+// Field names used in the body written by JSONErrorHandlerInfo
+const (
+	JSONFieldError        = "error"
+	JSONFieldRetryAfterMS = "retry_after_ms"
+	JSONFieldLimit        = "limit"
+	JSONFieldRemaining    = "remaining"
+	JSONFieldPolicy       = "policy"
+)
+
+// JSONErrorHandlerInfo returns a JSON error response with machine-readable
+// retry guidance taken from the request's LimitInfo, for example
+//
+//	{"error":"too_many_requests","limit":100,"policy":"100;w=60","remaining":0,"retry_after_ms":1234}
+//
+// Fields the limiter cannot provide are omitted. JSONErrorHandler keeps
+// writing the original body, plus the retry delay.
+func JSONErrorHandlerInfo(w http.ResponseWriter, r *http.Request) {
+	body := map[string]any{JSONFieldError: "too_many_requests"}
+	if info, ok := InfoFromContext(r.Context()); ok {
+		if info.RetryAfter > 0 {
+			body[JSONFieldRetryAfterMS] = retryAfterMS(info.RetryAfter)
+		}
+		if info.Limit > 0 {
+			body[JSONFieldLimit] = info.Limit
+			body[JSONFieldRemaining] = info.Remaining
+			if info.Window > 0 {
+				body[JSONFieldPolicy] = fmt.Sprintf("%d;w=%d", info.Limit, int(math.Ceil(info.Window.Seconds())))
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(body)
+}
+
 // KeyFuncs provides common key extraction functions
 var KeyFuncs = struct {
 	ByIP        KeyFunc
 	ByUserID    func(headerName string) KeyFunc
 	ByAPIKey    func(headerName string) KeyFunc
 	ByPath      KeyFunc
+	// Combination joins the keys of funcs with CombinationSeparator,
+	// escaping it and backslashes inside keys with a backslash, so
+	// different combinations cannot collide. Keys used to be formatted
+	// like "[/api/data user456]", so limiters and stats keyed by a
+	// combination start afresh after upgrading.
 	Combination func(funcs ...KeyFunc) KeyFunc
+	// CombinationSep is like Combination with the separator sep, which
+	// must be non-empty and contain no backslash
+	CombinationSep func(sep string, funcs ...KeyFunc) KeyFunc
+	// ByJWTClaim keys requests by a claim of their bearer JWT; see
+	// JWTClaimKeyFunc. Requests without the claim share "anonymous".
+	ByJWTClaim func(claim string) KeyFunc
+	// ByBasicAuthUser keys requests by their basic auth username, or
+	// "anonymous" without one
+	ByBasicAuthUser func() KeyFunc
+	// ByClientCert keys requests by their TLS client certificate; see
+	// ClientCertKeyFunc
+	ByClientCert func() KeyFunc
 }{
 	ByIP: DefaultKeyFunc,
 	
@@ -207,12 +1078,27 @@ var KeyFuncs = struct {
 	},
 	
 	Combination: func(funcs ...KeyFunc) KeyFunc {
+		return combineKeys(CombinationSeparator, funcs)
+	},
+
+	CombinationSep: func(sep string, funcs ...KeyFunc) KeyFunc {
+		return combineKeys(sep, funcs)
+	},
+
+	ByJWTClaim: func(claim string) KeyFunc {
+		return JWTClaimKeyFunc(claim, "anonymous")
+	},
+
+	ByBasicAuthUser: func() KeyFunc {
 		return func(r *http.Request) string {
-			var keys []string
-			for _, fn := range funcs {
-				keys = append(keys, fn(r))
+			if user, _, ok := r.BasicAuth(); ok && user != "" {
+				return user
 			}
-			return fmt.Sprintf("%v", keys)
+			return "anonymous"
 		}
 	},
+
+	ByClientCert: func() KeyFunc {
+		return ClientCertKeyFunc
+	},
 }
\ No newline at end of file
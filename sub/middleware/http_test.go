@@ -1,12 +1,20 @@
 package middleware
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/limiter"
+	"github.com/rRateLimit/arg/sub/stats"
 )
 
 // mockRateLimiter is a mock implementation of RateLimiter for testing
@@ -189,6 +197,27 @@ func TestCustomErrorHandler(t *testing.T) {
 	}
 }
 
+func TestCustomErrorHandlerPlaceholders(t *testing.T) {
+	handler := CustomErrorHandler("Limit {limit} exceeded for {key}, retry in {retry_after}s {unknown}", nil)
+	tests := []struct {
+		info LimitInfo
+		want string
+	}{
+		{LimitInfo{Key: "alice", Limit: 100, RetryAfter: 1500 * time.Millisecond}, "Limit 100 exceeded for alice, retry in 2s {unknown}\n"},
+		{LimitInfo{Key: "{limit}<b>"}, "Limit  exceeded for {limit}<b>, retry in s {unknown}\n"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler(rec, withLimitInfo(httptest.NewRequest("GET", "/", nil), tt.info))
+		if rec.Body.String() != tt.want {
+			t.Errorf("Expected body %q, got %q", tt.want, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" || rec.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected a plain text 429, got %d %s", rec.Code, ct)
+		}
+	}
+}
+
 func TestJSONErrorHandler(t *testing.T) {
 	mock := &mockRateLimiter{allowReturn: false}
 	
@@ -368,4 +397,671 @@ func TestConcurrentRequests(t *testing.T) {
 	if mock.getCallCount() != 100 {
 		t.Errorf("Expected 100 calls to Allow(), got %d", mock.getCallCount())
 	}
-}
\ No newline at end of file
+}
+
+// namedMockRateLimiter is a mock rate limiter that carries a name
+type namedMockRateLimiter struct {
+	mockRateLimiter
+	name string
+}
+
+func (m *namedMockRateLimiter) Name() string {
+	return m.name
+}
+
+func (m *namedMockRateLimiter) Wait() {}
+
+func TestLimiterNameEndToEnd(t *testing.T) {
+	named := &namedMockRateLimiter{name: "search-api"}
+	wrapped := stats.NewRateLimiterWithStats(named)
+
+	var handlerName string
+	opts := &Options{
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request) {
+			if info, ok := InfoFromContext(r.Context()); ok {
+				handlerName = info.LimiterName
+			}
+			DefaultErrorHandler(w, r)
+		},
+	}
+
+	rl := NewHTTPRateLimiter(wrapped, opts)
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called")
+	}))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if handlerName != "search-api" {
+		t.Errorf("Expected error handler to see name 'search-api', got %q", handlerName)
+	}
+
+	snapshot := wrapped.GetStats().GetSnapshot()
+	if snapshot.Name != "search-api" {
+		t.Errorf("Expected stats name 'search-api', got %q", snapshot.Name)
+	}
+	if snapshot.DeniedRequests != 1 {
+		t.Errorf("Expected 1 denied request in stats, got %d", snapshot.DeniedRequests)
+	}
+}
+
+func TestPerKeyLimitInfo(t *testing.T) {
+	factory := func() RateLimiter {
+		return &namedMockRateLimiter{name: "per-user"}
+	}
+
+	var info LimitInfo
+	opts := &Options{
+		KeyFunc: KeyFuncs.ByUserID("X-User-ID"),
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request) {
+			info, _ = InfoFromContext(r.Context())
+			DefaultErrorHandler(w, r)
+		},
+	}
+
+	rl := NewPerKeyHTTPRateLimiter(factory, opts)
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-User-ID", "alice")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if info.Key != "alice" || info.LimiterName != "per-user" {
+		t.Errorf("Expected info {alice per-user}, got %+v", info)
+	}
+}
+
+func TestLimitInfoOnAllowAndDeny(t *testing.T) {
+	var allowed, denied LimitInfo
+	var allowedOK, deniedOK bool
+	opts := &Options{
+		KeyFunc: KeyFuncs.ByUserID("X-User-ID"),
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request) {
+			denied, deniedOK = InfoFromContext(r.Context())
+			DefaultErrorHandler(w, r)
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, allowedOK = InfoFromContext(r.Context())
+	})
+	handlers := map[string]http.Handler{
+		"global":  NewHTTPRateLimiter(limiter.NewRateLimiter(1, 2), opts).Middleware(next),
+		"per-key": NewPerKeyHTTPRateLimiter(func() RateLimiter { return limiter.NewRateLimiter(1, 2) }, opts).Middleware(next),
+	}
+
+	for name, handler := range handlers {
+		allowedOK, deniedOK = false, false
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-User-ID", "alice")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		wantKey := "alice"
+		if name == "global" {
+			wantKey = ""
+		}
+		// The second request narrowly passed with nothing left
+		if !allowedOK || allowed.Key != wantKey || allowed.Limit != 2 || allowed.Remaining != 0 {
+			t.Errorf("%s: expected the next handler to see the decision, got %+v, %v", name, allowed, allowedOK)
+		}
+		if !deniedOK || denied.Key != wantKey || denied.Limit != 2 || denied.RetryAfter <= 0 {
+			t.Errorf("%s: expected the error handler to see the denial, got %+v, %v", name, denied, deniedOK)
+		}
+	}
+}
+
+func TestErrorHandlersIncludeRetryAfter(t *testing.T) {
+	for name, handler := range map[string]ErrorHandler{
+		"custom": CustomErrorHandler("slow down", nil),
+		"json":   JSONErrorHandler,
+	} {
+		rec := httptest.NewRecorder()
+		req := withLimitInfo(httptest.NewRequest("GET", "/", nil), LimitInfo{RetryAfter: 1500 * time.Millisecond})
+		handler(rec, req)
+		if got := rec.Header().Get("Retry-After"); name == "custom" && got != "2" {
+			t.Errorf("Expected the custom handler to set Retry-After 2, got %q", got)
+		}
+		if body := rec.Body.String(); name == "json" && body != `{"error":"too many requests","status":429,"retry_after_ms":1500}` {
+			t.Errorf("Expected the JSON body to include the retry delay, got %s", body)
+		}
+	}
+}
+
+func TestPerKeyDraining(t *testing.T) {
+	factory := func() RateLimiter {
+		return &mockRateLimiter{allowReturn: true}
+	}
+
+	rl := NewPerKeyHTTPRateLimiter(factory, &Options{KeyFunc: KeyFuncs.ByUserID("X-User-ID")})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-User-ID", user)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("known"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 before draining, got %d", rec.Code)
+	}
+
+	rl.SetDraining(true)
+	if !rl.IsDraining() {
+		t.Error("Expected IsDraining to report true")
+	}
+
+	if rec := send("known"); rec.Code != http.StatusOK {
+		t.Errorf("Expected known key to be served while draining, got %d", rec.Code)
+	}
+
+	rec := send("unknown")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 for unknown key while draining, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header on drain response")
+	}
+
+	// Unknown keys must not get a limiter, so they stay unknown
+	send("unknown")
+	if _, ok := rl.limiters.Lookup("unknown"); ok {
+		t.Error("Expected no limiter to be created for unknown key while draining")
+	}
+
+	rl.SetDraining(false)
+	if rec := send("unknown"); rec.Code != http.StatusOK {
+		t.Errorf("Expected unknown key to be admitted after draining stops, got %d", rec.Code)
+	}
+}
+
+func TestPerKeyDrainHandlerOption(t *testing.T) {
+	var drainedKey string
+	opts := &Options{
+		KeyFunc: KeyFuncs.ByUserID("X-User-ID"),
+		DrainHandler: func(w http.ResponseWriter, r *http.Request) {
+			info, _ := InfoFromContext(r.Context())
+			drainedKey = info.Key
+			http.Error(w, "gone", http.StatusGone)
+		},
+	}
+
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return &mockRateLimiter{allowReturn: true} }, opts)
+	rl.SetDraining(true)
+	handler := rl.MiddlewareFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called")
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-User-ID", "newcomer")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusGone {
+		t.Errorf("Expected custom drain status %d, got %d", http.StatusGone, rec.Code)
+	}
+	if drainedKey != "newcomer" {
+		t.Errorf("Expected drain handler to see key 'newcomer', got %q", drainedKey)
+	}
+}
+
+func TestPerKeyTopConsumers(t *testing.T) {
+	opts := &Options{
+		KeyFunc:      KeyFuncs.ByUserID("X-User-ID"),
+		TopConsumers: 4,
+	}
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return &mockRateLimiter{allowReturn: true} }, opts)
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	traffic := map[string]int{"heavy": 50, "medium": 20, "light": 5}
+	for user, count := range traffic {
+		for i := 0; i < count; i++ {
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.Header.Set("X-User-ID", user)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+
+	top := rl.TopConsumers(2)
+	if len(top) != 2 || top[0].Key != "heavy" || top[0].Count != 50 || top[1].Key != "medium" {
+		t.Errorf("Expected heavy then medium, got %+v", top)
+	}
+
+	rl.ResetTopConsumers()
+	if top := rl.TopConsumers(2); len(top) != 0 {
+		t.Errorf("Expected no consumers after reset, got %+v", top)
+	}
+
+	disabled := NewPerKeyHTTPRateLimiter(func() RateLimiter { return &mockRateLimiter{allowReturn: true} }, nil)
+	if disabled.TopConsumers(2) != nil {
+		t.Error("Expected nil TopConsumers when tracking is disabled")
+	}
+}
+
+func TestErrorHandlerFromConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Name = "search"
+	cfg.ResponseTemplate = `{"key":{{json .Key}},"limit":"{{.Limit}}","docs":"{{.DocsURL}}"}`
+	cfg.ResponseContentType = "application/json"
+	cfg.DocsURL = "https://example.com/limits"
+	cfg.CustomHeaders = map[string]string{"X-Policy": "search"}
+
+	errorHandler, err := ErrorHandlerFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("ErrorHandlerFromConfig failed: %v", err)
+	}
+
+	factory := func() RateLimiter { return &mockRateLimiter{allowReturn: false} }
+	rl := NewPerKeyHTTPRateLimiter(factory, &Options{
+		KeyFunc:      KeyFuncs.ByUserID("X-User-ID"),
+		ErrorHandler: errorHandler,
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-User-ID", `user "1"`)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %s", rec.Header().Get("Content-Type"))
+	}
+	if rec.Header().Get("X-Policy") != "search" {
+		t.Errorf("Expected custom header, got %q", rec.Header().Get("X-Policy"))
+	}
+
+	expected := `{"key":"user \"1\"","limit":"","docs":"https://example.com/limits"}`
+	if rec.Body.String() != expected {
+		t.Errorf("Expected body %s, got %s", expected, rec.Body.String())
+	}
+}
+
+func TestErrorHandlerFromConfigPlain(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ErrorMessage = "Slow down"
+
+	errorHandler, err := ErrorHandlerFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("ErrorHandlerFromConfig failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	errorHandler(rec, httptest.NewRequest("GET", "/test", nil))
+	if !strings.Contains(rec.Body.String(), "Slow down") {
+		t.Errorf("Expected configured error message, got %q", rec.Body.String())
+	}
+
+	cfg.ResponseTemplate = "{{.Missing}}"
+	if _, err := ErrorHandlerFromConfig(cfg); err == nil {
+		t.Error("Expected error for an invalid response template")
+	}
+}
+
+func TestRetryAfterAcrossComposedLimiters(t *testing.T) {
+	newWindow := func(count int, window time.Duration) *limiter.MultiWindowLimiter {
+		l, err := limiter.NewMultiWindowLimiter(limiter.WindowLimit{Count: count, Window: window})
+		if err != nil {
+			t.Fatalf("NewMultiWindowLimiter failed: %v", err)
+		}
+		return l
+	}
+
+	tests := []struct {
+		name       string
+		perSecond  int
+		perMinute  int
+		perHour    int
+		requests   int
+		minSeconds int
+		maxSeconds int
+	}{
+		{
+			name:      "per-second layer exhausted",
+			perSecond: 3, perMinute: 50, perHour: 100,
+			requests:   3,
+			minSeconds: 1, maxSeconds: 2,
+		},
+		{
+			name:      "per-minute layer exhausted",
+			perSecond: 50, perMinute: 3, perHour: 100,
+			requests:   3,
+			minSeconds: 80, maxSeconds: 81,
+		},
+		{
+			name:      "per-hour layer exhausted",
+			perSecond: 50, perMinute: 50, perHour: 3,
+			requests:   3,
+			minSeconds: 4800, maxSeconds: 4801,
+		},
+		{
+			name:      "second and hour exhausted, first asked denies soonest",
+			perSecond: 3, perMinute: 50, perHour: 3,
+			requests:   3,
+			minSeconds: 4800, maxSeconds: 4801,
+		},
+	}
+
+	// Sliding windows with 3 requests recorded free a slot a third of the
+	// way into the next window, so the expected waits are 4/3 of a window
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			composed := limiter.NewMultiLimiter(
+				newWindow(tt.perSecond, time.Second),
+				limiter.NewMultiLimiter(
+					newWindow(tt.perMinute, time.Minute),
+					newWindow(tt.perHour, time.Hour),
+				),
+			)
+
+			rl := NewHTTPRateLimiter(composed, nil)
+			handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			for i := 0; i < tt.requests; i++ {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("Expected request %d to be allowed, got %d", i, rec.Code)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+			}
+
+			retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+			if err != nil {
+				t.Fatalf("Expected numeric Retry-After, got %q", rec.Header().Get("Retry-After"))
+			}
+			if retryAfter < tt.minSeconds || retryAfter > tt.maxSeconds {
+				t.Errorf("Expected Retry-After between %d and %d, got %d", tt.minSeconds, tt.maxSeconds, retryAfter)
+			}
+		})
+	}
+}
+
+func TestNoRetryAfterWithoutIntrospection(t *testing.T) {
+	rl := NewHTTPRateLimiter(&mockRateLimiter{allowReturn: false}, nil)
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+	if rec.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected no Retry-After for a limiter without introspection, got %q", rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestJSONErrorHandlerInfo(t *testing.T) {
+	decode := func(t *testing.T, l RateLimiter, requests int) map[string]any {
+		t.Helper()
+		rl := NewHTTPRateLimiter(l, &Options{ErrorHandler: JSONErrorHandlerInfo})
+		handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		var rec *httptest.ResponseRecorder
+		for i := 0; i < requests; i++ {
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+		}
+
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+		}
+		if rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Expected Content-Type application/json, got %s", rec.Header().Get("Content-Type"))
+		}
+
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode body %q: %v", rec.Body.String(), err)
+		}
+		return body
+	}
+
+	t.Run("with introspection", func(t *testing.T) {
+		l, err := limiter.NewMultiWindowLimiter(limiter.WindowLimit{Count: 2, Window: time.Minute})
+		if err != nil {
+			t.Fatalf("NewMultiWindowLimiter failed: %v", err)
+		}
+		body := decode(t, l, 3)
+
+		if body[JSONFieldError] != "too_many_requests" {
+			t.Errorf("Expected error too_many_requests, got %v", body[JSONFieldError])
+		}
+		if body[JSONFieldLimit] != float64(2) || body[JSONFieldRemaining] != float64(0) {
+			t.Errorf("Expected limit 2 and remaining 0, got %v and %v", body[JSONFieldLimit], body[JSONFieldRemaining])
+		}
+		if body[JSONFieldPolicy] != "2;w=60" {
+			t.Errorf("Expected policy 2;w=60, got %v", body[JSONFieldPolicy])
+		}
+		if ms, ok := body[JSONFieldRetryAfterMS].(float64); !ok || ms <= 0 {
+			t.Errorf("Expected positive retry_after_ms, got %v", body[JSONFieldRetryAfterMS])
+		}
+	})
+
+	t.Run("without introspection", func(t *testing.T) {
+		body := decode(t, &mockRateLimiter{allowReturn: false}, 1)
+
+		if body[JSONFieldError] != "too_many_requests" {
+			t.Errorf("Expected error too_many_requests, got %v", body[JSONFieldError])
+		}
+		for _, field := range []string{JSONFieldRetryAfterMS, JSONFieldLimit, JSONFieldRemaining, JSONFieldPolicy} {
+			if _, ok := body[field]; ok {
+				t.Errorf("Expected %s to be omitted, got %v", field, body[field])
+			}
+		}
+	})
+}
+
+func TestTemplateErrorHandlerWithIntrospection(t *testing.T) {
+	tmpl, err := config.ParseResponseTemplate("{{.Limit}}/{{.Remaining}}/{{.RetryAfterSeconds}}")
+	if err != nil {
+		t.Fatalf("ParseResponseTemplate failed: %v", err)
+	}
+	l, err := limiter.NewMultiWindowLimiter(limiter.WindowLimit{Count: 1, Window: time.Minute})
+	if err != nil {
+		t.Fatalf("NewMultiWindowLimiter failed: %v", err)
+	}
+
+	rl := NewHTTPRateLimiter(l, &Options{ErrorHandler: TemplateErrorHandler(tmpl, "", "", nil)})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+	if !strings.HasPrefix(rec.Body.String(), "1/0/") || rec.Body.String() == "1/0/" {
+		t.Errorf("Expected limit, remaining and retry-after in body, got %q", rec.Body.String())
+	}
+}
+
+func TestCapabilitiesForwardedThroughWrappers(t *testing.T) {
+	// Three layers: stats around a renamed stats wrapper around a composite
+	// of token buckets
+	bucket := limiter.NewRateLimiter(1, 4)
+	inner := stats.NewRateLimiterWithStats(limiter.NewMultiLimiter(bucket, limiter.NewRateLimiter(100, 100)))
+	inner.SetName("search-api")
+	outer := stats.NewRateLimiterWithStats(inner)
+
+	var l RateLimiter = outer
+	if got := limiterName(l); got != "search-api" {
+		t.Errorf("Expected name search-api, got %q", got)
+	}
+	if !limiter.AllowN(l, 3) {
+		t.Fatal("Expected 3 of 4 tokens to be allowed")
+	}
+	if limiter.AllowN(l, 2) {
+		t.Error("Expected AllowN to reach the bucket and deny 2 tokens")
+	}
+	if retryAfter := limiter.RetryAfter(l); retryAfter != 0 {
+		t.Errorf("Expected no wait for 1 token, got %v", retryAfter)
+	}
+	if health, ok := limiter.HealthFraction(l); !ok || health > 0.3 {
+		t.Errorf("Expected health of about 0.25, got %v %v", health, ok)
+	}
+
+	// The cost reaches the bucket through the middleware too
+	rl := NewHTTPRateLimiter(l, &Options{
+		CostFunc: func(r *http.Request) int { return 2 },
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After for a cost of 2, got %d %v", rec.Code, rec.Header())
+	}
+
+	// And waiting reaches the bucket's context-aware wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.Allow()
+	if err := limiter.WaitContext(ctx, l); err != context.Canceled {
+		t.Errorf("Expected context.Canceled from an empty bucket, got %v", err)
+	}
+
+	snapshot := outer.GetStats().GetSnapshot()
+	if snapshot.Name != "search-api" || snapshot.AllowedRequests != 2 || snapshot.DeniedRequests != 2 {
+		t.Errorf("Expected 2 allowed and 2 denied for search-api, got %+v", snapshot)
+	}
+}
+
+func TestRetryAfterFromTokenBucket(t *testing.T) {
+	rl := limiter.NewRateLimiterPer(1, 10*time.Second, 1)
+	rl.Allow()
+
+	handler := NewHTTPRateLimiter(rl, nil).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "10" {
+		t.Errorf("Expected 429 with Retry-After 10, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestPausedLimiterReturns503(t *testing.T) {
+	rl := limiter.NewRateLimiter(10, 10)
+	rl.Pause()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handlers := map[string]http.Handler{
+		"global":  NewHTTPRateLimiter(stats.NewRateLimiterWithStats(rl), nil).Middleware(next),
+		"per-key": NewPerKeyHTTPRateLimiter(func() RateLimiter { return rl }, nil).Middleware(next),
+	}
+	for name, handler := range handlers {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "" {
+			t.Errorf("%s: expected 503 without Retry-After, got %d %q", name, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+
+	rl.Resume()
+	rec := httptest.NewRecorder()
+	handlers["global"].ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 after resume, got %d", rec.Code)
+	}
+}
+
+func TestKeyedFactory(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	factory := func(key string) RateLimiter {
+		mu.Lock()
+		keys = append(keys, key)
+		mu.Unlock()
+		return &namedMockRateLimiter{mockRateLimiter: mockRateLimiter{allowReturn: true}, name: "bucket:" + key}
+	}
+	rl := NewPerKeyHTTPRateLimiterKeyed(factory, &Options{
+		KeyFunc: func(r *http.Request) string { return r.Header.Get("X-User") },
+	})
+
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, user := range []string{"alice", "bob", "alice"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", user)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if len(keys) != 2 || keys[0] != "alice" || keys[1] != "bob" {
+		t.Errorf("Expected the factory to be called once per key with the key, got %v", keys)
+	}
+	l, _ := rl.getLimiter("bob")
+	if name := limiterName(l); name != "bucket:bob" {
+		t.Errorf("Expected bob's limiter, got %q", name)
+	}
+}
+
+func TestEmitHeaders(t *testing.T) {
+	l := limiter.NewRateLimiter(1, 2)
+	handler := NewHTTPRateLimiter(l, &Options{EmitHeaders: true}).Middleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	want := []struct {
+		code                    int
+		remaining, reset, retry string
+	}{
+		{http.StatusOK, "1", "1", ""},
+		{http.StatusOK, "0", "2", ""},
+		{http.StatusTooManyRequests, "0", "2", "1"},
+	}
+	for i, w := range want {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		h := rec.Header()
+		if rec.Code != w.code || h.Get("X-RateLimit-Limit") != "2" || h.Get("X-RateLimit-Remaining") != w.remaining ||
+			h.Get("X-RateLimit-Reset") != w.reset || h.Get("Retry-After") != w.retry {
+			t.Errorf("Request %d: expected %d with remaining %s, reset %s and Retry-After %q, got %d with %v",
+				i, w.code, w.remaining, w.reset, w.retry, rec.Code, h)
+		}
+	}
+}
+
+func TestEmitHeadersPerKey(t *testing.T) {
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter {
+		return limiter.NewRateLimiter(5, 5)
+	}, &Options{EmitHeaders: true, KeyFunc: KeyFuncs.ByPath})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/a", nil))
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "4" {
+		t.Errorf("Expected 4 remaining, got %q", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Limit"); got != "5" {
+		t.Errorf("Expected a limit of 5, got %q", got)
+	}
+}
+
+func TestEmitHeadersWithoutDetails(t *testing.T) {
+	for name, opts := range map[string]*Options{
+		"limiter without details": {EmitHeaders: true},
+		"headers off":             nil,
+	} {
+		var l RateLimiter = &mockRateLimiter{allowReturn: true}
+		if opts == nil {
+			l = limiter.NewRateLimiter(1, 2)
+		}
+		handler := NewHTTPRateLimiter(l, opts).Middleware(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", name, rec.Code)
+		}
+		for _, header := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"} {
+			if v := rec.Header().Get(header); v != "" {
+				t.Errorf("%s: expected no %s, got %q", name, header, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// ClientCertKeyFunc keys requests by the client certificate of their mTLS
+// connection: the subject common name if the certificate has one, otherwise
+// "spki:" and the hex SHA-256 of its subject public key info, which stays
+// the same when a certificate is renewed with the same key. Requests
+// without TLS or without a client certificate share the key
+// "no-client-cert". The certificate is only as trustworthy as the server's
+// tls.Config makes it: require and verify client certificates there.
+func ClientCertKeyFunc(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "no-client-cert"
+	}
+	cert := r.TLS.PeerCertificates[0]
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "spki:" + hex.EncodeToString(sum[:])
+}
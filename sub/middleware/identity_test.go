@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestByBasicAuthUser(t *testing.T) {
+	fn := KeyFuncs.ByBasicAuthUser()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("alice", "secret")
+	if got := fn(req); got != "alice" {
+		t.Errorf("Expected alice, got %q", got)
+	}
+
+	for _, authorization := range []string{"", "Basic !!!", "Bearer token"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		if got := fn(req); got != "anonymous" {
+			t.Errorf("%q: expected anonymous, got %q", authorization, got)
+		}
+	}
+
+	combined := KeyFuncs.Combination(fn, KeyFuncs.ByPath)
+	req = httptest.NewRequest("GET", "/reports", nil)
+	req.SetBasicAuth("alice", "secret")
+	if got := combined(req); got != "alice|/reports" {
+		t.Errorf("Expected the user combined with the path, got %q", got)
+	}
+}
+
+// clientCert creates a self-signed client certificate with the given common
+// name
+func clientCert(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestByClientCert(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, KeyFuncs.ByClientCert()(r))
+	}))
+	// The certificates are self-signed, so ask for them without verifying
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(certs ...tls.Certificate) string {
+		t.Helper()
+		// A fresh transport per call, so each request has its own handshake
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		defer transport.CloseIdleConnections()
+		transport.TLSClientConfig.Certificates = certs
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if got := get(clientCert(t, "billing-service")); got != "billing-service" {
+		t.Errorf("Expected the common name, got %q", got)
+	}
+	anonymous := clientCert(t, "")
+	sum := sha256.Sum256(anonymous.Leaf.RawSubjectPublicKeyInfo)
+	if got, want := get(anonymous), "spki:"+hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("Expected the SPKI hash %q, got %q", want, got)
+	}
+	if got := get(); got != "no-client-cert" {
+		t.Errorf("Expected no-client-cert without a certificate, got %q", got)
+	}
+
+	// Plain HTTP has no TLS state at all
+	if got := KeyFuncs.ByClientCert()(httptest.NewRequest("GET", "/", nil)); got != "no-client-cert" {
+		t.Errorf("Expected no-client-cert without TLS, got %q", got)
+	}
+}
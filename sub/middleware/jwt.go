@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// JWTClaimKeyFunc returns a KeyFunc that keys requests by a claim of the
+// bearer JWT in their Authorization header, such as "sub", or by fallback if
+// the request has no token, the token is malformed or the claim is missing.
+// The signature is not verified: the claim only picks a bucket, so verify
+// tokens before trusting them for anything else. String claims are used as
+// they are and numbers and booleans in their JSON form; other claims fall
+// back.
+func JWTClaimKeyFunc(claim, fallback string) KeyFunc {
+	return func(r *http.Request) string {
+		if key, ok := jwtClaim(r, claim); ok {
+			return key
+		}
+		return fallback
+	}
+}
+
+// jwtClaim returns the value of claim in r's bearer token as a string
+func jwtClaim(r *http.Request, claim string) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return "", false
+	}
+
+	var claims map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return "", false
+	}
+	switch value := claims[claim].(type) {
+	case string:
+		return value, value != ""
+	case json.Number:
+		return value.String(), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+	return "", false
+}
+
+// decodeSegment decodes a JWT segment, which should be unpadded base64url,
+// tolerating padding and the standard alphabet that some issuers use
+func decodeSegment(segment string) ([]byte, error) {
+	segment = strings.TrimRight(segment, "=")
+	if decoded, err := base64.RawURLEncoding.DecodeString(segment); err == nil {
+		return decoded, nil
+	}
+	return base64.RawStdEncoding.DecodeString(segment)
+}
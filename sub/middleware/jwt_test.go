@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+)
+
+// jwt builds an unsigned token with the given JSON payload
+func jwt(payload string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln"
+}
+
+func TestByJWTClaim(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		claim         string
+		want          string
+	}{
+		{"present claim", "Bearer " + jwt(`{"sub":"user-42"}`), "sub", "user-42"},
+		{"lowercase scheme", "bearer " + jwt(`{"sub":"user-42"}`), "sub", "user-42"},
+		{"numeric claim", "Bearer " + jwt(`{"uid":12345678901234567890}`), "uid", "12345678901234567890"},
+		{"boolean claim", "Bearer " + jwt(`{"admin":true}`), "admin", "true"},
+		{"padded payload", "Bearer " + base64.URLEncoding.EncodeToString([]byte("{}")) + "." + base64.URLEncoding.EncodeToString([]byte(`{"sub":"ab"}`)) + ".sig", "sub", "ab"},
+		{"standard alphabet", "Bearer e30." + base64.RawStdEncoding.EncodeToString([]byte(`{"sub":"??>"}`)) + ".sig", "sub", "??>"},
+		{"missing claim", "Bearer " + jwt(`{"iss":"auth"}`), "sub", "anonymous"},
+		{"object claim", "Bearer " + jwt(`{"sub":{"id":1}}`), "sub", "anonymous"},
+		{"empty claim", "Bearer " + jwt(`{"sub":""}`), "sub", "anonymous"},
+		{"garbage token", "Bearer not-a-jwt", "sub", "anonymous"},
+		{"garbage payload", "Bearer e30.!!!.sig", "sub", "anonymous"},
+		{"payload not JSON", "Bearer e30." + base64.RawURLEncoding.EncodeToString([]byte("sub")) + ".sig", "sub", "anonymous"},
+		{"basic auth", "Basic dXNlcjpwYXNz", "sub", "anonymous"},
+		{"missing header", "", "sub", "anonymous"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		if got := KeyFuncs.ByJWTClaim(tt.claim)(req); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if got := JWTClaimKeyFunc("sub", "guest")(req); got != "guest" {
+		t.Errorf("Expected the configured fallback, got %q", got)
+	}
+}
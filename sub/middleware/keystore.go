@@ -0,0 +1,23 @@
+package middleware
+
+import "github.com/rRateLimit/arg/sub/limiter"
+
+// ErrInvalidCursor is returned by SnapshotStates for a cursor it did not
+// produce
+var ErrInvalidCursor = limiter.ErrInvalidCursor
+
+// KeyState describes a key's limiter as of the key's last request
+type KeyState = limiter.KeyState
+
+// SnapshotStates returns the state of up to limit keys, starting after
+// cursor, and the cursor to pass for the next page, as described for
+// limiter.KeyedLimiter.Snapshot
+func (rl *PerKeyHTTPRateLimiter) SnapshotStates(limit int, cursor string) (states []KeyState, next string, err error) {
+	return rl.limiters.Snapshot(limit, cursor)
+}
+
+// Keyed returns the keyed limiter holding the middleware's per-key
+// limiters, for limiting other work by the same keys
+func (rl *PerKeyHTTPRateLimiter) Keyed() *limiter.KeyedLimiter {
+	return rl.limiters
+}
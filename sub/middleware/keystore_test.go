@@ -0,0 +1,270 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/internal/leaktest"
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/limiter"
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+func newKeyedLimiter(factory LimiterFactory) (*PerKeyHTTPRateLimiter, func(key string)) {
+	rl := NewPerKeyHTTPRateLimiter(factory, &Options{
+		KeyFunc: func(r *http.Request) string { return r.Header.Get("X-Key") },
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func(key string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Key", key)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	return rl, send
+}
+
+func TestSnapshotStates(t *testing.T) {
+	rl, send := newKeyedLimiter(func() RateLimiter {
+		l, _ := limiter.NewMultiWindowLimiter(limiter.WindowLimit{Count: 5, Window: time.Minute})
+		return l
+	})
+	now := time.Unix(1000, 0)
+	rl.now = func() time.Time { return now }
+
+	send("alice")
+	send("alice")
+	send("bob")
+
+	states, next, err := rl.SnapshotStates(0, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if next != "" {
+		t.Errorf("Expected no next cursor, got %q", next)
+	}
+	got := make(map[string]KeyState)
+	for _, s := range states {
+		got[s.Key] = s
+	}
+	if len(got) != 2 || got["alice"].Remaining != 3 || got["bob"].Remaining != 4 {
+		t.Errorf("Expected alice with 3 and bob with 4 remaining, got %+v", states)
+	}
+	if !got["alice"].LastAccess.Equal(now) {
+		t.Errorf("Expected last access %v, got %v", now, got["alice"].LastAccess)
+	}
+}
+
+func TestSnapshotStatesUnreportedRemaining(t *testing.T) {
+	rl, send := newKeyedLimiter(func() RateLimiter {
+		return &mockRateLimiter{allowReturn: true}
+	})
+	send("alice")
+
+	states, _, _ := rl.SnapshotStates(0, "")
+	if len(states) != 1 || states[0].Remaining != -1 {
+		t.Errorf("Expected one state with Remaining -1, got %+v", states)
+	}
+}
+
+func TestSnapshotStatesPagination(t *testing.T) {
+	rl, send := newKeyedLimiter(func() RateLimiter {
+		return &mockRateLimiter{allowReturn: true}
+	})
+	const keys = 1000
+	for i := 0; i < keys; i++ {
+		send("key-" + strconv.Itoa(i))
+	}
+
+	// Keep adding new keys and touching existing ones while paging. New keys
+	// are bounded too, or pages of one key might never catch up with them.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			send("key-" + strconv.Itoa(i%keys))
+			send("new-" + strconv.Itoa(i%keys))
+		}
+	}()
+
+	for _, limit := range []int{1, 7, 64, 100, keys} {
+		seen := make(map[string]int)
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > 3*keys {
+				t.Fatalf("Limit %d: pagination did not terminate", limit)
+			}
+			states, next, err := rl.SnapshotStates(limit, cursor)
+			if err != nil {
+				t.Fatalf("Limit %d: unexpected error: %v", limit, err)
+			}
+			if len(states) > limit {
+				t.Fatalf("Limit %d: got a page of %d states", limit, len(states))
+			}
+			for _, s := range states {
+				seen[s.Key]++
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		for i := 0; i < keys; i++ {
+			key := "key-" + strconv.Itoa(i)
+			if seen[key] != 1 {
+				t.Errorf("Limit %d: expected %s once, saw it %d times", limit, key, seen[key])
+			}
+		}
+		for key, n := range seen {
+			if n != 1 {
+				t.Errorf("Limit %d: saw %s %d times", limit, key, n)
+			}
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestSnapshotStatesInvalidCursor(t *testing.T) {
+	rl, _ := newKeyedLimiter(func() RateLimiter {
+		return &mockRateLimiter{allowReturn: true}
+	})
+	// Not base64, shard 64 of 64, and a shard that is not a number
+	for _, cursor := range []string{"!!", "NjQ", "eA"} {
+		if _, _, err := rl.SnapshotStates(10, cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Cursor %q: expected ErrInvalidCursor, got %v", cursor, err)
+		}
+	}
+}
+
+func TestIdleTTLEvictsKeys(t *testing.T) {
+	baseline := leaktest.BaselineGoroutines()
+	s := stats.NewStats()
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter {
+		return &mockRateLimiter{allowReturn: true}
+	}, &Options{
+		KeyFunc:     func(r *http.Request) string { return r.Header.Get("X-Key") },
+		IdleTTL:     20 * time.Millisecond,
+		KeyRecorder: s,
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Key", "scanner-"+strconv.Itoa(i))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	// The recorder hears of a sweep just after its keys are gone
+	for s.GetSnapshot().Evictions < 50 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if rl.KeyCount() != 0 || rl.Evictions() != 50 {
+		t.Errorf("Expected all 50 keys evicted, %d left and %d evicted", rl.KeyCount(), rl.Evictions())
+	}
+	if snapshot := s.GetSnapshot(); snapshot.Keys != 0 || snapshot.Evictions != 50 {
+		t.Errorf("Expected stats to report 0 keys and 50 evictions, got %d and %d", snapshot.Keys, snapshot.Evictions)
+	}
+
+	rl.Close()
+	leaktest.AssertNoLeak(t, baseline, time.Second)
+}
+
+func TestMaxKeysBoundsKeys(t *testing.T) {
+	const maxKeys = 100
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter {
+		return &mockRateLimiter{allowReturn: true}
+	}, &Options{
+		KeyFunc: func(r *http.Request) string { return r.Header.Get("X-Key") },
+		MaxKeys: maxKeys,
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func(key string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 10*maxKeys; i++ {
+		send("regular")
+		if code := send("spray-" + strconv.Itoa(i)); code != http.StatusOK {
+			t.Fatalf("Expected new keys to evict old ones, got %d", code)
+		}
+	}
+	if n := rl.KeyCount(); n > maxKeys {
+		t.Errorf("Expected at most %d keys, got %d", maxKeys, n)
+	}
+	if _, ok := rl.limiters.Lookup("regular"); !ok {
+		t.Error("Expected the regular client's limiter to be kept")
+	}
+	if rl.Evictions() == 0 {
+		t.Error("Expected evictions to be counted")
+	}
+}
+
+func TestMaxKeysRejectsNewKeys(t *testing.T) {
+	cfg := &config.Config{Rate: 10, Burst: 10, Enabled: true, PerKeyLimits: true, MaxKeys: 2, RejectNewKeys: true}
+	mw, err := NewFromConfig(cfg, &Options{KeyFunc: KeyFuncs.ByPath})
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for path, want := range map[string]int{"/a": http.StatusOK, "/b": http.StatusOK} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/c", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a third key to be refused with 429, got %d", rec.Code)
+	}
+}
+
+func TestFactoryCalledOncePerKey(t *testing.T) {
+	var calls atomic.Int64
+	rl, send := newKeyedLimiter(func() RateLimiter {
+		calls.Add(1)
+		return &mockRateLimiter{allowReturn: true}
+	})
+
+	keys := []string{"alice", "bob", "carol"}
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			send(keys[i%len(keys)])
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if n := calls.Load(); n != int64(len(keys)) {
+		t.Errorf("Expected the factory to be called once per key, called %d times", n)
+	}
+	if n := rl.KeyCount(); n != len(keys) {
+		t.Errorf("Expected %d keys, got %d", len(keys), n)
+	}
+}
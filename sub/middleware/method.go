@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// methodLimiter returns the limiter of the single-limiter middleware for
+// r: the one Options.MethodLimits sets for r's method, or else l
+func (rl *HTTPRateLimiter) methodLimiter(r *http.Request, l RateLimiter) RateLimiter {
+	if ml, ok := rl.methodLimits[r.Method]; ok {
+		return ml
+	}
+	return l
+}
+
+// methodKey returns the per-key middleware's key for a request with the
+// given method and client key. With Options.MethodFactories, the key of a
+// listed method is the method and the client key joined as by
+// KeyFuncs.Combination, and the key of any other method is the client key
+// escaped the same way, so no client key can pose as a method's.
+func (rl *PerKeyHTTPRateLimiter) methodKey(method, key string) string {
+	if len(rl.methods) == 0 {
+		return key
+	}
+	var b strings.Builder
+	if _, ok := rl.methods[method]; ok {
+		writeEscaped(&b, method, CombinationSeparator)
+		b.WriteString(CombinationSeparator)
+	}
+	writeEscaped(&b, key, CombinationSeparator)
+	return b.String()
+}
+
+// methodFactory returns a factory building the limiters of keys made by
+// methodKey: those of listed methods with the method's factory, and the
+// others with factory, passed the client key
+func (rl *PerKeyHTTPRateLimiter) methodFactory(factory KeyedLimiterFactory) KeyedLimiterFactory {
+	if len(rl.methods) == 0 {
+		return factory
+	}
+	methods := rl.methods
+	return func(key string) RateLimiter {
+		method, client, ok := splitMethodKey(key)
+		if mf, listed := methods[method]; ok && listed {
+			return mf()
+		}
+		return factory(client)
+	}
+}
+
+// splitMethodKey splits a key made by methodKey into its method, if it has
+// one, and the client key, undoing the escaping
+func splitMethodKey(key string) (method, client string, ok bool) {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case c == '\\' && i+1 < len(key):
+			i++
+			b.WriteByte(key[i])
+		case !ok && strings.HasPrefix(key[i:], CombinationSeparator):
+			method, ok = b.String(), true
+			b.Reset()
+			i += len(CombinationSeparator) - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return method, b.String(), ok
+}
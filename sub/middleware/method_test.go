@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+func TestMethodLimits(t *testing.T) {
+	exclusions, err := config.CompileExclusions([]string{"/health"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHTTPRateLimiter(limiter.NewRateLimiter(1, 20), &Options{
+		MethodLimits: map[string]RateLimiter{http.MethodPost: limiter.NewRateLimiter(1, 2)},
+		Exclusions:   exclusions,
+	}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	allowed := map[string]int{}
+	for i := 0; i < 10; i++ {
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(method, "/items", nil))
+			if rec.Code == http.StatusOK {
+				allowed[method]++
+			}
+		}
+	}
+	if allowed[http.MethodGet] != 10 || allowed[http.MethodPost] != 2 {
+		t.Errorf("Expected all 10 GETs and 2 POSTs allowed, got %v", allowed)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected an excluded path to skip the method's limiter, got %d", rec.Code)
+	}
+}
+
+func TestPerKeyMethodFactories(t *testing.T) {
+	var keys []string
+	rl := NewPerKeyHTTPRateLimiterKeyed(func(key string) RateLimiter {
+		keys = append(keys, key)
+		return limiter.NewRateLimiter(1, 20)
+	}, &Options{
+		KeyFunc:         KeyFuncs.ByAPIKey("X-API-Key"),
+		MethodFactories: map[string]LimiterFactory{http.MethodPost: func() RateLimiter { return limiter.NewRateLimiter(1, 2) }},
+	})
+	var infos []LimitInfo
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, _ := InfoFromContext(r.Context())
+		infos = append(infos, info)
+	}))
+	send := func(method, key string) int {
+		req := httptest.NewRequest(method, "/items", nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	allowed := map[string]int{}
+	for i := 0; i < 10; i++ {
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			for _, key := range []string{"alice", "bob"} {
+				if send(method, key) == http.StatusOK {
+					allowed[method+" "+key]++
+				}
+			}
+		}
+	}
+	want := map[string]int{"GET alice": 10, "GET bob": 10, "POST alice": 2, "POST bob": 2}
+	for k, n := range want {
+		if allowed[k] != n {
+			t.Errorf("Expected %d of %s allowed, got %d", n, k, allowed[k])
+		}
+	}
+	if infos[0].Key != "alice" || infos[2].Key != "POST|alice" {
+		t.Errorf("Expected keys of POSTs to include the method, got %q and %q", infos[0].Key, infos[2].Key)
+	}
+	if len(keys) != 2 || keys[0] != "alice" || keys[1] != "bob" {
+		t.Errorf("Expected the default factory to get the client keys of GETs only, got %q", keys)
+	}
+
+	// A client key cannot pose as another client's POST key
+	if code := send(http.MethodGet, "POST|alice"); code != http.StatusOK {
+		t.Errorf("Expected a GET with a method-like key to use its own limiter, got %d", code)
+	}
+	if keys[len(keys)-1] != "POST|alice" {
+		t.Errorf("Expected the default factory to get the unescaped key, got %q", keys[len(keys)-1])
+	}
+}
+
+func TestLimiterKeyReachesTheClientsLimiter(t *testing.T) {
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return limiter.NewRateLimiter(1, 1) }, &Options{
+		KeyFunc:         KeyFuncs.ByAPIKey("X-API-Key"),
+		MethodFactories: map[string]LimiterFactory{http.MethodPost: func() RateLimiter { return limiter.NewRateLimiter(1, 1) }},
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func(method string) int {
+		req := httptest.NewRequest(method, "/items", nil)
+		req.Header.Set("X-API-Key", `a\b`)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	admin := rl.AdminHandler()
+	adminCode := func(method, key string) int {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, "/keys/"+url.PathEscape(key), nil))
+		return rec.Code
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		send(method)
+		key := rl.LimiterKey(method, `a\b`)
+		if code := adminCode(http.MethodGet, key); code != http.StatusOK {
+			t.Errorf("Expected the admin route to find the %s limiter under %q, got %d", method, key, code)
+		}
+		if err := rl.BoostKey(key, 3, time.Minute); err != nil {
+			t.Fatalf("BoostKey failed: %v", err)
+		}
+	}
+	// Boosting a key other than the client's would have created a limiter
+	if n := rl.KeyCount(); n != 2 {
+		t.Errorf("Expected the boosts to reach the 2 existing limiters, got %d keys", n)
+	}
+
+	rl.SetKeyHashing(true)
+	send(http.MethodPost)
+	if code := adminCode(http.MethodDelete, rl.LimiterKey(http.MethodPost, `a\b`)); code != http.StatusNoContent {
+		t.Errorf("Expected the hashed key to be deleted, got %d", code)
+	}
+}
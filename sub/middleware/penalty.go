@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+// PenaltyFunc returns how many tokens to charge on top of a request's cost
+// for a response with the given status. The per-key middleware charges the
+// key's limiter once the response is written, going into debt if need be,
+// so that, for example, an attacker guessing passwords runs out of requests
+// long before a user who mistypes one.
+type PenaltyFunc func(status int) int
+
+// Penalizer is implemented by limiters that can charge a request after the
+// fact, as PenaltyFunc requires
+type Penalizer = limiter.Penalizer
+
+// PenalizeAuthFailures returns a PenaltyFunc charging penalty extra tokens
+// for responses with status 401 or 403, to slow down guessing credentials
+func PenalizeAuthFailures(penalty int) PenaltyFunc {
+	return func(status int) int {
+		if status == http.StatusUnauthorized || status == http.StatusForbidden {
+			return penalty
+		}
+		return 0
+	}
+}
+
+// serveNext calls next with r and, if a PenaltyFunc is set, charges l the
+// penalty for the status next responded with. Responses on hijacked
+// connections have no status and are never penalized, nor are requests let
+// through without a limiter, when l is nil.
+func (rl *PerKeyHTTPRateLimiter) serveNext(w http.ResponseWriter, r *http.Request, l RateLimiter, next http.HandlerFunc) {
+	if rl.penaltyFunc == nil || l == nil {
+		next(w, r)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: w}
+	next(sw, r)
+	if sw.hijacked {
+		return
+	}
+	status := sw.status
+	if status == 0 {
+		// The handler wrote nothing, so the server responds 200
+		status = http.StatusOK
+	}
+	if penalty := rl.penaltyFunc(status); penalty > 0 {
+		limiter.Penalize(l, penalty)
+	}
+}
+
+// statusWriter records the status a handler responds with
+type statusWriter struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	// Informational responses precede the real one
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush flushes the underlying writer if it can be flushed
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack hijacks the underlying connection if it can be hijacked
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("middleware: response writer cannot be hijacked")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
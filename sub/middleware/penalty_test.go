@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+func TestPenaltyFunc(t *testing.T) {
+	newLimiter := func() RateLimiter {
+		return stats.NewRateLimiterWithStats(limiter.NewRateLimiterPer(1, time.Hour, 20))
+	}
+	login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic correct" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		// A correct password writes nothing, an implicit 200
+	})
+	rl := NewPerKeyHTTPRateLimiter(newLimiter, &Options{
+		KeyFunc:     KeyFuncs.ByPath,
+		PenaltyFunc: PenalizeAuthFailures(3),
+	})
+	handler := rl.Middleware(login)
+	attempt := func(path, authorization string) int {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Each failed attempt costs its token and three more, so five use up
+	// the burst of 20
+	for i := 0; i < 5; i++ {
+		if code := attempt("/attacker", "Basic wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("Expected attempt %d to reach the handler, got %d", i, code)
+		}
+	}
+	if code := attempt("/attacker", "Basic wrong"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the sixth failed attempt to be blocked, got %d", code)
+	}
+
+	// Successful logins cost one token each
+	for i := 0; i < 20; i++ {
+		if code := attempt("/user", "Basic correct"); code != http.StatusOK {
+			t.Fatalf("Expected login %d to succeed, got %d", i, code)
+		}
+	}
+
+	// MiddlewareFunc charges penalties too
+	handler = rl.MiddlewareFunc(login)
+	for i := 0; i < 4; i++ {
+		attempt("/other", "Basic wrong")
+	}
+	attempt("/other", "Basic correct")
+	attempt("/other", "Basic wrong")
+	if code := attempt("/other", "Basic correct"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected MiddlewareFunc to charge penalties, got %d", code)
+	}
+}
+
+func TestPenaltySkipsHijackedConnections(t *testing.T) {
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return limiter.NewRateLimiterPer(1, time.Hour, 2) }, &Options{
+		KeyFunc:     KeyFuncs.ByPath,
+		PenaltyFunc: func(status int) int { return 100 },
+	})
+	server := httptest.NewServer(rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Expected the connection to be hijackable through the middleware: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 401 Unauthorized\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		rw.Flush()
+	})))
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: test\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected request %d to be served, not penalized, got %d", i, resp.StatusCode)
+		}
+	}
+}
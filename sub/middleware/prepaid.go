@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrepaidHeader carries a signed token marking a request as already paid
+// for, such as an internal retry of a request the client was charged for
+const PrepaidHeader = "X-RateLimit-Prepaid"
+
+type prepaidKey struct{}
+
+// MarkPrepaid returns a context marking the request as already paid for.
+// Requests carrying it pass the middleware without consuming tokens.
+func MarkPrepaid(ctx context.Context) context.Context {
+	return context.WithValue(ctx, prepaidKey{}, true)
+}
+
+// IsPrepaid reports whether ctx was marked with MarkPrepaid
+func IsPrepaid(ctx context.Context) bool {
+	prepaid, _ := ctx.Value(prepaidKey{}).(bool)
+	return prepaid
+}
+
+// PrepaidRecorder is implemented by limiters that count prepaid requests
+// separately from the ones they decide on
+type PrepaidRecorder interface {
+	RecordPrepaid()
+}
+
+// MaxPrepaidLifetime is the longest a PrepaidHeader token is accepted for:
+// tokens expiring further ahead are refused, so a leaked token cannot be
+// replayed for longer
+const MaxPrepaidLifetime = 5 * time.Minute
+
+// SignPrepaid returns a PrepaidHeader value for one request, valid until
+// expiry, which must be within MaxPrepaidLifetime of the request. It is
+// bound to the client key, as the middleware's KeyFunc reports it, and to
+// the request's method and path, so it lets no other request through.
+// Only middleware configured with the same secret accepts it.
+func SignPrepaid(secret []byte, key, method, path string, expiry time.Time) string {
+	expires := strconv.FormatInt(expiry.Unix(), 10)
+	return expires + "." + hex.EncodeToString(prepaidMAC(secret, expires, key, method, path))
+}
+
+// verifyPrepaid reports whether token is a PrepaidHeader value signed with
+// secret for the key, method and path that has not expired at now and
+// does not expire beyond MaxPrepaidLifetime
+func verifyPrepaid(secret []byte, token, key, method, path string, now time.Time) bool {
+	expires, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	mac, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, prepaidMAC(secret, expires, key, method, path)) {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return false
+	}
+	expiry := time.Unix(unix, 0)
+	return now.Before(expiry) && expiry.Sub(now) <= MaxPrepaidLifetime
+}
+
+// prepaidMAC signs the fields of a token, each prefixed by its length so
+// that no two sets of fields sign the same bytes
+func prepaidMAC(secret []byte, fields ...string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	for _, field := range fields {
+		mac.Write([]byte(strconv.Itoa(len(field)) + ":" + field))
+	}
+	return mac.Sum(nil)
+}
+
+// takePrepaid removes the PrepaidHeader from r when secret is set, so the
+// token is not passed on to the next handler or upstream, and returns it
+func takePrepaid(r *http.Request, secret []byte) string {
+	if len(secret) == 0 {
+		return ""
+	}
+	token := r.Header.Get(PrepaidHeader)
+	r.Header.Del(PrepaidHeader)
+	return token
+}
+
+// isPrepaid reports whether r was marked prepaid through its context or
+// carries token, taken by takePrepaid, signed with secret for its client
+// key, as keyFunc reports it, method and path
+func isPrepaid(r *http.Request, secret []byte, token string, keyFunc KeyFunc) bool {
+	if IsPrepaid(r.Context()) {
+		return true
+	}
+	return token != "" && verifyPrepaid(secret, token, keyFunc(r), r.Method, r.URL.Path, time.Now())
+}
+
+// recordPrepaid counts a prepaid request against l if it keeps such counts
+func recordPrepaid(l RateLimiter) {
+	if r, ok := l.(PrepaidRecorder); ok {
+		r.RecordPrepaid()
+	}
+}
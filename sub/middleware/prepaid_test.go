@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+func TestPrepaidContextBypass(t *testing.T) {
+	mock := &namedMockRateLimiter{mockRateLimiter: mockRateLimiter{allowReturn: false}}
+	limited := stats.NewRateLimiterWithStats(mock)
+	handler := NewHTTPRateLimiter(limited, nil).Middleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req.WithContext(MarkPrepaid(req.Context())))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected prepaid request to pass, got %d", rec.Code)
+	}
+	if mock.getCallCount() != 0 {
+		t.Error("Expected prepaid request not to consume from the limiter")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected unmarked request to be limited, got %d", rec.Code)
+	}
+
+	snapshot := limited.GetStats().GetSnapshot()
+	if snapshot.PrepaidRequests != 1 || snapshot.TotalRequests != 1 || snapshot.DeniedRequests != 1 {
+		t.Errorf("Expected 1 prepaid and 1 denied request, got %+v", snapshot)
+	}
+}
+
+func TestPrepaidHeaderBypass(t *testing.T) {
+	secret := []byte("shared-secret")
+	mock := &mockRateLimiter{allowReturn: false}
+	var forwarded []string
+	handler := NewPerKeyHTTPRateLimiter(func() RateLimiter { return mock }, &Options{
+		PrepaidSecret: secret,
+		KeyFunc:       KeyFuncs.ByAPIKey("X-API-Key"),
+	}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Values(PrepaidHeader)
+	}))
+
+	soon := time.Now().Add(time.Minute)
+	valid := SignPrepaid(secret, "alice", "POST", "/orders", soon)
+	expires, signature, _ := strings.Cut(valid, ".")
+	tampered := expires + "." + strings.Repeat("0", len(signature))
+	extended := "9999999999." + signature
+
+	tests := []struct {
+		name        string
+		key, method string
+		path, token string
+		status      int
+	}{
+		{"valid", "alice", "POST", "/orders", valid, http.StatusOK},
+		{"other key", "mallory", "POST", "/orders", valid, http.StatusTooManyRequests},
+		{"other method", "alice", "DELETE", "/orders", valid, http.StatusTooManyRequests},
+		{"other path", "alice", "POST", "/admin", valid, http.StatusTooManyRequests},
+		{"tampered signature", "alice", "POST", "/orders", tampered, http.StatusTooManyRequests},
+		{"extended expiry", "alice", "POST", "/orders", extended, http.StatusTooManyRequests},
+		{"expired", "alice", "POST", "/orders", SignPrepaid(secret, "alice", "POST", "/orders", time.Now().Add(-time.Second)), http.StatusTooManyRequests},
+		{"beyond the maximum lifetime", "alice", "POST", "/orders", SignPrepaid(secret, "alice", "POST", "/orders", time.Now().Add(MaxPrepaidLifetime+time.Minute)), http.StatusTooManyRequests},
+		{"other secret", "alice", "POST", "/orders", SignPrepaid([]byte("other"), "alice", "POST", "/orders", soon), http.StatusTooManyRequests},
+		{"fields shifted", "alic", "POST", "/orders", SignPrepaid(secret, "alic", "ePOST", "/orders", soon), http.StatusTooManyRequests},
+		{"malformed", "alice", "POST", "/orders", "not-a-token", http.StatusTooManyRequests},
+		{"missing", "alice", "POST", "/orders", "", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("X-API-Key", tt.key)
+			if tt.token != "" {
+				req.Header.Set(PrepaidHeader, tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+
+	forwarded = []string{"not called"}
+	req := httptest.NewRequest("POST", "/orders", nil)
+	req.Header.Set("X-API-Key", "alice")
+	req.Header.Set(PrepaidHeader, valid)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if forwarded != nil {
+		t.Errorf("Expected the token not to be passed on, got %q", forwarded)
+	}
+}
+
+func TestPrepaidHeaderIgnoredWithoutSecret(t *testing.T) {
+	secret := []byte("shared-secret")
+	handler := NewHTTPRateLimiter(&mockRateLimiter{allowReturn: false}, nil).Middleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(PrepaidHeader, SignPrepaid(secret, DefaultKeyFunc(req), req.Method, req.URL.Path, time.Now().Add(time.Minute)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected header to be ignored without a secret, got %d", rec.Code)
+	}
+}
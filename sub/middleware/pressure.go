@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+// PressureHeader carries how close the limiter is to exhaustion, from 0 when
+// idle to 1 when every request is being denied
+const PressureHeader = "X-RateLimit-Pressure"
+
+// pressure returns 1 minus l's health, or false if l cannot report it
+func pressure(l RateLimiter) (float64, bool) {
+	health, ok := limiter.HealthFraction(l)
+	if !ok {
+		return 0, false
+	}
+	return min(max(1-health, 0), 1), true
+}
+
+// setPressure writes l's pressure header if l reports its health
+func setPressure(w http.ResponseWriter, l RateLimiter) {
+	if p, ok := pressure(l); ok {
+		w.Header().Set(PressureHeader, strconv.FormatFloat(p, 'f', 2, 64))
+	}
+}
+
+// PressureOptions configures a PressureHandler
+type PressureOptions struct {
+	// High is the pressure at or above which the handler reports the
+	// limiter as overloaded. Defaults to 0.9.
+	High float64
+	// Low is the pressure at or below which an overloaded limiter is
+	// reported healthy again. Defaults to High, which disables hysteresis.
+	Low float64
+	// StatusCode is returned while overloaded. Defaults to 503.
+	StatusCode int
+}
+
+// PressureHandler is a readiness endpoint for a limiter. It answers 200
+// while the limiter's pressure is below a threshold and a configurable error
+// status above it, so a load balancer stops sending traffic to a node before
+// its clients are denied. Hysteresis between the high and low thresholds
+// keeps the endpoint from flapping when pressure hovers around one value.
+type PressureHandler struct {
+	limiter    RateLimiter
+	high       float64
+	low        float64
+	statusCode int
+
+	mu         sync.Mutex
+	overloaded bool
+}
+
+// NewPressureHandler creates a readiness handler for l, which should report
+// its health through HealthFraction; one that does not is always ready
+func NewPressureHandler(l RateLimiter, opts *PressureOptions) *PressureHandler {
+	h := &PressureHandler{
+		limiter:    l,
+		high:       0.9,
+		statusCode: http.StatusServiceUnavailable,
+	}
+	if opts != nil {
+		if opts.High > 0 {
+			h.high = opts.High
+		}
+		h.low = opts.Low
+		if opts.StatusCode != 0 {
+			h.statusCode = opts.StatusCode
+		}
+	}
+	if h.low <= 0 || h.low > h.high {
+		h.low = h.high
+	}
+	return h
+}
+
+// Overloaded reports whether the limiter is overloaded, updating the state
+// from its current pressure
+func (h *PressureHandler) Overloaded() bool {
+	p, _ := pressure(h.limiter)
+	return h.update(p)
+}
+
+// update moves between the healthy and overloaded states given pressure p
+func (h *PressureHandler) update(p float64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.overloaded {
+		h.overloaded = p > h.low
+	} else {
+		h.overloaded = p >= h.high
+	}
+	return h.overloaded
+}
+
+// ServeHTTP reports readiness with a JSON body describing the pressure
+func (h *PressureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, _ := pressure(h.limiter)
+	overloaded := h.update(p)
+
+	status := http.StatusOK
+	if overloaded {
+		status = h.statusCode
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(PressureHeader, strconv.FormatFloat(p, 'f', 2, 64))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"pressure":   p,
+		"overloaded": overloaded,
+	})
+}
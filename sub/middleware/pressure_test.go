@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+// healthMockRateLimiter reports a settable health
+type healthMockRateLimiter struct {
+	mockRateLimiter
+	health float64
+}
+
+func (m *healthMockRateLimiter) HealthFraction() float64 {
+	return m.health
+}
+
+func TestPressureHeaderTowardExhaustion(t *testing.T) {
+	l, err := limiter.NewMultiWindowLimiter(limiter.WindowLimit{Count: 4, Window: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHTTPRateLimiter(l, &Options{EmitPressure: true}).Middleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	want := []string{"0.25", "0.50", "0.75", "1.00", "1.00"}
+	for i, expected := range want {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if got := rec.Header().Get(PressureHeader); got != expected {
+			t.Errorf("Request %d: expected pressure %s, got %q", i, expected, got)
+		}
+	}
+}
+
+func TestPressureHeaderOptIn(t *testing.T) {
+	l := &healthMockRateLimiter{mockRateLimiter: mockRateLimiter{allowReturn: true}, health: 0.5}
+	handler := NewPerKeyHTTPRateLimiter(func() RateLimiter { return l }, nil).Middleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if got := rec.Header().Get(PressureHeader); got != "" {
+		t.Errorf("Expected no pressure header without EmitPressure, got %q", got)
+	}
+
+	handler = NewPerKeyHTTPRateLimiter(func() RateLimiter { return l }, &Options{EmitPressure: true}).Middleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if got := rec.Header().Get(PressureHeader); got != "0.50" {
+		t.Errorf("Expected pressure 0.50, got %q", got)
+	}
+}
+
+func TestPressureHandlerHysteresis(t *testing.T) {
+	l := &healthMockRateLimiter{health: 1}
+	h := NewPressureHandler(l, &PressureOptions{High: 0.8, Low: 0.5, StatusCode: http.StatusTooManyRequests})
+
+	steps := []struct {
+		pressure float64
+		status   int
+	}{
+		{0.0, http.StatusOK},
+		{0.7, http.StatusOK},
+		{0.8, http.StatusTooManyRequests},
+		{0.7, http.StatusTooManyRequests}, // still above low
+		{0.6, http.StatusTooManyRequests},
+		{0.5, http.StatusOK},
+		{0.7, http.StatusOK}, // below high again
+		{1.0, http.StatusTooManyRequests},
+	}
+	for i, step := range steps {
+		l.health = 1 - step.pressure
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+		if rec.Code != step.status {
+			t.Errorf("Step %d (pressure %v): expected status %d, got %d", i, step.pressure, step.status, rec.Code)
+		}
+		p, err := strconv.ParseFloat(rec.Header().Get(PressureHeader), 64)
+		if err != nil || p < step.pressure-0.01 || p > step.pressure+0.01 {
+			t.Errorf("Step %d: expected pressure header %v, got %q", i, step.pressure, rec.Header().Get(PressureHeader))
+		}
+	}
+}
+
+func TestPressureHandlerDefaults(t *testing.T) {
+	h := NewPressureHandler(&mockRateLimiter{}, nil)
+	if h.Overloaded() {
+		t.Error("Expected limiter without health reporting to always be ready")
+	}
+
+	l := &healthMockRateLimiter{health: 0.05}
+	h = NewPressureHandler(l, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 above default threshold, got %d", rec.Code)
+	}
+	l.health = 0.15
+	if h.Overloaded() {
+		t.Error("Expected recovery below default threshold without hysteresis")
+	}
+}
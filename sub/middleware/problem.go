@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+// Problem is the body ProblemJSONErrorHandler writes, the problem details
+// of RFC 7807 with the seconds to wait as the extension member
+// retry_after
+type Problem struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// ProblemJSONErrorHandler returns an error handler writing RFC 7807
+// problem details with the given type URI and title, for example
+//
+//	{"type":"https://example.com/probs/rate-limit","title":"Too Many Requests","status":429,"detail":"Rate limit of 100 requests exceeded for key alice","retry_after":2}
+//
+// The detail and retry_after come from the request's LimitInfo and are
+// omitted when it has none. The body is encoded as JSON, so keys holding
+// quotes or other special characters cannot break it. An empty typeURI
+// defaults to "about:blank", as RFC 7807 says.
+func ProblemJSONErrorHandler(typeURI, title string) ErrorHandler {
+	if typeURI == "" {
+		typeURI = "about:blank"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		problem := Problem{Type: typeURI, Title: title, Status: http.StatusTooManyRequests}
+		if info, ok := InfoFromContext(r.Context()); ok {
+			problem.Detail = problemDetail(info)
+			if info.RetryAfter > 0 {
+				problem.RetryAfter = int(math.Ceil(info.RetryAfter.Seconds()))
+			}
+		}
+
+		w.Header().Set("Content-Type", ProblemContentType)
+		w.WriteHeader(problem.Status)
+		json.NewEncoder(w).Encode(problem)
+	}
+}
+
+// problemDetail describes the limit a request exceeded, as far as info
+// tells
+func problemDetail(info LimitInfo) string {
+	detail := "Rate limit exceeded"
+	if info.Limit > 0 {
+		detail = "Rate limit of " + strconv.Itoa(info.Limit) + " requests exceeded"
+	}
+	if info.Key != "" {
+		detail += " for key " + info.Key
+	}
+	return detail
+}
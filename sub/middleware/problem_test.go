@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+func TestProblemJSONErrorHandler(t *testing.T) {
+	handler := ProblemJSONErrorHandler("https://example.com/probs/rate-limit", "Too Many Requests")
+	tests := []struct {
+		name string
+		info *LimitInfo
+		want string
+	}{
+		{
+			name: "with info",
+			info: &LimitInfo{Key: "alice", Limit: 100, RetryAfter: 1500 * time.Millisecond},
+			want: `{"type":"https://example.com/probs/rate-limit","title":"Too Many Requests","status":429,"detail":"Rate limit of 100 requests exceeded for key alice","retry_after":2}`,
+		},
+		{
+			name: "without limit",
+			info: &LimitInfo{Key: "bob"},
+			want: `{"type":"https://example.com/probs/rate-limit","title":"Too Many Requests","status":429,"detail":"Rate limit exceeded for key bob"}`,
+		},
+		{
+			name: "without info",
+			want: `{"type":"https://example.com/probs/rate-limit","title":"Too Many Requests","status":429}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.info != nil {
+				req = withLimitInfo(req, *tt.info)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != http.StatusTooManyRequests {
+				t.Errorf("Expected status 429, got %d", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != ProblemContentType {
+				t.Errorf("Expected Content-Type %s, got %s", ProblemContentType, ct)
+			}
+			if got := rec.Body.String(); got != tt.want+"\n" {
+				t.Errorf("Expected body %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	ProblemJSONErrorHandler("", "Slow down")(rec, httptest.NewRequest("GET", "/", nil))
+	var problem Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil || problem.Type != "about:blank" {
+		t.Errorf("Expected the type to default to about:blank, got %+v, %v", problem, err)
+	}
+}
+
+func TestProblemJSONErrorHandlerEscapesKey(t *testing.T) {
+	l, err := limiter.NewMultiWindowLimiter(limiter.WindowLimit{Count: 1, Window: time.Minute})
+	if err != nil {
+		t.Fatalf("NewMultiWindowLimiter failed: %v", err)
+	}
+	key := `al"ice\</script>` + "\n"
+	// The single-limiter middleware only resolves keys for its hooks
+	rl := NewHTTPRateLimiter(l, &Options{
+		KeyFunc:      func(r *http.Request) string { return key },
+		ErrorHandler: ProblemJSONErrorHandler("about:blank", "Too Many Requests"),
+		OnDenied:     func(r *http.Request, key string) {},
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	}
+
+	var problem Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Expected a valid document, got %q: %v", rec.Body.String(), err)
+	}
+	if problem.Detail != "Rate limit of 1 requests exceeded for key "+key || problem.RetryAfter <= 0 {
+		t.Errorf("Expected the key and retry delay from the decision, got %+v", problem)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected the Retry-After header to be kept")
+	}
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+// Reloadable is rate limiting middleware built from a config, as
+// NewFromConfig builds it, that can be rebuilt from a new config while
+// serving, such as one config.Watch reports:
+//
+//	rl, err := middleware.NewReloadable(cfg, nil)
+//	stop, err := config.Watch("limits.yaml", func(cfg *config.Config) {
+//		if err := rl.Reload(cfg); err != nil {
+//			log.Printf("keeping the old limits: %v", err)
+//		}
+//	})
+//
+// Requests already being limited finish under the config they started
+// with. Limiter state starts afresh with each config, so every key gets a
+// new allowance when limits change. A config that only turns limiting off
+// or on is applied with SetEnabled instead, keeping the limiters' state.
+type Reloadable struct {
+	opts    *Options
+	current atomic.Pointer[reloadState]
+	mu      sync.Mutex
+}
+
+// reloadState is the middleware built from one config
+type reloadState struct {
+	cfg        *config.Config
+	middleware func(http.Handler) http.Handler
+	closer     io.Closer
+	limiter    enabler
+}
+
+// NewReloadable builds middleware from cfg and opts as NewFromConfig does.
+// opts applies to every config the middleware is rebuilt from.
+func NewReloadable(cfg *config.Config, opts *Options) (*Reloadable, error) {
+	r := &Reloadable{opts: opts}
+	if err := r.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload rebuilds the middleware from cfg and switches new requests to it.
+// If cfg is invalid it returns an error and the current config stays in
+// effect.
+func (r *Reloadable) Reload(cfg *config.Config) error {
+	cfg = cfg.Clone()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if current := r.current.Load(); current != nil {
+		if changes := current.cfg.Diff(cfg); len(changes) == 1 && changes[0].Field == "enabled" {
+			r.setEnabled(cfg.Enabled)
+			return nil
+		}
+	}
+	middleware, closer, limiter, err := buildFromConfig(cfg, r.opts)
+	if err != nil {
+		return err
+	}
+
+	old := r.current.Swap(&reloadState{cfg: cfg, middleware: middleware, closer: closer, limiter: limiter})
+	if old != nil && old.closer != nil {
+		return old.closer.Close()
+	}
+	return nil
+}
+
+// SetEnabled turns limiting off or on without rebuilding the middleware,
+// as HTTPRateLimiter.SetEnabled does, and records the change in Config
+func (r *Reloadable) SetEnabled(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setEnabled(enabled)
+}
+
+// setEnabled is SetEnabled with mu held
+func (r *Reloadable) setEnabled(enabled bool) {
+	state := *r.current.Load()
+	state.cfg = state.cfg.Clone()
+	state.cfg.Enabled = enabled
+	state.limiter.SetEnabled(enabled)
+	r.current.Store(&state)
+}
+
+// Enabled reports whether limiting is on
+func (r *Reloadable) Enabled() bool {
+	return r.current.Load().limiter.Enabled()
+}
+
+// Config returns a copy of the config in effect
+func (r *Reloadable) Config() *config.Config {
+	return r.current.Load().cfg.Clone()
+}
+
+// Close releases the current middleware's resources, such as the janitor
+// of a per-key limiter with an IdleTTL
+func (r *Reloadable) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if closer := r.current.Load().closer; closer != nil {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Middleware returns a handler limiting requests to next with the
+// middleware built from the current config
+func (r *Reloadable) Middleware(next http.Handler) http.Handler {
+	type wrapped struct {
+		state   *reloadState
+		handler http.Handler
+	}
+	var cached atomic.Pointer[wrapped]
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		state := r.current.Load()
+		h := cached.Load()
+		if h == nil || h.state != state {
+			// Wrap next once per config rather than once per request
+			h = &wrapped{state: state, handler: state.middleware(next)}
+			cached.Store(h)
+		}
+		h.handler.ServeHTTP(w, req)
+	})
+}
+
+// MiddlewareFunc is like Middleware for a handler function
+func (r *Reloadable) MiddlewareFunc(next http.HandlerFunc) http.HandlerFunc {
+	return r.Middleware(next).ServeHTTP
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+func TestReloadable(t *testing.T) {
+	rl, err := NewReloadable(&config.Config{Rate: 1, Burst: 1, Enabled: true}, nil)
+	if err != nil {
+		t.Fatalf("NewReloadable failed: %v", err)
+	}
+	defer rl.Close()
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec.Code
+	}
+
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("Expected the first request through, got %d", code)
+	}
+	if code := send(); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the second request limited, got %d", code)
+	}
+
+	if err := rl.Reload(&config.Config{Rate: 1, Burst: 5, Enabled: true, ErrorMessage: "Slow down"}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if code := send(); code != http.StatusOK {
+			t.Fatalf("Request %d: expected the new burst to apply, got %d", i, code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Body.String() != "Slow down\n" {
+		t.Errorf("Expected the new error message, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	if err := rl.Reload(&config.Config{Rate: -1, Burst: 5, Enabled: true}); err == nil {
+		t.Error("Expected an invalid config to be refused")
+	}
+	if cfg := rl.Config(); cfg.Burst != 5 || cfg.ErrorMessage != "Slow down" {
+		t.Errorf("Expected the previous config to stay in effect, got %+v", cfg)
+	}
+
+	if err := rl.Reload(&config.Config{Rate: 1, Burst: 1}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if code := send(); code != http.StatusOK {
+			t.Fatalf("Expected a disabled config to let requests through, got %d", code)
+		}
+	}
+}
+
+func TestReloadableConcurrentReloads(t *testing.T) {
+	rl, err := NewReloadable(&config.Config{Rate: 100, Burst: 100, Enabled: true, PerKeyLimits: true}, &Options{KeyFunc: KeyFuncs.ByPath})
+	if err != nil {
+		t.Fatalf("NewReloadable failed: %v", err)
+	}
+	defer rl.Close()
+	handler := rl.MiddlewareFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			}
+		}()
+	}
+	for burst := 100; burst < 150; burst++ {
+		if err := rl.Reload(&config.Config{Rate: 100, Burst: burst, Enabled: true, PerKeyLimits: true}); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+	}
+	wg.Wait()
+
+	if cfg := rl.Config(); cfg.Burst != 149 {
+		t.Errorf("Expected the last config in effect, got burst %d", cfg.Burst)
+	}
+}
@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rRateLimit/arg/sub/config"
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+// RouteRule limits the requests matching Pattern, a config.Route pattern
+// such as "GET /api/search" or "/api/items/{id}", with Limiter, shared by
+// every such request, or with one limiter per key built by Factory. Name
+// identifies the rule to error handlers and stats, and defaults to Pattern.
+type RouteRule struct {
+	Name    string
+	Pattern string
+	Limiter RateLimiter
+	Factory LimiterFactory
+}
+
+// RouteRateLimiter applies different limits to different routes from one
+// middleware. The first rule matching a request wins; requests no rule
+// matches go to the fallback, if there is one, or through unlimited.
+//
+// The matched rule's name is available to error handlers as
+// LimitInfo.Route and to the next handler through RouteFromContext, and
+// every rule counts the requests it allows and denies; see Stats.
+type RouteRateLimiter struct {
+	routes   []*route
+	fallback *route
+}
+
+// route is a compiled rule
+type route struct {
+	name    string
+	pattern *config.RoutePattern
+	wrap    func(http.Handler) http.Handler
+	closer  func() error
+	stats   *stats.Stats
+}
+
+// routeMatch records the route a request matched and whether it got
+// through the route's limiter
+type routeMatch struct {
+	name   string
+	passed bool
+}
+
+type routeKey struct{}
+
+// RouteFromContext returns the name of the RouteRateLimiter rule the
+// request matched
+func RouteFromContext(ctx context.Context) (string, bool) {
+	m, ok := ctx.Value(routeKey{}).(*routeMatch)
+	if !ok {
+		return "", false
+	}
+	return m.name, true
+}
+
+// NewRouteRateLimiter creates middleware applying rules in order. The
+// fallback rule, which may be nil, needs no pattern. Every rule is built
+// with opts.
+func NewRouteRateLimiter(rules []RouteRule, fallback *RouteRule, opts *Options) (*RouteRateLimiter, error) {
+	rl := &RouteRateLimiter{}
+	for _, rule := range rules {
+		rt, err := newRoute(rule, opts)
+		if err != nil {
+			return nil, err
+		}
+		rl.routes = append(rl.routes, rt)
+	}
+	if fallback != nil {
+		rule := *fallback
+		if rule.Name == "" {
+			rule.Name = "default"
+		}
+		if rule.Pattern == "" {
+			rule.Pattern = "/*"
+		}
+		rt, err := newRoute(rule, opts)
+		if err != nil {
+			return nil, err
+		}
+		rl.fallback = rt
+	}
+	return rl, nil
+}
+
+// newRoute compiles rule, building its middleware with opts
+func newRoute(rule RouteRule, opts *Options) (*route, error) {
+	if (rule.Limiter == nil) == (rule.Factory == nil) {
+		return nil, fmt.Errorf("route %q: exactly one of Limiter and Factory must be set", rule.Pattern)
+	}
+	if rule.Factory != nil {
+		perKey := NewPerKeyHTTPRateLimiter(rule.Factory, opts)
+		return compileRoute(rule.Name, rule.Pattern, perKey.Middleware, perKey.Close)
+	}
+	return compileRoute(rule.Name, rule.Pattern, NewHTTPRateLimiter(rule.Limiter, opts).Middleware, nil)
+}
+
+// compileRoute compiles the route named name, which defaults to pattern
+func compileRoute(name, pattern string, wrap func(http.Handler) http.Handler, closer func() error) (*route, error) {
+	compiled, err := config.CompileRoutePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = pattern
+	}
+	return &route{name: name, pattern: compiled, wrap: wrap, closer: closer, stats: stats.NewStats()}, nil
+}
+
+// close releases the route's limiters, if they need it
+func (rt *route) close() {
+	if rt.closer != nil {
+		rt.closer()
+	}
+}
+
+// NewRouteRateLimiterFromConfigSet creates middleware applying the route
+// table of cs. Each route's middleware is built from its resolved config by
+// NewFromConfig with opts, so routes keep their own error messages, costs
+// and exclusions.
+func NewRouteRateLimiterFromConfigSet(cs *config.ConfigSet, opts *Options) (*RouteRateLimiter, error) {
+	table := cs.Routes()
+	if table == nil {
+		return nil, errors.New("config set has no routes")
+	}
+	rl := &RouteRateLimiter{}
+	build := func(name, pattern, configName string) (*route, error) {
+		cfg, err := cs.Resolve(configName)
+		if err != nil {
+			return nil, err
+		}
+		wrap, err := NewFromConfig(cfg, opts)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", pattern, err)
+		}
+		return compileRoute(name, pattern, wrap, nil)
+	}
+	for _, r := range table.Routes {
+		rt, err := build(r.Name, r.Pattern, r.Config)
+		if err != nil {
+			return nil, err
+		}
+		rl.routes = append(rl.routes, rt)
+	}
+	if table.Default != "" {
+		rt, err := build("default", "/*", table.Default)
+		if err != nil {
+			return nil, err
+		}
+		rl.fallback = rt
+	}
+	return rl, nil
+}
+
+// match returns the first route matching r, or the fallback
+func (rl *RouteRateLimiter) match(r *http.Request) *route {
+	for _, rt := range rl.routes {
+		if rt.pattern.Match(r.Method, r.URL.Path) {
+			return rt
+		}
+	}
+	return rl.fallback
+}
+
+// Middleware returns an HTTP middleware function with per-route rate
+// limiting
+func (rl *RouteRateLimiter) Middleware(next http.Handler) http.Handler {
+	passed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m, ok := r.Context().Value(routeKey{}).(*routeMatch); ok {
+			m.passed = true
+		}
+		next.ServeHTTP(w, r)
+	})
+	handlers := make(map[*route]http.Handler, len(rl.routes)+1)
+	for _, rt := range rl.routes {
+		handlers[rt] = rt.wrap(passed)
+	}
+	if rl.fallback != nil {
+		handlers[rl.fallback] = rl.fallback.wrap(passed)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt := rl.match(r)
+		if rt == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m := &routeMatch{name: rt.name}
+		handlers[rt].ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, m)))
+		if m.passed {
+			rt.stats.RecordAllowed()
+		} else {
+			rt.stats.RecordDenied()
+		}
+	})
+}
+
+// MiddlewareFunc returns an HTTP middleware function for use with http.HandlerFunc
+func (rl *RouteRateLimiter) MiddlewareFunc(next http.HandlerFunc) http.HandlerFunc {
+	return rl.Middleware(next).ServeHTTP
+}
+
+// Stats returns the counts of the rule named name, or nil if there is no
+// such rule. The fallback rule is named "default" unless named otherwise.
+func (rl *RouteRateLimiter) Stats(name string) *stats.Stats {
+	for _, rt := range rl.routes {
+		if rt.name == name {
+			return rt.stats
+		}
+	}
+	if rl.fallback != nil && rl.fallback.name == name {
+		return rl.fallback.stats
+	}
+	return nil
+}
+
+// Close stops evicting idle keys in the rules with one limiter per key.
+// The middleware keeps working. Close always returns nil.
+func (rl *RouteRateLimiter) Close() error {
+	for _, rt := range rl.routes {
+		rt.close()
+	}
+	if rl.fallback != nil {
+		rl.fallback.close()
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+func TestRouteRateLimiter(t *testing.T) {
+	search := &mockRateLimiter{allowReturn: false}
+	postItems := &mockRateLimiter{allowReturn: false}
+	items := &mockRateLimiter{allowReturn: true}
+	fallback := &mockRateLimiter{allowReturn: true}
+
+	var denied []LimitInfo
+	rl, err := NewRouteRateLimiter([]RouteRule{
+		{Name: "search", Pattern: "/api/search", Limiter: search},
+		{Name: "create item", Pattern: "POST /api/items/*", Limiter: postItems},
+		{Name: "items", Pattern: "/api/items/{id}", Limiter: items},
+		{Pattern: "/api/*", Limiter: items}, // shadowed for the paths above
+	}, &RouteRule{Limiter: fallback}, &Options{
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request) {
+			info, _ := InfoFromContext(r.Context())
+			denied = append(denied, info)
+			DefaultErrorHandler(w, r)
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRateLimiter failed: %v", err)
+	}
+	var route string
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, _ = RouteFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		method, path string
+		code         int
+		route        string
+	}{
+		{"GET", "/api/search", http.StatusTooManyRequests, "search"},
+		{"POST", "/api/items/42", http.StatusTooManyRequests, "create item"},
+		{"GET", "/api/items/42", http.StatusOK, "items"},
+		{"GET", "/api/items/42/reviews", http.StatusOK, "/api/*"},
+		{"GET", "/api/search/recent", http.StatusOK, "/api/*"},
+		{"GET", "/home", http.StatusOK, "default"},
+	}
+	for _, tt := range tests {
+		route = ""
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.code, rec.Code)
+		}
+		if tt.code == http.StatusOK && route != tt.route {
+			t.Errorf("%s %s: expected route %q, got %q", tt.method, tt.path, tt.route, route)
+		}
+	}
+
+	if search.getCallCount() != 1 || postItems.getCallCount() != 1 || items.getCallCount() != 3 || fallback.getCallCount() != 1 {
+		t.Errorf("Expected each request to reach only its first matching rule, got calls %d, %d, %d, %d",
+			search.getCallCount(), postItems.getCallCount(), items.getCallCount(), fallback.getCallCount())
+	}
+	if len(denied) != 2 || denied[0].Route != "search" || denied[1].Route != "create item" {
+		t.Errorf("Expected the error handler to see the matched routes, got %+v", denied)
+	}
+	if s := rl.Stats("search").GetSnapshot(); s.DeniedRequests != 1 || s.AllowedRequests != 0 {
+		t.Errorf("Expected search stats to count one denial, got %+v", s)
+	}
+	if s := rl.Stats("/api/*").GetSnapshot(); s.AllowedRequests != 2 {
+		t.Errorf("Expected an unnamed rule to count under its pattern, got %+v", s)
+	}
+	if rl.Stats("missing") != nil {
+		t.Error("Expected no stats for an unknown rule")
+	}
+}
+
+func TestRouteRateLimiterWithoutFallback(t *testing.T) {
+	rl, err := NewRouteRateLimiter([]RouteRule{
+		{Pattern: "/limited", Factory: func() RateLimiter { return &mockRateLimiter{} }},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewRouteRateLimiter failed: %v", err)
+	}
+	defer rl.Close()
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/limited", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the per-key rule to deny, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/other", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected unmatched requests to pass without a fallback, got %d", rec.Code)
+	}
+
+	if _, err := NewRouteRateLimiter([]RouteRule{{Pattern: "/a"}}, nil, nil); err == nil {
+		t.Error("Expected a rule without a limiter to be rejected")
+	}
+	if _, err := NewRouteRateLimiter([]RouteRule{{Pattern: "a", Limiter: &mockRateLimiter{}}}, nil, nil); err == nil {
+		t.Error("Expected a malformed pattern to be rejected")
+	}
+}
+
+func TestRouteRateLimiterFromConfigSet(t *testing.T) {
+	cs := config.NewConfigSet()
+	cs.Add("search", &config.Config{Rate: 1, Burst: 1, Enabled: true, ErrorMessage: "search limited"})
+	cs.Add("items", &config.Config{Rate: 100, Burst: 100, Enabled: true})
+	if err := cs.SetRoutes(&config.RouteTable{
+		Routes: []config.Route{
+			{Name: "search", Pattern: "/api/search", Config: "search"},
+			{Name: "items", Pattern: "/api/items/*", Config: "items"},
+		},
+		Default: "search",
+	}); err != nil {
+		t.Fatalf("SetRoutes failed: %v", err)
+	}
+
+	rl, err := NewRouteRateLimiterFromConfigSet(cs, nil)
+	if err != nil {
+		t.Fatalf("NewRouteRateLimiterFromConfigSet failed: %v", err)
+	}
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	send("/api/search")
+	if rec := send("/api/search"); rec.Code != http.StatusTooManyRequests || rec.Body.String() != "search limited\n" {
+		t.Errorf("Expected the search route's own limit and message, got %d %q", rec.Code, rec.Body.String())
+	}
+	for i := 0; i < 10; i++ {
+		if rec := send("/api/items/1"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected the items route's higher limit, request %d got %d", i, rec.Code)
+		}
+	}
+	// The default route has a limiter of its own, separate from search's
+	if rec := send("/home"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the default route to allow its first request, got %d", rec.Code)
+	}
+	if rec := send("/home"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the default route to deny its second request, got %d", rec.Code)
+	}
+
+	if _, err := NewRouteRateLimiterFromConfigSet(config.NewConfigSet(), nil); err == nil {
+		t.Error("Expected a config set without routes to be rejected")
+	}
+}
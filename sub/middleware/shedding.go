@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// MemoryReading is a sample of the resources a per-key limiter consumes
+type MemoryReading struct {
+	HeapBytes uint64
+	Keys      int
+}
+
+// SheddingStep is one rung of a degradation ladder. Apply gives up some
+// functionality to save memory and Restore undoes it.
+type SheddingStep struct {
+	Name    string
+	Apply   func()
+	Restore func()
+}
+
+// SheddingEvent reports a step being applied or restored
+type SheddingEvent struct {
+	Step    string
+	Applied bool
+	Reading MemoryReading
+}
+
+// SheddingOptions configures a SheddingCoordinator
+type SheddingOptions struct {
+	// MaxHeapBytes and MaxKeys are the pressure thresholds; exceeding
+	// either one is pressure. Zero disables a threshold.
+	MaxHeapBytes uint64
+	MaxKeys      int
+	// RecoverFraction sets the hysteresis: steps are restored only once
+	// every reading is below this fraction of its threshold. Defaults to
+	// 0.8.
+	RecoverFraction float64
+	// Interval is how often Start samples memory. Defaults to 10 seconds.
+	Interval time.Duration
+	// Steps is the degradation ladder, applied in order. Defaults to
+	// DefaultSheddingSteps for the limiter.
+	Steps []SheddingStep
+	// OnEvent, if set, is called for every step applied or restored
+	OnEvent func(SheddingEvent)
+}
+
+// DefaultSheddingSteps returns the degradation ladder for rl: first stop
+// tracking top consumers, then stop counting requests per key in
+// Options.KeyedStats, then halve the key cap and idle TTL so keys are
+// evicted sooner. Without a key cap, the keys held when the last step is
+// applied are capped at half their number. Every step leaves the keys
+// that stay with their limiters, so clients keep the limits they have
+// used up; hashing keys, see SetKeyHashing, would not.
+func DefaultSheddingSteps(rl *PerKeyHTTPRateLimiter) []SheddingStep {
+	return []SheddingStep{
+		{
+			Name:    "pause-consumer-tracking",
+			Apply:   func() { rl.SetConsumerTracking(false) },
+			Restore: func() { rl.SetConsumerTracking(true) },
+		},
+		{
+			Name:    "pause-keyed-stats",
+			Apply:   func() { rl.SetKeyedStatsRecording(false) },
+			Restore: func() { rl.SetKeyedStatsRecording(true) },
+		},
+		{
+			Name: "tighten-key-limits",
+			Apply: func() {
+				maxKeys := rl.maxKeys
+				if maxKeys == 0 {
+					maxKeys = rl.KeyCount()
+				}
+				rl.limiters.SetMaxKeys(max(maxKeys/2, 1), rl.rejectNewKeys)
+				rl.limiters.SetIdleTTL(rl.idleTTL / 2)
+			},
+			Restore: func() {
+				rl.limiters.SetMaxKeys(rl.maxKeys, rl.rejectNewKeys)
+				rl.limiters.SetIdleTTL(rl.idleTTL)
+			},
+		},
+	}
+}
+
+// SheddingCoordinator watches the memory a per-key limiter uses and
+// degrades it one step at a time while under pressure, restoring steps in
+// reverse order once pressure has subsided
+type SheddingCoordinator struct {
+	limiter *PerKeyHTTPRateLimiter
+	opts    SheddingOptions
+	read    func() MemoryReading
+
+	mu      sync.Mutex
+	applied int
+	stop    chan struct{}
+}
+
+// NewSheddingCoordinator creates a coordinator for rl. Call Start to sample
+// memory periodically or Check to evaluate once.
+func NewSheddingCoordinator(rl *PerKeyHTTPRateLimiter, opts SheddingOptions) *SheddingCoordinator {
+	if opts.RecoverFraction <= 0 || opts.RecoverFraction > 1 {
+		opts.RecoverFraction = 0.8
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.Steps == nil {
+		opts.Steps = DefaultSheddingSteps(rl)
+	}
+
+	c := &SheddingCoordinator{limiter: rl, opts: opts}
+	c.read = func() MemoryReading {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return MemoryReading{HeapBytes: m.HeapAlloc, Keys: rl.KeyCount()}
+	}
+	return c
+}
+
+// Level returns how many steps are currently applied
+func (c *SheddingCoordinator) Level() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.applied
+}
+
+// Check samples memory once and applies or restores at most one step
+func (c *SheddingCoordinator) Check() {
+	reading := c.read()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.exceeds(reading, 1) && c.applied < len(c.opts.Steps):
+		step := c.opts.Steps[c.applied]
+		c.applied++
+		step.Apply()
+		c.emit(SheddingEvent{Step: step.Name, Applied: true, Reading: reading})
+	case !c.exceeds(reading, c.opts.RecoverFraction) && c.applied > 0:
+		c.applied--
+		step := c.opts.Steps[c.applied]
+		step.Restore()
+		c.emit(SheddingEvent{Step: step.Name, Applied: false, Reading: reading})
+	}
+}
+
+// Start samples memory every interval until Stop is called
+func (c *SheddingCoordinator) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.stop = stop
+	go func() {
+		ticker := time.NewTicker(c.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.Check()
+			}
+		}
+	}()
+}
+
+// Stop stops periodic sampling. Applied steps stay applied.
+func (c *SheddingCoordinator) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+}
+
+// exceeds reports whether reading is above fraction of any threshold.
+// Must hold mu.
+func (c *SheddingCoordinator) exceeds(reading MemoryReading, fraction float64) bool {
+	if c.opts.MaxHeapBytes > 0 && float64(reading.HeapBytes) > fraction*float64(c.opts.MaxHeapBytes) {
+		return true
+	}
+	return c.opts.MaxKeys > 0 && float64(reading.Keys) > fraction*float64(c.opts.MaxKeys)
+}
+
+// emit reports an event. Must hold mu.
+func (c *SheddingCoordinator) emit(event SheddingEvent) {
+	if c.opts.OnEvent != nil {
+		c.opts.OnEvent(event)
+	}
+}
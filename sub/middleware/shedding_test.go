@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/internal/leaktest"
+	"github.com/rRateLimit/arg/sub/limiter"
+	"github.com/rRateLimit/arg/sub/stats"
+)
+
+func TestSheddingLadder(t *testing.T) {
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter {
+		return &mockRateLimiter{allowReturn: true}
+	}, &Options{
+		TopConsumers: 4,
+		KeyFunc:      func(r *http.Request) string { return r.Header.Get("X-Key") },
+	})
+
+	var reading MemoryReading
+	var events []string
+	c := NewSheddingCoordinator(rl, SheddingOptions{
+		MaxHeapBytes: 1000,
+		MaxKeys:      100,
+		OnEvent: func(e SheddingEvent) {
+			verb := "restore"
+			if e.Applied {
+				verb = "apply"
+			}
+			events = append(events, verb+" "+e.Step)
+		},
+	})
+	c.read = func() MemoryReading { return reading }
+
+	steps := []struct {
+		reading MemoryReading
+		level   int
+	}{
+		{MemoryReading{HeapBytes: 500, Keys: 10}, 0},
+		{MemoryReading{HeapBytes: 1500, Keys: 10}, 1},
+		{MemoryReading{HeapBytes: 500, Keys: 150}, 2},  // keys alone are pressure
+		{MemoryReading{HeapBytes: 2000, Keys: 150}, 3},
+		{MemoryReading{HeapBytes: 2000, Keys: 150}, 3}, // no more steps
+		{MemoryReading{HeapBytes: 900, Keys: 10}, 3},   // below threshold, above recovery
+		{MemoryReading{HeapBytes: 700, Keys: 10}, 2},
+		{MemoryReading{HeapBytes: 700, Keys: 90}, 2},
+		{MemoryReading{HeapBytes: 100, Keys: 10}, 1},
+		{MemoryReading{HeapBytes: 100, Keys: 10}, 0},
+		{MemoryReading{HeapBytes: 100, Keys: 10}, 0},
+	}
+	for i, step := range steps {
+		reading = step.reading
+		c.Check()
+		if got := c.Level(); got != step.level {
+			t.Errorf("Step %d (%+v): expected level %d, got %d", i, step.reading, step.level, got)
+		}
+	}
+
+	expected := "apply pause-consumer-tracking, apply pause-keyed-stats, apply tighten-key-limits, " +
+		"restore tighten-key-limits, restore pause-keyed-stats, restore pause-consumer-tracking"
+	if got := strings.Join(events, ", "); got != expected {
+		t.Errorf("Expected events %q, got %q", expected, got)
+	}
+}
+
+func TestDefaultSheddingSteps(t *testing.T) {
+	keyed := stats.NewKeyedStats(16)
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter {
+		return limiter.NewRateLimiter(1, 1)
+	}, &Options{
+		TopConsumers: 4,
+		KeyedStats:   keyed,
+		MaxKeys:      8,
+		IdleTTL:      time.Hour,
+		KeyFunc:      func(r *http.Request) string { return r.Header.Get("X-Key") },
+	})
+	defer rl.Close()
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func(key string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	steps := DefaultSheddingSteps(rl)
+	send("alice")
+
+	steps[0].Apply()
+	send("alice")
+	if top := rl.TopConsumers(1); len(top) != 1 || top[0].Count != 1 {
+		t.Errorf("Expected tracking to be paused, got %+v", top)
+	}
+
+	steps[1].Apply()
+	send("alice")
+	if got, _ := keyed.Get("alice"); got.Allowed != 1 || got.Denied != 1 {
+		t.Errorf("Expected keyed stats to be paused, got %+v", got)
+	}
+
+	for i := 0; i < 6; i++ {
+		send(strconv.Itoa(i))
+	}
+	steps[2].Apply()
+	if code := send("alice"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected alice to keep the used-up limiter, got %d", code)
+	}
+	for i := 6; i < 10; i++ {
+		send(strconv.Itoa(i))
+	}
+	if n := rl.KeyCount(); n != 4 {
+		t.Errorf("Expected the key cap to be halved to 4, got %d keys", n)
+	}
+
+	steps[2].Restore()
+	for i := 10; i < 16; i++ {
+		send(strconv.Itoa(i))
+	}
+	if n := rl.KeyCount(); n != 8 {
+		t.Errorf("Expected the key cap to be restored to 8, got %d keys", n)
+	}
+
+	steps[1].Restore()
+	steps[0].Restore()
+	send("alice")
+	if top := rl.TopConsumers(1); top[0].Count != 2 {
+		t.Errorf("Expected tracking to resume, got %+v", top)
+	}
+	if got, _ := keyed.Get("alice"); got.Allowed+got.Denied != 3 {
+		t.Errorf("Expected keyed stats to resume, got %+v", got)
+	}
+}
+
+func TestTightenKeyLimitsWithoutCap(t *testing.T) {
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter {
+		return &mockRateLimiter{allowReturn: true}
+	}, &Options{KeyFunc: func(r *http.Request) string { return r.Header.Get("X-Key") }})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func(key string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Key", key)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	for i := 0; i < 10; i++ {
+		send(strconv.Itoa(i))
+	}
+
+	step := DefaultSheddingSteps(rl)[2]
+	step.Apply()
+	send("new")
+	if n := rl.KeyCount(); n != 5 {
+		t.Errorf("Expected the keys held to be capped at half, got %d", n)
+	}
+	step.Restore()
+	for i := 10; i < 20; i++ {
+		send(strconv.Itoa(i))
+	}
+	if n := rl.KeyCount(); n != 15 {
+		t.Errorf("Expected no cap once restored, got %d keys", n)
+	}
+}
+
+func TestSheddingCoordinatorStartStop(t *testing.T) {
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return &mockRateLimiter{} }, nil)
+	applied := make(chan struct{}, 1)
+	c := NewSheddingCoordinator(rl, SheddingOptions{
+		MaxKeys:  1,
+		Interval: time.Millisecond,
+		OnEvent: func(SheddingEvent) {
+			select {
+			case applied <- struct{}{}:
+			default:
+			}
+		},
+	})
+	c.read = func() MemoryReading { return MemoryReading{Keys: 5} }
+
+	c.Start()
+	c.Start()
+	defer c.Stop()
+	select {
+	case <-applied:
+	case <-time.After(time.Second):
+		t.Fatal("Expected periodic sampling to apply a step")
+	}
+}
+
+func TestSheddingCoordinatorSoak(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	leaktest.Soak(t, 50, 10, 2*time.Second, func(i int) {
+		rl := NewPerKeyHTTPRateLimiter(func() RateLimiter {
+			return &mockRateLimiter{allowReturn: true}
+		}, &Options{
+			TopConsumers: 8,
+			KeyFunc:      func(r *http.Request) string { return r.Header.Get("X-Key") },
+		})
+		handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		c := NewSheddingCoordinator(rl, SheddingOptions{MaxKeys: 20, Interval: time.Millisecond})
+		c.Start()
+
+		keys := 1 + rng.Intn(40)
+		for j := 0; j < 200; j++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Key", strconv.Itoa(rng.Intn(keys)))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		c.Stop()
+
+		if got := rl.KeyCount(); got > keys {
+			t.Fatalf("Cycle %d: expected at most %d keys, got %d", i, keys, got)
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+// skips reports whether r bypasses rate limiting, because skip returns
+// true for it or exclusions exclude it or its client IP
+func skips(r *http.Request, skip SkipFunc, exclusions *config.Exclusions, clientIP KeyFunc) bool {
+	return (skip != nil && skip(r)) || isExcluded(r, exclusions, clientIP)
+}
+
+// SkipOptions skips CORS preflight requests, which browsers send on their
+// own before the request they precede
+func SkipOptions(r *http.Request) bool {
+	return r.Method == http.MethodOptions
+}
+
+// SkipHealthChecks returns a SkipFunc skipping requests for exactly the
+// given paths, such as "/healthz", so that load balancer probes are never
+// limited
+func SkipHealthChecks(paths ...string) SkipFunc {
+	set := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		set[p] = true
+	}
+	return func(r *http.Request) bool {
+		return set[r.URL.Path]
+	}
+}
+
+// SkipAny returns a SkipFunc skipping the requests any of funcs skips
+func SkipAny(funcs ...SkipFunc) SkipFunc {
+	return func(r *http.Request) bool {
+		for _, f := range funcs {
+			if f(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+func TestSkipFunc(t *testing.T) {
+	exclusions, err := config.CompileExclusions([]string{"/static/*"}, nil)
+	if err != nil {
+		t.Fatalf("CompileExclusions failed: %v", err)
+	}
+	internal := func(r *http.Request) bool { return r.Header.Get("X-Service-Token") == "secret" }
+	opts := &Options{
+		SkipFunc:   SkipAny(SkipOptions, SkipHealthChecks("/healthz"), internal),
+		Exclusions: exclusions,
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		token   string
+		skipped bool
+	}{
+		{"preflight", http.MethodOptions, "/api", "", true},
+		{"health check", http.MethodGet, "/healthz", "", true},
+		{"service token", http.MethodPost, "/api", "secret", true},
+		{"excluded path", http.MethodGet, "/static/app.js", "", true},
+		{"health check prefix", http.MethodGet, "/healthz/deep", "", false},
+		{"wrong token", http.MethodPost, "/api", "guess", false},
+		{"plain request", http.MethodGet, "/api", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockRateLimiter{allowReturn: false}
+			perKeyMock := &mockRateLimiter{allowReturn: false}
+			handlers := map[string]http.Handler{
+				"global":  NewHTTPRateLimiter(mock, opts).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+				"per-key": NewPerKeyHTTPRateLimiter(func() RateLimiter { return perKeyMock }, opts).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+			}
+			for name, handler := range handlers {
+				req := httptest.NewRequest(tt.method, tt.path, nil)
+				if tt.token != "" {
+					req.Header.Set("X-Service-Token", tt.token)
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				want := http.StatusTooManyRequests
+				if tt.skipped {
+					want = http.StatusOK
+				}
+				if rec.Code != want {
+					t.Errorf("%s: expected %d, got %d", name, want, rec.Code)
+				}
+			}
+			calls := mock.getCallCount() + perKeyMock.getCallCount()
+			if tt.skipped && calls != 0 {
+				t.Errorf("Expected skipped requests not to call the limiter, got %d calls", calls)
+			}
+			if !tt.skipped && calls != 2 {
+				t.Errorf("Expected each middleware to call its limiter once, got %d calls", calls)
+			}
+		})
+	}
+}
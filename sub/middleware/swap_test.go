@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRateLimiter allows up to limit requests and counts every call
+type countingRateLimiter struct {
+	calls atomic.Int64
+	limit int64
+}
+
+func (c *countingRateLimiter) Allow() bool {
+	return c.calls.Add(1) <= c.limit
+}
+
+// blockingRateLimiter blocks in Allow until release is closed
+type blockingRateLimiter struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingRateLimiter) Allow() bool {
+	close(b.entered)
+	<-b.release
+	return true
+}
+
+func TestSetLimiterMidBurst(t *testing.T) {
+	old := &countingRateLimiter{limit: 1 << 30}
+	rl := NewHTTPRateLimiter(old, nil)
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	const workers, perWorker = 8, 200
+	var (
+		wg      sync.WaitGroup
+		allowed atomic.Int64
+		swapped = make(chan struct{})
+		next    = &countingRateLimiter{limit: 1 << 30}
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+				if rec.Code == http.StatusOK {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(swapped)
+		time.Sleep(time.Millisecond)
+		if got := rl.SetLimiter(next); got != old {
+			t.Error("Expected SetLimiter to return the previous limiter")
+		}
+	}()
+	wg.Wait()
+	<-swapped
+
+	if rl.GetLimiter() != next {
+		t.Error("Expected GetLimiter to return the new limiter")
+	}
+	// Every request was checked by exactly one of the two limiters
+	total := old.calls.Load() + next.calls.Load()
+	if total != workers*perWorker || allowed.Load() != total {
+		t.Errorf("Expected %d requests split across limiters, got %d+%d with %d allowed",
+			workers*perWorker, old.calls.Load(), next.calls.Load(), allowed.Load())
+	}
+}
+
+func TestSetLimiterAndDrain(t *testing.T) {
+	old := &blockingRateLimiter{entered: make(chan struct{}), release: make(chan struct{})}
+	rl := NewHTTPRateLimiter(old, nil)
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	<-old.entered
+
+	next := &mockRateLimiter{allowReturn: true}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := rl.SetLimiterAndDrain(ctx, next); err != context.DeadlineExceeded {
+		t.Errorf("Expected drain to time out while a request is in flight, got %v", err)
+	}
+
+	// New requests use the new limiter while the old one is still busy
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if next.getCallCount() != 1 {
+		t.Error("Expected new requests to use the new limiter")
+	}
+
+	close(old.release)
+	<-done
+	drained, err := rl.SetLimiterAndDrain(context.Background(), &mockRateLimiter{allowReturn: true})
+	if err != nil || drained != next {
+		t.Errorf("Expected to drain the idle limiter, got %v, %v", drained, err)
+	}
+}
+
+func TestSetLimiterNil(t *testing.T) {
+	rl := NewHTTPRateLimiter(&mockRateLimiter{}, nil)
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected SetLimiter(nil) to panic")
+		}
+	}()
+	rl.SetLimiter(nil)
+}
+
+func TestSetFactory(t *testing.T) {
+	first := &mockRateLimiter{allowReturn: true}
+	second := &mockRateLimiter{allowReturn: false}
+	rl := NewPerKeyHTTPRateLimiter(func() RateLimiter { return first }, &Options{
+		KeyFunc: func(r *http.Request) string { return r.URL.Path },
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil))
+	rl.SetFactory(func() RateLimiter { return second })
+
+	recA := httptest.NewRecorder()
+	handler.ServeHTTP(recA, httptest.NewRequest("GET", "/a", nil))
+	recB := httptest.NewRecorder()
+	handler.ServeHTTP(recB, httptest.NewRequest("GET", "/b", nil))
+
+	if recA.Code != http.StatusOK {
+		t.Errorf("Expected existing key to keep its limiter, got %d", recA.Code)
+	}
+	if recB.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected new key to use the new factory, got %d", recB.Code)
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+// TierFunc returns the tier a request belongs to, such as "free" or
+// "enterprise", for NewTieredHTTPRateLimiter
+type TierFunc func(r *http.Request) string
+
+// DefaultTier names the config NewTieredHTTPRateLimiter uses for requests
+// whose tier has none
+const DefaultTier = "default"
+
+// NewTieredHTTPRateLimiter creates per-key middleware limiting each request
+// by its tier, from tierFunc, and its key, from keyFunc or else
+// opts.KeyFunc or DefaultKeyFunc. Every tier and key pair gets its own
+// limiter, built from the tier's config in cs, or from the DefaultTier
+// config if cs has none for the tier, so one key can be limited in several
+// tiers and the same key in different tiers does not share a limiter. The
+// DefaultTier config must resolve.
+//
+// Configs are looked up as limiters are built, so changing cs applies to
+// pairs seen afterwards. Existing limiters keep their old limits until they
+// are evicted, by Options.IdleTTL or Options.MaxKeys. Keys the limiter
+// reports, such as in LimitInfo and TopConsumers, are the tier and key
+// joined as by KeyFuncs.Combination. It panics if tierFunc is nil.
+func NewTieredHTTPRateLimiter(cs *config.ConfigSet, tierFunc TierFunc, keyFunc KeyFunc, opts *Options) (*PerKeyHTTPRateLimiter, error) {
+	if tierFunc == nil {
+		panic("middleware: nil tier func")
+	}
+	factory, err := KeyedFactoryFromConfigSet(cs, tierOfKey, DefaultTier)
+	if err != nil {
+		return nil, err
+	}
+
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if keyFunc == nil {
+		keyFunc = o.KeyFunc
+	}
+	if keyFunc == nil {
+		keyFunc = DefaultKeyFunc
+	}
+	o.KeyFunc = combineKeys(CombinationSeparator, []KeyFunc{KeyFunc(tierFunc), keyFunc})
+	return NewPerKeyHTTPRateLimiterKeyed(factory, &o), nil
+}
+
+// tierOfKey returns the tier a key built by NewTieredHTTPRateLimiter starts
+// with, undoing the escaping of combineKeys
+func tierOfKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case c == '\\' && i+1 < len(key):
+			i++
+			b.WriteByte(key[i])
+		case strings.HasPrefix(key[i:], CombinationSeparator):
+			return b.String()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/config"
+)
+
+func TestTieredHTTPRateLimiter(t *testing.T) {
+	cs := config.NewConfigSet()
+	cs.Add("free", &config.Config{Rate: 1, Burst: 2, Enabled: true})
+	cs.Add("pro", &config.Config{Rate: 1, Burst: 5, Enabled: true})
+	cs.Add(DefaultTier, &config.Config{Rate: 1, Burst: 1, Enabled: true})
+
+	tierFunc := func(r *http.Request) string { return r.Header.Get("X-Tier") }
+	rl, err := NewTieredHTTPRateLimiter(cs, tierFunc, KeyFuncs.ByAPIKey("X-API-Key"), nil)
+	if err != nil {
+		t.Fatalf("NewTieredHTTPRateLimiter failed: %v", err)
+	}
+	defer rl.Close()
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	admitted := func(tier, key string) int {
+		n := 0
+		for i := 0; i < 10; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Tier", tier)
+			req.Header.Set("X-API-Key", key)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code == http.StatusOK {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := admitted("free", "alice"); n != 2 {
+		t.Errorf("Expected the free burst of 2, got %d", n)
+	}
+	if n := admitted("pro", "bob"); n != 5 {
+		t.Errorf("Expected the pro burst of 5, got %d", n)
+	}
+	if n := admitted("pro", "alice"); n != 5 {
+		t.Errorf("Expected the same key in another tier to get its own limiter, got %d", n)
+	}
+	if n := admitted("gold", "carol"); n != 1 {
+		t.Errorf("Expected an unknown tier to fall back to the default burst of 1, got %d", n)
+	}
+	if n := admitted("a|b", "carol"); n != 1 {
+		t.Errorf("Expected a tier containing the separator to fall back to the default, got %d", n)
+	}
+
+	// Changing the set applies to new limiters only
+	cs.Add("free", &config.Config{Rate: 1, Burst: 3, Enabled: true})
+	if n := admitted("free", "alice"); n != 0 {
+		t.Errorf("Expected the existing limiter to keep its limits, got %d", n)
+	}
+	if n := admitted("free", "dave"); n != 3 {
+		t.Errorf("Expected a new key to get the new burst of 3, got %d", n)
+	}
+}
+
+func TestTieredBoostKey(t *testing.T) {
+	cs := config.NewConfigSet()
+	cs.Add(DefaultTier, &config.Config{Rate: 1, Burst: 2, Enabled: true})
+	tierFunc := func(r *http.Request) string { return r.Header.Get("X-Tier") }
+	rl, err := NewTieredHTTPRateLimiter(cs, tierFunc, KeyFuncs.ByAPIKey("X-API-Key"), nil)
+	if err != nil {
+		t.Fatalf("NewTieredHTTPRateLimiter failed: %v", err)
+	}
+	defer rl.Close()
+	var keys []string
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, _ := InfoFromContext(r.Context())
+		keys = append(keys, info.Key)
+	}))
+
+	if err := rl.BoostKey(TierKey("a|b", "alice"), 2, time.Minute); err != nil {
+		t.Fatalf("BoostKey failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Tier", "a|b")
+		req.Header.Set("X-API-Key", "alice")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if len(keys) != 4 || keys[0] != TierKey("a|b", "alice") {
+		t.Errorf("Expected the boosted burst of 4 under the tier key, got %q", keys)
+	}
+}
+
+func TestTieredHTTPRateLimiterRequiresDefault(t *testing.T) {
+	cs := config.NewConfigSet()
+	cs.Add("free", &config.Config{Rate: 1, Burst: 2, Enabled: true})
+	if _, err := NewTieredHTTPRateLimiter(cs, func(*http.Request) string { return "free" }, nil, nil); err == nil {
+		t.Error("Expected a set without a default tier to be rejected")
+	}
+}
+
+func TestTierOfKey(t *testing.T) {
+	for _, tier := range []string{"", "free", "a|b", `a\b`, `a\|`} {
+		key := combineKeys(CombinationSeparator, []KeyFunc{
+			func(*http.Request) string { return tier },
+			func(*http.Request) string { return "x|y" },
+		})(httptest.NewRequest("GET", "/", nil))
+		if got := tierOfKey(key); got != tier {
+			t.Errorf("tierOfKey(%q) = %q, want %q", key, got, tier)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+// RateLimitedError is returned by Transport for requests it does not send
+// because their key is over the limit. LimiterName is the name of the key's
+// limiter, as in LimitInfo. Err is the cause when the request gave up
+// waiting, such as context.DeadlineExceeded.
+type RateLimitedError struct {
+	Key         string
+	LimiterName string
+	RetryAfter  time.Duration
+	Err         error
+}
+
+func (e *RateLimitedError) Error() string {
+	msg := fmt.Sprintf("rate limited: %s", e.Key)
+	if e.LimiterName != "" {
+		msg = fmt.Sprintf("rate limited by %s: %s", e.LimiterName, e.Key)
+	}
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(" (retry after %v)", e.RetryAfter)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *RateLimitedError) Unwrap() error {
+	return e.Err
+}
+
+// TransportOptions configures a Transport
+type TransportOptions struct {
+	// KeyFunc picks the limiter for a request. Defaults to the request's
+	// host, so every upstream API gets its own limiter.
+	KeyFunc KeyFunc
+	// Mode selects whether requests over the limit fail at once with a
+	// RateLimitedError, the default, or wait, for at most MaxWait if
+	// positive and as long as their context allows.
+	Mode    Mode
+	MaxWait time.Duration
+	// Feedback reports every response to its limiter if it implements
+	// limiter.OutcomeReporter, as a failure for 429 and 503 and a success
+	// otherwise, and holds back a key's requests for as long as a 429 or
+	// 503 response's Retry-After asks
+	Feedback bool
+}
+
+// Transport is an http.RoundTripper that rate limits outbound requests
+// before passing them to another RoundTripper, to stay within a third-party
+// API's limits
+type Transport struct {
+	base     http.RoundTripper
+	limiters *limiter.KeyedLimiter
+	keyFunc  KeyFunc
+	mode     Mode
+	maxWait  time.Duration
+	feedback bool
+	now      func() time.Time
+
+	mu      sync.Mutex
+	blocked map[string]time.Time
+}
+
+// HostKeyFunc uses the request's host, including any port, as the key
+func HostKeyFunc(r *http.Request) string {
+	return r.URL.Host
+}
+
+// NewTransport creates a Transport sending requests through base, or
+// http.DefaultTransport if nil, limited by one limiter per key built by
+// factory
+func NewTransport(base http.RoundTripper, factory LimiterFactory, opts *TransportOptions) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &Transport{
+		base:     base,
+		limiters: limiter.NewKeyedLimiter(func(string) limiter.Allower { return factory() }),
+		keyFunc:  HostKeyFunc,
+		now:      time.Now,
+		blocked:  make(map[string]time.Time),
+	}
+	if opts != nil {
+		if opts.KeyFunc != nil {
+			t.keyFunc = opts.KeyFunc
+		}
+		t.mode = opts.Mode
+		t.maxWait = opts.MaxWait
+		t.feedback = opts.Feedback
+	}
+	return t
+}
+
+// RoundTrip sends req once its key's limiter admits it
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := t.keyFunc(req)
+	entry, err := t.limiters.Entry(key)
+	if err == nil {
+		err = t.admit(req.Context(), key, entry.Limiter())
+	}
+	if err != nil {
+		// A RoundTripper must close the body even when it fails
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && t.feedback {
+		t.observe(key, entry.Limiter(), resp)
+	}
+	return resp, err
+}
+
+// admit waits for or checks l according to the mode, after any hold a
+// Retry-After put on key
+func (t *Transport) admit(ctx context.Context, key string, l RateLimiter) error {
+	hold := t.hold(key)
+	if t.mode != ModeWait {
+		if hold > 0 {
+			return &RateLimitedError{Key: key, LimiterName: limiterName(l), RetryAfter: hold}
+		}
+		if decision := limiter.AllowDetail(l); !decision.Allowed {
+			return &RateLimitedError{Key: key, LimiterName: limiterName(l), RetryAfter: decision.RetryAfter}
+		}
+		return nil
+	}
+
+	if t.maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.maxWait)
+		defer cancel()
+	}
+	if hold > 0 {
+		timer := time.NewTimer(hold)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return &RateLimitedError{Key: key, LimiterName: limiterName(l), RetryAfter: t.hold(key), Err: ctx.Err()}
+		}
+	}
+	if err := limiter.WaitContext(ctx, l); err != nil {
+		return &RateLimitedError{Key: key, LimiterName: limiterName(l), RetryAfter: limiter.RetryAfter(l), Err: err}
+	}
+	return nil
+}
+
+// hold returns how much longer a Retry-After holds back key's requests
+func (t *Transport) hold(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.blocked[key]
+	if !ok {
+		return 0
+	}
+	if d := until.Sub(t.now()); d > 0 {
+		return d
+	}
+	delete(t.blocked, key)
+	return 0
+}
+
+// observe reports resp to l and records any Retry-After it carries
+func (t *Transport) observe(key string, l RateLimiter, resp *http.Response) {
+	throttled := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+	if r, ok := l.(limiter.OutcomeReporter); ok {
+		if throttled {
+			r.ReportFailure()
+		} else {
+			r.ReportSuccess()
+		}
+	}
+	if !throttled {
+		return
+	}
+	if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), t.now()); ok {
+		t.mu.Lock()
+		if until := t.now().Add(d); until.After(t.blocked[key]) {
+			t.blocked[key] = until
+		}
+		t.mu.Unlock()
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as an HTTP
+// date, into a delay from now
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+// timestampServer records when each request arrived
+type timestampServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	times []time.Time
+}
+
+func newTimestampServer(t *testing.T, handler http.HandlerFunc) *timestampServer {
+	s := &timestampServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.times = append(s.times, time.Now())
+		s.mu.Unlock()
+		if handler != nil {
+			handler(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *timestampServer) requests() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), s.times...)
+}
+
+func TestTransportWaits(t *testing.T) {
+	server := newTimestampServer(t, nil)
+	transport := NewTransport(nil, func() RateLimiter { return limiter.NewRateLimiter(20, 1) }, &TransportOptions{Mode: ModeWait})
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+	times := server.requests()
+	if len(times) != 5 {
+		t.Fatalf("Expected 5 requests to reach the server, got %d", len(times))
+	}
+	// Four requests beyond the burst at 20 per second need about 200ms
+	if span := times[4].Sub(times[0]); span < 150*time.Millisecond {
+		t.Errorf("Expected requests to be spaced out, all five arrived within %v", span)
+	}
+}
+
+func TestTransportFailsFastPerHost(t *testing.T) {
+	first := newTimestampServer(t, nil)
+	second := newTimestampServer(t, nil)
+	transport := NewTransport(nil, func() RateLimiter { return limiter.NewNamedRateLimiter("upstream", 1, 1) }, nil)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(first.URL)
+	if err != nil {
+		t.Fatalf("First request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	_, err = client.Get(first.URL)
+	var limited *RateLimitedError
+	if !errors.As(err, &limited) {
+		t.Fatalf("Expected a RateLimitedError, got %v", err)
+	}
+	if limited.Key != first.Listener.Addr().String() || limited.LimiterName != "upstream" || limited.RetryAfter <= 0 {
+		t.Errorf("Expected the error to name the host, the limiter and when to retry, got %+v", limited)
+	}
+	if n := len(first.requests()); n != 1 {
+		t.Errorf("Expected the denied request not to be sent, server saw %d", n)
+	}
+
+	// Another host has its own limiter
+	resp, err = client.Get(second.URL)
+	if err != nil {
+		t.Fatalf("Expected another host to be unaffected, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestTransportWaitCancelled(t *testing.T) {
+	server := newTimestampServer(t, nil)
+	transport := NewTransport(nil, func() RateLimiter { return limiter.NewRateLimiterPer(1, time.Minute, 1) }, &TransportOptions{Mode: ModeWait})
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("First request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	start := time.Now()
+	_, err = client.Do(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the wait to end with the context, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the cancelled wait to stop promptly, took %v", elapsed)
+	}
+	if n := len(server.requests()); n != 1 {
+		t.Errorf("Expected the cancelled request not to be sent, server saw %d", n)
+	}
+}
+
+func TestTransportFeedback(t *testing.T) {
+	throttle := true
+	var mu sync.Mutex
+	server := newTimestampServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if throttle {
+			throttle = false
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+
+	adaptive, err := limiter.NewAdaptiveLimiter(limiter.AdaptivePolicy{MinRate: 1, MaxRate: 100, Burst: 10})
+	if err != nil {
+		t.Fatalf("NewAdaptiveLimiter failed: %v", err)
+	}
+	transport := NewTransport(nil, func() RateLimiter { return adaptive }, &TransportOptions{Feedback: true})
+	clock := newFakeClock()
+	transport.now = clock.Now
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("First request failed: %v", err)
+	}
+	resp.Body.Close()
+	if rate := adaptive.Rate(); rate != 50 {
+		t.Errorf("Expected a 429 to halve the rate to 50, got %v", rate)
+	}
+
+	_, err = client.Get(server.URL)
+	var limited *RateLimitedError
+	if !errors.As(err, &limited) || limited.RetryAfter != 30*time.Second {
+		t.Fatalf("Expected Retry-After to hold the host back for 30s, got %v", err)
+	}
+
+	clock.Advance(30 * time.Second)
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected requests to resume after Retry-After, got %v", err)
+	}
+	resp.Body.Close()
+	if rate := adaptive.Rate(); rate <= 50 {
+		t.Errorf("Expected a success to raise the rate, got %v", rate)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"Mon, 01 Jan 2024 00:00:30 GMT", 30 * time.Second, true},
+		{"Sun, 31 Dec 2023 23:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, expected %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
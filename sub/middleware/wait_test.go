@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
+)
+
+func TestModeWaitSpacesRequests(t *testing.T) {
+	rl := NewHTTPRateLimiter(limiter.NewRateLimiter(20, 1), &Options{Mode: ModeWait, MaxWait: time.Second})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to wait and succeed, got %d", i, rec.Code)
+		}
+	}
+	// Four requests beyond the burst at 20 per second need about 200ms
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected requests to be spaced out, all five took %v", elapsed)
+	}
+}
+
+func TestModeWaitGivesUp(t *testing.T) {
+	factory := func() RateLimiter { return limiter.NewRateLimiterPer(1, time.Minute, 1) }
+	handler := NewPerKeyHTTPRateLimiter(factory, &Options{Mode: ModeWait, MaxWait: 20 * time.Millisecond}).
+		Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func(ctx context.Context) (int, time.Duration) {
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		return rec.Code, time.Since(start)
+	}
+
+	if code, _ := send(context.Background()); code != http.StatusOK {
+		t.Fatalf("Expected the first request to use the burst, got %d", code)
+	}
+	code, elapsed := send(context.Background())
+	if code != http.StatusTooManyRequests {
+		t.Errorf("Expected a request waiting past MaxWait to be rejected, got %d", code)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Expected the wait to stop at MaxWait, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	code, elapsed = send(ctx)
+	if code != http.StatusTooManyRequests {
+		t.Errorf("Expected a request whose client went away to be rejected, got %d", code)
+	}
+	if elapsed > 10*time.Millisecond {
+		t.Errorf("Expected a cancelled request to stop waiting promptly, took %v", elapsed)
+	}
+}
+
+func TestModeWaitCancelledMidWait(t *testing.T) {
+	rl := NewHTTPRateLimiter(limiter.NewRateLimiterPer(1, time.Minute, 1), &Options{Mode: ModeWait})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	// Without a deadline the limiter cannot tell the wait is hopeless, so it
+	// waits until the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the request to be rejected when its context is cancelled, got %d", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the wait to end promptly on cancellation, took %v", elapsed)
+	}
+}
+
+func TestModeWaitWithoutWaiter(t *testing.T) {
+	mock := &mockRateLimiter{allowReturn: false}
+	rl := NewHTTPRateLimiter(mock, &Options{Mode: ModeWait, MaxWait: time.Minute})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusTooManyRequests || mock.getCallCount() != 1 {
+		t.Errorf("Expected an Allow-only limiter to reject at once, got %d after %d calls", rec.Code, mock.getCallCount())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected no waiting, took %v", elapsed)
+	}
+}
@@ -0,0 +1,124 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// MarshalJSON encodes the snapshot with its durations both in nanoseconds,
+// as backend_latency_ns and duration_ns, and as human-readable strings, as
+// backend_latency and duration
+func (s StatsSnapshot) MarshalJSON() ([]byte, error) {
+	type plain StatsSnapshot
+	return json.Marshal(struct {
+		plain
+		BackendLatency string `json:"backend_latency"`
+		Duration       string `json:"duration"`
+	}{
+		plain:          plain(s),
+		BackendLatency: s.BackendLatency.String(),
+		Duration:       s.Duration.Round(time.Millisecond).String(),
+	})
+}
+
+// swapper is implemented by collectors that can reset atomically, such as
+// Stats
+type swapper interface {
+	Swap() StatsSnapshot
+}
+
+// saturationReporter is implemented by collectors that record when the
+// limiter was saturated, such as Stats
+type saturationReporter interface {
+	SaturationIntervals(since time.Time) []SaturationInterval
+}
+
+// saturationJSON is a SaturationInterval as Handler serves it, without an
+// end while it is ongoing
+type saturationJSON struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// Handler serves c's current snapshot as JSON. A POST with ?reset=true
+// resets c and serves the snapshot taken just before, atomically if c has
+// a Swap method as Stats does. Responses are never cached.
+//
+// With ?since=T, where T is an RFC 3339 time or a duration such as 1h to
+// look back from now, the response also holds saturation_intervals, the
+// SaturationIntervals since T, for collectors recording them as Stats
+// does.
+func Handler(c Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reset := r.URL.Query().Get("reset") == "true"
+		switch {
+		case reset && r.Method != http.MethodPost:
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "reset requires POST", http.StatusMethodNotAllowed)
+			return
+		case !reset && r.Method != http.MethodGet && r.Method != http.MethodHead:
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var intervals []saturationJSON
+		if param := r.URL.Query().Get("since"); param != "" {
+			reporter, ok := c.(saturationReporter)
+			if !ok {
+				http.Error(w, "collector does not record saturation", http.StatusBadRequest)
+				return
+			}
+			since, err := parseSince(param)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			intervals = []saturationJSON{}
+			for _, interval := range reporter.SaturationIntervals(since) {
+				entry := saturationJSON{Start: interval.Start}
+				if !interval.End.IsZero() {
+					entry.End = &interval.End
+				}
+				intervals = append(intervals, entry)
+			}
+		}
+
+		var snapshot StatsSnapshot
+		if !reset {
+			snapshot = c.GetSnapshot()
+		} else if s, ok := c.(swapper); ok {
+			snapshot = s.Swap()
+		} else {
+			snapshot = c.GetSnapshot()
+			c.Reset()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if intervals == nil {
+			json.NewEncoder(w).Encode(snapshot)
+			return
+		}
+		// The snapshot encodes itself, so add the intervals to its fields
+		var fields map[string]json.RawMessage
+		encoded, _ := json.Marshal(snapshot)
+		json.Unmarshal(encoded, &fields)
+		fields["saturation_intervals"], _ = json.Marshal(intervals)
+		json.NewEncoder(w).Encode(fields)
+	})
+}
+
+// parseSince parses the since parameter of Handler
+func parseSince(param string) (time.Time, error) {
+	if d, err := time.ParseDuration(param); err == nil && d >= 0 {
+		return time.Now().Add(-d), nil
+	}
+	since, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("since must be an RFC 3339 time or a non-negative duration, got %q", param)
+	}
+	return since, nil
+}
@@ -0,0 +1,185 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	s, now := newClockedStats()
+	s.Name = "api"
+	s.RecordAllowed()
+	s.RecordAllowed()
+	s.RecordDenied()
+	s.RecordBackendCall(1500*time.Microsecond, nil)
+	*now = now.Add(2 * time.Second)
+
+	server := httptest.NewServer(Handler(s))
+	defer server.Close()
+	do := func(method, query string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	decode := func(resp *http.Response) map[string]any {
+		t.Helper()
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.StatusCode)
+		}
+		var fields map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&fields); err != nil {
+			t.Fatal(err)
+		}
+		return fields
+	}
+
+	resp := do("GET", "")
+	if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Expected the snapshot not to be cached, got %q", got)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Expected JSON, got %q", got)
+	}
+	fields := decode(resp)
+	want := map[string]any{
+		"name":               "api",
+		"total_requests":     3.0,
+		"allowed_requests":   2.0,
+		"denied_requests":    1.0,
+		"backend_calls":      1.0,
+		"backend_latency_ns": 1.5e6,
+		"backend_latency":    "1.5ms",
+		"duration_ns":        2e9,
+		"duration":           "2s",
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, fields[name])
+		}
+	}
+	for _, name := range []string{"start_time", "last_request_time", "rate", "acceptance_ratio", "last_1m", "last_5m", "last_15m"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("Expected field %s in %v", name, fields)
+		}
+	}
+	if last1m, _ := fields["last_1m"].(map[string]any); last1m["allowed"] == nil || last1m["denied"] == nil {
+		t.Errorf("Expected allowed and denied rates in last_1m, got %v", fields["last_1m"])
+	}
+
+	// Resetting requires POST
+	for _, method := range []string{"GET", "PUT"} {
+		resp := do(method, "?reset=true")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("%s: expected a reset to require POST, got %d", method, resp.StatusCode)
+		}
+	}
+	if s.GetSnapshot().TotalRequests != 3 {
+		t.Fatal("Expected a refused reset to leave the counters alone")
+	}
+
+	if fields := decode(do("POST", "?reset=true")); fields["total_requests"] != 3.0 {
+		t.Errorf("Expected the reset to return the counts before it, got %v", fields)
+	}
+	if fields := decode(do("GET", "")); fields["total_requests"] != 0.0 {
+		t.Errorf("Expected the counters to be reset, got %v", fields)
+	}
+	resp = do("POST", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected POST without reset to be refused, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerSaturationIntervals(t *testing.T) {
+	s, now := newClockedStats()
+	start := *now
+	for _, allowed := range []bool{false, false, true, false} {
+		if allowed {
+			s.RecordAllowed()
+		} else {
+			s.RecordDenied()
+		}
+		*now = now.Add(time.Second)
+	}
+	handler := Handler(s)
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/"+query, nil))
+		return rec
+	}
+
+	var body struct {
+		TotalRequests int64 `json:"total_requests"`
+		Intervals     []struct {
+			Start time.Time  `json:"start"`
+			End   *time.Time `json:"end"`
+		} `json:"saturation_intervals"`
+	}
+	rec := get("?since=" + start.Add(-time.Minute).Format(time.RFC3339))
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode %q: %v", rec.Body.String(), err)
+	}
+	if body.TotalRequests != 4 {
+		t.Errorf("Expected the snapshot fields alongside the intervals, got %s", rec.Body.String())
+	}
+	if len(body.Intervals) != 2 {
+		t.Fatalf("Expected a finished and an ongoing interval, got %s", rec.Body.String())
+	}
+	if first := body.Intervals[0]; !first.Start.Equal(start) || first.End == nil || !first.End.Equal(start.Add(2*time.Second)) {
+		t.Errorf("Unexpected first interval %+v", first)
+	}
+	if ongoing := body.Intervals[1]; !ongoing.Start.Equal(start.Add(3*time.Second)) || ongoing.End != nil {
+		t.Errorf("Expected the ongoing interval to have no end, got %+v", ongoing)
+	}
+
+	// The finished interval ended before since, the ongoing one has not
+	body.Intervals = nil
+	json.Unmarshal(get("?since="+start.Add(2*time.Second).Format(time.RFC3339)).Body.Bytes(), &body)
+	if len(body.Intervals) != 1 {
+		t.Errorf("Expected only the ongoing interval, got %+v", body.Intervals)
+	}
+
+	if rec := get(""); strings.Contains(rec.Body.String(), "saturation_intervals") {
+		t.Errorf("Expected no intervals without since, got %s", rec.Body.String())
+	}
+	if rec := get("?since=yesterday"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a malformed since to be refused, got %d", rec.Code)
+	}
+	if rec := get("?since=1h"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a duration to be accepted, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	Handler(MultiCollector(NewStats())).ServeHTTP(rec, httptest.NewRequest("GET", "/?since=1h", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a collector without saturation history to be refused, got %d", rec.Code)
+	}
+}
+
+func TestSnapshotJSONRoundTrip(t *testing.T) {
+	s, now := newClockedStats()
+	s.RecordAllowed()
+	*now = now.Add(time.Second)
+	original := s.GetSnapshot()
+
+	data, err := json.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded StatsSnapshot
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.AllowedRequests != 1 || decoded.Duration != original.Duration || !decoded.StartTime.Equal(original.StartTime) {
+		t.Errorf("Expected %+v to survive a round trip, got %+v", original, decoded)
+	}
+}
@@ -0,0 +1,132 @@
+package stats
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultKeyedStatsCapacity is the number of keys KeyedStats tracks when
+// created with a capacity below one
+const DefaultKeyedStatsCapacity = 10000
+
+// KeyStats holds the counts recorded for one key
+type KeyStats struct {
+	Key        string
+	Allowed    int64
+	Denied     int64
+	LastDenied time.Time
+}
+
+// KeyedStats counts allowed and denied requests per key, to find out which
+// clients are being throttled. It tracks at most capacity keys: recording
+// a new key beyond that forgets the least recently recorded one, along with
+// its counts, so a flood of one-off keys cannot exhaust memory. It is safe
+// for concurrent use.
+type KeyedStats struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List // of *KeyStats, most recently recorded first
+	now      func() time.Time
+}
+
+// NewKeyedStats creates a KeyedStats tracking up to capacity keys
+func NewKeyedStats(capacity int) *KeyedStats {
+	if capacity < 1 {
+		capacity = DefaultKeyedStatsCapacity
+	}
+	return &KeyedStats{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// RecordAllowed records an allowed request for key
+func (k *KeyedStats) RecordAllowed(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.entry(key).Allowed++
+}
+
+// RecordDenied records a denied request for key
+func (k *KeyedStats) RecordDenied(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	s := k.entry(key)
+	s.Denied++
+	s.LastDenied = k.now()
+}
+
+// entry returns the counts for key, making it the most recently recorded
+// and evicting the least recently recorded key if it is new and there is
+// no room. Must hold mu.
+func (k *KeyedStats) entry(key string) *KeyStats {
+	if e, ok := k.entries[key]; ok {
+		k.lru.MoveToFront(e)
+		return e.Value.(*KeyStats)
+	}
+	if k.lru.Len() >= k.capacity {
+		oldest := k.lru.Back()
+		k.lru.Remove(oldest)
+		delete(k.entries, oldest.Value.(*KeyStats).Key)
+	}
+	s := &KeyStats{Key: key}
+	k.entries[key] = k.lru.PushFront(s)
+	return s
+}
+
+// Get returns the counts for key, if it is tracked
+func (k *KeyedStats) Get(key string) (KeyStats, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if e, ok := k.entries[key]; ok {
+		return *e.Value.(*KeyStats), true
+	}
+	return KeyStats{}, false
+}
+
+// TopDenied returns up to n tracked keys with denied requests, most denied
+// first. A negative n returns all of them.
+func (k *KeyedStats) TopDenied(n int) []KeyStats {
+	k.mu.Lock()
+	var denied []KeyStats
+	for e := k.lru.Front(); e != nil; e = e.Next() {
+		if s := e.Value.(*KeyStats); s.Denied > 0 {
+			denied = append(denied, *s)
+		}
+	}
+	k.mu.Unlock()
+
+	sort.Slice(denied, func(i, j int) bool {
+		if denied[i].Denied != denied[j].Denied {
+			return denied[i].Denied > denied[j].Denied
+		}
+		return denied[i].Key < denied[j].Key
+	})
+	if n >= 0 && n < len(denied) {
+		denied = denied[:n]
+	}
+	return denied
+}
+
+// Len returns the number of keys tracked
+func (k *KeyedStats) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lru.Len()
+}
+
+// Reset forgets every key
+func (k *KeyedStats) Reset() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.entries = make(map[string]*list.Element)
+	k.lru.Init()
+}
@@ -0,0 +1,93 @@
+package stats
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedStats(t *testing.T) {
+	ks := NewKeyedStats(10)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ks.now = func() time.Time { return now }
+
+	ks.RecordAllowed("alice")
+	ks.RecordDenied("alice")
+	for i := 0; i < 3; i++ {
+		ks.RecordDenied("mallory")
+	}
+	now = now.Add(time.Minute)
+	ks.RecordDenied("mallory")
+	ks.RecordAllowed("bob")
+
+	top := ks.TopDenied(5)
+	if len(top) != 2 || top[0].Key != "mallory" || top[1].Key != "alice" {
+		t.Fatalf("Expected mallory then alice, got %+v", top)
+	}
+	if top[0].Denied != 4 || !top[0].LastDenied.Equal(now) {
+		t.Errorf("Expected mallory denied 4 times, last a minute in, got %+v", top[0])
+	}
+	if top[1].Allowed != 1 || top[1].Denied != 1 {
+		t.Errorf("Expected alice allowed and denied once, got %+v", top[1])
+	}
+	if got := ks.TopDenied(1); len(got) != 1 || got[0].Key != "mallory" {
+		t.Errorf("Expected only the worst offender, got %+v", got)
+	}
+	if s, ok := ks.Get("bob"); !ok || s.Allowed != 1 || !s.LastDenied.IsZero() {
+		t.Errorf("Expected bob allowed once and never denied, got %+v, %v", s, ok)
+	}
+
+	ks.Reset()
+	if ks.Len() != 0 || len(ks.TopDenied(-1)) != 0 {
+		t.Error("Expected Reset to forget every key")
+	}
+}
+
+func TestKeyedStatsEvictsLeastRecent(t *testing.T) {
+	ks := NewKeyedStats(2)
+	ks.RecordDenied("a")
+	ks.RecordDenied("b")
+	ks.RecordDenied("a") // b is now the least recent
+	ks.RecordDenied("c")
+
+	if _, ok := ks.Get("b"); ok {
+		t.Error("Expected the least recently recorded key to be evicted")
+	}
+	if s, ok := ks.Get("a"); !ok || s.Denied != 2 {
+		t.Errorf("Expected a to keep its counts, got %+v, %v", s, ok)
+	}
+}
+
+func TestKeyedStatsConcurrentBounded(t *testing.T) {
+	const capacity = 100
+	ks := NewKeyedStats(capacity)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				key := fmt.Sprintf("key-%d-%d", g, i%500)
+				if i%3 == 0 {
+					ks.RecordDenied(key)
+				} else {
+					ks.RecordAllowed(key)
+				}
+				if n := ks.Len(); n > capacity {
+					t.Errorf("Expected at most %d keys, got %d", capacity, n)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n := ks.Len(); n != capacity {
+		t.Errorf("Expected the map to be full at %d keys, got %d", capacity, n)
+	}
+	if top := ks.TopDenied(-1); len(top) > capacity {
+		t.Errorf("Expected at most %d denied keys, got %d", capacity, len(top))
+	}
+}
@@ -0,0 +1,116 @@
+package stats
+
+import "time"
+
+// prepaidRecorder is implemented by collectors that count prepaid requests,
+// such as Stats
+type prepaidRecorder interface {
+	RecordPrepaid()
+}
+
+// bypassRecorder is implemented by collectors that count requests let
+// through while limiting is off, such as Stats
+type bypassRecorder interface {
+	RecordBypassed()
+}
+
+// waitRecorder is implemented by collectors that track how long callers
+// wait, such as Stats
+type waitRecorder interface {
+	RecordWait(d time.Duration)
+}
+
+// multiCollector fans out to several collectors
+type multiCollector struct {
+	collectors []Collector
+}
+
+// MultiCollector returns a Collector recording every request to each of
+// collectors, such as Stats for a dashboard alongside an exporter, in
+// order. If a collector panics the others still see the request before the
+// panic is raised again, but each call is synchronous: a slow collector
+// slows every request. GetSnapshot returns the first collector's snapshot,
+// and Reset resets them all.
+func MultiCollector(collectors ...Collector) Collector {
+	return &multiCollector{collectors: collectors}
+}
+
+func (m *multiCollector) RecordAllowed() {
+	m.each(func(c Collector) { c.RecordAllowed() })
+}
+
+func (m *multiCollector) RecordDenied() {
+	m.each(func(c Collector) { c.RecordDenied() })
+}
+
+// RecordPrepaid records a prepaid request to the collectors that count them
+func (m *multiCollector) RecordPrepaid() {
+	m.each(func(c Collector) {
+		if p, ok := c.(prepaidRecorder); ok {
+			p.RecordPrepaid()
+		}
+	})
+}
+
+// RecordBypassed records a bypassed request to the collectors that count
+// them
+func (m *multiCollector) RecordBypassed() {
+	m.each(func(c Collector) {
+		if b, ok := c.(bypassRecorder); ok {
+			b.RecordBypassed()
+		}
+	})
+}
+
+// RecordWait records a wait to the collectors that track waits
+func (m *multiCollector) RecordWait(d time.Duration) {
+	m.each(func(c Collector) {
+		if w, ok := c.(waitRecorder); ok {
+			w.RecordWait(d)
+		}
+	})
+}
+
+func (m *multiCollector) GetSnapshot() StatsSnapshot {
+	if len(m.collectors) == 0 {
+		return StatsSnapshot{}
+	}
+	return m.collectors[0].GetSnapshot()
+}
+
+func (m *multiCollector) Reset() {
+	m.each(func(c Collector) { c.Reset() })
+}
+
+// each calls fn with every collector, even after one of the calls panics,
+// and then raises the first panic again
+func (m *multiCollector) each(fn func(Collector)) {
+	var failure any
+	for _, c := range m.collectors {
+		func() {
+			defer func() {
+				if err := recover(); err != nil && failure == nil {
+					failure = err
+				}
+			}()
+			fn(c)
+		}()
+	}
+	if failure != nil {
+		panic(failure)
+	}
+}
+
+// rename sets the name reported by c, or by every Stats c fans out to
+func rename(c Collector, name string) {
+	switch c := c.(type) {
+	case *Stats:
+		c.mu.Lock()
+		c.Name = name
+		c.mu.Unlock()
+	case *multiCollector:
+		for _, child := range c.collectors {
+			rename(child, name)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// countingCollector counts the events it sees
+type countingCollector struct {
+	allowed, denied, prepaid, bypassed, resets atomic.Int64
+	panics                                     bool
+}
+
+func (c *countingCollector) RecordAllowed() {
+	c.allowed.Add(1)
+	if c.panics {
+		panic("sink unavailable")
+	}
+}
+
+func (c *countingCollector) RecordDenied()   { c.denied.Add(1) }
+func (c *countingCollector) RecordPrepaid()  { c.prepaid.Add(1) }
+func (c *countingCollector) RecordBypassed() { c.bypassed.Add(1) }
+func (c *countingCollector) Reset()          { c.resets.Add(1) }
+
+func (c *countingCollector) GetSnapshot() StatsSnapshot {
+	return StatsSnapshot{AllowedRequests: c.allowed.Load(), DeniedRequests: c.denied.Load()}
+}
+
+func TestMultiCollector(t *testing.T) {
+	first, second := &countingCollector{}, &countingCollector{}
+	memory := NewStats()
+	rl := NewRateLimiterWithStats(&mockRateLimiter{allowReturn: true}, MultiCollector(first, memory, second))
+
+	rl.Allow()
+	rl.Allow()
+	rl.RecordPrepaid()
+	rl.RecordBypassed()
+	rl.limiter.(*mockRateLimiter).allowReturn = false
+	rl.Allow()
+
+	for name, c := range map[string]*countingCollector{"first": first, "second": second} {
+		if c.allowed.Load() != 2 || c.denied.Load() != 1 || c.prepaid.Load() != 1 || c.bypassed.Load() != 1 {
+			t.Errorf("%s: expected 2 allowed, 1 denied, 1 prepaid and 1 bypassed, got %d, %d, %d and %d", name, c.allowed.Load(), c.denied.Load(), c.prepaid.Load(), c.bypassed.Load())
+		}
+	}
+	if s := memory.GetSnapshot(); s.AllowedRequests != 2 || s.DeniedRequests != 1 || s.PrepaidRequests != 1 || s.BypassedRequests != 1 {
+		t.Errorf("Expected Stats to see every event, got %+v", s)
+	}
+	if s := rl.GetStats().GetSnapshot(); s.AllowedRequests != 2 || s.DeniedRequests != 1 {
+		t.Errorf("Expected the first collector's snapshot, got %+v", s)
+	}
+
+	rl.SetName("api")
+	if memory.GetSnapshot().Name != "api" {
+		t.Error("Expected SetName to rename the Stats behind the multi collector")
+	}
+
+	rl.GetStats().Reset()
+	if first.resets.Load() != 1 || second.resets.Load() != 1 || memory.TotalRequests() != 0 {
+		t.Error("Expected Reset to reach every collector")
+	}
+}
+
+func TestMultiCollectorPanickingChild(t *testing.T) {
+	failing, healthy := &countingCollector{panics: true}, &countingCollector{}
+	multi := MultiCollector(failing, healthy)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected the child's panic to be raised again")
+			}
+		}()
+		multi.RecordAllowed()
+	}()
+	if healthy.allowed.Load() != 1 {
+		t.Error("Expected the collectors after a panicking one to still see the request")
+	}
+}
+
+func TestNewRateLimiterWithStatsDefaultCollector(t *testing.T) {
+	rl := NewRateLimiterWithStats(&mockRateLimiter{allowReturn: true})
+	rl.Allow()
+	if _, ok := rl.GetStats().(*Stats); !ok {
+		t.Fatalf("Expected a Stats by default, got %T", rl.GetStats())
+	}
+	if s := rl.GetStats().GetSnapshot(); s.AllowedRequests != 1 {
+		t.Errorf("Expected the default Stats to count, got %+v", s)
+	}
+
+	custom := &countingCollector{}
+	NewRateLimiterWithStats(&mockRateLimiter{allowReturn: true}, custom).Allow()
+	if custom.allowed.Load() != 1 {
+		t.Error("Expected a given collector to be recorded to")
+	}
+}
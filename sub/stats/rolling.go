@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// rollingSeconds is how far back the rolling counters reach
+const rollingSeconds = 15 * 60
+
+// WindowRates are the allowed and denied requests per second over a
+// trailing window
+type WindowRates struct {
+	Allowed float64 `json:"allowed"`
+	Denied  float64 `json:"denied"`
+}
+
+// rollingCounter counts decisions in per-second buckets covering the last
+// rollingSeconds. Each bucket packs the second it counts, as a 32-bit Unix
+// time, above a 32-bit count, so a bucket left over from an earlier lap of
+// the ring is ignored when read and restarted when written without a lock:
+// idle periods need no work to drop out.
+type rollingCounter struct {
+	allowed [rollingSeconds]atomic.Uint64
+	denied  [rollingSeconds]atomic.Uint64
+	floor   atomic.Uint32 // buckets up to this second predate a reset
+}
+
+// record counts a decision made at now
+func (c *rollingCounter) record(allowed bool, now time.Time) {
+	second := uint64(uint32(now.Unix()))
+	bucket := &c.denied[second%rollingSeconds]
+	if allowed {
+		bucket = &c.allowed[second%rollingSeconds]
+	}
+	for {
+		old := bucket.Load()
+		next := second<<32 | 1
+		if old>>32 == second {
+			next = old + 1
+		}
+		if bucket.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// windows returns the rates over the trailing 1, 5 and 15 minutes ending
+// at now, in one pass over the buckets. Rates are averaged over the whole
+// window, even one longer than the counter has been running.
+func (c *rollingCounter) windows(now time.Time) (last1m, last5m, last15m WindowRates) {
+	newest := uint32(now.Unix())
+	floor := c.floor.Load()
+	var allowed, denied uint64
+	count := func(bucket *atomic.Uint64, second uint32) uint64 {
+		if packed := bucket.Load(); packed != 0 && uint32(packed>>32) == second {
+			return packed & (1<<32 - 1)
+		}
+		return 0
+	}
+	rates := func(seconds float64) WindowRates {
+		return WindowRates{Allowed: float64(allowed) / seconds, Denied: float64(denied) / seconds}
+	}
+	for age := uint32(0); age < rollingSeconds; age++ {
+		second := newest - age
+		if second <= floor {
+			break
+		}
+		allowed += count(&c.allowed[second%rollingSeconds], second)
+		denied += count(&c.denied[second%rollingSeconds], second)
+		switch age + 1 {
+		case 60:
+			last1m = rates(60)
+		case 300:
+			last5m = rates(300)
+		}
+	}
+	if newest-floor <= 60 {
+		last1m = rates(60)
+	}
+	if newest-floor <= 300 {
+		last5m = rates(300)
+	}
+	return last1m, last5m, rates(rollingSeconds)
+}
+
+// reset forgets every decision made up to now, including those made
+// earlier in the current second
+func (c *rollingCounter) reset(now time.Time) {
+	c.floor.Store(uint32(now.Unix()))
+}
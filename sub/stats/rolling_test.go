@@ -0,0 +1,58 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollingRates(t *testing.T) {
+	s, now := newClockedStats()
+
+	// A burst of 120 allowed and 60 denied requests within one second
+	for i := 0; i < 120; i++ {
+		s.RecordAllowed()
+	}
+	for i := 0; i < 60; i++ {
+		s.RecordDenied()
+	}
+	snapshot := s.GetSnapshot()
+	if snapshot.Last1m != (WindowRates{Allowed: 2, Denied: 1}) {
+		t.Errorf("Expected 2 allowed and 1 denied per second over a minute, got %+v", snapshot.Last1m)
+	}
+	if snapshot.Last5m != (WindowRates{Allowed: 0.4, Denied: 0.2}) {
+		t.Errorf("Expected the burst spread over five minutes, got %+v", snapshot.Last5m)
+	}
+
+	// A minute later the burst has left the 1m window but not the others
+	*now = now.Add(time.Minute)
+	s.RecordDenied()
+	snapshot = s.GetSnapshot()
+	if snapshot.Last1m.Allowed != 0 || snapshot.Last1m.Denied != 1.0/60 {
+		t.Errorf("Expected the 1m rate to decay to the one new denial, got %+v", snapshot.Last1m)
+	}
+	if snapshot.Last15m.Allowed != 120.0/900 || snapshot.Last15m.Denied != 61.0/900 {
+		t.Errorf("Expected the 15m window to keep the burst, got %+v", snapshot.Last15m)
+	}
+	if snapshot.AllowedRequests != 120 || snapshot.DeniedRequests != 61 {
+		t.Errorf("Expected lifetime totals to keep growing, got %+v", snapshot)
+	}
+
+	// Fifteen idle minutes later every window is empty, and the current
+	// second's bucket still holds the denial from a lap ago
+	*now = now.Add(15 * time.Minute)
+	snapshot = s.GetSnapshot()
+	if snapshot.Last1m != (WindowRates{}) || snapshot.Last15m != (WindowRates{}) {
+		t.Errorf("Expected idle windows to be empty, got %+v and %+v", snapshot.Last1m, snapshot.Last15m)
+	}
+
+	// A bucket reused after a lap counts only the new second
+	s.RecordAllowed()
+	if got := s.GetSnapshot().Last1m; got != (WindowRates{Allowed: 1.0 / 60}) {
+		t.Errorf("Expected a reused bucket to start from zero, got %+v", got)
+	}
+
+	s.Reset()
+	if got := s.GetSnapshot().Last15m; got != (WindowRates{}) {
+		t.Errorf("Expected Reset to clear the rolling rates, got %+v", got)
+	}
+}
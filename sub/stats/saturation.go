@@ -0,0 +1,104 @@
+package stats
+
+import "time"
+
+// DefaultSaturationHistory is how many saturation intervals Stats keeps
+// unless SetSaturationHistory is called
+const DefaultSaturationHistory = 64
+
+// SaturationInterval is a period during which every decision was a denial.
+// It starts at the first denial and ends at the next allowed request; End
+// is zero while the limiter is still saturated.
+type SaturationInterval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Duration returns the length of the interval, up to now if it is ongoing
+func (i SaturationInterval) Duration(now time.Time) time.Duration {
+	if i.End.IsZero() {
+		return now.Sub(i.Start)
+	}
+	return i.End.Sub(i.Start)
+}
+
+// saturationRing keeps the most recent closed intervals, oldest first
+type saturationRing struct {
+	intervals []SaturationInterval
+	next      int
+	full      bool
+}
+
+func newSaturationRing(capacity int) saturationRing {
+	return saturationRing{intervals: make([]SaturationInterval, max(capacity, 1))}
+}
+
+func (r *saturationRing) add(interval SaturationInterval) {
+	r.intervals[r.next] = interval
+	r.next = (r.next + 1) % len(r.intervals)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// all returns the intervals in the order they were added
+func (r *saturationRing) all() []SaturationInterval {
+	if !r.full {
+		return append([]SaturationInterval(nil), r.intervals[:r.next]...)
+	}
+	return append(append([]SaturationInterval(nil), r.intervals[r.next:]...), r.intervals[:r.next]...)
+}
+
+// SaturationIntervals returns the recorded saturation intervals that ended
+// after since, oldest first, followed by the current one if the limiter is
+// saturated now. History survives Reset and Swap, since it is already
+// bounded in size by SetSaturationHistory.
+func (s *Stats) SaturationIntervals(since time.Time) []SaturationInterval {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var intervals []SaturationInterval
+	for _, interval := range s.saturation.all() {
+		if interval.End.After(since) {
+			intervals = append(intervals, interval)
+		}
+	}
+	if !s.saturatedSince.IsZero() {
+		intervals = append(intervals, SaturationInterval{Start: s.saturatedSince})
+	}
+	return intervals
+}
+
+// SetSaturationHistory changes how many saturation intervals are kept,
+// keeping the most recent ones that fit
+func (s *Stats) SetSaturationHistory(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ring := newSaturationRing(n)
+	kept := s.saturation.all()
+	for _, interval := range kept[max(len(kept)-len(ring.intervals), 0):] {
+		ring.add(interval)
+	}
+	s.saturation = ring
+}
+
+// observeSaturation updates the saturation state for a decision made at
+// now. It takes mu only when the state changes.
+func (s *Stats) observeSaturation(allowed bool, now time.Time) {
+	if allowed != s.saturated.Load() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case !allowed && !s.saturated.Load():
+		s.saturatedSince = now
+		s.saturated.Store(true)
+	case allowed && s.saturated.Load():
+		s.saturation.add(SaturationInterval{Start: s.saturatedSince, End: now})
+		s.saturatedSince = time.Time{}
+		s.saturated.Store(false)
+	}
+}
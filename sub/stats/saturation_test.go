@@ -0,0 +1,113 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+// newClockedStats returns Stats reading time from a clock the test advances
+func newClockedStats() (*Stats, *time.Time) {
+	now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.UTC)
+	s := NewStats()
+	s.now = func() time.Time { return now }
+	s.start = now
+	return s, &now
+}
+
+func TestSaturationIntervals(t *testing.T) {
+	s, now := newClockedStats()
+	start := *now
+	tick := time.Second
+
+	// Allowed for 10 ticks, denied for 5, allowed for 10, denied for 20,
+	// then allowed again
+	pattern := []struct {
+		allowed bool
+		ticks   int
+	}{
+		{true, 10}, {false, 5}, {true, 10}, {false, 20}, {true, 1},
+	}
+	for _, p := range pattern {
+		for i := 0; i < p.ticks; i++ {
+			if p.allowed {
+				s.RecordAllowed()
+			} else {
+				s.RecordDenied()
+			}
+			*now = now.Add(tick)
+		}
+	}
+
+	intervals := s.SaturationIntervals(time.Time{})
+	if len(intervals) != 2 {
+		t.Fatalf("Expected 2 saturation intervals, got %+v", intervals)
+	}
+	expected := []SaturationInterval{
+		{Start: start.Add(10 * tick), End: start.Add(15 * tick)},
+		{Start: start.Add(25 * tick), End: start.Add(45 * tick)},
+	}
+	for i, want := range expected {
+		got := intervals[i]
+		if got.Start.Sub(want.Start).Abs() > tick || got.End.Sub(want.End).Abs() > tick {
+			t.Errorf("Interval %d: expected %v to %v, got %v to %v", i, want.Start, want.End, got.Start, got.End)
+		}
+	}
+	if d := intervals[1].Duration(*now); d != 20*tick {
+		t.Errorf("Expected second interval to last 20s, got %v", d)
+	}
+
+	if recent := s.SaturationIntervals(start.Add(20 * tick)); len(recent) != 1 || !recent[0].Start.Equal(expected[1].Start) {
+		t.Errorf("Expected only the second interval after 14:00:20, got %+v", recent)
+	}
+}
+
+func TestSaturationOngoingAndReset(t *testing.T) {
+	s, now := newClockedStats()
+	s.RecordAllowed()
+	*now = now.Add(time.Second)
+	s.RecordDenied()
+	*now = now.Add(time.Second)
+	s.RecordDenied()
+
+	intervals := s.SaturationIntervals(time.Time{})
+	if len(intervals) != 1 || !intervals[0].End.IsZero() {
+		t.Fatalf("Expected one ongoing interval, got %+v", intervals)
+	}
+	if d := intervals[0].Duration(*now); d != time.Second {
+		t.Errorf("Expected ongoing interval of 1s, got %v", d)
+	}
+
+	s.Reset()
+	*now = now.Add(time.Second)
+	s.RecordAllowed()
+	if intervals := s.SaturationIntervals(time.Time{}); len(intervals) != 1 || intervals[0].End.IsZero() {
+		t.Errorf("Expected the interval to survive reset and close, got %+v", intervals)
+	}
+}
+
+func TestSaturationHistoryBounded(t *testing.T) {
+	s, now := newClockedStats()
+	s.SetSaturationHistory(3)
+
+	for i := 0; i < 5; i++ {
+		s.RecordDenied()
+		*now = now.Add(time.Second)
+		s.RecordAllowed()
+		*now = now.Add(time.Second)
+	}
+
+	intervals := s.SaturationIntervals(time.Time{})
+	if len(intervals) != 3 {
+		t.Fatalf("Expected the last 3 intervals, got %d", len(intervals))
+	}
+	for i := 1; i < len(intervals); i++ {
+		if !intervals[i].Start.After(intervals[i-1].Start) {
+			t.Errorf("Expected intervals oldest first, got %+v", intervals)
+		}
+	}
+
+	s.SetSaturationHistory(2)
+	if shrunk := s.SaturationIntervals(time.Time{}); len(shrunk) != 2 || !shrunk[1].Start.Equal(intervals[2].Start) {
+		t.Errorf("Expected shrinking to keep the 2 newest intervals, got %+v", shrunk)
+	}
+}
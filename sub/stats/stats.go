@@ -1,103 +1,336 @@
 package stats
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/rRateLimit/arg/sub/limiter"
 )
 
-// Stats holds rate limiter statistics
+// Stats holds rate limiter statistics. Requests are counted with atomics,
+// so recording never waits for a lock; read the counts with GetSnapshot or
+// the accessor methods. The counters were exported fields before, which
+// cannot be read safely alongside atomics.
 type Stats struct {
-	TotalRequests    int64
-	AllowedRequests  int64
-	DeniedRequests   int64
-	StartTime        time.Time
-	LastRequestTime  time.Time
-	mu               sync.RWMutex
+	// Name is reported in snapshots. Set it before the Stats is in use.
+	Name string
+
+	allowed        atomic.Int64
+	denied         atomic.Int64
+	prepaid        atomic.Int64
+	bypassed       atomic.Int64
+	backendCalls   atomic.Int64
+	backendErrors  atomic.Int64
+	backendLatency atomic.Int64 // total, in nanoseconds
+	keys           atomic.Int64
+	evictions      atomic.Int64
+	lastRequest    atomic.Int64 // Unix nanoseconds, zero before the first request
+	rolling        rollingCounter
+	waits          waitHistogram
+	saturated      atomic.Bool
+
+	// mu guards the fields below and serializes resets
+	mu             sync.RWMutex
+	start          time.Time
+	now            func() time.Time
+	saturatedSince time.Time
+	saturation     saturationRing
 }
 
 // NewStats creates a new Stats instance
 func NewStats() *Stats {
 	return &Stats{
-		StartTime: time.Now(),
+		start:      time.Now(),
+		now:        time.Now,
+		saturation: newSaturationRing(DefaultSaturationHistory),
 	}
 }
 
 // RecordAllowed records an allowed request
 func (s *Stats) RecordAllowed() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	
-	s.TotalRequests++
-	s.AllowedRequests++
-	s.LastRequestTime = time.Now()
+	s.record(true)
 }
 
 // RecordDenied records a denied request
 func (s *Stats) RecordDenied() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	
-	s.TotalRequests++
-	s.DeniedRequests++
-	s.LastRequestTime = time.Now()
+	s.record(false)
+}
+
+func (s *Stats) record(allowed bool) {
+	now := s.now()
+	if allowed {
+		s.allowed.Add(1)
+	} else {
+		s.denied.Add(1)
+	}
+	s.lastRequest.Store(now.UnixNano())
+	s.rolling.record(allowed, now)
+	s.observeSaturation(allowed, now)
+}
+
+// RecordPrepaid records a request that was let through without a decision
+// because it was already paid for. Prepaid requests are not part of
+// TotalRequests, so they do not affect the rate or acceptance ratio.
+func (s *Stats) RecordPrepaid() {
+	s.prepaid.Add(1)
+	s.lastRequest.Store(s.now().UnixNano())
+}
+
+// RecordBypassed records a request that was let through without a
+// decision because limiting was turned off, such as by a config with
+// enabled set to false. Like prepaid requests, bypassed requests are not
+// part of TotalRequests.
+func (s *Stats) RecordBypassed() {
+	s.bypassed.Add(1)
+	s.lastRequest.Store(s.now().UnixNano())
+}
+
+// RecordBackendCall records a call a limiter made to a remote store, such
+// as Redis, that took latency and failed with err if it is not nil
+func (s *Stats) RecordBackendCall(latency time.Duration, err error) {
+	s.backendCalls.Add(1)
+	s.backendLatency.Add(int64(latency))
+	if err != nil {
+		s.backendErrors.Add(1)
+	}
+}
+
+// RecordKeys records how many keys a keyed limiter holds and how many idle
+// keys it has just evicted
+func (s *Stats) RecordKeys(keys, evicted int) {
+	s.keys.Store(int64(keys))
+	s.evictions.Add(int64(evicted))
+}
+
+// TotalRequests returns the number of allowed and denied requests in the
+// current period
+func (s *Stats) TotalRequests() int64 {
+	return s.allowed.Load() + s.denied.Load()
+}
+
+// AllowedRequests returns the number of allowed requests in the current
+// period
+func (s *Stats) AllowedRequests() int64 {
+	return s.allowed.Load()
+}
+
+// DeniedRequests returns the number of denied requests in the current
+// period
+func (s *Stats) DeniedRequests() int64 {
+	return s.denied.Load()
 }
 
-// GetSnapshot returns a copy of current statistics
+// StartTime returns when the current period started
+func (s *Stats) StartTime() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.start
+}
+
+// LastRequestTime returns when the last request of the current period was
+// recorded, or the zero time if none was
+func (s *Stats) LastRequestTime() time.Time {
+	return unixNano(s.lastRequest.Load())
+}
+
+// unixNano converts Unix nanoseconds to a time, zero staying zero
+func unixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// GetSnapshot returns a copy of current statistics. Each count is exact,
+// but requests recorded while the snapshot is taken may be in some counts
+// and not yet in others; TotalRequests is always the sum of
+// AllowedRequests and DeniedRequests.
 func (s *Stats) GetSnapshot() StatsSnapshot {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	
-	duration := time.Since(s.StartTime)
-	if s.LastRequestTime.After(s.StartTime) {
-		duration = s.LastRequestTime.Sub(s.StartTime)
+
+	now := s.now()
+	return s.snapshot(counts{
+		allowed:        s.allowed.Load(),
+		denied:         s.denied.Load(),
+		prepaid:        s.prepaid.Load(),
+		bypassed:       s.bypassed.Load(),
+		backendCalls:   s.backendCalls.Load(),
+		backendErrors:  s.backendErrors.Load(),
+		backendLatency: s.backendLatency.Load(),
+		evictions:      s.evictions.Load(),
+		lastRequest:    s.lastRequest.Load(),
+		waits:          s.waits.load(false),
+	}, now)
+}
+
+// counts holds the counters of a period
+type counts struct {
+	allowed, denied, prepaid, bypassed          int64
+	backendCalls, backendErrors, backendLatency int64
+	evictions, lastRequest                      int64
+	waits                                       WaitStats
+}
+
+// snapshot builds a snapshot of the period with the given counts. Must hold
+// mu.
+func (s *Stats) snapshot(c counts, now time.Time) StatsSnapshot {
+	last := unixNano(c.lastRequest)
+	duration := now.Sub(s.start)
+	if last.After(s.start) {
+		duration = last.Sub(s.start)
 	}
-	
+
 	var rate float64
 	if duration.Seconds() > 0 {
-		rate = float64(s.AllowedRequests) / duration.Seconds()
+		rate = float64(c.allowed) / duration.Seconds()
+	}
+
+	var latency time.Duration
+	if c.backendCalls > 0 {
+		latency = time.Duration(c.backendLatency / c.backendCalls)
+	}
+
+	last1m, last5m, last15m := s.rolling.windows(now)
+
+	total := c.allowed + c.denied
+	var acceptance float64
+	if total > 0 {
+		acceptance = float64(c.allowed) / float64(total)
 	}
-	
+
 	return StatsSnapshot{
-		TotalRequests:   s.TotalRequests,
-		AllowedRequests: s.AllowedRequests,
-		DeniedRequests:  s.DeniedRequests,
-		StartTime:       s.StartTime,
-		LastRequestTime: s.LastRequestTime,
-		Duration:        duration,
-		Rate:            rate,
-		AcceptanceRatio: s.calculateAcceptanceRatio(),
+		Name:             s.Name,
+		TotalRequests:    total,
+		AllowedRequests:  c.allowed,
+		DeniedRequests:   c.denied,
+		PrepaidRequests:  c.prepaid,
+		BypassedRequests: c.bypassed,
+		BackendCalls:     c.backendCalls,
+		BackendErrors:    c.backendErrors,
+		BackendLatency:   latency,
+		Keys:             int(s.keys.Load()),
+		Evictions:        c.evictions,
+		StartTime:        s.start,
+		LastRequestTime:  last,
+		Duration:         duration,
+		Rate:             rate,
+		AcceptanceRatio:  acceptance,
+		Last1m:           last1m,
+		Last5m:           last5m,
+		Last15m:          last15m,
+		Waits:            c.waits,
 	}
 }
 
 // Reset resets all statistics
 func (s *Stats) Reset() {
+	s.Swap()
+}
+
+// Swap resets all statistics and returns a snapshot of the period that just
+// ended. No request is lost or counted twice between the two, unlike
+// calling GetSnapshot and then Reset.
+func (s *Stats) Swap() StatsSnapshot {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	
-	s.TotalRequests = 0
-	s.AllowedRequests = 0
-	s.DeniedRequests = 0
-	s.StartTime = time.Now()
-	s.LastRequestTime = time.Time{}
+
+	now := s.now()
+	final := s.snapshot(counts{
+		allowed:        s.allowed.Swap(0),
+		denied:         s.denied.Swap(0),
+		prepaid:        s.prepaid.Swap(0),
+		bypassed:       s.bypassed.Swap(0),
+		backendCalls:   s.backendCalls.Swap(0),
+		backendErrors:  s.backendErrors.Swap(0),
+		backendLatency: s.backendLatency.Swap(0),
+		evictions:      s.evictions.Swap(0),
+		lastRequest:    s.lastRequest.Swap(0),
+		waits:          s.waits.load(true),
+	}, now)
+	s.rolling.reset(now)
+	s.start = now
+	return final
+}
+
+// ScheduleReset resets the statistics every day at hour:minute in loc, for
+// daily reporting. If archive is not nil it is called with the final
+// snapshot of each day. The returned function cancels the schedule.
+func (s *Stats) ScheduleReset(hour, minute int, loc *time.Location, archive func(StatsSnapshot)) (stop func()) {
+	return s.scheduleReset(func(now time.Time) time.Time {
+		return nextDaily(now, hour, minute, loc)
+	}, archive)
 }
 
-func (s *Stats) calculateAcceptanceRatio() float64 {
-	if s.TotalRequests == 0 {
-		return 0
+// scheduleReset swaps the statistics at every time returned by next
+func (s *Stats) scheduleReset(next func(time.Time) time.Time, archive func(StatsSnapshot)) func() {
+	done := make(chan struct{})
+	go func() {
+		for {
+			timer := time.NewTimer(time.Until(next(time.Now())))
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			final := s.Swap()
+			if archive != nil {
+				archive(final)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// nextDaily returns the first hour:minute in loc strictly after now
+func nextDaily(now time.Time, hour, minute int, loc *time.Location) time.Time {
+	local := now.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, loc)
+	if !next.After(now) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, hour, minute, 0, 0, loc)
 	}
-	return float64(s.AllowedRequests) / float64(s.TotalRequests)
+	return next
 }
 
 // StatsSnapshot represents a point-in-time snapshot of statistics
 type StatsSnapshot struct {
-	TotalRequests   int64
-	AllowedRequests int64
-	DeniedRequests  int64
-	StartTime       time.Time
-	LastRequestTime time.Time
-	Duration        time.Duration
-	Rate            float64
-	AcceptanceRatio float64
+	Name            string `json:"name"`
+	TotalRequests   int64  `json:"total_requests"`
+	AllowedRequests int64  `json:"allowed_requests"`
+	DeniedRequests  int64  `json:"denied_requests"`
+	PrepaidRequests int64  `json:"prepaid_requests"`
+	// BypassedRequests counts the requests let through while limiting was
+	// turned off, which are not part of TotalRequests
+	BypassedRequests int64 `json:"bypassed_requests"`
+	// BackendCalls and BackendErrors count calls to a remote store, and
+	// BackendLatency is their mean duration
+	BackendCalls   int64         `json:"backend_calls"`
+	BackendErrors  int64         `json:"backend_errors"`
+	BackendLatency time.Duration `json:"backend_latency_ns"`
+	// Keys is the number of keys a keyed limiter last reported holding, and
+	// Evictions how many idle keys it has evicted
+	Keys            int           `json:"keys"`
+	Evictions       int64         `json:"evictions"`
+	StartTime       time.Time     `json:"start_time"`
+	LastRequestTime time.Time     `json:"last_request_time"`
+	Duration        time.Duration `json:"duration_ns"`
+	// Rate is the allowed requests per second over the whole period, and
+	// Last1m, Last5m and Last15m the rates over the trailing minutes, to
+	// spot a current spike in throttling
+	Rate            float64     `json:"rate"`
+	AcceptanceRatio float64     `json:"acceptance_ratio"`
+	Last1m          WindowRates `json:"last_1m"`
+	Last5m          WindowRates `json:"last_5m"`
+	Last15m         WindowRates `json:"last_15m"`
+	// Waits summarizes how long Wait and WaitContext calls blocked
+	Waits WaitStats `json:"waits"`
 }
 
 // Collector interface for collecting rate limiter statistics
@@ -108,41 +341,177 @@ type Collector interface {
 	Reset()
 }
 
-// RateLimiterWithStats wraps a rate limiter with statistics collection
+// RateLimiterWithStats wraps a rate limiter with statistics collection. It
+// forwards every optional capability of the wrapped limiter, degrading as
+// the helpers in the limiter package do when the wrapped limiter lacks one,
+// so it can stand in for the limiter anywhere.
 type RateLimiterWithStats struct {
-	limiter   RateLimiter
-	stats     *Stats
+	limiter   limiter.Allower
+	stats     Collector
+	name      string
 }
 
-// RateLimiter interface that the main rate limiter should implement
-type RateLimiter interface {
-	Allow() bool
-	Wait()
-}
+// RateLimiter is the interface of limiters that can both admit and wait
+type RateLimiter = limiter.AllowWaiter
+
+// Named is implemented by limiters that carry a name
+type Named = limiter.Named
 
-// NewRateLimiterWithStats creates a new rate limiter with statistics
-func NewRateLimiterWithStats(limiter RateLimiter) *RateLimiterWithStats {
+// NewRateLimiterWithStats creates a new rate limiter with statistics. It
+// records to collector if one is given, such as a MultiCollector to record
+// to several sinks, and otherwise to a new Stats named after l.
+func NewRateLimiterWithStats(l limiter.Allower, collector ...Collector) *RateLimiterWithStats {
+	if len(collector) > 0 && collector[0] != nil {
+		return &RateLimiterWithStats{limiter: l, stats: collector[0]}
+	}
+	stats := NewStats()
+	stats.Name = limiter.Name(l)
 	return &RateLimiterWithStats{
-		limiter: limiter,
-		stats:   NewStats(),
+		limiter: l,
+		stats:   stats,
 	}
 }
 
+// Name returns the overriding name if one was set, otherwise the wrapped
+// limiter's name
+func (r *RateLimiterWithStats) Name() string {
+	if r.name != "" {
+		return r.name
+	}
+	return limiter.Name(r.limiter)
+}
+
+// SetName overrides the wrapped limiter's name. It also renames the
+// statistics, if they are Stats, so snapshots keep reporting the same name
+// as the limiter.
+// SetName is not safe to call while the limiter is in use.
+func (r *RateLimiterWithStats) SetName(name string) {
+	r.name = name
+	rename(r.stats, name)
+}
+
 // Allow checks if a request can be processed and records statistics
 func (r *RateLimiterWithStats) Allow() bool {
 	allowed := r.limiter.Allow()
+	r.observe(allowed)
+	return allowed
+}
+
+// AllowN checks if n requests can be processed together and records them
+// as one request
+func (r *RateLimiterWithStats) AllowN(n int) bool {
+	return r.AllowNDetail(n).Allowed
+}
+
+// AllowDetail is like Allow but also reports the wrapped limiter's decision
+func (r *RateLimiterWithStats) AllowDetail() limiter.Decision {
+	decision := limiter.AllowDetail(r.limiter)
+	r.observe(decision.Allowed)
+	return decision
+}
+
+// AllowNDetail is like AllowN but also reports the wrapped limiter's
+// decision
+func (r *RateLimiterWithStats) AllowNDetail(n int) limiter.Decision {
+	decision := limiter.AllowNDetail(r.limiter, n)
+	r.observe(decision.Allowed)
+	return decision
+}
+
+// RetryAfter returns the wrapped limiter's estimate, or zero if it has none
+func (r *RateLimiterWithStats) RetryAfter() time.Duration {
+	return limiter.RetryAfter(r.limiter)
+}
+
+// ResetAfter returns the wrapped limiter's reset time, or zero if it does
+// not report it
+func (r *RateLimiterWithStats) ResetAfter() time.Duration {
+	resetAfter, _ := limiter.ResetAfter(r.limiter)
+	return resetAfter
+}
+
+// HealthFraction returns the wrapped limiter's health, or 1 if it does not
+// report it
+func (r *RateLimiterWithStats) HealthFraction() float64 {
+	if health, ok := limiter.HealthFraction(r.limiter); ok {
+		return health
+	}
+	return 1
+}
+
+// Refund gives n requests back to the wrapped limiter if it can take them.
+// The statistics already recorded are left as they are.
+func (r *RateLimiterWithStats) Refund(n int) {
+	limiter.Refund(r.limiter, n)
+}
+
+// Penalize charges the wrapped limiter n more requests if it can be
+// charged after the fact. The statistics are left as they are.
+func (r *RateLimiterWithStats) Penalize(n int) {
+	limiter.Penalize(r.limiter, n)
+}
+
+// IsPaused reports whether the wrapped limiter is paused
+func (r *RateLimiterWithStats) IsPaused() bool {
+	return limiter.IsPaused(r.limiter)
+}
+
+// Wait blocks until a token is available and records statistics,
+// including how long it blocked. A wrapped limiter with a WaitContext
+// method is waited on through it, so a wait it gives up on is not
+// recorded, as with WaitContext.
+func (r *RateLimiterWithStats) Wait() {
+	if _, ok := r.limiter.(limiter.ContextWaiter); ok {
+		r.WaitContext(context.Background())
+		return
+	}
+	start := time.Now()
+	limiter.Wait(r.limiter)
+	r.recordWait(time.Since(start))
+	r.stats.RecordAllowed()
+}
+
+// WaitContext blocks until a token is available or ctx is done. Only
+// successful waits are recorded.
+func (r *RateLimiterWithStats) WaitContext(ctx context.Context) error {
+	start := time.Now()
+	if err := limiter.WaitContext(ctx, r.limiter); err != nil {
+		return err
+	}
+	r.recordWait(time.Since(start))
+	r.stats.RecordAllowed()
+	return nil
+}
+
+// recordWait records a wait to the collector if it tracks waits
+func (r *RateLimiterWithStats) recordWait(d time.Duration) {
+	if w, ok := r.stats.(waitRecorder); ok {
+		w.RecordWait(d)
+	}
+}
+
+func (r *RateLimiterWithStats) observe(allowed bool) {
 	if allowed {
 		r.stats.RecordAllowed()
 	} else {
 		r.stats.RecordDenied()
 	}
-	return allowed
 }
 
-// Wait blocks until a token is available and records statistics
-func (r *RateLimiterWithStats) Wait() {
-	r.limiter.Wait()
-	r.stats.RecordAllowed()
+// RecordPrepaid records a request that bypassed the limiter because it was
+// already paid for
+func (r *RateLimiterWithStats) RecordPrepaid() {
+	if p, ok := r.stats.(prepaidRecorder); ok {
+		p.RecordPrepaid()
+	}
+}
+
+// RecordBypassed records a request that bypassed the limiter because
+// limiting was turned off
+func (r *RateLimiterWithStats) RecordBypassed() {
+	if b, ok := r.stats.(bypassRecorder); ok {
+		b.RecordBypassed()
+	}
 }
 
 // GetStats returns the statistics collector
@@ -1,9 +1,13 @@
 package stats
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/rRateLimit/arg/internal/leaktest"
 )
 
 // mockRateLimiter is a mock implementation of RateLimiter for testing
@@ -30,16 +34,16 @@ func (m *mockRateLimiter) Wait() {
 func TestNewStats(t *testing.T) {
 	stats := NewStats()
 	
-	if stats.TotalRequests != 0 {
-		t.Errorf("Expected TotalRequests to be 0, got %d", stats.TotalRequests)
+	if stats.TotalRequests() != 0 {
+		t.Errorf("Expected TotalRequests to be 0, got %d", stats.TotalRequests())
 	}
-	if stats.AllowedRequests != 0 {
-		t.Errorf("Expected AllowedRequests to be 0, got %d", stats.AllowedRequests)
+	if stats.AllowedRequests() != 0 {
+		t.Errorf("Expected AllowedRequests to be 0, got %d", stats.AllowedRequests())
 	}
-	if stats.DeniedRequests != 0 {
-		t.Errorf("Expected DeniedRequests to be 0, got %d", stats.DeniedRequests)
+	if stats.DeniedRequests() != 0 {
+		t.Errorf("Expected DeniedRequests to be 0, got %d", stats.DeniedRequests())
 	}
-	if stats.StartTime.IsZero() {
+	if stats.StartTime().IsZero() {
 		t.Error("Expected StartTime to be set")
 	}
 }
@@ -50,16 +54,16 @@ func TestRecordAllowed(t *testing.T) {
 	stats.RecordAllowed()
 	stats.RecordAllowed()
 	
-	if stats.TotalRequests != 2 {
-		t.Errorf("Expected TotalRequests to be 2, got %d", stats.TotalRequests)
+	if stats.TotalRequests() != 2 {
+		t.Errorf("Expected TotalRequests to be 2, got %d", stats.TotalRequests())
 	}
-	if stats.AllowedRequests != 2 {
-		t.Errorf("Expected AllowedRequests to be 2, got %d", stats.AllowedRequests)
+	if stats.AllowedRequests() != 2 {
+		t.Errorf("Expected AllowedRequests to be 2, got %d", stats.AllowedRequests())
 	}
-	if stats.DeniedRequests != 0 {
-		t.Errorf("Expected DeniedRequests to be 0, got %d", stats.DeniedRequests)
+	if stats.DeniedRequests() != 0 {
+		t.Errorf("Expected DeniedRequests to be 0, got %d", stats.DeniedRequests())
 	}
-	if stats.LastRequestTime.IsZero() {
+	if stats.LastRequestTime().IsZero() {
 		t.Error("Expected LastRequestTime to be set")
 	}
 }
@@ -71,14 +75,14 @@ func TestRecordDenied(t *testing.T) {
 	stats.RecordDenied()
 	stats.RecordDenied()
 	
-	if stats.TotalRequests != 3 {
-		t.Errorf("Expected TotalRequests to be 3, got %d", stats.TotalRequests)
+	if stats.TotalRequests() != 3 {
+		t.Errorf("Expected TotalRequests to be 3, got %d", stats.TotalRequests())
 	}
-	if stats.AllowedRequests != 0 {
-		t.Errorf("Expected AllowedRequests to be 0, got %d", stats.AllowedRequests)
+	if stats.AllowedRequests() != 0 {
+		t.Errorf("Expected AllowedRequests to be 0, got %d", stats.AllowedRequests())
 	}
-	if stats.DeniedRequests != 3 {
-		t.Errorf("Expected DeniedRequests to be 3, got %d", stats.DeniedRequests)
+	if stats.DeniedRequests() != 3 {
+		t.Errorf("Expected DeniedRequests to be 3, got %d", stats.DeniedRequests())
 	}
 }
 
@@ -125,16 +129,16 @@ func TestReset(t *testing.T) {
 	// Reset
 	stats.Reset()
 	
-	if stats.TotalRequests != 0 {
-		t.Errorf("Expected TotalRequests to be 0 after reset, got %d", stats.TotalRequests)
+	if stats.TotalRequests() != 0 {
+		t.Errorf("Expected TotalRequests to be 0 after reset, got %d", stats.TotalRequests())
 	}
-	if stats.AllowedRequests != 0 {
-		t.Errorf("Expected AllowedRequests to be 0 after reset, got %d", stats.AllowedRequests)
+	if stats.AllowedRequests() != 0 {
+		t.Errorf("Expected AllowedRequests to be 0 after reset, got %d", stats.AllowedRequests())
 	}
-	if stats.DeniedRequests != 0 {
-		t.Errorf("Expected DeniedRequests to be 0 after reset, got %d", stats.DeniedRequests)
+	if stats.DeniedRequests() != 0 {
+		t.Errorf("Expected DeniedRequests to be 0 after reset, got %d", stats.DeniedRequests())
 	}
-	if stats.LastRequestTime.IsZero() == false {
+	if stats.LastRequestTime().IsZero() == false {
 		t.Error("Expected LastRequestTime to be zero after reset")
 	}
 }
@@ -234,4 +238,264 @@ func TestAcceptanceRatioEdgeCases(t *testing.T) {
 	if snapshot.AcceptanceRatio != 0 {
 		t.Errorf("Expected AcceptanceRatio to be 0 with only denied requests, got %f", snapshot.AcceptanceRatio)
 	}
-}
\ No newline at end of file
+}
+
+// namedMockRateLimiter is a mock rate limiter that carries a name
+type namedMockRateLimiter struct {
+	mockRateLimiter
+	name string
+}
+
+func (m *namedMockRateLimiter) Name() string {
+	return m.name
+}
+
+func TestRateLimiterWithStatsName(t *testing.T) {
+	mock := &namedMockRateLimiter{name: "api"}
+	rlWithStats := NewRateLimiterWithStats(mock)
+
+	if rlWithStats.Name() != "api" {
+		t.Errorf("Expected Name() to delegate to wrapped limiter, got %q", rlWithStats.Name())
+	}
+	if name := rlWithStats.GetStats().GetSnapshot().Name; name != "api" {
+		t.Errorf("Expected snapshot name 'api', got %q", name)
+	}
+
+	rlWithStats.SetName("override")
+	if rlWithStats.Name() != "override" {
+		t.Errorf("Expected overridden name, got %q", rlWithStats.Name())
+	}
+	if name := rlWithStats.GetStats().GetSnapshot().Name; name != "override" {
+		t.Errorf("Expected snapshot name 'override', got %q", name)
+	}
+
+	unnamed := NewRateLimiterWithStats(&mockRateLimiter{})
+	if unnamed.Name() != "" {
+		t.Errorf("Expected empty name for unnamed limiter, got %q", unnamed.Name())
+	}
+}
+
+func TestConcurrentSwapAndReset(t *testing.T) {
+	stats := NewStats()
+
+	var (
+		wg       sync.WaitGroup
+		archived int64
+		mu       sync.Mutex
+		done     = make(chan struct{})
+	)
+	const recorders, perRecorder = 8, 2000
+
+	for i := 0; i < recorders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perRecorder; j++ {
+				if j%2 == 0 {
+					stats.RecordDenied()
+				} else {
+					stats.RecordAllowed()
+				}
+			}
+		}()
+	}
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			s := stats.GetSnapshot()
+			if s.DeniedRequests > s.TotalRequests || s.AllowedRequests+s.DeniedRequests != s.TotalRequests {
+				t.Errorf("Torn snapshot: %+v", s)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			s := stats.Swap()
+			mu.Lock()
+			archived += s.TotalRequests
+			mu.Unlock()
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	// Every request lands in exactly one archived period or the current one
+	if total := archived + stats.GetSnapshot().TotalRequests; total != recorders*perRecorder {
+		t.Errorf("Expected %d requests across periods, got %d", recorders*perRecorder, total)
+	}
+}
+
+func TestNextDaily(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	tests := []struct {
+		now      time.Time
+		expected time.Time
+	}{
+		{time.Date(2024, 3, 1, 10, 0, 0, 0, loc), time.Date(2024, 3, 2, 0, 0, 0, 0, loc)},
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, loc), time.Date(2024, 3, 2, 0, 0, 0, 0, loc)},
+		{time.Date(2024, 2, 29, 23, 59, 0, 0, time.UTC), time.Date(2024, 3, 2, 0, 0, 0, 0, loc)},
+		{time.Date(2024, 12, 31, 21, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		if got := nextDaily(tt.now, 0, 0, loc); !got.Equal(tt.expected) {
+			t.Errorf("nextDaily(%v) = %v, expected %v", tt.now, got, tt.expected)
+		}
+	}
+}
+
+func TestScheduleReset(t *testing.T) {
+	stats := NewStats()
+	stats.RecordAllowed()
+	stats.RecordDenied()
+
+	archived := make(chan StatsSnapshot, 1)
+	stop := stats.scheduleReset(func(now time.Time) time.Time {
+		return now.Add(10 * time.Millisecond)
+	}, func(s StatsSnapshot) {
+		select {
+		case archived <- s:
+		default:
+		}
+	})
+	defer stop()
+
+	select {
+	case s := <-archived:
+		if s.TotalRequests != 2 || s.DeniedRequests != 1 {
+			t.Errorf("Expected final snapshot with 2 requests, got %+v", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a scheduled reset")
+	}
+	stop()
+	stop()
+}
+
+func TestScheduleResetSoak(t *testing.T) {
+	leaktest.Soak(t, 100, 25, 2*time.Second, func(i int) {
+		stats := NewStats()
+		stop := stats.scheduleReset(func(now time.Time) time.Time {
+			return now.Add(time.Millisecond)
+		}, nil)
+		stats.RecordAllowed()
+		stop()
+		stop()
+	})
+}
+
+// allowOnlyLimiter allows every other request and has no other methods
+type allowOnlyLimiter struct {
+	calls int
+}
+
+func (a *allowOnlyLimiter) Allow() bool {
+	a.calls++
+	return a.calls%2 == 0
+}
+
+func TestRateLimiterWithStatsDegradesCapabilities(t *testing.T) {
+	inner := &allowOnlyLimiter{}
+	rl := NewRateLimiterWithStats(inner)
+
+	// AllowN asks a limiter without it once
+	if rl.AllowN(5) || inner.calls != 1 {
+		t.Errorf("Expected one denied call, got %d calls", inner.calls)
+	}
+	if rl.RetryAfter() != 0 || rl.HealthFraction() != 1 {
+		t.Error("Expected no retry estimate and full health without the capabilities")
+	}
+
+	// Waiting polls Allow
+	if err := rl.WaitContext(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rl.Wait()
+
+	snapshot := rl.GetStats().GetSnapshot()
+	if snapshot.AllowedRequests != 2 || snapshot.DeniedRequests != 1 {
+		t.Errorf("Expected 2 allowed and 1 denied, got %+v", snapshot)
+	}
+}
+
+func TestRecordBackendCall(t *testing.T) {
+	s := NewStats()
+	s.RecordBackendCall(2*time.Millisecond, nil)
+	s.RecordBackendCall(4*time.Millisecond, errors.New("timeout"))
+
+	snapshot := s.GetSnapshot()
+	if snapshot.BackendCalls != 2 || snapshot.BackendErrors != 1 || snapshot.BackendLatency != 3*time.Millisecond {
+		t.Errorf("Expected 2 calls, 1 error and 3ms mean latency, got %+v", snapshot)
+	}
+	s.Reset()
+	if snapshot := s.GetSnapshot(); snapshot.BackendCalls != 0 || snapshot.BackendLatency != 0 {
+		t.Errorf("Expected Reset to clear backend calls, got %+v", snapshot)
+	}
+}
+
+func TestRecordKeys(t *testing.T) {
+	s := NewStats()
+	s.RecordKeys(10, 3)
+	s.RecordKeys(8, 2)
+
+	snapshot := s.GetSnapshot()
+	if snapshot.Keys != 8 || snapshot.Evictions != 5 {
+		t.Errorf("Expected 8 keys and 5 evictions, got %+v", snapshot)
+	}
+	s.Reset()
+	if snapshot := s.GetSnapshot(); snapshot.Keys != 8 || snapshot.Evictions != 0 {
+		t.Errorf("Expected Reset to clear evictions but keep the key count, got %+v", snapshot)
+	}
+}
+
+func BenchmarkRecordParallel(b *testing.B) {
+	s := NewStats()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%4 == 0 {
+				s.RecordDenied()
+			} else {
+				s.RecordAllowed()
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkRecordWithSnapshotReaders(b *testing.B) {
+	s := NewStats()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				s.GetSnapshot()
+			}
+		}
+	}()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.RecordAllowed()
+		}
+	})
+}
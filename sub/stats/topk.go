@@ -0,0 +1,143 @@
+package stats
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// KeyCount is an approximate count for a key reported by TopK. The true
+// count lies between Count-Error and Count.
+type KeyCount struct {
+	Key   string
+	Count int64
+	Error int64
+}
+
+// TopK tracks the most frequent keys in a stream using the space-saving
+// algorithm. It keeps at most capacity counters regardless of how many
+// distinct keys are observed. Every reported count overestimates the true
+// count by at most Total()/capacity, and any key whose true count exceeds
+// that bound is guaranteed to be tracked.
+type TopK struct {
+	mu       sync.Mutex
+	capacity int
+	total    int64
+	counters map[string]*topKCounter
+	heap     topKHeap
+}
+
+type topKCounter struct {
+	key   string
+	count int64
+	error int64
+	index int
+}
+
+// NewTopK creates a sketch that tracks up to capacity keys
+func NewTopK(capacity int) *TopK {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &TopK{
+		capacity: capacity,
+		counters: make(map[string]*topKCounter, capacity),
+		heap:     make(topKHeap, 0, capacity),
+	}
+}
+
+// Observe counts one occurrence of key
+func (t *TopK) Observe(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.total++
+
+	if c, ok := t.counters[key]; ok {
+		c.count++
+		heap.Fix(&t.heap, c.index)
+		return
+	}
+
+	if len(t.heap) < t.capacity {
+		c := &topKCounter{key: key, count: 1}
+		t.counters[key] = c
+		heap.Push(&t.heap, c)
+		return
+	}
+
+	// Replace the least counted key; the newcomer inherits its count as
+	// the possible overestimation
+	c := t.heap[0]
+	delete(t.counters, c.key)
+	c.key = key
+	c.error = c.count
+	c.count++
+	t.counters[key] = c
+	heap.Fix(&t.heap, 0)
+}
+
+// Top returns up to k tracked keys ordered by descending count. A negative k
+// returns every tracked key.
+func (t *TopK) Top(k int) []KeyCount {
+	t.mu.Lock()
+	counts := make([]KeyCount, 0, len(t.heap))
+	for _, c := range t.heap {
+		counts = append(counts, KeyCount{Key: c.key, Count: c.count, Error: c.error})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Key < counts[j].Key
+	})
+
+	if k >= 0 && k < len(counts) {
+		counts = counts[:k]
+	}
+	return counts
+}
+
+// Total returns the number of observations since creation or the last reset
+func (t *TopK) Total() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.total
+}
+
+// Reset forgets all observations
+func (t *TopK) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.total = 0
+	t.counters = make(map[string]*topKCounter, t.capacity)
+	t.heap = t.heap[:0]
+}
+
+// topKHeap is a min-heap of counters ordered by count
+type topKHeap []*topKCounter
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x any) {
+	c := x.(*topKCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *topKHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,136 @@
+package stats
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestTopKZipf(t *testing.T) {
+	const (
+		capacity     = 50
+		observations = 100000
+	)
+
+	rng := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rng, 1.2, 1, 100000)
+
+	sketch := NewTopK(capacity)
+	truth := make(map[string]int64)
+	for i := 0; i < observations; i++ {
+		key := fmt.Sprintf("key-%d", zipf.Uint64())
+		truth[key]++
+		sketch.Observe(key)
+	}
+
+	if sketch.Total() != observations {
+		t.Errorf("Expected total %d, got %d", observations, sketch.Total())
+	}
+
+	type trueCount struct {
+		key   string
+		count int64
+	}
+	var sorted []trueCount
+	for key, count := range truth {
+		sorted = append(sorted, trueCount{key, count})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].count > sorted[j].count })
+
+	top := sketch.Top(capacity)
+	reported := make(map[string]KeyCount, len(top))
+	for _, kc := range top {
+		reported[kc.Key] = kc
+	}
+
+	maxError := int64(observations / capacity)
+	for _, heavy := range sorted[:10] {
+		kc, ok := reported[heavy.key]
+		if !ok {
+			t.Errorf("Expected heavy hitter %s (count %d) in top-K", heavy.key, heavy.count)
+			continue
+		}
+		if kc.Count < heavy.count || kc.Count-kc.Error > heavy.count {
+			t.Errorf("Expected true count %d within [%d, %d] for %s", heavy.count, kc.Count-kc.Error, kc.Count, heavy.key)
+		}
+		if kc.Count-heavy.count > maxError {
+			t.Errorf("Expected overestimate for %s at most %d, got %d", heavy.key, maxError, kc.Count-heavy.count)
+		}
+	}
+
+	top10 := sketch.Top(10)
+	if len(top10) != 10 {
+		t.Fatalf("Expected 10 keys, got %d", len(top10))
+	}
+	if top10[0].Key != sorted[0].key {
+		t.Errorf("Expected hottest key %s first, got %s", sorted[0].key, top10[0].Key)
+	}
+	for i := 1; i < len(top10); i++ {
+		if top10[i].Count > top10[i-1].Count {
+			t.Errorf("Expected descending counts, got %d after %d", top10[i].Count, top10[i-1].Count)
+		}
+	}
+}
+
+func TestTopKBoundedMemory(t *testing.T) {
+	sketch := NewTopK(8)
+	for i := 0; i < 10000; i++ {
+		sketch.Observe(fmt.Sprintf("key-%d", i))
+	}
+
+	if len(sketch.counters) != 8 || len(sketch.heap) != 8 {
+		t.Errorf("Expected 8 counters, got %d in map and %d in heap", len(sketch.counters), len(sketch.heap))
+	}
+	if top := sketch.Top(100); len(top) != 8 {
+		t.Errorf("Expected Top to return at most capacity keys, got %d", len(top))
+	}
+}
+
+func TestTopKReset(t *testing.T) {
+	sketch := NewTopK(4)
+	sketch.Observe("a")
+	sketch.Observe("a")
+	sketch.Observe("b")
+
+	top := sketch.Top(1)
+	if len(top) != 1 || top[0].Key != "a" || top[0].Count != 2 || top[0].Error != 0 {
+		t.Errorf("Expected exact count {a 2 0}, got %+v", top)
+	}
+
+	sketch.Reset()
+	if sketch.Total() != 0 || len(sketch.Top(10)) != 0 {
+		t.Error("Expected empty sketch after reset")
+	}
+}
+
+func TestTopKConcurrent(t *testing.T) {
+	sketch := NewTopK(16)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				sketch.Observe(fmt.Sprintf("key-%d", (worker+i)%32))
+				if i%100 == 0 {
+					sketch.Top(5)
+				}
+				if worker == 0 && i == 500 {
+					sketch.Reset()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var sum int64
+	for _, kc := range sketch.Top(-1) {
+		sum += kc.Count
+	}
+	if sum != sketch.Total() {
+		t.Errorf("Expected counts to sum to total %d, got %d", sketch.Total(), sum)
+	}
+}
@@ -0,0 +1,121 @@
+package stats
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WaitBuckets are the upper bounds of the buckets wait durations are
+// counted in. Longer waits fall in a final, unbounded bucket.
+var WaitBuckets = [...]time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// WaitBucket counts the waits longer than the previous bucket's bound and
+// at most UpperBound, which is zero for the final, unbounded bucket
+type WaitBucket struct {
+	UpperBound time.Duration `json:"upper_bound_ns"`
+	Count      int64         `json:"count"`
+}
+
+// WaitStats summarizes how long callers blocked waiting for the limiter.
+// The percentiles are estimated from the buckets, assuming waits are spread
+// evenly within each.
+type WaitStats struct {
+	Count   int64         `json:"count"`
+	Sum     time.Duration `json:"sum_ns"`
+	Max     time.Duration `json:"max_ns"`
+	P50     time.Duration `json:"p50_ns"`
+	P95     time.Duration `json:"p95_ns"`
+	P99     time.Duration `json:"p99_ns"`
+	Buckets []WaitBucket  `json:"buckets"`
+}
+
+// waitHistogram counts wait durations into WaitBuckets with atomics
+type waitHistogram struct {
+	buckets [len(WaitBuckets) + 1]atomic.Int64
+	count   atomic.Int64
+	sum     atomic.Int64
+	max     atomic.Int64
+}
+
+func (h *waitHistogram) record(d time.Duration) {
+	i := 0
+	for i < len(WaitBuckets) && d > WaitBuckets[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+	for {
+		old := h.max.Load()
+		if int64(d) <= old || h.max.CompareAndSwap(old, int64(d)) {
+			return
+		}
+	}
+}
+
+// load returns the histogram's summary, zeroing it if reset is set
+func (h *waitHistogram) load(reset bool) WaitStats {
+	read := func(v *atomic.Int64) int64 {
+		if reset {
+			return v.Swap(0)
+		}
+		return v.Load()
+	}
+
+	stats := WaitStats{
+		Count:   read(&h.count),
+		Sum:     time.Duration(read(&h.sum)),
+		Max:     time.Duration(read(&h.max)),
+		Buckets: make([]WaitBucket, len(h.buckets)),
+	}
+	for i := range h.buckets {
+		stats.Buckets[i].Count = read(&h.buckets[i])
+		if i < len(WaitBuckets) {
+			stats.Buckets[i].UpperBound = WaitBuckets[i]
+		}
+	}
+	stats.P50 = stats.percentile(0.50)
+	stats.P95 = stats.percentile(0.95)
+	stats.P99 = stats.percentile(0.99)
+	return stats
+}
+
+// percentile estimates the q-th quantile of the waits by interpolating
+// within the bucket it falls in. The unbounded bucket reaches up to Max.
+func (s WaitStats) percentile(q float64) time.Duration {
+	var total int64
+	for _, b := range s.Buckets {
+		total += b.Count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var below int64
+	var lower time.Duration
+	for _, b := range s.Buckets {
+		upper := b.UpperBound
+		if upper == 0 || upper > s.Max {
+			upper = max(s.Max, lower)
+		}
+		if b.Count > 0 && float64(below+b.Count) >= rank {
+			fraction := (rank - float64(below)) / float64(b.Count)
+			return lower + time.Duration(fraction*float64(upper-lower))
+		}
+		below += b.Count
+		lower = upper
+	}
+	return s.Max
+}
+
+// RecordWait records that a caller blocked for d waiting for the limiter
+func (s *Stats) RecordWait(d time.Duration) {
+	s.waits.record(d)
+}
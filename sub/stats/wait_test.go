@@ -0,0 +1,124 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sleepingLimiter blocks each wait for the next of its delays
+type sleepingLimiter struct {
+	mu     sync.Mutex
+	delays []time.Duration
+}
+
+func (l *sleepingLimiter) Allow() bool { return true }
+
+func (l *sleepingLimiter) WaitContext(ctx context.Context) error {
+	l.mu.Lock()
+	delay := l.delays[0]
+	l.delays = l.delays[1:]
+	l.mu.Unlock()
+	time.Sleep(delay)
+	return nil
+}
+
+// failingLimiter gives up on every wait
+type failingLimiter struct{}
+
+func (failingLimiter) Allow() bool { return false }
+
+func (failingLimiter) WaitContext(ctx context.Context) error {
+	return errors.New("backend unavailable")
+}
+
+func TestWaitFailureNotRecorded(t *testing.T) {
+	rl := NewRateLimiterWithStats(failingLimiter{})
+	rl.Wait()
+	if err := rl.WaitContext(context.Background()); err == nil {
+		t.Error("Expected the limiter's error from WaitContext")
+	}
+
+	snapshot := rl.GetStats().GetSnapshot()
+	if snapshot.AllowedRequests != 0 || snapshot.Waits.Count != 0 {
+		t.Errorf("Expected failed waits not to be recorded, got %d allowed and %d waits", snapshot.AllowedRequests, snapshot.Waits.Count)
+	}
+}
+
+func TestWaitLatency(t *testing.T) {
+	delays := []time.Duration{0, 3 * time.Millisecond, 3 * time.Millisecond, 30 * time.Millisecond, 300 * time.Millisecond}
+	rl := NewRateLimiterWithStats(&sleepingLimiter{delays: delays})
+	for range delays {
+		rl.Wait()
+	}
+
+	waits := rl.GetStats().GetSnapshot().Waits
+	if waits.Count != 5 {
+		t.Fatalf("Expected 5 waits, got %+v", waits)
+	}
+	want := []int64{1, 2, 1, 1, 0, 0}
+	if len(waits.Buckets) != len(want) {
+		t.Fatalf("Expected %d buckets, got %+v", len(want), waits.Buckets)
+	}
+	for i, b := range waits.Buckets {
+		if b.Count != want[i] {
+			t.Errorf("Bucket %v: expected %d waits, got %d", b.UpperBound, want[i], b.Count)
+		}
+	}
+	if last := waits.Buckets[len(waits.Buckets)-1]; last.UpperBound != 0 {
+		t.Errorf("Expected the last bucket to be unbounded, got %v", last.UpperBound)
+	}
+	if waits.Max < 300*time.Millisecond || waits.Max > time.Second {
+		t.Errorf("Expected the longest wait to be about 300ms, got %v", waits.Max)
+	}
+	if waits.Sum < 336*time.Millisecond || waits.Sum < waits.Max {
+		t.Errorf("Expected the waits to sum to at least 336ms, got %v", waits.Sum)
+	}
+	// The median is the third of five waits, in the 1-10ms bucket; the 99th
+	// percentile is in the longest wait's bucket
+	if waits.P50 <= time.Millisecond || waits.P50 > 10*time.Millisecond {
+		t.Errorf("Expected a median between 1ms and 10ms, got %v", waits.P50)
+	}
+	if waits.P99 <= 100*time.Millisecond || waits.P99 > waits.Max {
+		t.Errorf("Expected a 99th percentile between 100ms and the max, got %v", waits.P99)
+	}
+	if waits.P50 > waits.P95 || waits.P95 > waits.P99 {
+		t.Errorf("Expected ordered percentiles, got %+v", waits)
+	}
+
+	if swapped := rl.GetStats().(*Stats).Swap().Waits; swapped.Count != 5 {
+		t.Errorf("Expected Swap to return the waits, got %+v", swapped)
+	}
+	if after := rl.GetStats().GetSnapshot().Waits; after.Count != 0 || after.Max != 0 || after.Buckets[1].Count != 0 {
+		t.Errorf("Expected Swap to clear the waits, got %+v", after)
+	}
+}
+
+func TestWaitHistogramConcurrent(t *testing.T) {
+	s := NewStats()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s.RecordWait(time.Duration(g) * time.Millisecond)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	waits := s.GetSnapshot().Waits
+	var inBuckets int64
+	for _, b := range waits.Buckets {
+		inBuckets += b.Count
+	}
+	if waits.Count != 8000 || inBuckets != 8000 {
+		t.Errorf("Expected 8000 waits in total and in buckets, got %d and %d", waits.Count, inBuckets)
+	}
+	if waits.Max != 7*time.Millisecond || waits.Sum != 28000*time.Millisecond {
+		t.Errorf("Expected a 7ms max and 28s sum, got %v and %v", waits.Max, waits.Sum)
+	}
+}